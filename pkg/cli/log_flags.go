@@ -27,6 +27,12 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/log/channel"
 	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
 	"github.com/cockroachdb/cockroach/pkg/util/log/logcrash"
+	// Import the logkafka package to trigger its init function, which
+	// registers the Kafka log sinks with pkg/util/log.
+	_ "github.com/cockroachdb/cockroach/pkg/util/log/logkafka"
+	// Import the logotlp package to trigger its init function, which
+	// registers the gRPC OTLP log sinks with pkg/util/log.
+	_ "github.com/cockroachdb/cockroach/pkg/util/log/logotlp"
 	"github.com/cockroachdb/cockroach/pkg/util/log/severity"
 	"github.com/cockroachdb/errors"
 	"github.com/spf13/cobra"
@@ -202,6 +208,12 @@ func setupLogging(ctx context.Context, cmd *cobra.Command, isServerCmd, applyCon
 		return err
 	}
 
+	// Make the locality of the node available to the sinks configured
+	// with include-locality.
+	if isServerCmd {
+		log.SetNodeLocality(serverCfg.Locality.String())
+	}
+
 	// Configuration ready and directories exist; apply it.
 	fatalOnLogStall := func() bool {
 		return fs.MaxSyncDurationFatalOnExceeded.Get(&serverCfg.Settings.SV)
@@ -62,6 +62,38 @@ func TestSetupLogging(t *testing.T) {
 		`flush-trigger-size: 1.0MiB, ` +
 		`max-buffer-size: 50MiB, ` +
 		`format: newline}}`
+	const defaultKafkaConfig = `kafka-defaults: {` +
+		`timeout: 2s, ` +
+		`tls: false, ` +
+		`unsafe-tls: false, ` +
+		`filter: INFO, ` +
+		`format: json-compact, ` +
+		`redactable: true, ` +
+		`exit-on-error: false, ` +
+		`buffering: NONE}`
+	const defaultSyslogConfig = `syslog-defaults: {` +
+		`facility: user, ` +
+		`app-name: cockroach, ` +
+		`timeout: 2s, ` +
+		`tls: false, ` +
+		`unsafe-tls: false, ` +
+		`filter: INFO, ` +
+		`format: crdb-v2, ` +
+		`redactable: true, ` +
+		`exit-on-error: false, ` +
+		`buffering: NONE}`
+	const defaultGRPCOTLPConfig = `grpc-otlp-defaults: {` +
+		`timeout: 2s, ` +
+		`tls: false, ` +
+		`unsafe-tls: false, ` +
+		`filter: INFO, ` +
+		`format: otlp-json, ` +
+		`redactable: true, ` +
+		`exit-on-error: false, ` +
+		`buffering: {max-staleness: 5s, ` +
+		`flush-trigger-size: 1.0MiB, ` +
+		`max-buffer-size: 50MiB, ` +
+		`format: newline}}`
 	stdFileDefaultsRe := regexp.MustCompile(
 		`file-defaults: \{` +
 			`dir: (?P<path>[^,]+), ` +
@@ -194,6 +226,9 @@ func TestSetupLogging(t *testing.T) {
 		// Shorten the configuration for legibility during reviews of test changes.
 		actual = strings.ReplaceAll(actual, defaultFluentConfig, "<fluentDefaults>")
 		actual = strings.ReplaceAll(actual, defaultHTTPConfig, "<httpDefaults>")
+		actual = strings.ReplaceAll(actual, defaultKafkaConfig, "<kafkaDefaults>")
+		actual = strings.ReplaceAll(actual, defaultSyslogConfig, "<syslogDefaults>")
+		actual = strings.ReplaceAll(actual, defaultGRPCOTLPConfig, "<grpcOTLPDefaults>")
 		actual = stdFileDefaultsRe.ReplaceAllString(actual, "<stdFileDefaults($path)>")
 		actual = fileDefaultsNoMaxSizeRe.ReplaceAllString(actual, "<fileDefaultsNoMaxSize($path)>")
 		actual = strings.ReplaceAll(actual, fileDefaultsNoDir, "<fileDefaultsNoDir>")
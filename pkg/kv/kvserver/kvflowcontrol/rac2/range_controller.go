@@ -14,6 +14,7 @@ import (
 	"cmp"
 	"context"
 	"slices"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/raft/raftpb"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
@@ -24,8 +25,9 @@ import (
 // RangeController provides flow control for replication traffic in KV, for a
 // range at the leader.
 //
-// None of the methods are called with Replica.mu held. The caller should
-// typically order its mutexes before Replica.mu.
+// None of the methods, except SetVotersForWaitForEvalRaftMuLocked, are called
+// with Replica.mu held. The caller should typically order its mutexes before
+// Replica.mu.
 type RangeController interface {
 	// WaitForEval seeks admission to evaluate a request at the given priority.
 	// This blocks until there are positive tokens available for the request to
@@ -49,10 +51,38 @@ type RangeController interface {
 	//
 	// Requires replica.raftMu to be held.
 	SetReplicasRaftMuLocked(ctx context.Context, replicas ReplicaSet) error
+	// SetVotersForWaitForEvalRaftMuLocked eagerly updates the voting replicas
+	// that WaitForEval uses, when the replicas of the range change. It is
+	// followed later by a SetReplicasRaftMuLocked call with the same replicas,
+	// which updates the rest of the state. The caller will never mutate
+	// replicas, and neither should the callee.
+	//
+	// Requires replica.raftMu to be held. Replica.mu is also held, so the
+	// callee must not acquire it, and any mutex acquired by the callee must
+	// be ordered after Replica.mu.
+	SetVotersForWaitForEvalRaftMuLocked(ctx context.Context, replicas ReplicaSet)
 	// SetLeaseholderRaftMuLocked sets the leaseholder of the range.
 	//
 	// Requires raftMu to be held.
 	SetLeaseholderRaftMuLocked(ctx context.Context, replica roachpb.ReplicaID)
+	// ReplicaAdmittedStatesRaftMuLocked returns the state of each replica
+	// that is relevant to how far behind its admitted indices are. The
+	// returned map is owned by the caller.
+	//
+	// Requires replica.raftMu to be held.
+	ReplicaAdmittedStatesRaftMuLocked() map[roachpb.ReplicaID]ReplicaAdmittedState
+	// HasOutstandingTokensRaftMuLocked returns true if any flow tokens
+	// deducted by the range controller have not been returned. It may be
+	// called after CloseRaftMuLocked, to verify that closing returned all the
+	// tokens.
+	//
+	// Requires replica.raftMu to be held.
+	HasOutstandingTokensRaftMuLocked() bool
+	// WaitForEvalStatsRaftMuLocked returns the number of requests currently
+	// blocked in WaitForEval, and when the oldest of them started waiting.
+	//
+	// Requires replica.raftMu to be held.
+	WaitForEvalStatsRaftMuLocked() WaitForEvalStats
 	// CloseRaftMuLocked closes the range controller.
 	//
 	// Requires replica.raftMu to be held.
@@ -65,6 +95,26 @@ type RaftEvent struct {
 	Entries []raftpb.Entry
 }
 
+// ReplicaAdmittedState is the state of a replica, as known at the leader,
+// that is relevant to how far behind its admitted indices are.
+type ReplicaAdmittedState struct {
+	// Match and Next are the raft log indices tracked by the leader for the
+	// replica.
+	Match, Next uint64
+	// Admitted is the admitted index of the replica for each priority.
+	Admitted [raftpb.NumPriorities]uint64
+}
+
+// WaitForEvalStats describes the requests waiting in
+// RangeController.WaitForEval.
+type WaitForEvalStats struct {
+	// NumWaiting is the number of requests waiting for evaluation.
+	NumWaiting int
+	// OldestWaitStart is when the longest waiting request started waiting. It
+	// is zero if NumWaiting is zero.
+	OldestWaitStart time.Time
+}
+
 // NoReplicaID is a special value of roachpb.ReplicaID, which can never be a
 // valid ID.
 const NoReplicaID roachpb.ReplicaID = 0
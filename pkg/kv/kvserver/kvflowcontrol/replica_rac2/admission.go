@@ -103,7 +103,7 @@ type interval struct {
 func (p *lowPriOverrideState) sideChannelForLowPriOverride(
 	leaderTerm uint64, first, last uint64, lowPriOverride bool,
 ) bool {
-	if leaderTerm < p.leaderTerm {
+	if p.isStaleTerm(leaderTerm) {
 		return false
 	}
 	n := len(p.intervals)
@@ -152,9 +152,11 @@ func (p *lowPriOverrideState) sideChannelForLowPriOverride(
 	return true
 }
 
-// sideChannelForV1Leader returns true iff the leaderTerm advanced.
+// sideChannelForV1Leader returns true iff the leaderTerm advanced. A stale
+// leaderTerm is ignored, as is the same leaderTerm, since a leader does a
+// one-way switch from v1 => v2.
 func (p *lowPriOverrideState) sideChannelForV1Leader(leaderTerm uint64) bool {
-	if leaderTerm <= p.leaderTerm {
+	if p.isStaleTerm(leaderTerm) || leaderTerm == p.leaderTerm {
 		return false
 	}
 	p.leaderTerm = leaderTerm
@@ -162,6 +164,13 @@ func (p *lowPriOverrideState) sideChannelForV1Leader(leaderTerm uint64) bool {
 	return true
 }
 
+// isStaleTerm returns true iff leaderTerm is lower than the highest term
+// observed so far. This can happen since RaftMessageRequests can be
+// reordered, and side-channel information for a stale term must be ignored.
+func (p *lowPriOverrideState) isStaleTerm(leaderTerm uint64) bool {
+	return leaderTerm < p.leaderTerm
+}
+
 func (p *lowPriOverrideState) getEffectivePriority(
 	index uint64, pri raftpb.Priority,
 ) raftpb.Priority {
@@ -193,6 +202,36 @@ func (p *lowPriOverrideState) getEffectivePriority(
 	return pri
 }
 
+// peekEffectivePriority is like getEffectivePriority, except that it does
+// not discard any information. It is used for debugging.
+func (p *lowPriOverrideState) peekEffectivePriority(
+	index uint64, pri raftpb.Priority,
+) raftpb.Priority {
+	for _, interval := range p.intervals {
+		if interval.last < index {
+			continue
+		}
+		if interval.first <= index && interval.lowPriOverride {
+			return raftpb.LowPri
+		}
+		break
+	}
+	return pri
+}
+
+// purgeUpTo discards the information for indices <= index. It is called
+// when a snapshot is applied, since those indices are no longer in the raft
+// log.
+func (p *lowPriOverrideState) purgeUpTo(index uint64) {
+	drop := 0
+	for n := len(p.intervals); drop < n && p.intervals[drop].last <= index; drop++ {
+	}
+	p.intervals = p.intervals[drop:]
+	if len(p.intervals) > 0 && p.intervals[0].first <= index {
+		p.intervals[0].first = index + 1
+	}
+}
+
 // waitingForAdmissionState records the indices of individual entries that are
 // waiting for admission in the AC queues. These are added after emerging as
 // MsgStorageAppend in handleRaftReady, hence we can expect monotonicity of
@@ -235,9 +274,14 @@ type waitingForAdmissionState struct {
 type admissionEntry struct {
 	index      uint64
 	leaderTerm uint64
+	// addedAt is the time, in nanoseconds, at which the entry started waiting
+	// for admission. It is only used for diagnostics.
+	addedAt int64
 }
 
-func (w *waitingForAdmissionState) add(leaderTerm uint64, index uint64, pri raftpb.Priority) {
+func (w *waitingForAdmissionState) add(
+	leaderTerm uint64, index uint64, pri raftpb.Priority, addedAt int64,
+) {
 	n := len(w.waiting[pri])
 	i := n
 	// Linear scan, and all the scanned items will be removed.
@@ -266,9 +310,30 @@ func (w *waitingForAdmissionState) add(leaderTerm uint64, index uint64, pri raft
 	w.waiting[pri] = append(w.waiting[pri], admissionEntry{
 		index:      index,
 		leaderTerm: leaderTerm,
+		addedAt:    addedAt,
 	})
 }
 
+// oldest returns the entry that has been waiting for admission the longest,
+// and its priority. ok is false if no entry is waiting.
+func (w *waitingForAdmissionState) oldest() (
+	entry admissionEntry,
+	pri raftpb.Priority,
+	ok bool,
+) {
+	for i := range w.waiting {
+		// The entries for a priority are added in increasing time order, so
+		// only the first one needs to be considered.
+		if len(w.waiting[i]) == 0 {
+			continue
+		}
+		if e := w.waiting[i][0]; !ok || e.addedAt < entry.addedAt {
+			entry, pri, ok = e, raftpb.Priority(i), true
+		}
+	}
+	return entry, pri, ok
+}
+
 func (w *waitingForAdmissionState) remove(
 	leaderTerm uint64, index uint64, pri raftpb.Priority,
 ) (admittedMayAdvance bool) {
@@ -290,10 +355,49 @@ func (w *waitingForAdmissionState) remove(
 	return pos >= 0
 }
 
+// purgeUpTo removes the entries with index <= index, for all priorities. It
+// is called when a snapshot is applied, since those entries are no longer in
+// the raft log. Returns true iff some entry was removed.
+func (w *waitingForAdmissionState) purgeUpTo(index uint64) (purged bool) {
+	for i := range w.waiting {
+		pos := 0
+		for n := len(w.waiting[i]); pos < n && w.waiting[i][pos].index <= index; pos++ {
+		}
+		if pos > 0 {
+			w.waiting[i] = w.waiting[i][pos:]
+			purged = true
+		}
+	}
+	return purged
+}
+
+// len returns the number of entries waiting for admission, across all
+// priorities.
+func (w *waitingForAdmissionState) len() int {
+	n := 0
+	for i := range w.waiting {
+		n += len(w.waiting[i])
+	}
+	return n
+}
+
+// countsByPriority returns the number of entries waiting for admission at
+// each priority. The entries of a priority are kept in a slice, so the counts
+// are the lengths of the slices, and the entries are not scanned.
+func (w *waitingForAdmissionState) countsByPriority() (counts [raftpb.NumPriorities]int) {
+	for i := range w.waiting {
+		counts[i] = len(w.waiting[i])
+	}
+	return counts
+}
+
+// computeAdmitted returns the admitted array implied by the stable index and
+// the entries waiting for admission. It also returns whether any element
+// advanced past prevAdmitted, which is computed in the same pass to avoid a
+// separate comparison by the caller.
 func (w *waitingForAdmissionState) computeAdmitted(
-	stableIndex uint64,
-) [raftpb.NumPriorities]uint64 {
-	var admitted [raftpb.NumPriorities]uint64
+	stableIndex uint64, prevAdmitted [raftpb.NumPriorities]uint64,
+) (admitted [raftpb.NumPriorities]uint64, advanced bool) {
 	for i := range w.waiting {
 		admitted[i] = stableIndex
 		if len(w.waiting[i]) > 0 {
@@ -302,6 +406,9 @@ func (w *waitingForAdmissionState) computeAdmitted(
 				admitted[i] = upperBoundAdmitted
 			}
 		}
+		if admitted[i] > prevAdmitted[i] {
+			advanced = true
+		}
 	}
-	return admitted
+	return admitted, advanced
 }
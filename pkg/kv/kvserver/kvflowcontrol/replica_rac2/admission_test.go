@@ -69,6 +69,15 @@ func TestLowPriOverrideState(t *testing.T) {
 				termAdvanced := lpos.sideChannelForV1Leader(leaderTerm)
 				return fmt.Sprintf("term-advanced: %t\n%s", termAdvanced, lposString())
 
+			case "purge":
+				// Example:
+				//  purge index=7
+				// Discards the information for indices <= 7.
+				var index uint64
+				d.ScanArgs(t, "index", &index)
+				lpos.purgeUpTo(index)
+				return lposString()
+
 			case "get-effective-priority":
 				// Example:
 				//  get-effective-priority index=4 pri=HighPri
@@ -136,7 +145,7 @@ func TestWaitingForAdmissionState(t *testing.T) {
 				// Adds for tracking index 5, with the given priority,
 				// received at the specified leader-term.
 				leaderTerm, index, pri := argsLeaderIndexPri(t, d)
-				w.add(leaderTerm, index, pri)
+				w.add(leaderTerm, index, pri, 0 /* addedAt */)
 				return waitingStateString()
 
 			case "remove":
@@ -149,13 +158,29 @@ func TestWaitingForAdmissionState(t *testing.T) {
 
 			case "compute-admitted":
 				// Example:
-				//  compute-admitted stable-index=7
-				// Computes the admitted array.
+				//  compute-admitted stable-index=7 [prev-admitted=[4,7,7,6]]
+				// Computes the admitted array, and whether it advanced past
+				// prev-admitted, which defaults to all zeroes.
 				var stableIndex uint64
 				d.ScanArgs(t, "stable-index", &stableIndex)
-				admitted := w.computeAdmitted(stableIndex)
-				return fmt.Sprintf("admitted: [%d, %d, %d, %d]\n",
-					admitted[0], admitted[1], admitted[2], admitted[3])
+				var prevAdmitted [raftpb.NumPriorities]uint64
+				if d.HasArg("prev-admitted") {
+					var arg string
+					d.ScanArgs(t, "prev-admitted", &arg)
+					prevAdmitted = parseAdmitted(t, arg)
+				}
+				admitted, advanced := w.computeAdmitted(stableIndex, prevAdmitted)
+				return fmt.Sprintf("admitted: [%d, %d, %d, %d] advanced: %t\n",
+					admitted[0], admitted[1], admitted[2], admitted[3], advanced)
+
+			case "purge":
+				// Example:
+				//  purge index=7
+				// Removes all entries with index <= 7.
+				var index uint64
+				d.ScanArgs(t, "index", &index)
+				purged := w.purgeUpTo(index)
+				return fmt.Sprintf("purged: %t\n%s", purged, waitingStateString())
 
 			default:
 				return fmt.Sprintf("unknown command: %s", d.Cmd)
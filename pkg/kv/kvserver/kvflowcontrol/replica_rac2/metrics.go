@@ -0,0 +1,238 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package replica_rac2
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/admission/admissionpb"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+)
+
+var (
+	admittedEntries = metric.Metadata{
+		Name:        "kvflowcontrol.processor.admitted_entries",
+		Help:        "Number of raft log entries admitted by admission control at replicas",
+		Measurement: "Entries",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	waitingForAdmission = metric.Metadata{
+		Name:        "kvflowcontrol.processor.waiting_for_admission",
+		Help:        "Number of raft log entries waiting for admission at replicas",
+		Measurement: "Entries",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	waitingForEval = metric.Metadata{
+		Name:        "kvflowcontrol.processor.waiting_for_eval",
+		Help:        "Number of requests waiting for evaluation in the range controllers of leader replicas",
+		Measurement: "Requests",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	followersWithV1Leader = metric.Metadata{
+		Name:        "kvflowcontrol.processor.followers_with_v1_leader",
+		Help:        "Number of follower replicas that believe their raft leader is using the RACv1 protocol",
+		Measurement: "Replicas",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	followersWithV2Leader = metric.Metadata{
+		Name:        "kvflowcontrol.processor.followers_with_v2_leader",
+		Help:        "Number of follower replicas that believe their raft leader is using the RACv2 protocol",
+		Measurement: "Replicas",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	piggybackedResponsesEnqueued = metric.Metadata{
+		Name:        "kvflowcontrol.processor.piggybacked_responses_enqueued",
+		Help:        "Number of admitted MsgAppResps enqueued to be piggybacked to the leader",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	piggybackedResponsesDropped = metric.Metadata{
+		Name:        "kvflowcontrol.processor.piggybacked_responses_dropped",
+		Help:        "Number of admitted MsgAppResps dropped since the leader's node was unknown",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	admittedResponsesSentDirect = metric.Metadata{
+		Name:        "kvflowcontrol.processor.admitted_responses_sent_direct",
+		Help:        "Number of admitted MsgAppResps sent directly to the leader, instead of being piggybacked, since the follower was far behind and idle",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	enqueuedPiggybackedResponsesDropped = metric.Metadata{
+		Name:        "kvflowcontrol.processor.enqueued_piggybacked_responses_dropped",
+		Help:        "Number of piggybacked admitted MsgAppResps dropped at the leader since the enqueued responses exceeded their size limit",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	staleSideChannelInfoIgnored = metric.Metadata{
+		Name:        "kvflowcontrol.processor.stale_side_channel_info_ignored",
+		Help:        "Number of times side-channel information from the leader was ignored at followers since it was for a stale leader term",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	admissionRejected = metric.Metadata{
+		Name:        "kvflowcontrol.processor.admission_rejected",
+		Help:        "Number of raft log entries rejected by the admission work queue, which will not be admitted",
+		Measurement: "Entries",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	rangeControllerRecreated = metric.Metadata{
+		Name:        "kvflowcontrol.processor.range_controller_recreated",
+		Help:        "Number of times the range controller at the leader was recreated after repeated errors handling raft events",
+		Measurement: "Recreations",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	rangeControllerTermRecreations = metric.Metadata{
+		Name:        "kvflowcontrol.processor.rangecontroller_recreations",
+		Help:        "Number of times the range controller at the leader was recreated since the leader term advanced, which happens frequently during election storms",
+		Measurement: "Recreations",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	v1EncodingRegularPriority = metric.Metadata{
+		Name:        "kvflowcontrol.processor.v1_encoding_regular_priority",
+		Help:        "Number of raft log entries using the RACv1 encoding with a regular work class priority, which should not happen",
+		Measurement: "Entries",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	leaderTransitions = metric.Metadata{
+		Name:        "kvflowcontrol.processor.leader_transitions",
+		Help:        "Number of times replicas observed a change in the raft leader",
+		Measurement: "Transitions",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	skippedUndecodableEntries = metric.Metadata{
+		Name:        "kvflowcontrol.processor.skipped_undecodable_entries",
+		Help:        "Number of raft log entries skipped for admission at replicas since their admission metadata could not be decoded",
+		Measurement: "Entries",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	negativeRequestedCount = metric.Metadata{
+		Name:        "kvflowcontrol.processor.negative_requested_count",
+		Help:        "Number of raft log entries that requested a negative number of admission tokens, which was clamped to zero",
+		Measurement: "Entries",
+		Unit:        metric.Unit_COUNT,
+	}
+
+	admissionWaitDuration = metric.Metadata{
+		Name:        "kvflowcontrol.processor.%s_admission_wait_duration",
+		Help:        "Latency histogram for time %s raft log entries spent waiting for admission at replicas",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+)
+
+// annotateMetricTemplateWithWorkClass uses the given metric template to build
+// one suitable for the specific work class.
+func annotateMetricTemplateWithWorkClass(
+	wc admissionpb.WorkClass, tmpl metric.Metadata,
+) metric.Metadata {
+	rv := tmpl
+	rv.Name = fmt.Sprintf(tmpl.Name, wc)
+	rv.Help = fmt.Sprintf(tmpl.Help, wc)
+	return rv
+}
+
+// Metrics is a metric.Struct for the Processors of all the replicas on a
+// store.
+type Metrics struct {
+	AdmittedEntries     *metric.Counter
+	WaitingForAdmission *metric.Gauge
+	// WaitingForEval is refreshed on each raft Ready at the leader, so it
+	// lags the RangeControllers by up to one Ready.
+	WaitingForEval *metric.Gauge
+	// FollowersWithV1Leader and FollowersWithV2Leader count the followers
+	// with a known leader, by the protocol they believe the leader is using.
+	// A follower believes the leader is using RACv1 until it learns
+	// otherwise via the side channel, so together they track the progress
+	// of the rollout of RACv2.
+	FollowersWithV1Leader        *metric.Gauge
+	FollowersWithV2Leader        *metric.Gauge
+	PiggybackedResponsesEnqueued *metric.Counter
+	PiggybackedResponsesDropped  *metric.Counter
+	AdmittedResponsesSentDirect  *metric.Counter
+	// EnqueuedPiggybackedResponsesDropped counts the responses dropped at
+	// the leader, unlike PiggybackedResponsesDropped, which counts the
+	// responses dropped at followers.
+	EnqueuedPiggybackedResponsesDropped *metric.Counter
+	StaleSideChannelInfoIgnored         *metric.Counter
+	AdmissionRejected                   *metric.Counter
+	RangeControllerRecreated            *metric.Counter
+	// RangeControllerTermRecreations counts the recreations due to the leader
+	// term advancing, unlike RangeControllerRecreated, which counts the
+	// recreations due to errors.
+	RangeControllerTermRecreations *metric.Counter
+	V1EncodingRegularPriority      *metric.Counter
+	LeaderTransitions              *metric.Counter
+	SkippedUndecodableEntries      *metric.Counter
+	NegativeRequestedCount         *metric.Counter
+	// AdmissionWaitDuration is indexed by the work class of the entry. An
+	// entry that is admitted immediately records a (near) zero duration.
+	AdmissionWaitDuration [admissionpb.NumWorkClasses]metric.IHistogram
+}
+
+var _ metric.Struct = &Metrics{}
+
+// NewMetrics returns a new instance of Metrics.
+func NewMetrics(histogramWindow time.Duration) *Metrics {
+	m := &Metrics{
+		AdmittedEntries:                     metric.NewCounter(admittedEntries),
+		WaitingForAdmission:                 metric.NewGauge(waitingForAdmission),
+		WaitingForEval:                      metric.NewGauge(waitingForEval),
+		FollowersWithV1Leader:               metric.NewGauge(followersWithV1Leader),
+		FollowersWithV2Leader:               metric.NewGauge(followersWithV2Leader),
+		PiggybackedResponsesEnqueued:        metric.NewCounter(piggybackedResponsesEnqueued),
+		PiggybackedResponsesDropped:         metric.NewCounter(piggybackedResponsesDropped),
+		AdmittedResponsesSentDirect:         metric.NewCounter(admittedResponsesSentDirect),
+		EnqueuedPiggybackedResponsesDropped: metric.NewCounter(enqueuedPiggybackedResponsesDropped),
+		StaleSideChannelInfoIgnored:         metric.NewCounter(staleSideChannelInfoIgnored),
+		AdmissionRejected:                   metric.NewCounter(admissionRejected),
+		RangeControllerRecreated:            metric.NewCounter(rangeControllerRecreated),
+		RangeControllerTermRecreations:      metric.NewCounter(rangeControllerTermRecreations),
+		V1EncodingRegularPriority:           metric.NewCounter(v1EncodingRegularPriority),
+		LeaderTransitions:                   metric.NewCounter(leaderTransitions),
+		SkippedUndecodableEntries:           metric.NewCounter(skippedUndecodableEntries),
+		NegativeRequestedCount:              metric.NewCounter(negativeRequestedCount),
+	}
+	for _, wc := range []admissionpb.WorkClass{
+		admissionpb.RegularWorkClass,
+		admissionpb.ElasticWorkClass,
+	} {
+		m.AdmissionWaitDuration[wc] = metric.NewHistogram(
+			metric.HistogramOptions{
+				Metadata:     annotateMetricTemplateWithWorkClass(wc, admissionWaitDuration),
+				Duration:     histogramWindow,
+				BucketConfig: metric.IOLatencyBuckets,
+				Mode:         metric.HistogramModePrometheus,
+			},
+		)
+	}
+	return m
+}
+
+// MetricStruct implements the metric.Struct interface.
+func (m *Metrics) MetricStruct() {}
@@ -0,0 +1,198 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package replica_rac2
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvflowcontrol/kvflowcontrolpb"
+	"github.com/cockroachdb/cockroach/pkg/raft/raftpb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+)
+
+// PiggybackBatcherOptions are specified when creating a PiggybackBatcher.
+type PiggybackBatcherOptions struct {
+	// MaxBatchSize is the maximum number of responses returned by a single
+	// call to PopMsgsForNode.
+	MaxBatchSize int
+	// MaxDelay is the maximum duration a response is expected to wait for a
+	// message to piggyback on, after which the node is returned by
+	// NodesWithOverdueMsgs.
+	MaxDelay time.Duration
+	Clock    *hlc.Clock
+	// SendDirect, if non-nil, is used by MaybeSendDirect to send a response
+	// to the given node without waiting for another message. It returns
+	// false if the response could not be sent. It must not block.
+	SendDirect func(roachpb.NodeID, kvflowcontrolpb.AdmittedResponseForRange) bool
+}
+
+// PiggybackBatcher is an AdmittedPiggybacker that groups the pending
+// MsgAppResps for admitted by the destination (leader) node, so that the
+// responses for many ranges can be sent together, on the next outbound
+// RaftTransport message to that node. It is shared by all the Processors on
+// a node, which register with it by using it as their
+// ProcessorOptions.AdmittedPiggybacker.
+//
+// Only the latest MsgAppResp from a replica is retained, since it supersedes
+// the earlier ones.
+//
+// The RaftTransport calls PopMsgsForNode when sending a message to a node,
+// and periodically calls NodesWithOverdueMsgs to find nodes for which it
+// should send a message, even if it has nothing else to send, so that the
+// responses do not wait longer than MaxDelay.
+type PiggybackBatcher struct {
+	opts PiggybackBatcherOptions
+	mu   struct {
+		syncutil.Mutex
+		outbox map[roachpb.NodeID]pendingResponses
+	}
+}
+
+var _ AdmittedPiggybacker = &PiggybackBatcher{}
+
+// pendingResponseKey is used to coalesce responses bound for a given node.
+type pendingResponseKey struct {
+	roachpb.RangeID
+	from raftpb.PeerID
+}
+
+type pendingResponse struct {
+	resp        kvflowcontrolpb.AdmittedResponseForRange
+	enqueueTime time.Time
+}
+
+type pendingResponses map[pendingResponseKey]pendingResponse
+
+// NewPiggybackBatcher constructs a new PiggybackBatcher.
+func NewPiggybackBatcher(opts PiggybackBatcherOptions) *PiggybackBatcher {
+	b := &PiggybackBatcher{opts: opts}
+	b.mu.outbox = map[roachpb.NodeID]pendingResponses{}
+	return b
+}
+
+// AddMsgAppRespForLeader implements AdmittedPiggybacker.
+func (b *PiggybackBatcher) AddMsgAppRespForLeader(
+	n roachpb.NodeID, s roachpb.StoreID, r roachpb.RangeID, msg raftpb.Message,
+) {
+	now := b.opts.Clock.PhysicalTime()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pending, ok := b.mu.outbox[n]
+	if !ok {
+		pending = pendingResponses{}
+		b.mu.outbox[n] = pending
+	}
+	key := pendingResponseKey{RangeID: r, from: msg.From}
+	enqueueTime := now
+	if existing, ok := pending[key]; ok {
+		// Retain the original enqueue time, so that the delay is bounded for
+		// a replica that keeps replacing its response.
+		enqueueTime = existing.enqueueTime
+	}
+	pending[key] = pendingResponse{
+		resp: kvflowcontrolpb.AdmittedResponseForRange{
+			LeaderStoreID: s,
+			RangeID:       r,
+			Msg:           msg,
+		},
+		enqueueTime: enqueueTime,
+	}
+}
+
+// MaybeSendDirect implements AdmittedPiggybacker.
+func (b *PiggybackBatcher) MaybeSendDirect(
+	n roachpb.NodeID, s roachpb.StoreID, r roachpb.RangeID, msg raftpb.Message,
+) bool {
+	if b.opts.SendDirect == nil {
+		return false
+	}
+	if !b.opts.SendDirect(n, kvflowcontrolpb.AdmittedResponseForRange{
+		LeaderStoreID: s,
+		RangeID:       r,
+		Msg:           msg,
+	}) {
+		return false
+	}
+	// Any pending response from the same replica is superseded by the one
+	// just sent.
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if pending, ok := b.mu.outbox[n]; ok {
+		delete(pending, pendingResponseKey{RangeID: r, from: msg.From})
+		if len(pending) == 0 {
+			delete(b.mu.outbox, n)
+		}
+	}
+	return true
+}
+
+// PopMsgsForNode returns up to MaxBatchSize pending responses for the given
+// node, in the order they were enqueued, and the number of responses that
+// are still pending.
+func (b *PiggybackBatcher) PopMsgsForNode(
+	n roachpb.NodeID,
+) (resps []kvflowcontrolpb.AdmittedResponseForRange, remaining int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pending, ok := b.mu.outbox[n]
+	if !ok {
+		return nil, 0
+	}
+	keys := make([]pendingResponseKey, 0, len(pending))
+	for key := range pending {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, c pendingResponseKey) int {
+		if r := pending[a].enqueueTime.Compare(pending[c].enqueueTime); r != 0 {
+			return r
+		}
+		if r := cmp.Compare(a.RangeID, c.RangeID); r != 0 {
+			return r
+		}
+		return cmp.Compare(a.from, c.from)
+	})
+	if b.opts.MaxBatchSize > 0 && len(keys) > b.opts.MaxBatchSize {
+		keys = keys[:b.opts.MaxBatchSize]
+	}
+	resps = make([]kvflowcontrolpb.AdmittedResponseForRange, 0, len(keys))
+	for _, key := range keys {
+		resps = append(resps, pending[key].resp)
+		delete(pending, key)
+	}
+	remaining = len(pending)
+	if remaining == 0 {
+		delete(b.mu.outbox, n)
+	}
+	return resps, remaining
+}
+
+// NodesWithOverdueMsgs returns the nodes with a pending response that has
+// waited for at least MaxDelay, in increasing order of NodeID.
+func (b *PiggybackBatcher) NodesWithOverdueMsgs() []roachpb.NodeID {
+	now := b.opts.Clock.PhysicalTime()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var nodes []roachpb.NodeID
+	for n, pending := range b.mu.outbox {
+		for _, p := range pending {
+			if now.Sub(p.enqueueTime) >= b.opts.MaxDelay {
+				nodes = append(nodes, n)
+				break
+			}
+		}
+	}
+	slices.Sort(nodes)
+	return nodes
+}
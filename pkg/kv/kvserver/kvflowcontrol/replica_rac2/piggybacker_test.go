@@ -0,0 +1,132 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package replica_rac2
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvflowcontrol/kvflowcontrolpb"
+	"github.com/cockroachdb/cockroach/pkg/raft/raftpb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/testutils/datapathutils"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/datadriven"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPiggybackBatcher(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	var clock *timeutil.ManualTime
+	var pb *PiggybackBatcher
+	var sent strings.Builder
+	datadriven.RunTest(t, datapathutils.TestDataPath(t, "piggyback_batcher"),
+		func(t *testing.T, d *datadriven.TestData) string {
+			switch d.Cmd {
+			case "init":
+				var maxBatchSize int
+				d.ScanArgs(t, "max-batch-size", &maxBatchSize)
+				var maxDelayStr string
+				d.ScanArgs(t, "max-delay", &maxDelayStr)
+				maxDelay, err := time.ParseDuration(maxDelayStr)
+				require.NoError(t, err)
+				clock = timeutil.NewManualTime(timeutil.Unix(0, 0))
+				opts := PiggybackBatcherOptions{
+					MaxBatchSize: maxBatchSize,
+					MaxDelay:     maxDelay,
+					Clock:        hlc.NewClockForTesting(clock),
+				}
+				if d.HasArg("send-direct") {
+					opts.SendDirect = func(
+						n roachpb.NodeID, r kvflowcontrolpb.AdmittedResponseForRange,
+					) bool {
+						fmt.Fprintf(&sent, "SendDirect(n%s): s%s r%s %s index: %d\n",
+							n, r.LeaderStoreID, r.RangeID, msgString(r.Msg), r.Msg.Index)
+						return true
+					}
+				}
+				pb = NewPiggybackBatcher(opts)
+				return ""
+
+			case "add":
+				var nodeID, storeID, rangeID int
+				d.ScanArgs(t, "node-id", &nodeID)
+				d.ScanArgs(t, "store-id", &storeID)
+				d.ScanArgs(t, "range-id", &rangeID)
+				var from, to, index uint64
+				d.ScanArgs(t, "from", &from)
+				d.ScanArgs(t, "to", &to)
+				d.ScanArgs(t, "index", &index)
+				pb.AddMsgAppRespForLeader(roachpb.NodeID(nodeID), roachpb.StoreID(storeID),
+					roachpb.RangeID(rangeID), raftpb.Message{
+						Type:  raftpb.MsgAppResp,
+						From:  raftpb.PeerID(from),
+						To:    raftpb.PeerID(to),
+						Index: index,
+					})
+				return ""
+
+			case "send-direct":
+				var nodeID, storeID, rangeID int
+				d.ScanArgs(t, "node-id", &nodeID)
+				d.ScanArgs(t, "store-id", &storeID)
+				d.ScanArgs(t, "range-id", &rangeID)
+				var from, to, index uint64
+				d.ScanArgs(t, "from", &from)
+				d.ScanArgs(t, "to", &to)
+				d.ScanArgs(t, "index", &index)
+				ok := pb.MaybeSendDirect(roachpb.NodeID(nodeID), roachpb.StoreID(storeID),
+					roachpb.RangeID(rangeID), raftpb.Message{
+						Type:  raftpb.MsgAppResp,
+						From:  raftpb.PeerID(from),
+						To:    raftpb.PeerID(to),
+						Index: index,
+					})
+				fmt.Fprintf(&sent, "sent: %t\n", ok)
+				str := sent.String()
+				sent.Reset()
+				return str
+
+			case "pop":
+				var nodeID int
+				d.ScanArgs(t, "node-id", &nodeID)
+				resps, remaining := pb.PopMsgsForNode(roachpb.NodeID(nodeID))
+				var b strings.Builder
+				for _, r := range resps {
+					fmt.Fprintf(&b, "s%s r%s %s index: %d\n",
+						r.LeaderStoreID, r.RangeID, msgString(r.Msg), r.Msg.Index)
+				}
+				fmt.Fprintf(&b, "remaining: %d\n", remaining)
+				return b.String()
+
+			case "overdue-nodes":
+				return fmt.Sprintf("%v\n", pb.NodesWithOverdueMsgs())
+
+			case "advance-clock":
+				var durationStr string
+				d.ScanArgs(t, "duration", &durationStr)
+				duration, err := time.ParseDuration(durationStr)
+				require.NoError(t, err)
+				clock.Advance(duration)
+				return ""
+
+			default:
+				return fmt.Sprintf("unknown command: %s", d.Cmd)
+			}
+		})
+}
@@ -11,21 +11,63 @@
 package replica_rac2
 
 import (
+	"cmp"
 	"context"
+	"slices"
 	"sync/atomic"
 	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvflowcontrol/kvflowcontrolpb"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvflowcontrol/rac2"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/raftlog"
 	"github.com/cockroachdb/cockroach/pkg/raft/raftpb"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/util/admission/admissionpb"
 	"github.com/cockroachdb/cockroach/pkg/util/buildutil"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing/tracingpb"
 	"github.com/cockroachdb/errors"
 )
 
+// tolerateDecodeErrors controls whether a raft log entry whose admission
+// control encoding or metadata cannot be decoded crashes the node, or is
+// skipped for admission.
+var tolerateDecodeErrors = settings.RegisterBoolSetting(
+	settings.SystemOnly,
+	"kvadmission.rac2.tolerate_decode_errors.enabled",
+	"when true, raft log entries whose admission control metadata cannot be "+
+		"decoded are logged and skipped for admission, instead of crashing the node",
+	false,
+)
+
+// rangeControllerErrorThreshold is the number of consecutive errors from
+// RangeController.HandleRaftEventRaftMuLocked after which the Processor
+// assumes the RangeController is stuck, and recreates it.
+var rangeControllerErrorThreshold = settings.RegisterIntSetting(
+	settings.SystemOnly,
+	"kvadmission.rac2.range_controller_recreate_error_threshold",
+	"number of consecutive errors handling raft events after which the range "+
+		"controller at the leader is closed and recreated; 0 disables recreation",
+	10,
+	settings.NonNegativeInt,
+)
+
+// admissionStallLogThreshold is the duration after which an entry waiting
+// for admission is considered stalled, and reported in the logs.
+var admissionStallLogThreshold = settings.RegisterDurationSetting(
+	settings.SystemOnly,
+	"kvadmission.rac2.admission_stall_log_threshold",
+	"duration after which a raft log entry waiting for admission at a replica "+
+		"is reported in the logs as stalled; 0 disables the reporting",
+	5*time.Minute,
+	settings.NonNegativeDuration,
+)
+
 // Replica abstracts kvserver.Replica. It exposes internal implementation
 // details of Replica, specifically the locking behavior, since it is
 // essential to reason about correctness.
@@ -118,6 +160,8 @@ type RaftNode interface {
 	// MyLeaderTermLocked returns the term, if this replica is the leader, else
 	// 0.
 	MyLeaderTermLocked() uint64
+	// TermLocked returns the current term of this replica.
+	TermLocked() uint64
 
 	// Mutating methods.
 
@@ -137,6 +181,13 @@ type RaftNode interface {
 // relevant range.
 type AdmittedPiggybacker interface {
 	AddMsgAppRespForLeader(roachpb.NodeID, roachpb.StoreID, roachpb.RangeID, raftpb.Message)
+	// MaybeSendDirect is a fallback for when the message is unlikely to be
+	// piggybacked soon, e.g. on a low-traffic range. It tries to send the
+	// message to the leader node without waiting for other messages, and
+	// returns false if that is not possible, in which case the caller should
+	// use AddMsgAppRespForLeader instead. It is called with Processor.mu
+	// held, so must not block or call into the Processor.
+	MaybeSendDirect(roachpb.NodeID, roachpb.StoreID, roachpb.RangeID, raftpb.Message) bool
 }
 
 // EntryForAdmission is the information provided to the admission control (AC)
@@ -172,11 +223,31 @@ type EntryForAdmissionCallbackState struct {
 	LeaderTerm uint64
 	Index      uint64
 	Priority   raftpb.Priority
+	// EnqueueTime is the time, in unix nanos, at which the entry was handed
+	// to ACWorkQueue.Admit. It is used to compute the admission wait duration.
+	EnqueueTime int64
 }
 
+// AdmissionOutcome is the outcome of submitting an entry to ACWorkQueue.
+type AdmissionOutcome uint8
+
+const (
+	// AdmissionAccepted means the entry was queued for admission, or admitted
+	// immediately. The admission callback will be (or has already been)
+	// invoked.
+	AdmissionAccepted AdmissionOutcome = iota
+	// AdmissionRejected means the work queue is overloaded and rejected the
+	// entry. The admission callback will not be invoked.
+	AdmissionRejected
+)
+
 // ACWorkQueue abstracts the behavior needed from admission.WorkQueue.
 type ACWorkQueue interface {
-	Admit(ctx context.Context, entry EntryForAdmission)
+	// Admit submits the entry for admission. It may block if the work queue
+	// is saturated, and must stop blocking and return an error when ctx is
+	// canceled, in which case the entry will not be admitted. If the work
+	// queue is overloaded, it can reject the entry instead of queueing it.
+	Admit(ctx context.Context, entry EntryForAdmission) (AdmissionOutcome, error)
 }
 
 // TODO(sumeer): temporary placeholder, until RangeController is more fully
@@ -189,15 +260,19 @@ type rangeControllerInitState struct {
 
 // RangeControllerFactory abstracts RangeController creation for testing.
 type RangeControllerFactory interface {
-	// New creates a new RangeController.
-	New(state rangeControllerInitState) rac2.RangeController
+	// New creates a new RangeController. An error is returned if the
+	// resources needed by the RangeController could not be allocated, in
+	// which case the replica will not subject writes to replication flow
+	// control when it is the leader.
+	New(state rangeControllerInitState) (rac2.RangeController, error)
 }
 
 // EnabledWhenLeaderLevel captures the level at which RACv2 is enabled when
 // this replica is the leader.
 //
 // State transitions are NotEnabledWhenLeader => EnabledWhenLeaderV1Encoding
-// => EnabledWhenLeaderV2Encoding, i.e., the level will never regress.
+// => EnabledWhenLeaderV2Encoding, i.e., the level will never regress, except
+// via an explicit Processor.ForceSetEnabledWhenLeaderRaftMuLocked.
 type EnabledWhenLeaderLevel uint8
 
 const (
@@ -224,10 +299,75 @@ type ProcessorOptions struct {
 	AdmittedPiggybacker    AdmittedPiggybacker
 	ACWorkQueue            ACWorkQueue
 	RangeControllerFactory RangeControllerFactory
+	// Metrics is shared by all the Processors on a store. It must be
+	// non-nil.
+	Metrics *Metrics
+	// Clock is used to measure the admission wait duration of entries. It
+	// must be non-nil.
+	Clock *hlc.Clock
+	// Settings is used to read the cluster settings that control the
+	// Processor, e.g. the admission stall threshold. It must be non-nil.
+	Settings *cluster.Settings
+	// OnLeaderChange, if non-nil, is called when the leader known to this
+	// replica changes, with the previous and new leader, and the raft term
+	// when the change was observed. A zero leader means the leader is not
+	// known. It is called with raftMu held, but without holding
+	// Processor.mu or Replica.mu, so it may call into the Processor or
+	// acquire Replica.mu.
+	OnLeaderChange func(old, new roachpb.ReplicaID, term uint64)
+	// MaxEnqueuedPiggybackedResponsesBytes, if positive, is the limit on the
+	// total serialized size of the piggybacked MsgAppResps enqueued at the
+	// leader. A message that would cause the limit to be exceeded is
+	// dropped.
+	MaxEnqueuedPiggybackedResponsesBytes int64
+	// OnAdmissionRejected, if non-nil, is called when ACWorkQueue rejects an
+	// entry. It is called without holding Processor.mu or Replica.mu.
+	OnAdmissionRejected func(entry EntryForAdmission)
+	// PriorityMapper, if non-nil, maps the raft priority of an entry to the
+	// admission priority used when submitting it to ACWorkQueue, instead of
+	// rac2.RaftToAdmissionPriority. The raft priority is still used to track
+	// the entry until it is admitted.
+	PriorityMapper func(raftpb.Priority) admissionpb.WorkPriority
+	// TenantPriorityFloors, if non-nil, specifies the minimum admission
+	// priority for the entries of a tenant. The admission priority of an
+	// entry of TenantID is raised to the floor, if any, after the raft
+	// priority is mapped to the admission priority. The raft priority, which
+	// is used to track the entry until it is admitted, is not changed.
+	TenantPriorityFloors map[roachpb.TenantID]admissionpb.WorkPriority
+	// DirectSendAdmitted enables sending an admitted MsgAppResp at a
+	// follower via AdmittedPiggybacker.MaybeSendDirect, instead of waiting
+	// for it to be piggybacked, when admitted advances by at least
+	// DirectSendAdmittedLagThreshold for some priority, and no entries are
+	// waiting for admission. The latter means no further admitted updates
+	// are expected, which the response could be coalesced with.
+	DirectSendAdmitted             bool
+	DirectSendAdmittedLagThreshold uint64
+	// DisablePiggybacking causes every admitted MsgAppResp at a follower to
+	// be sent via AdmittedPiggybacker.MaybeSendDirect, instead of waiting for
+	// it to be piggybacked. This avoids the piggybacking latency, e.g. in
+	// latency-sensitive benchmarks, at the cost of sending more messages. The
+	// response is still piggybacked if it cannot be sent directly.
+	DisablePiggybacking bool
+	// Knobs is only used in tests, and may be nil.
+	Knobs *ProcessorTestingKnobs
 
 	EnabledWhenLeaderLevel EnabledWhenLeaderLevel
 }
 
+// ProcessorTestingKnobs are the testing knobs for Processor. They are only
+// honored in test builds.
+type ProcessorTestingKnobs struct {
+	// ProcessAdmittedSynchronously causes the processing of admitted, which
+	// AdmittedLogEntry would otherwise schedule via
+	// RaftScheduler.EnqueueRaftReady, to happen inline before AdmittedLogEntry
+	// returns. The caller of AdmittedLogEntry must then hold raftMu.
+	ProcessAdmittedSynchronously bool
+	// DecodeRaftAdmissionMeta, if non-nil, replaces
+	// raftlog.DecodeRaftAdmissionMeta when admitting entries. It allows tests
+	// to inject decoding errors for chosen entries.
+	DecodeRaftAdmissionMeta func(entry raftpb.Entry) (kvflowcontrolpb.RaftAdmissionMeta, error)
+}
+
 // SideChannelInfoUsingRaftMessageRequest is used to provide a follower
 // information about the leader's protocol, and if the leader is using the
 // RACv2 protocol, additional information about entries.
@@ -300,6 +440,14 @@ type Processor interface {
 	//
 	// raftMu is held.
 	OnDestroyRaftMuLocked(ctx context.Context)
+	// HasOutstandingTokensRaftMuLocked returns true if this replica is the
+	// leader, and its RangeController has deducted flow tokens that have not
+	// been returned. It always returns false at a follower, or after the
+	// Processor is destroyed, since there is no RangeController. It is meant
+	// for detecting token leaks.
+	//
+	// raftMu is held.
+	HasOutstandingTokensRaftMuLocked() bool
 
 	// SetEnabledWhenLeaderRaftMuLocked is the dynamic change corresponding to
 	// ProcessorOptions.EnabledWhenLeaderLevel. The level must only be ratcheted
@@ -308,7 +456,35 @@ type Processor interface {
 	// This may be a noop if the level has already been reached.
 	//
 	// raftMu is held.
-	SetEnabledWhenLeaderRaftMuLocked(level EnabledWhenLeaderLevel)
+	SetEnabledWhenLeaderRaftMuLocked(ctx context.Context, level EnabledWhenLeaderLevel)
+	// ForceSetEnabledWhenLeaderRaftMuLocked is like
+	// SetEnabledWhenLeaderRaftMuLocked, except that when allowRegression is
+	// true, the level can also be lowered. This is meant for rolling back
+	// RACv2, and must not be used in the normal course of operation.
+	//
+	// A regression closes the RangeController, if any, which returns all the
+	// flow tokens held by it, and recreates it if the new level is still
+	// enabled and this replica is the leader. The follower state learnt from
+	// a leader using the v2 protocol is also reset. Regressing while entries
+	// are in flight has the following implications:
+	//
+	// - The tokens returned by closing the RangeController may have been
+	//   deducted for entries that have not been admitted at the followers, so
+	//   the leader can temporarily over-admit.
+	//
+	// - At a follower, entries that are already waiting for admission remain
+	//   tracked until admitted, but admitted is not advanced until the
+	//   follower again learns that the leader is using the v2 protocol. Until
+	//   then, AdmitRaftEntriesFromMsgStorageAppendRaftMuLocked returns false,
+	//   so new entries go through the RACv1 path, and any low-pri overrides
+	//   for entries already in the log are forgotten.
+	//
+	// It is a noop if the level is unchanged, so it is safe to call
+	// repeatedly.
+	//
+	// raftMu is held.
+	ForceSetEnabledWhenLeaderRaftMuLocked(
+		ctx context.Context, level EnabledWhenLeaderLevel, allowRegression bool)
 	// GetEnabledWhenLeader returns the current level. It may be used in
 	// highly concurrent settings at the leaseholder, when waiting for eval,
 	// and when encoding a proposal. Note that if the leaseholder is not the
@@ -316,19 +492,31 @@ type Processor interface {
 	// done, since the leaseholder can continue waiting for v1 tokens and use
 	// the v1 entry encoding.
 	GetEnabledWhenLeader() EnabledWhenLeaderLevel
+	// GetLeaderNotInReplicasDuration returns for how long, as of the last
+	// raft Ready, the leader has been known but not in the set of replicas,
+	// so that admitted MsgAppResps cannot be sent to it. It returns zero if
+	// this replica is not in that state. It may be called concurrently, e.g.
+	// to populate a per-range gauge.
+	GetLeaderNotInReplicasDuration() time.Duration
 
 	// OnDescChangedLocked provides a possibly updated RangeDescriptor.
 	//
 	// Both Replica mu and raftMu are held.
 	//
-	// TODO(sumeer): we are currently delaying the processing caused by this
-	// until HandleRaftReadyRaftMuLocked, including telling the
-	// RangeController. However, RangeController.WaitForEval needs to have the
-	// latest state. We need to either (a) change this
-	// OnDescChangedRaftMuLocked, or (b) add a method in RangeController that
-	// only updates the voting replicas used in WaitForEval, and call that
-	// from OnDescChangedLocked, and do the rest of the updating later.
+	// Most of the processing caused by this is delayed until
+	// HandleRaftReadyRaftMuLocked. The exception is the voting replicas used
+	// by RangeController.WaitForEval, which need to be the latest, so that
+	// eval does not wait for (or ignore) the wrong replicas after a membership
+	// change. These are synchronously provided to the RangeController via
+	// SetVotersForWaitForEvalRaftMuLocked.
 	OnDescChangedLocked(ctx context.Context, desc *roachpb.RangeDescriptor)
+	// OnLeaseChangedRaftMuLocked is called when the leaseholder changes, so
+	// that the RangeController, if any, learns about the new leaseholder
+	// without waiting for the next HandleRaftReadyRaftMuLocked. This matters
+	// for WaitForEval after a lease transfer.
+	//
+	// raftMu is held.
+	OnLeaseChangedRaftMuLocked(ctx context.Context, leaseholderID roachpb.ReplicaID)
 
 	// HandleRaftReadyRaftMuLocked corresponds to processing that happens when
 	// Replica.handleRaftReadyRaftMuLocked is called. It must be called even
@@ -344,6 +532,15 @@ type Processor interface {
 	//
 	// raftMu is held.
 	HandleRaftReadyRaftMuLocked(ctx context.Context, entries []raftpb.Entry)
+	// OnSnapshotAppliedRaftMuLocked is called after a snapshot at snapIndex
+	// is applied. The entries at or below snapIndex that are waiting for
+	// admission are no longer in the raft log, and any priority override
+	// information for them is stale, so they are discarded, and admitted is
+	// re-derived. Admission callbacks for discarded entries, that arrive
+	// later, are harmless.
+	//
+	// raftMu is held.
+	OnSnapshotAppliedRaftMuLocked(ctx context.Context, snapIndex uint64)
 	// AdmitRaftEntriesFromMsgStorageAppendRaftMuLocked subjects entries to
 	// admission control on a replica (leader or follower). Like
 	// HandleRaftReadyRaftMuLocked, this is called from
@@ -352,11 +549,55 @@ type Processor interface {
 	// are writing to the store in Replica.handleRaftReadyRaftMuLocked. This is
 	// a noop if the leader is not using the RACv2 protocol. Returns false if
 	// the leader is using RACv1, in which the caller should follow the RACv1
-	// admission pathway.
+	// admission pathway. If ctx is canceled, e.g. when the store is draining,
+	// the remaining entries are not submitted for admission.
 	//
 	// raftMu is held.
 	AdmitRaftEntriesFromMsgStorageAppendRaftMuLocked(
 		ctx context.Context, leaderTerm uint64, entries []raftpb.Entry) bool
+	// RebuildWaitingStateRaftMuLocked rebuilds the state of entries waiting
+	// for admission after a node restart, since that state is only kept in
+	// memory. The entries are those in the raft log in (admitted, stable
+	// index], where admitted is the minimum over all priorities. The entries
+	// that are not already admitted for their priority are re-submitted for
+	// admission, as done in AdmitRaftEntriesFromMsgStorageAppendRaftMuLocked.
+	//
+	// It is a noop unless the leader is known to be using the RACv2 protocol,
+	// so at a follower it must be called after the first call to
+	// SideChannelForPriorityOverrideAtFollowerRaftMuLocked for the current
+	// leader, and before any entries are admitted via
+	// AdmitRaftEntriesFromMsgStorageAppendRaftMuLocked. The entries are
+	// tracked at the current leader term.
+	//
+	// Priority overrides are re-applied using the information provided via
+	// SideChannelForPriorityOverrideAtFollowerRaftMuLocked since the restart,
+	// which typically only covers these entries if the leader retransmitted
+	// them. The override information from before the restart is lost, and
+	// such entries are admitted at their original priority. This is the
+	// error that is tolerated by lowPriOverrideState, where the leader has
+	// overridden to LowPri, but the follower thinks it has not.
+	//
+	// raftMu is held.
+	RebuildWaitingStateRaftMuLocked(ctx context.Context, entries []raftpb.Entry)
+	// SetDrainingRaftMuLocked is called when the store starts, or stops,
+	// draining. While draining, the entries provided to
+	// AdmitRaftEntriesFromMsgStorageAppendRaftMuLocked (and
+	// RebuildWaitingStateRaftMuLocked) are tracked as waiting for admission,
+	// but are not submitted to ACWorkQueue, so that the drain is not held up
+	// by admission control. Entries that were already submitted continue to
+	// be processed when admitted.
+	//
+	// The entries that are not submitted are never admitted, and prevent the
+	// admitted index from advancing past them, until they are overwritten by
+	// a new leader or the replica is destroyed. The leader will consequently
+	// not get its flow tokens back for these entries. This is acceptable
+	// since the draining is expected to be followed by the node shutting
+	// down, at which point the leader will stop tracking this replica. If the
+	// draining is aborted, these entries remain stuck until the node is
+	// restarted, or the range sees a leader change.
+	//
+	// raftMu is held.
+	SetDrainingRaftMuLocked(draining bool)
 
 	// EnqueuePiggybackedAdmittedAtLeader is called at the leader when
 	// receiving a piggybacked MsgAppResp that can advance a follower's
@@ -370,6 +611,16 @@ type Processor interface {
 	//
 	// raftMu is held.
 	ProcessPiggybackedAdmittedAtLeaderRaftMuLocked(ctx context.Context) bool
+	// FlushPiggybackedAdmittedRaftMuLocked immediately steps all the
+	// enqueued piggybacked MsgAppResps at the leader, without waiting for
+	// ProcessPiggybackedAdmittedAtLeaderRaftMuLocked to be scheduled, and
+	// returns the number of messages stepped. It is used when the leader is
+	// stepping down gracefully, so that the admitted state of followers is
+	// current before the lease is transferred. It is a noop if this replica
+	// is not the leader with a RangeController.
+	//
+	// raftMu is held.
+	FlushPiggybackedAdmittedRaftMuLocked(ctx context.Context) int
 
 	// SideChannelForPriorityOverrideAtFollowerRaftMuLocked is called on a
 	// follower to provide information about whether the leader is using the
@@ -381,12 +632,140 @@ type Processor interface {
 	SideChannelForPriorityOverrideAtFollowerRaftMuLocked(
 		info SideChannelInfoUsingRaftMessageRequest,
 	)
+	// GetLeaderTermRaftMuLocked returns the leader term known to the RACv2
+	// protocol at this replica. If this replica is the leader with a
+	// RangeController, this is the term at which it became the leader. Else
+	// it is the highest leader term observed via the side-channel, or 0 if
+	// none has been observed.
+	//
+	// raftMu is held.
+	GetLeaderTermRaftMuLocked() uint64
+	// IsLeaderUsingV2RaftMuLocked returns true iff this replica is the leader
+	// with a RangeController, or is a follower that knows the leader is using
+	// the RACv2 protocol.
+	//
+	// raftMu is held.
+	IsLeaderUsingV2RaftMuLocked() bool
+	// GetEffectivePriorityRaftMuLocked returns the priority that would be used
+	// to admit the entry at the given index, with the given raft priority, at
+	// a follower, taking into account the low-priority override communicated
+	// by the leader via the side-channel. It returns raftPri unchanged if this
+	// replica is not a follower that knows the leader is using the RACv2
+	// protocol. It is meant for debugging, and does not modify the state of
+	// the override.
+	//
+	// raftMu is held.
+	GetEffectivePriorityRaftMuLocked(index uint64, raftPri raftpb.Priority) raftpb.Priority
 
 	// AdmittedLogEntry is called when an entry is admitted. It can be called
 	// synchronously from within ACWorkQueue.Admit if admission is immediate.
 	AdmittedLogEntry(
 		ctx context.Context, state EntryForAdmissionCallbackState,
 	)
+
+	// GetReplicaAdmittedStateRaftMuLocked returns, at the leader, the
+	// admitted state of each replica, as populated by the RangeController. It
+	// returns nil if this replica is not the leader with a RangeController.
+	// The returned map is owned by the caller.
+	//
+	// raftMu is held.
+	GetReplicaAdmittedStateRaftMuLocked() map[roachpb.ReplicaID]rac2.ReplicaAdmittedState
+
+	// GetWaitForEvalStatsRaftMuLocked returns, at the leader, the number of
+	// requests waiting for evaluation in the RangeController, and when the
+	// oldest of them started waiting. It returns the zero value if this
+	// replica is not the leader with a RangeController.
+	//
+	// raftMu is held.
+	GetWaitForEvalStatsRaftMuLocked() rac2.WaitForEvalStats
+
+	// WaitingForAdmissionByPriorityRaftMuLocked returns the number of entries
+	// waiting for admission at each priority. The entries whose priority was
+	// overridden by the leader are counted at LowPri.
+	//
+	// raftMu is held.
+	WaitingForAdmissionByPriorityRaftMuLocked() [raftpb.NumPriorities]int
+
+	// InspectRaftMuLocked returns a snapshot of the internal state of the
+	// Processor, for debugging. The returned state is a copy, and can be
+	// retained and mutated by the caller.
+	//
+	// The state is not exposed by the range debug endpoints yet, since the
+	// Replica does not own a Processor yet.
+	//
+	// raftMu is held.
+	InspectRaftMuLocked(ctx context.Context) ProcessorInspectState
+	// ReconcileWaitingStateRaftMuLocked compares the entries waiting for
+	// admission with the given raft log entries, and returns the
+	// discrepancies. Only the indices in (admitted, stable index] are
+	// compared, using the admitted array for the priority, so logEntries
+	// must include the entries in that interval. It does not modify any
+	// state, and is meant for consistency checking in tests.
+	//
+	// An entry that is admitted is removed from the waiting state before
+	// admitted advances past it, so the result can contain spurious
+	// AbsentButShouldTrack discrepancies while admitted is being advanced.
+	// Also, the priority override for an entry is discarded once the entry
+	// is submitted for admission, so a v2 encoded entry waiting at LowPri is
+	// assumed to have been overridden.
+	//
+	// raftMu is held.
+	ReconcileWaitingStateRaftMuLocked(ctx context.Context, logEntries []raftpb.Entry) []Discrepancy
+}
+
+// ProcessorInspectState is a snapshot of the state of a Processor, returned
+// by Processor.InspectRaftMuLocked.
+type ProcessorInspectState struct {
+	LeaderID      roachpb.ReplicaID
+	LeaseholderID roachpb.ReplicaID
+	LeaderNodeID  roachpb.NodeID
+	// Admitted is the admitted array in the RaftNode. It is zero if the
+	// Replica is not yet initialized.
+	Admitted                [raftpb.NumPriorities]uint64
+	LastObservedStableIndex uint64
+	// IsLeaderUsingV2 is true iff this replica is the leader with a
+	// RangeController, or is a follower that knows the leader is using the
+	// RACv2 protocol.
+	IsLeaderUsingV2 bool
+	// NumWaitingForAdmission is the number of entries that are waiting for
+	// admission, across all priorities.
+	NumWaitingForAdmission int
+	// PendingRangeControllerCreation is true iff this replica is the leader,
+	// but the creation of its RangeController is deferred until it is in
+	// the descriptor.
+	PendingRangeControllerCreation bool
+}
+
+// DiscrepancyKind is the kind of a Discrepancy.
+type DiscrepancyKind uint8
+
+const (
+	// TrackedButAbsent is used when an entry is waiting for admission at a
+	// priority, but the raft log does not contain an entry at that index
+	// that should be waiting at that priority.
+	TrackedButAbsent DiscrepancyKind = iota
+	// AbsentButShouldTrack is used when the raft log contains an entry that
+	// should be waiting for admission, but is not.
+	AbsentButShouldTrack
+)
+
+func (k DiscrepancyKind) String() string {
+	switch k {
+	case TrackedButAbsent:
+		return "tracked-but-absent"
+	case AbsentButShouldTrack:
+		return "absent-but-should-track"
+	default:
+		return "unknown"
+	}
+}
+
+// Discrepancy is a mismatch between the entries waiting for admission and
+// the raft log, returned by Processor.ReconcileWaitingStateRaftMuLocked.
+type Discrepancy struct {
+	Kind     DiscrepancyKind
+	Index    uint64
+	Priority raftpb.Priority
 }
 
 type processorImpl struct {
@@ -411,6 +790,19 @@ type processorImpl struct {
 		lastObservedStableIndex     uint64
 		scheduledAdmittedProcessing bool
 		waitingForAdmissionState    waitingForAdmissionState
+		// numWaitingForAdmission is the contribution of this Processor to
+		// Metrics.WaitingForAdmission.
+		numWaitingForAdmission int
+		// numWaitingForEval is the contribution of this Processor to
+		// Metrics.WaitingForEval.
+		numWaitingForEval int
+		// leaderNotInReplicasSince is when the leader was first observed to be
+		// known but not in raftMu.replicas, i.e., leaderID != 0 and
+		// leaderNodeID == 0. It is zero if the leader is not in that state.
+		leaderNotInReplicasSince time.Time
+		// followerLeaderProtocol is the contribution of this Processor to
+		// Metrics.FollowersWithV1Leader and Metrics.FollowersWithV2Leader.
+		followerLeaderProtocol followerLeaderProtocol
 		// State at a follower.
 		follower struct {
 			isLeaderUsingV2Protocol bool
@@ -420,12 +812,26 @@ type processorImpl struct {
 		// protocol is enabled.
 		leader struct {
 			enqueuedPiggybackedResponses map[roachpb.ReplicaID]raftpb.Message
-			rc                           rac2.RangeController
+			// enqueuedPiggybackedResponsesBytes is the total serialized size of
+			// enqueuedPiggybackedResponses.
+			enqueuedPiggybackedResponsesBytes int64
+			// rc is only modified while holding both raftMu and mu, so it can
+			// be read while holding either.
+			rc rac2.RangeController
 			// Term is used to notice transitions out of leadership and back,
 			// to recreate rc. It is set when rc is created, and is not
 			// up-to-date if there is no rc (which can happen when using the
 			// v1 protocol).
 			term uint64
+			// pendingCreation is true when this replica is the leader, but rc
+			// has not been created since this replica is not in
+			// raftMu.replicas. While it is set, creation is only retried by
+			// the Ready following an OnDescChangedLocked, since the replicas
+			// must change for creation to succeed.
+			pendingCreation bool
+			// consecutiveRaftEventErrors is the number of consecutive errors
+			// returned by rc.HandleRaftEventRaftMuLocked.
+			consecutiveRaftEventErrors int64
 		}
 		// Is the RACv2 protocol enabled when this replica is the leader.
 		enabledWhenLeader EnabledWhenLeaderLevel
@@ -440,21 +846,54 @@ type processorImpl struct {
 		// the state in replicas.
 		replicas        rac2.ReplicaSet
 		replicasChanged bool
+		// replicasSinceReady is the history of replica sets provided by
+		// OnDescChangedLocked since replicasChanged was last consumed. Only
+		// the latest, which is replicas, is applied to the RangeController,
+		// but the history is useful for understanding rapid config changes.
+		replicasSinceReady []rac2.ReplicaSet
+		// descGeneration is the generation of the latest descriptor provided
+		// by OnDescChangedLocked.
+		descGeneration roachpb.RangeGeneration
+		// draining is set by SetDrainingRaftMuLocked.
+		draining bool
 	}
 	// Atomic value, for serving GetEnabledWhenLeader. Mirrors
 	// mu.enabledWhenLeader.
 	enabledWhenLeader atomic.Uint32
+	// Atomic value, for serving GetLeaderNotInReplicasDuration. It is the
+	// duration, in nanoseconds, since mu.leaderNotInReplicasSince, as of the
+	// last raft Ready.
+	leaderNotInReplicasDuration atomic.Int64
 
 	v1EncodingPriorityMismatch log.EveryN
+	// droppedAdmittedUnknownLeader rate limits the logging when an admitted
+	// MsgAppResp is dropped at a follower, since the leader is not known.
+	droppedAdmittedUnknownLeader log.EveryN
+	// negativeRequestedCount rate limits the logging when an entry requests
+	// a negative number of admission tokens.
+	negativeRequestedCount log.EveryN
+	// admissionStall rate limits the logging of entries that have been
+	// waiting for admission for longer than admissionStallLogThreshold.
+	admissionStall log.EveryN
+	// rangeControllerRecreation rate limits the logging when the
+	// RangeController is recreated since the leader term advanced.
+	rangeControllerRecreation log.EveryN
 }
 
 var _ Processor = &processorImpl{}
 
 func NewProcessor(opts ProcessorOptions) Processor {
+	if opts.Metrics == nil || opts.Clock == nil || opts.Settings == nil {
+		panic(errors.AssertionFailedf("Metrics, Clock and Settings must be non-nil"))
+	}
 	p := &processorImpl{opts: opts}
 	p.mu.enabledWhenLeader = opts.EnabledWhenLeaderLevel
 	p.enabledWhenLeader.Store(uint32(opts.EnabledWhenLeaderLevel))
 	p.v1EncodingPriorityMismatch = log.Every(time.Minute)
+	p.droppedAdmittedUnknownLeader = log.Every(time.Minute)
+	p.negativeRequestedCount = log.Every(time.Minute)
+	p.admissionStall = log.Every(time.Minute)
+	p.rangeControllerRecreation = log.Every(time.Minute)
 	return p
 }
 
@@ -465,15 +904,85 @@ func (p *processorImpl) OnDestroyRaftMuLocked(ctx context.Context) {
 	defer p.mu.Unlock()
 
 	p.mu.destroyed = true
+	rc := p.mu.leader.rc
 	p.closeLeaderStateRaftMuLockedProcLocked(ctx)
+	if buildutil.CrdbTestBuild && rc != nil && rc.HasOutstandingTokensRaftMuLocked() {
+		// OnDestroyRaftMuLocked is called when the replica is removed, e.g.,
+		// by replica GC, so any tokens not returned now are leaked.
+		panic(errors.AssertionFailedf("r%s: flow tokens leaked on destroy", p.opts.RangeID))
+	}
 
 	// Release some memory.
 	p.mu.waitingForAdmissionState = waitingForAdmissionState{}
 	p.mu.follower.lowPriOverrideState = lowPriOverrideState{}
+	p.updateWaitingForAdmissionMetricProcLocked()
+	p.updateFollowerLeaderProtocolMetricsProcLocked()
+	p.updateLeaderNotInReplicasProcLocked()
+}
+
+// updateWaitingForAdmissionMetricProcLocked must be called after
+// waitingForAdmissionState is modified.
+func (p *processorImpl) updateWaitingForAdmissionMetricProcLocked() {
+	n := p.mu.waitingForAdmissionState.len()
+	if delta := n - p.mu.numWaitingForAdmission; delta != 0 {
+		p.opts.Metrics.WaitingForAdmission.Inc(int64(delta))
+		p.mu.numWaitingForAdmission = n
+	}
+}
+
+// updateWaitingForEvalMetricProcLocked updates the contribution of this
+// Processor to Metrics.WaitingForEval to n.
+func (p *processorImpl) updateWaitingForEvalMetricProcLocked(n int) {
+	if delta := n - p.mu.numWaitingForEval; delta != 0 {
+		p.opts.Metrics.WaitingForEval.Inc(int64(delta))
+		p.mu.numWaitingForEval = n
+	}
+}
+
+// followerLeaderProtocol is the protocol that a follower believes the leader
+// is using.
+type followerLeaderProtocol uint8
+
+const (
+	// notFollower is used when the replica is the leader, the leader is
+	// unknown, or the Processor is destroyed.
+	notFollower followerLeaderProtocol = iota
+	followerWithV1Leader
+	followerWithV2Leader
+)
+
+// updateFollowerLeaderProtocolMetricsProcLocked must be called after the
+// leader, or what is known about the leader's protocol, changes.
+func (p *processorImpl) updateFollowerLeaderProtocolMetricsProcLocked() {
+	proto := notFollower
+	if !p.mu.destroyed && p.mu.leaderID != 0 && p.mu.leaderID != p.opts.ReplicaID {
+		proto = followerWithV1Leader
+		if p.mu.follower.isLeaderUsingV2Protocol {
+			proto = followerWithV2Leader
+		}
+	}
+	if proto == p.mu.followerLeaderProtocol {
+		return
+	}
+	switch p.mu.followerLeaderProtocol {
+	case followerWithV1Leader:
+		p.opts.Metrics.FollowersWithV1Leader.Dec(1)
+	case followerWithV2Leader:
+		p.opts.Metrics.FollowersWithV2Leader.Dec(1)
+	}
+	switch proto {
+	case followerWithV1Leader:
+		p.opts.Metrics.FollowersWithV1Leader.Inc(1)
+	case followerWithV2Leader:
+		p.opts.Metrics.FollowersWithV2Leader.Inc(1)
+	}
+	p.mu.followerLeaderProtocol = proto
 }
 
 // SetEnabledWhenLeaderRaftMuLocked implements Processor.
-func (p *processorImpl) SetEnabledWhenLeaderRaftMuLocked(level EnabledWhenLeaderLevel) {
+func (p *processorImpl) SetEnabledWhenLeaderRaftMuLocked(
+	ctx context.Context, level EnabledWhenLeaderLevel,
+) {
 	p.opts.Replica.RaftMuAssertHeld()
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -485,7 +994,43 @@ func (p *processorImpl) SetEnabledWhenLeaderRaftMuLocked(level EnabledWhenLeader
 	if level != EnabledWhenLeaderV1Encoding || p.raftMu.replicas == nil {
 		return
 	}
-	// May need to create RangeController.
+	p.maybeCreateLeaderStateRaftMuLockedProcLocked(ctx)
+}
+
+// ForceSetEnabledWhenLeaderRaftMuLocked implements Processor.
+func (p *processorImpl) ForceSetEnabledWhenLeaderRaftMuLocked(
+	ctx context.Context, level EnabledWhenLeaderLevel, allowRegression bool,
+) {
+	// NB: the level is only modified while holding raftMu, so it is safe to
+	// read it before acquiring mu.
+	if !allowRegression || level >= p.GetEnabledWhenLeader() {
+		p.SetEnabledWhenLeaderRaftMuLocked(ctx, level)
+		return
+	}
+	p.opts.Replica.RaftMuAssertHeld()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mu.destroyed {
+		return
+	}
+	p.mu.enabledWhenLeader = level
+	p.enabledWhenLeader.Store(uint32(level))
+	// Return all the flow tokens, and forget what we know about the leader's
+	// protocol.
+	p.closeLeaderStateRaftMuLockedProcLocked(ctx)
+	p.mu.follower.isLeaderUsingV2Protocol = false
+	p.mu.follower.lowPriOverrideState = lowPriOverrideState{}
+	p.updateFollowerLeaderProtocolMetricsProcLocked()
+	if level == NotEnabledWhenLeader || p.raftMu.replicas == nil {
+		return
+	}
+	p.maybeCreateLeaderStateRaftMuLockedProcLocked(ctx)
+}
+
+// maybeCreateLeaderStateRaftMuLockedProcLocked creates the RangeController
+// if this replica is the leader. It must only be called when the
+// RangeController does not exist, and the raftNode is known.
+func (p *processorImpl) maybeCreateLeaderStateRaftMuLockedProcLocked(ctx context.Context) {
 	var leaderID roachpb.ReplicaID
 	var myLeaderTerm uint64
 	var nextUnstableIndex uint64
@@ -499,15 +1044,48 @@ func (p *processorImpl) SetEnabledWhenLeaderRaftMuLocked(level EnabledWhenLeader
 		}
 	}()
 	if leaderID == p.opts.ReplicaID {
-		p.createLeaderStateRaftMuLockedProcLocked(myLeaderTerm, nextUnstableIndex)
+		p.createLeaderStateRaftMuLockedProcLocked(ctx, myLeaderTerm, nextUnstableIndex)
 	}
 }
 
+// HasOutstandingTokensRaftMuLocked implements Processor.
+func (p *processorImpl) HasOutstandingTokensRaftMuLocked() bool {
+	p.opts.Replica.RaftMuAssertHeld()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mu.destroyed || p.mu.leader.rc == nil {
+		return false
+	}
+	return p.mu.leader.rc.HasOutstandingTokensRaftMuLocked()
+}
+
 // GetEnabledWhenLeader implements Processor.
 func (p *processorImpl) GetEnabledWhenLeader() EnabledWhenLeaderLevel {
 	return EnabledWhenLeaderLevel(p.enabledWhenLeader.Load())
 }
 
+// GetLeaderNotInReplicasDuration implements Processor.
+func (p *processorImpl) GetLeaderNotInReplicasDuration() time.Duration {
+	return time.Duration(p.leaderNotInReplicasDuration.Load())
+}
+
+// updateLeaderNotInReplicasProcLocked updates the duration for which the
+// leader has been known but not in the set of replicas. It must be called
+// after leaderID or leaderNodeID may have changed, and on each raft Ready
+// so that the duration is current.
+func (p *processorImpl) updateLeaderNotInReplicasProcLocked() {
+	if p.mu.destroyed || p.mu.leaderID == 0 || p.mu.leaderNodeID != 0 {
+		p.mu.leaderNotInReplicasSince = time.Time{}
+		p.leaderNotInReplicasDuration.Store(0)
+		return
+	}
+	now := p.opts.Clock.PhysicalTime()
+	if p.mu.leaderNotInReplicasSince.IsZero() {
+		p.mu.leaderNotInReplicasSince = now
+	}
+	p.leaderNotInReplicasDuration.Store(int64(now.Sub(p.mu.leaderNotInReplicasSince)))
+}
+
 func descToReplicaSet(desc *roachpb.RangeDescriptor) rac2.ReplicaSet {
 	rs := rac2.ReplicaSet{}
 	for _, r := range desc.InternalReplicas {
@@ -525,8 +1103,38 @@ func (p *processorImpl) OnDescChangedLocked(ctx context.Context, desc *roachpb.R
 		// RaftNode.
 		p.raftMu.raftNode = p.opts.Replica.RaftNodeMuLocked()
 	}
+	if buildutil.CrdbTestBuild && desc.Generation < p.raftMu.descGeneration {
+		panic(errors.AssertionFailedf("descriptor generation regressed from %d to %d",
+			p.raftMu.descGeneration, desc.Generation))
+	}
+	p.raftMu.descGeneration = desc.Generation
 	p.raftMu.replicas = descToReplicaSet(desc)
 	p.raftMu.replicasChanged = true
+	p.raftMu.replicasSinceReady = append(p.raftMu.replicasSinceReady, p.raftMu.replicas)
+	// NB: we cannot acquire mu since Replica.mu is held. It is safe to read
+	// leader.rc since raftMu is held.
+	if rc := p.mu.leader.rc; rc != nil {
+		rc.SetVotersForWaitForEvalRaftMuLocked(ctx, p.raftMu.replicas)
+	}
+}
+
+// OnLeaseChangedRaftMuLocked implements Processor.
+func (p *processorImpl) OnLeaseChangedRaftMuLocked(
+	ctx context.Context, leaseholderID roachpb.ReplicaID,
+) {
+	p.opts.Replica.RaftMuAssertHeld()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mu.destroyed || leaseholderID == p.mu.leaseholderID {
+		return
+	}
+	// NB: the leaseholder is stored even if this replica is not the leader,
+	// so that the next HandleRaftReadyRaftMuLocked does not consider it a
+	// change.
+	p.mu.leaseholderID = leaseholderID
+	if p.mu.leader.rc != nil {
+		p.mu.leader.rc.SetLeaseholderRaftMuLocked(ctx, leaseholderID)
+	}
 }
 
 // makeStateConsistentRaftMuLockedProcLocked, uses the union of the latest
@@ -543,9 +1151,17 @@ func (p *processorImpl) makeStateConsistentRaftMuLockedProcLocked(
 	leaseholderID roachpb.ReplicaID,
 	myLeaderTerm uint64,
 ) {
+	// The duration is updated even in the common case below, where nothing
+	// changed, so that it keeps increasing while the state persists.
+	defer p.updateLeaderNotInReplicasProcLocked()
 	replicasChanged := p.raftMu.replicasChanged
 	if replicasChanged {
 		p.raftMu.replicasChanged = false
+		if n := len(p.raftMu.replicasSinceReady); n > 1 {
+			log.VInfof(ctx, 1, "coalesced %d replica set changes into %v",
+				n, p.raftMu.replicas)
+		}
+		p.raftMu.replicasSinceReady = nil
 	}
 	if !replicasChanged && leaderID == p.mu.leaderID && leaseholderID == p.mu.leaseholderID &&
 		(p.mu.leader.rc == nil || p.mu.leader.term == myLeaderTerm) {
@@ -554,8 +1170,12 @@ func (p *processorImpl) makeStateConsistentRaftMuLockedProcLocked(
 	}
 	// The leader or leaseholder or replicas or myLeaderTerm changed. We set
 	// everything.
+	if leaderID != p.mu.leaderID {
+		p.opts.Metrics.LeaderTransitions.Inc(1)
+	}
 	p.mu.leaderID = leaderID
 	p.mu.leaseholderID = leaseholderID
+	p.updateFollowerLeaderProtocolMetricsProcLocked()
 	// Set leaderNodeID, leaderStoreID.
 	if p.mu.leaderID == 0 {
 		p.mu.leaderNodeID = 0
@@ -567,7 +1187,8 @@ func (p *processorImpl) makeStateConsistentRaftMuLockedProcLocked(
 				// Is leader, but not in the set of replicas. We expect this
 				// should not be happening anymore, due to
 				// raft.Config.StepDownOnRemoval being set to true. But we
-				// tolerate it.
+				// tolerate it, by deferring the creation of the
+				// RangeController until the descriptor catches up.
 				log.Errorf(ctx,
 					"leader=%d is not in the set of replicas=%v",
 					leaderID, p.raftMu.replicas)
@@ -586,6 +1207,7 @@ func (p *processorImpl) makeStateConsistentRaftMuLockedProcLocked(
 		}
 	}
 	if p.mu.leaderID != p.opts.ReplicaID {
+		p.mu.leader.pendingCreation = false
 		if p.mu.leader.rc != nil {
 			// Transition from leader to follower.
 			p.closeLeaderStateRaftMuLockedProcLocked(ctx)
@@ -597,11 +1219,22 @@ func (p *processorImpl) makeStateConsistentRaftMuLockedProcLocked(
 		return
 	}
 	if p.mu.leader.rc != nil && myLeaderTerm > p.mu.leader.term {
-		// Need to recreate the RangeController.
+		// Need to recreate the RangeController. Frequent recreations indicate
+		// an election storm, which affects flow control.
+		p.opts.Metrics.RangeControllerTermRecreations.Inc(1)
+		if p.rangeControllerRecreation.ShouldLog() {
+			log.Infof(ctx, "recreating range controller for r%s since the leader term advanced from %d to %d",
+				p.opts.RangeID, p.mu.leader.term, myLeaderTerm)
+		}
 		p.closeLeaderStateRaftMuLockedProcLocked(ctx)
 	}
 	if p.mu.leader.rc == nil {
-		p.createLeaderStateRaftMuLockedProcLocked(myLeaderTerm, nextUnstableIndex)
+		if p.mu.leader.pendingCreation && !replicasChanged {
+			// Creation was deferred until the replicas include this replica,
+			// and they have not changed.
+			return
+		}
+		p.createLeaderStateRaftMuLockedProcLocked(ctx, myLeaderTerm, nextUnstableIndex)
 		return
 	}
 	// Existing RangeController.
@@ -614,26 +1247,50 @@ func (p *processorImpl) makeStateConsistentRaftMuLockedProcLocked(
 }
 
 func (p *processorImpl) closeLeaderStateRaftMuLockedProcLocked(ctx context.Context) {
+	p.mu.leader.pendingCreation = false
+	p.mu.leader.consecutiveRaftEventErrors = 0
 	if p.mu.leader.rc == nil {
 		return
 	}
 	p.mu.leader.rc.CloseRaftMuLocked(ctx)
 	p.mu.leader.rc = nil
+	p.updateWaitingForEvalMetricProcLocked(0)
 	p.mu.leader.enqueuedPiggybackedResponses = nil
+	p.mu.leader.enqueuedPiggybackedResponsesBytes = 0
 	p.mu.leader.term = 0
 }
 
+// createLeaderStateRaftMuLockedProcLocked creates the RangeController. If
+// that fails, the error is logged and rc stays nil, so the replica behaves
+// as a leader that is not using the v2 protocol. Creation is attempted again
+// on a subsequent change to the leader, leaseholder or replicas.
 func (p *processorImpl) createLeaderStateRaftMuLockedProcLocked(
-	term uint64, nextUnstableIndex uint64,
+	ctx context.Context, term uint64, nextUnstableIndex uint64,
 ) {
 	if p.mu.leader.rc != nil {
 		panic("RangeController already exists")
 	}
-	p.mu.leader.rc = p.opts.RangeControllerFactory.New(rangeControllerInitState{
+	if _, ok := p.raftMu.replicas[p.opts.ReplicaID]; !ok {
+		// Creating the RangeController with a replica set that does not
+		// include the leader would account for tokens against the wrong set
+		// of replicas. Wait for the next OnDescChangedLocked, after which the
+		// next Ready will retry.
+		log.VInfof(ctx, 1, "deferring RangeController creation since leader=%d is not in replicas=%v",
+			p.opts.ReplicaID, p.raftMu.replicas)
+		p.mu.leader.pendingCreation = true
+		return
+	}
+	p.mu.leader.pendingCreation = false
+	rc, err := p.opts.RangeControllerFactory.New(rangeControllerInitState{
 		replicaSet:    p.raftMu.replicas,
 		leaseholder:   p.mu.leaseholderID,
 		nextRaftIndex: nextUnstableIndex,
 	})
+	if err != nil {
+		log.Errorf(ctx, "error creating RangeController: %v", err)
+		return
+	}
+	p.mu.leader.rc = rc
 	p.mu.leader.term = term
 	p.mu.leader.enqueuedPiggybackedResponses = map[roachpb.ReplicaID]raftpb.Message{}
 }
@@ -641,6 +1298,18 @@ func (p *processorImpl) createLeaderStateRaftMuLockedProcLocked(
 // HandleRaftReadyRaftMuLocked implements Processor.
 func (p *processorImpl) HandleRaftReadyRaftMuLocked(ctx context.Context, entries []raftpb.Entry) {
 	p.opts.Replica.RaftMuAssertHeld()
+	// The leader change, if any, is reported after p.mu is released, since
+	// deferred functions are run in LIFO order.
+	var leaderChange struct {
+		changed  bool
+		old, new roachpb.ReplicaID
+		term     uint64
+	}
+	defer func() {
+		if leaderChange.changed {
+			p.opts.OnLeaderChange(leaderChange.old, leaderChange.new, leaderChange.term)
+		}
+	}()
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	if p.mu.destroyed {
@@ -674,6 +1343,16 @@ func (p *processorImpl) HandleRaftReadyRaftMuLocked(ctx context.Context, entries
 		if leaderID == p.opts.ReplicaID {
 			myLeaderTerm = p.raftMu.raftNode.MyLeaderTermLocked()
 		}
+		if p.opts.OnLeaderChange != nil && leaderID != p.mu.leaderID {
+			leaderChange.changed = true
+			leaderChange.old = p.mu.leaderID
+			leaderChange.new = leaderID
+			if leaderID == p.opts.ReplicaID {
+				leaderChange.term = myLeaderTerm
+			} else {
+				leaderChange.term = p.raftMu.raftNode.TermLocked()
+			}
+		}
 	}()
 	if len(entries) > 0 {
 		nextUnstableIndex = entries[0].Index
@@ -690,27 +1369,206 @@ func (p *processorImpl) HandleRaftReadyRaftMuLocked(ctx context.Context, entries
 	// If there was a recent MsgStoreAppendResp that triggered this Ready
 	// processing, it has already been stepped, so the stable index would have
 	// advanced. So this is an opportune place to do Admitted processing.
-	nextAdmitted := p.mu.waitingForAdmissionState.computeAdmitted(stableIndex)
-	if admittedIncreased(admitted, nextAdmitted) {
-		p.opts.Replica.MuLock()
-		msgResp := p.raftMu.raftNode.SetAdmittedLocked(nextAdmitted)
-		p.opts.Replica.MuUnlock()
-		if p.mu.leader.rc == nil && p.mu.leaderNodeID != 0 {
-			// Follower, and know leaderNodeID, leaderStoreID.
-			p.opts.AdmittedPiggybacker.AddMsgAppRespForLeader(
-				p.mu.leaderNodeID, p.mu.leaderStoreID, p.opts.RangeID, msgResp)
-		}
-		// Else if the local replica is the leader, we have already told it
-		// about the update by calling SetAdmittedLocked. If the leader is not
-		// known, we simply drop the message.
-	}
+	p.maybeAdvanceAdmittedRaftMuLockedProcLocked(ctx, stableIndex, admitted)
+	p.maybeLogAdmissionStallProcLocked(ctx)
 	if p.mu.leader.rc != nil {
+		ctx, sp := childSpanIfRecording(ctx, "replica_rac2.handle-raft-event")
+		defer sp.Finish()
+		log.Eventf(ctx, "handling raft event with %d entries", len(entries))
 		if err := p.mu.leader.rc.HandleRaftEventRaftMuLocked(ctx, rac2.RaftEvent{
 			Entries: entries,
 		}); err != nil {
 			log.Errorf(ctx, "error handling raft event: %v", err)
+			nextRaftIndex := nextUnstableIndex
+			if n := len(entries); n > 0 {
+				nextRaftIndex = entries[n-1].Index + 1
+			}
+			p.raftEventErrorRaftMuLockedProcLocked(ctx, err, nextRaftIndex)
+		} else {
+			p.mu.leader.consecutiveRaftEventErrors = 0
+		}
+	}
+	// The RangeController may have been closed above.
+	if p.mu.leader.rc != nil {
+		p.updateWaitingForEvalMetricProcLocked(
+			p.mu.leader.rc.WaitForEvalStatsRaftMuLocked().NumWaiting)
+	}
+}
+
+// maybeLogAdmissionStallProcLocked logs the entry that has been waiting for
+// admission the longest, if it has been waiting for longer than
+// admissionStallLogThreshold. The logging is rate limited, so that a stalled
+// range is reported periodically.
+func (p *processorImpl) maybeLogAdmissionStallProcLocked(ctx context.Context) {
+	threshold := admissionStallLogThreshold.Get(&p.opts.Settings.SV)
+	if threshold == 0 {
+		return
+	}
+	entry, pri, ok := p.mu.waitingForAdmissionState.oldest()
+	if !ok {
+		return
+	}
+	age := time.Duration(p.opts.Clock.PhysicalNow() - entry.addedAt)
+	if age < threshold || !p.admissionStall.ShouldLog() {
+		return
+	}
+	log.Warningf(ctx, "admission stalled: oldest waiting entry index=%d pri=%s "+
+		"leader-term=%d waiting=%s num-waiting=%d",
+		entry.index, pri, entry.leaderTerm, age, p.mu.waitingForAdmissionState.len())
+}
+
+// raftEventErrorRaftMuLockedProcLocked is called when
+// rc.HandleRaftEventRaftMuLocked returns an error. After
+// rangeControllerErrorThreshold consecutive errors, the RangeController is
+// closed and recreated with the same term, instead of leaving the range
+// wedged. nextRaftIndex is the index of the next entry that will be provided
+// to the RangeController.
+func (p *processorImpl) raftEventErrorRaftMuLockedProcLocked(
+	ctx context.Context, err error, nextRaftIndex uint64,
+) {
+	p.mu.leader.consecutiveRaftEventErrors++
+	threshold := rangeControllerErrorThreshold.Get(&p.opts.Settings.SV)
+	if threshold == 0 || p.mu.leader.consecutiveRaftEventErrors < threshold {
+		return
+	}
+	term := p.mu.leader.term
+	log.Warningf(ctx, "recreating RangeController at term %d after %d consecutive errors: %v",
+		term, p.mu.leader.consecutiveRaftEventErrors, err)
+	p.opts.Metrics.RangeControllerRecreated.Inc(1)
+	p.closeLeaderStateRaftMuLockedProcLocked(ctx)
+	p.createLeaderStateRaftMuLockedProcLocked(ctx, term, nextRaftIndex)
+}
+
+// childSpanIfRecording returns a child span of the span in ctx, if that span
+// is recording. Otherwise it returns ctx and a nil span, which is safe to
+// Finish, to avoid the overhead of creating spans in the common case.
+func childSpanIfRecording(ctx context.Context, opName string) (context.Context, *tracing.Span) {
+	if tracing.SpanFromContext(ctx).RecordingType() == tracingpb.RecordingOff {
+		return ctx, nil
+	}
+	return tracing.ChildSpan(ctx, opName)
+}
+
+// setAdmittedIfAdvancedRaftMuLockedProcLocked computes the admitted array
+// implied by the stable index and the entries waiting for admission, and if
+// it advances the current admitted array, sets it in the RaftNode and returns
+// the new admitted array and the resulting MsgAppResp. Replica.mu is only
+// acquired if admitted advances, which is not the case for most Ready
+// iterations of a range with a steady stream of writes.
+func (p *processorImpl) setAdmittedIfAdvancedRaftMuLockedProcLocked(
+	ctx context.Context, stableIndex uint64, admitted [raftpb.NumPriorities]uint64,
+) (nextAdmitted [raftpb.NumPriorities]uint64, msgResp raftpb.Message, advanced bool) {
+	func() {
+		ctx, sp := childSpanIfRecording(ctx, "replica_rac2.compute-admitted")
+		defer sp.Finish()
+		nextAdmitted, advanced =
+			p.mu.waitingForAdmissionState.computeAdmitted(stableIndex, admitted)
+		if advanced {
+			log.Eventf(ctx, "admitted advanced from %v to %v (stable index %d)",
+				admitted, nextAdmitted, stableIndex)
 		}
+	}()
+	if !advanced {
+		return nextAdmitted, raftpb.Message{}, false
+	}
+	if buildutil.CrdbTestBuild {
+		// SetAdmittedLocked requires that admitted does not advance beyond the
+		// stable index.
+		for pri := range nextAdmitted {
+			if nextAdmitted[pri] > stableIndex {
+				panic(errors.AssertionFailedf("admitted %d for %s exceeds stable index %d",
+					nextAdmitted[pri], raftpb.Priority(pri), stableIndex))
+			}
+		}
+	}
+	_, sp := childSpanIfRecording(ctx, "replica_rac2.set-admitted")
+	defer sp.Finish()
+	p.opts.Replica.MuLock()
+	defer p.opts.Replica.MuUnlock()
+	return nextAdmitted, p.raftMu.raftNode.SetAdmittedLocked(nextAdmitted), true
+}
+
+// maybeAdvanceAdmittedRaftMuLockedProcLocked advances admitted in the
+// RaftNode, if it is behind what is implied by the stable index and the
+// entries waiting for admission. Must only be called when the leader is
+// using the v2 protocol.
+func (p *processorImpl) maybeAdvanceAdmittedRaftMuLockedProcLocked(
+	ctx context.Context, stableIndex uint64, admitted [raftpb.NumPriorities]uint64,
+) {
+	nextAdmitted, msgResp, advanced :=
+		p.setAdmittedIfAdvancedRaftMuLockedProcLocked(ctx, stableIndex, admitted)
+	if advanced {
+		if p.mu.leader.rc == nil {
+			if p.mu.leaderNodeID != 0 {
+				// Follower, and know leaderNodeID, leaderStoreID.
+				ctx, sp := childSpanIfRecording(ctx, "replica_rac2.piggyback-admitted")
+				if p.shouldSendAdmittedDirectProcLocked(admitted, nextAdmitted) &&
+					p.opts.AdmittedPiggybacker.MaybeSendDirect(
+						p.mu.leaderNodeID, p.mu.leaderStoreID, p.opts.RangeID, msgResp) {
+					log.Eventf(ctx, "sent admitted %v directly to leader (n%s,s%s)",
+						nextAdmitted, p.mu.leaderNodeID, p.mu.leaderStoreID)
+					p.opts.Metrics.AdmittedResponsesSentDirect.Inc(1)
+				} else {
+					log.Eventf(ctx, "enqueueing admitted %v for leader (n%s,s%s)",
+						nextAdmitted, p.mu.leaderNodeID, p.mu.leaderStoreID)
+					p.opts.AdmittedPiggybacker.AddMsgAppRespForLeader(
+						p.mu.leaderNodeID, p.mu.leaderStoreID, p.opts.RangeID, msgResp)
+					p.opts.Metrics.PiggybackedResponsesEnqueued.Inc(1)
+				}
+				sp.Finish()
+			} else {
+				// The leader is not known, so we simply drop the message.
+				p.opts.Metrics.PiggybackedResponsesDropped.Inc(1)
+				if p.droppedAdmittedUnknownLeader.ShouldLog() {
+					log.Warningf(ctx,
+						"dropping admitted %v for r%s since the leader is unknown",
+						nextAdmitted, p.opts.RangeID)
+				}
+			}
+		}
+		// Else the local replica is the leader, and we have already told it
+		// about the update by calling SetAdmittedLocked.
+	}
+}
+
+// shouldSendAdmittedDirectProcLocked returns true if the MsgAppResp for
+// admitted advancing from prev to next should be sent directly to the
+// leader. See ProcessorOptions.DirectSendAdmitted and
+// ProcessorOptions.DisablePiggybacking.
+func (p *processorImpl) shouldSendAdmittedDirectProcLocked(
+	prev, next [raftpb.NumPriorities]uint64,
+) bool {
+	if p.opts.DisablePiggybacking {
+		return true
+	}
+	if !p.opts.DirectSendAdmitted || p.mu.waitingForAdmissionState.len() > 0 {
+		return false
+	}
+	for pri := range next {
+		if next[pri]-prev[pri] >= p.opts.DirectSendAdmittedLagThreshold {
+			return true
+		}
+	}
+	return false
+}
+
+// OnSnapshotAppliedRaftMuLocked implements Processor.
+func (p *processorImpl) OnSnapshotAppliedRaftMuLocked(ctx context.Context, snapIndex uint64) {
+	p.opts.Replica.RaftMuAssertHeld()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mu.destroyed || p.raftMu.raftNode == nil {
+		return
+	}
+	p.mu.follower.lowPriOverrideState.purgeUpTo(snapIndex)
+	if !p.mu.waitingForAdmissionState.purgeUpTo(snapIndex) {
+		return
 	}
+	p.updateWaitingForAdmissionMetricProcLocked()
+	if p.mu.leader.rc == nil && !p.mu.follower.isLeaderUsingV2Protocol {
+		return
+	}
+	p.advanceAdmittedRaftMuLockedProcLocked(ctx)
 }
 
 // AdmitRaftEntriesFromMsgStorageAppendRaftMuLocked implements Processor.
@@ -729,19 +1587,94 @@ func (p *processorImpl) AdmitRaftEntriesFromMsgStorageAppendRaftMuLocked(
 	if !isLeaderUsingV2Protocol {
 		return false
 	}
+	p.admitRaftEntriesRaftMuLocked(ctx, leaderTerm, entries, [raftpb.NumPriorities]uint64{})
+	return true
+}
+
+// SetDrainingRaftMuLocked implements Processor.
+func (p *processorImpl) SetDrainingRaftMuLocked(draining bool) {
+	p.opts.Replica.RaftMuAssertHeld()
+	p.raftMu.draining = draining
+}
+
+// RebuildWaitingStateRaftMuLocked implements Processor.
+func (p *processorImpl) RebuildWaitingStateRaftMuLocked(
+	ctx context.Context, entries []raftpb.Entry,
+) {
+	p.opts.Replica.RaftMuAssertHeld()
+	var leaderTerm uint64
+	rebuild := func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.mu.destroyed || p.raftMu.raftNode == nil {
+			return false
+		}
+		if n := p.mu.waitingForAdmissionState.len(); n > 0 {
+			log.Errorf(ctx, "not rebuilding since %d entries are already waiting for admission", n)
+			return false
+		}
+		if p.mu.leader.rc != nil {
+			leaderTerm = p.mu.leader.term
+		} else if p.mu.follower.isLeaderUsingV2Protocol {
+			leaderTerm = p.mu.follower.lowPriOverrideState.leaderTerm
+		} else {
+			return false
+		}
+		return true
+	}()
+	if !rebuild {
+		return
+	}
+	var admitted [raftpb.NumPriorities]uint64
+	func() {
+		p.opts.Replica.MuLock()
+		defer p.opts.Replica.MuUnlock()
+		admitted = p.raftMu.raftNode.GetAdmittedLocked()
+	}()
+	p.admitRaftEntriesRaftMuLocked(ctx, leaderTerm, entries, admitted)
+}
+
+// admitRaftEntriesRaftMuLocked submits the entries for admission, at the
+// given leaderTerm, skipping the entries that are already admitted, i.e.,
+// have an index <= alreadyAdmitted for their (effective) priority.
+func (p *processorImpl) admitRaftEntriesRaftMuLocked(
+	ctx context.Context,
+	leaderTerm uint64,
+	entries []raftpb.Entry,
+	alreadyAdmitted [raftpb.NumPriorities]uint64,
+) {
+	tolerate := tolerateDecodeErrors.Get(&p.opts.Settings.SV)
 	for _, entry := range entries {
+		if ctx.Err() != nil {
+			// The store is shutting down, so stop submitting the remaining
+			// entries. They are not added to waitingForAdmissionState, and
+			// will not hold up admitted.
+			log.VInfof(ctx, 1, "not submitting entries starting at index %d for admission: %v",
+				entry.Index, ctx.Err())
+			return
+		}
 		typ, priBits, err := raftlog.EncodingOf(entry)
 		if err != nil {
-			panic(errors.Wrap(err, "unable to determine raft command encoding"))
+			err = errors.Wrap(err, "unable to determine raft command encoding")
+			if !tolerate {
+				panic(err)
+			}
+			p.skipUndecodableEntry(ctx, entry, err)
+			continue
 		}
 		if !typ.UsesAdmissionControl() {
 			continue // nothing to do
 		}
 		isV2Encoding := typ == raftlog.EntryEncodingStandardWithACAndPriority ||
 			typ == raftlog.EntryEncodingSideloadedWithACAndPriority
-		meta, err := raftlog.DecodeRaftAdmissionMeta(entry.Data)
+		meta, err := p.decodeRaftAdmissionMeta(entry)
 		if err != nil {
-			panic(errors.Wrap(err, "unable to decode raft command admission data: %v"))
+			err = errors.Wrap(err, "unable to decode raft command admission data")
+			if !tolerate {
+				panic(err)
+			}
+			p.skipUndecodableEntry(ctx, entry, err)
+			continue
 		}
 		var raftPri raftpb.Priority
 		if isV2Encoding {
@@ -749,46 +1682,149 @@ func (p *processorImpl) AdmitRaftEntriesFromMsgStorageAppendRaftMuLocked(
 			if raftPri != priBits {
 				panic(errors.AssertionFailedf("inconsistent priorities %s, %s", raftPri, priBits))
 			}
-			func() {
-				p.mu.Lock()
-				defer p.mu.Unlock()
-				raftPri = p.mu.follower.lowPriOverrideState.getEffectivePriority(entry.Index, raftPri)
-				p.mu.waitingForAdmissionState.add(leaderTerm, entry.Index, raftPri)
-			}()
 		} else {
 			raftPri = raftpb.LowPri
 			if admissionpb.WorkClassFromPri(admissionpb.WorkPriority(meta.AdmissionPriority)) ==
-				admissionpb.RegularWorkClass && p.v1EncodingPriorityMismatch.ShouldLog() {
-				log.Errorf(ctx,
-					"do not use RACv1 for pri %s, which is regular work",
-					admissionpb.WorkPriority(meta.AdmissionPriority))
+				admissionpb.RegularWorkClass {
+				p.opts.Metrics.V1EncodingRegularPriority.Inc(1)
+				if p.v1EncodingPriorityMismatch.ShouldLog() {
+					log.Errorf(ctx,
+						"do not use RACv1 for pri %s, which is regular work (r%s, replica %s, index %d)",
+						admissionpb.WorkPriority(meta.AdmissionPriority), p.opts.RangeID,
+						p.opts.ReplicaID, entry.Index)
+				}
 			}
-			func() {
-				p.mu.Lock()
-				defer p.mu.Unlock()
-				p.mu.waitingForAdmissionState.add(leaderTerm, entry.Index, raftPri)
-			}()
 		}
-		admissionPri := rac2.RaftToAdmissionPriority(raftPri)
-		// NB: cannot hold mu when calling Admit since the callback may
-		// execute from inside Admit, when the entry is immediately admitted.
-		p.opts.ACWorkQueue.Admit(ctx, EntryForAdmission{
+		alreadyAdmittedEntry := func() bool {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if isV2Encoding {
+				raftPri = p.mu.follower.lowPriOverrideState.getEffectivePriority(entry.Index, raftPri)
+			}
+			if entry.Index <= alreadyAdmitted[raftPri] {
+				return true
+			}
+			p.mu.waitingForAdmissionState.add(
+				leaderTerm, entry.Index, raftPri, p.opts.Clock.PhysicalNow())
+			p.updateWaitingForAdmissionMetricProcLocked()
+			return false
+		}()
+		if alreadyAdmittedEntry {
+			continue
+		}
+		if p.raftMu.draining {
+			// The entry is tracked as waiting for admission, but is not
+			// submitted. See SetDrainingRaftMuLocked.
+			continue
+		}
+		var admissionPri admissionpb.WorkPriority
+		if p.opts.PriorityMapper != nil {
+			admissionPri = p.opts.PriorityMapper(raftPri)
+		} else {
+			admissionPri = rac2.RaftToAdmissionPriority(raftPri)
+		}
+		if floor, ok := p.opts.TenantPriorityFloors[p.opts.TenantID]; ok && admissionPri < floor {
+			admissionPri = floor
+		}
+		entryForAdmission := EntryForAdmission{
 			TenantID:       p.opts.TenantID,
 			Priority:       admissionPri,
 			CreateTime:     meta.AdmissionCreateTime,
 			RequestedCount: int64(len(entry.Data)),
 			Ingested:       typ.IsSideloaded(),
 			CallbackState: EntryForAdmissionCallbackState{
-				StoreID:    p.opts.StoreID,
-				RangeID:    p.opts.RangeID,
-				ReplicaID:  p.opts.ReplicaID,
-				LeaderTerm: leaderTerm,
-				Index:      entry.Index,
-				Priority:   raftPri,
+				StoreID:     p.opts.StoreID,
+				RangeID:     p.opts.RangeID,
+				ReplicaID:   p.opts.ReplicaID,
+				LeaderTerm:  leaderTerm,
+				Index:       entry.Index,
+				Priority:    raftPri,
+				EnqueueTime: p.opts.Clock.PhysicalNow(),
 			},
-		})
+		}
+		p.clampRequestedCount(ctx, &entryForAdmission)
+		// NB: cannot hold mu when calling Admit since the callback may
+		// execute from inside Admit, when the entry is immediately admitted.
+		outcome, err := p.opts.ACWorkQueue.Admit(ctx, entryForAdmission)
+		if err != nil {
+			// The context was canceled, so the store is shutting down. Like the
+			// remaining entries, which are not submitted, the entry stops
+			// waiting for admission, and will not hold up admitted.
+			log.VInfof(ctx, 1, "entry at index %d not admitted: %v", entry.Index, err)
+			p.stopWaitingForAdmission(ctx, entryForAdmission.CallbackState, false /* rejected */)
+			return
+		}
+		if outcome == AdmissionRejected {
+			p.admissionRejected(ctx, entryForAdmission)
+		}
 	}
-	return true
+}
+
+// clampRequestedCount clamps a negative RequestedCount to zero, so that a
+// corrupt entry does not cause negative accounting in admission control.
+func (p *processorImpl) clampRequestedCount(ctx context.Context, entry *EntryForAdmission) {
+	if entry.RequestedCount >= 0 {
+		return
+	}
+	p.opts.Metrics.NegativeRequestedCount.Inc(1)
+	if p.negativeRequestedCount.ShouldLog() {
+		log.Warningf(ctx, "entry at index %d requested %d admission tokens, clamping to 0",
+			entry.CallbackState.Index, entry.RequestedCount)
+	}
+	entry.RequestedCount = 0
+}
+
+// admissionRejected is called when ACWorkQueue rejects an entry. Since the
+// entry will never be admitted, it stops waiting for admission, so that it
+// does not hold up admitted indefinitely.
+func (p *processorImpl) admissionRejected(ctx context.Context, entry EntryForAdmission) {
+	p.stopWaitingForAdmission(ctx, entry.CallbackState, true /* rejected */)
+	if p.opts.OnAdmissionRejected != nil {
+		p.opts.OnAdmissionRejected(entry)
+	}
+}
+
+// stopWaitingForAdmission removes an entry that will never be admitted from
+// waitingForAdmissionState. The entry was either rejected by ACWorkQueue, or
+// not admitted since the context was canceled.
+func (p *processorImpl) stopWaitingForAdmission(
+	ctx context.Context, state EntryForAdmissionCallbackState, rejected bool,
+) {
+	var processInline bool
+	func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.mu.destroyed {
+			return
+		}
+		if rejected {
+			p.opts.Metrics.AdmissionRejected.Inc(1)
+		}
+		processInline = p.removeWaitingForAdmissionProcLocked(state)
+	}()
+	if processInline {
+		p.processAdmittedRaftMuLocked(ctx)
+	}
+}
+
+// decodeRaftAdmissionMeta decodes the admission metadata of the entry, using
+// the testing knob, if any.
+func (p *processorImpl) decodeRaftAdmissionMeta(
+	entry raftpb.Entry,
+) (kvflowcontrolpb.RaftAdmissionMeta, error) {
+	if buildutil.CrdbTestBuild && p.opts.Knobs != nil && p.opts.Knobs.DecodeRaftAdmissionMeta != nil {
+		return p.opts.Knobs.DecodeRaftAdmissionMeta(entry)
+	}
+	return raftlog.DecodeRaftAdmissionMeta(entry.Data)
+}
+
+// skipUndecodableEntry is called when the entry cannot be decoded, and
+// tolerateDecodeErrors is true. The entry is not subjected to admission
+// control, and admitted will not wait for it.
+func (p *processorImpl) skipUndecodableEntry(ctx context.Context, entry raftpb.Entry, err error) {
+	log.Errorf(ctx, "skipping admission of entry at index %d, term %d: %v",
+		entry.Index, entry.Term, err)
+	p.opts.Metrics.SkippedUndecodableEntries.Inc(1)
 }
 
 // EnqueuePiggybackedAdmittedAtLeader implements Processor.
@@ -803,7 +1839,19 @@ func (p *processorImpl) EnqueuePiggybackedAdmittedAtLeader(msg raftpb.Message) {
 		return
 	}
 	// Only need to keep the latest message from a replica.
-	p.mu.leader.enqueuedPiggybackedResponses[roachpb.ReplicaID(msg.From)] = msg
+	from := roachpb.ReplicaID(msg.From)
+	size := int64(msg.Size())
+	var prevSize int64
+	if prev, ok := p.mu.leader.enqueuedPiggybackedResponses[from]; ok {
+		prevSize = int64(prev.Size())
+	}
+	bytes := p.mu.leader.enqueuedPiggybackedResponsesBytes - prevSize + size
+	if limit := p.opts.MaxEnqueuedPiggybackedResponsesBytes; limit > 0 && bytes > limit {
+		p.opts.Metrics.EnqueuedPiggybackedResponsesDropped.Inc(1)
+		return
+	}
+	p.mu.leader.enqueuedPiggybackedResponses[from] = msg
+	p.mu.leader.enqueuedPiggybackedResponsesBytes = bytes
 }
 
 // ProcessPiggybackedAdmittedAtLeaderRaftMuLocked implements Processor.
@@ -811,8 +1859,23 @@ func (p *processorImpl) ProcessPiggybackedAdmittedAtLeaderRaftMuLocked(ctx conte
 	p.opts.Replica.RaftMuAssertHeld()
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.mu.destroyed || len(p.mu.leader.enqueuedPiggybackedResponses) == 0 || p.raftMu.raftNode == nil {
-		return false
+	return p.stepPiggybackedAdmittedRaftMuLockedProcLocked(ctx) > 0
+}
+
+// FlushPiggybackedAdmittedRaftMuLocked implements Processor.
+func (p *processorImpl) FlushPiggybackedAdmittedRaftMuLocked(ctx context.Context) int {
+	p.opts.Replica.RaftMuAssertHeld()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stepPiggybackedAdmittedRaftMuLockedProcLocked(ctx)
+}
+
+// stepPiggybackedAdmittedRaftMuLockedProcLocked steps all the enqueued
+// piggybacked MsgAppResps, and returns the number of messages stepped.
+func (p *processorImpl) stepPiggybackedAdmittedRaftMuLockedProcLocked(ctx context.Context) int {
+	n := len(p.mu.leader.enqueuedPiggybackedResponses)
+	if p.mu.destroyed || n == 0 || p.raftMu.raftNode == nil {
+		return 0
 	}
 	p.opts.Replica.MuLock()
 	defer p.opts.Replica.MuUnlock()
@@ -823,7 +1886,8 @@ func (p *processorImpl) ProcessPiggybackedAdmittedAtLeaderRaftMuLocked(ctx conte
 		}
 		delete(p.mu.leader.enqueuedPiggybackedResponses, k)
 	}
-	return true
+	p.mu.leader.enqueuedPiggybackedResponsesBytes = 0
+	return n
 }
 
 // SideChannelForPriorityOverrideAtFollowerRaftMuLocked implements Processor.
@@ -836,6 +1900,12 @@ func (p *processorImpl) SideChannelForPriorityOverrideAtFollowerRaftMuLocked(
 	if p.mu.destroyed {
 		return
 	}
+	if p.mu.follower.lowPriOverrideState.isStaleTerm(info.LeaderTerm) {
+		// A reordered message from a stale leader. It must not change what we
+		// know about the current leader's protocol.
+		p.opts.Metrics.StaleSideChannelInfoIgnored.Inc(1)
+		return
+	}
 	if info.UsingV2Protocol {
 		if p.mu.follower.lowPriOverrideState.sideChannelForLowPriOverride(
 			info.LeaderTerm, info.First, info.Last, info.LowPriOverride) &&
@@ -852,37 +1922,280 @@ func (p *processorImpl) SideChannelForPriorityOverrideAtFollowerRaftMuLocked(
 			p.mu.follower.isLeaderUsingV2Protocol = false
 		}
 	}
+	p.updateFollowerLeaderProtocolMetricsProcLocked()
+}
+
+// GetLeaderTermRaftMuLocked implements Processor.
+func (p *processorImpl) GetLeaderTermRaftMuLocked() uint64 {
+	p.opts.Replica.RaftMuAssertHeld()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mu.leader.rc != nil {
+		return p.mu.leader.term
+	}
+	return p.mu.follower.lowPriOverrideState.leaderTerm
+}
+
+// IsLeaderUsingV2RaftMuLocked implements Processor.
+func (p *processorImpl) IsLeaderUsingV2RaftMuLocked() bool {
+	p.opts.Replica.RaftMuAssertHeld()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.mu.destroyed &&
+		(p.mu.leader.rc != nil || p.mu.follower.isLeaderUsingV2Protocol)
+}
+
+// GetEffectivePriorityRaftMuLocked implements Processor.
+func (p *processorImpl) GetEffectivePriorityRaftMuLocked(
+	index uint64, raftPri raftpb.Priority,
+) raftpb.Priority {
+	p.opts.Replica.RaftMuAssertHeld()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mu.destroyed || p.mu.leader.rc != nil || !p.mu.follower.isLeaderUsingV2Protocol {
+		return raftPri
+	}
+	return p.mu.follower.lowPriOverrideState.peekEffectivePriority(index, raftPri)
+}
+
+// ReconcileWaitingStateRaftMuLocked implements Processor.
+func (p *processorImpl) ReconcileWaitingStateRaftMuLocked(
+	ctx context.Context, logEntries []raftpb.Entry,
+) []Discrepancy {
+	p.opts.Replica.RaftMuAssertHeld()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mu.destroyed || p.raftMu.raftNode == nil ||
+		(p.mu.leader.rc == nil && !p.mu.follower.isLeaderUsingV2Protocol) {
+		// No entries are admitted.
+		return nil
+	}
+	var admitted [raftpb.NumPriorities]uint64
+	var stableIndex uint64
+	func() {
+		// Lock ordering: this.mu < Replica.mu.
+		p.opts.Replica.MuLock()
+		defer p.opts.Replica.MuUnlock()
+		admitted = p.raftMu.raftNode.GetAdmittedLocked()
+		stableIndex = p.raftMu.raftNode.StableIndexLocked()
+	}()
+
+	// Decode the entries in the log that are subject to admission control,
+	// in the same way as admitRaftEntriesRaftMuLocked.
+	type logEntry struct {
+		pri          raftpb.Priority
+		isV2Encoding bool
+	}
+	inLog := make(map[uint64]logEntry, len(logEntries))
+	for _, entry := range logEntries {
+		if entry.Index > stableIndex {
+			continue
+		}
+		typ, priBits, err := raftlog.EncodingOf(entry)
+		if err != nil || !typ.UsesAdmissionControl() {
+			continue
+		}
+		if _, err := p.decodeRaftAdmissionMeta(entry); err != nil {
+			// Undecodable entries are not admitted, see skipUndecodableEntry.
+			continue
+		}
+		e := logEntry{
+			pri: raftpb.LowPri,
+			isV2Encoding: typ == raftlog.EntryEncodingStandardWithACAndPriority ||
+				typ == raftlog.EntryEncodingSideloadedWithACAndPriority,
+		}
+		if e.isV2Encoding {
+			e.pri = p.mu.follower.lowPriOverrideState.peekEffectivePriority(entry.Index, priBits)
+		}
+		inLog[entry.Index] = e
+	}
+	matches := func(e logEntry, pri raftpb.Priority) bool {
+		return e.pri == pri || (e.isV2Encoding && pri == raftpb.LowPri)
+	}
+
+	var discrepancies []Discrepancy
+	tracked := map[uint64]struct{}{}
+	for i := range p.mu.waitingForAdmissionState.waiting {
+		pri := raftpb.Priority(i)
+		for _, w := range p.mu.waitingForAdmissionState.waiting[i] {
+			if w.index <= admitted[pri] || w.index > stableIndex {
+				continue
+			}
+			if e, ok := inLog[w.index]; ok && matches(e, pri) {
+				tracked[w.index] = struct{}{}
+				continue
+			}
+			discrepancies = append(discrepancies,
+				Discrepancy{Kind: TrackedButAbsent, Index: w.index, Priority: pri})
+		}
+	}
+	for index, e := range inLog {
+		if _, ok := tracked[index]; ok || index <= admitted[e.pri] ||
+			(e.isV2Encoding && index <= admitted[raftpb.LowPri]) {
+			// Tracked, or may have been admitted, possibly at LowPri.
+			continue
+		}
+		discrepancies = append(discrepancies,
+			Discrepancy{Kind: AbsentButShouldTrack, Index: index, Priority: e.pri})
+	}
+	slices.SortFunc(discrepancies, func(a, b Discrepancy) int {
+		if a.Index != b.Index {
+			return cmp.Compare(a.Index, b.Index)
+		}
+		return cmp.Compare(a.Kind, b.Kind)
+	})
+	return discrepancies
 }
 
 // AdmittedLogEntry implements Processor.
 func (p *processorImpl) AdmittedLogEntry(
 	ctx context.Context, state EntryForAdmissionCallbackState,
 ) {
+	if p.recordAdmittedLogEntry(state) {
+		p.processAdmittedRaftMuLocked(ctx)
+	}
+}
+
+// recordAdmittedLogEntry does the work of AdmittedLogEntry that needs mu.
+// See removeWaitingForAdmissionProcLocked for the return value.
+func (p *processorImpl) recordAdmittedLogEntry(state EntryForAdmissionCallbackState) bool {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	if p.mu.destroyed || state.ReplicaID != p.opts.ReplicaID {
-		return
+		return false
+	}
+	p.opts.Metrics.AdmittedEntries.Inc(1)
+	// NB: the wait is recorded even when admission is immediate, i.e., this is
+	// called synchronously from within ACWorkQueue.Admit, so that the
+	// histogram is not biased towards entries that had to wait.
+	waitDuration := p.opts.Clock.PhysicalNow() - state.EnqueueTime
+	if waitDuration < 0 {
+		waitDuration = 0
 	}
+	p.opts.Metrics.AdmissionWaitDuration[rac2.WorkClassFromRaftPriority(state.Priority)].
+		RecordValue(waitDuration)
+	return p.removeWaitingForAdmissionProcLocked(state)
+}
+
+// removeWaitingForAdmissionProcLocked removes the entry from
+// waitingForAdmissionState, and schedules processing if admitted may
+// advance. It returns true if the processing must instead be done inline by
+// the caller, after releasing mu, via processAdmittedRaftMuLocked.
+func (p *processorImpl) removeWaitingForAdmissionProcLocked(
+	state EntryForAdmissionCallbackState,
+) (processInline bool) {
 	admittedMayAdvance :=
 		p.mu.waitingForAdmissionState.remove(state.LeaderTerm, state.Index, state.Priority)
+	p.updateWaitingForAdmissionMetricProcLocked()
 	if !admittedMayAdvance || state.Index > p.mu.lastObservedStableIndex ||
 		(p.mu.leader.rc == nil && !p.mu.follower.isLeaderUsingV2Protocol) {
-		return
+		return false
 	}
 	// The lastObservedStableIndex has moved at or ahead of state.Index. This
 	// will happen when admission is not immediate. In this case we need to
 	// schedule processing.
 	if !p.mu.scheduledAdmittedProcessing {
 		p.mu.scheduledAdmittedProcessing = true
+		if p.processAdmittedSynchronously() {
+			return true
+		}
 		p.opts.RaftScheduler.EnqueueRaftReady(p.opts.RangeID)
 	}
+	return false
 }
 
-func admittedIncreased(prev, next [raftpb.NumPriorities]uint64) bool {
-	for i := range prev {
-		if prev[i] < next[i] {
-			return true
-		}
+// processAdmittedSynchronously returns true if the testing knob to process
+// admitted inline, instead of scheduling it, is set.
+func (p *processorImpl) processAdmittedSynchronously() bool {
+	return buildutil.CrdbTestBuild && p.opts.Knobs != nil &&
+		p.opts.Knobs.ProcessAdmittedSynchronously
+}
+
+// processAdmittedRaftMuLocked does the scheduled processing of admitted,
+// that would otherwise be done by the next HandleRaftReadyRaftMuLocked. It
+// is only used when ProcessorTestingKnobs.ProcessAdmittedSynchronously is
+// set.
+func (p *processorImpl) processAdmittedRaftMuLocked(ctx context.Context) {
+	p.opts.Replica.RaftMuAssertHeld()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mu.destroyed || p.raftMu.raftNode == nil || !p.mu.scheduledAdmittedProcessing {
+		return
 	}
-	return false
+	p.mu.scheduledAdmittedProcessing = false
+	if p.mu.leader.rc == nil && !p.mu.follower.isLeaderUsingV2Protocol {
+		return
+	}
+	p.advanceAdmittedRaftMuLockedProcLocked(ctx)
+}
+
+// advanceAdmittedRaftMuLockedProcLocked reads the stable index and admitted
+// from the RaftNode, and advances admitted if possible. Must only be called
+// when the leader is using the v2 protocol.
+func (p *processorImpl) advanceAdmittedRaftMuLockedProcLocked(ctx context.Context) {
+	var stableIndex uint64
+	var admitted [raftpb.NumPriorities]uint64
+	func() {
+		p.opts.Replica.MuLock()
+		defer p.opts.Replica.MuUnlock()
+		stableIndex = p.raftMu.raftNode.StableIndexLocked()
+		admitted = p.raftMu.raftNode.GetAdmittedLocked()
+	}()
+	p.mu.lastObservedStableIndex = stableIndex
+	p.maybeAdvanceAdmittedRaftMuLockedProcLocked(ctx, stableIndex, admitted)
+}
+
+// GetReplicaAdmittedStateRaftMuLocked implements Processor.
+func (p *processorImpl) GetReplicaAdmittedStateRaftMuLocked() map[roachpb.ReplicaID]rac2.ReplicaAdmittedState {
+	p.opts.Replica.RaftMuAssertHeld()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mu.destroyed || p.mu.leader.rc == nil {
+		return nil
+	}
+	return p.mu.leader.rc.ReplicaAdmittedStatesRaftMuLocked()
+}
+
+// GetWaitForEvalStatsRaftMuLocked implements Processor.
+func (p *processorImpl) GetWaitForEvalStatsRaftMuLocked() rac2.WaitForEvalStats {
+	p.opts.Replica.RaftMuAssertHeld()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mu.destroyed || p.mu.leader.rc == nil {
+		return rac2.WaitForEvalStats{}
+	}
+	stats := p.mu.leader.rc.WaitForEvalStatsRaftMuLocked()
+	p.updateWaitingForEvalMetricProcLocked(stats.NumWaiting)
+	return stats
+}
+
+// WaitingForAdmissionByPriorityRaftMuLocked implements Processor.
+func (p *processorImpl) WaitingForAdmissionByPriorityRaftMuLocked() [raftpb.NumPriorities]int {
+	p.opts.Replica.RaftMuAssertHeld()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.mu.waitingForAdmissionState.countsByPriority()
+}
+
+// InspectRaftMuLocked implements Processor.
+func (p *processorImpl) InspectRaftMuLocked(ctx context.Context) ProcessorInspectState {
+	p.opts.Replica.RaftMuAssertHeld()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state := ProcessorInspectState{
+		LeaderID:                       p.mu.leaderID,
+		LeaseholderID:                  p.mu.leaseholderID,
+		LeaderNodeID:                   p.mu.leaderNodeID,
+		LastObservedStableIndex:        p.mu.lastObservedStableIndex,
+		IsLeaderUsingV2:                p.mu.leader.rc != nil || p.mu.follower.isLeaderUsingV2Protocol,
+		PendingRangeControllerCreation: p.mu.leader.pendingCreation,
+	}
+	state.NumWaitingForAdmission = p.mu.waitingForAdmissionState.len()
+	if p.raftMu.raftNode != nil {
+		// Lock ordering: this.mu < Replica.mu.
+		p.opts.Replica.MuLock()
+		defer p.opts.Replica.MuUnlock()
+		state.Admitted = p.raftMu.raftNode.GetAdmittedLocked()
+	}
+	return state
 }
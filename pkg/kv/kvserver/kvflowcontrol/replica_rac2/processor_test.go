@@ -13,9 +13,13 @@ package replica_rac2
 import (
 	"context"
 	"fmt"
+	"math"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvflowcontrol/kvflowcontrolpb"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvflowcontrol/rac2"
@@ -24,11 +28,21 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/raftlog"
 	"github.com/cockroachdb/cockroach/pkg/raft/raftpb"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/testutils/datapathutils"
+	"github.com/cockroachdb/cockroach/pkg/testutils/skip"
 	"github.com/cockroachdb/cockroach/pkg/util/admission/admissionpb"
+	"github.com/cockroachdb/cockroach/pkg/util/buildutil"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing/tracingpb"
 	"github.com/cockroachdb/datadriven"
+	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -99,6 +113,7 @@ type testRaftNode struct {
 	stableIndex       uint64
 	nextUnstableIndex uint64
 	myLeaderTerm      uint64
+	term              uint64
 }
 
 func (rn *testRaftNode) EnablePingForAdmittedLaggingLocked() {
@@ -136,6 +151,12 @@ func (rn *testRaftNode) MyLeaderTermLocked() uint64 {
 	return rn.myLeaderTerm
 }
 
+func (rn *testRaftNode) TermLocked() uint64 {
+	rn.r.mu.AssertHeld()
+	fmt.Fprintf(rn.b, " RaftNode.TermLocked() = %d\n", rn.term)
+	return rn.term
+}
+
 func (rn *testRaftNode) SetAdmittedLocked(admitted [raftpb.NumPriorities]uint64) raftpb.Message {
 	rn.r.mu.AssertHeld()
 	// TODO(sumeer): set more fields.
@@ -173,30 +194,93 @@ func (p *testAdmittedPiggybacker) AddMsgAppRespForLeader(
 		n, s, r, msgString(msg))
 }
 
+func (p *testAdmittedPiggybacker) MaybeSendDirect(
+	n roachpb.NodeID, s roachpb.StoreID, r roachpb.RangeID, msg raftpb.Message,
+) bool {
+	fmt.Fprintf(p.b, " Piggybacker.MaybeSendDirect(leader=(n%s,s%s,r%s), msg=%s)\n",
+		n, s, r, msgString(msg))
+	return true
+}
+
 type testACWorkQueue struct {
 	b *strings.Builder
+	p Processor
+	// admitImmediately causes the admitted callback to be invoked
+	// synchronously from within Admit.
+	admitImmediately bool
+	// reject causes Admit to reject the entry.
+	reject bool
+	// cancelAt is the index of the entry for which Admit cancels the context,
+	// using cancel, and returns the error.
+	cancelAt uint64
+	cancel   context.CancelFunc
 }
 
-func (q *testACWorkQueue) Admit(ctx context.Context, entry EntryForAdmission) {
+func (q *testACWorkQueue) Admit(
+	ctx context.Context, entry EntryForAdmission,
+) (AdmissionOutcome, error) {
 	fmt.Fprintf(q.b, " ACWorkQueue.Admit(%+v)\n", entry)
+	if q.cancelAt != 0 && entry.CallbackState.Index == q.cancelAt {
+		q.cancel()
+		return AdmissionAccepted, ctx.Err()
+	}
+	if q.reject {
+		return AdmissionRejected, nil
+	}
+	if q.admitImmediately {
+		q.p.AdmittedLogEntry(ctx, entry.CallbackState)
+	}
+	return AdmissionAccepted, nil
 }
 
 type testRangeControllerFactory struct {
 	b *strings.Builder
+	// fail causes New to return an error.
+	fail bool
+	// failRaftEvent is shared by all the RangeControllers created by this
+	// factory.
+	failRaftEvent bool
+	// leakTokens causes the RangeControllers created by this factory to have
+	// outstanding tokens, which are not returned by CloseRaftMuLocked.
+	leakTokens bool
 }
 
-func (f *testRangeControllerFactory) New(state rangeControllerInitState) rac2.RangeController {
+func (f *testRangeControllerFactory) New(
+	state rangeControllerInitState,
+) (rac2.RangeController, error) {
 	fmt.Fprintf(f.b, " RangeControllerFactory.New(replicaSet=%s, leaseholder=%s, nextRaftIndex=%d)\n",
 		state.replicaSet, state.leaseholder, state.nextRaftIndex)
-	return &testRangeController{b: f.b}
+	if f.fail {
+		return nil, errors.New("injected error")
+	}
+	return &testRangeController{
+		b:                 f.b,
+		voters:            state.replicaSet,
+		failRaftEvent:     &f.failRaftEvent,
+		outstandingTokens: f.leakTokens,
+		leakTokensOnClose: f.leakTokens,
+	}, nil
 }
 
 type testRangeController struct {
 	b *strings.Builder
+	// voters is used by WaitForEval.
+	voters rac2.ReplicaSet
+	// admittedStates is returned by ReplicaAdmittedStatesRaftMuLocked.
+	admittedStates map[roachpb.ReplicaID]rac2.ReplicaAdmittedState
+	// failRaftEvent causes HandleRaftEventRaftMuLocked to return an error.
+	failRaftEvent *bool
+	// outstandingTokens is returned by HasOutstandingTokensRaftMuLocked. It
+	// is cleared by CloseRaftMuLocked, unless leakTokensOnClose is set.
+	outstandingTokens bool
+	leakTokensOnClose bool
+	// waitForEvalStats is returned by WaitForEvalStatsRaftMuLocked.
+	waitForEvalStats rac2.WaitForEvalStats
 }
 
 func (c *testRangeController) WaitForEval(ctx context.Context, pri admissionpb.WorkPriority) error {
-	panic("WaitForEval should not be called")
+	fmt.Fprintf(c.b, " RangeController.WaitForEval(pri=%s, voters=%s)\n", pri, c.voters)
+	return nil
 }
 
 func raftEventString(e rac2.RaftEvent) string {
@@ -217,6 +301,9 @@ func (c *testRangeController) HandleRaftEventRaftMuLocked(
 	ctx context.Context, e rac2.RaftEvent,
 ) error {
 	fmt.Fprintf(c.b, " RangeController.HandleRaftEventRaftMuLocked(%s)\n", raftEventString(e))
+	if *c.failRaftEvent {
+		return errors.New("injected error")
+	}
 	return nil
 }
 
@@ -231,14 +318,97 @@ func (c *testRangeController) SetReplicasRaftMuLocked(
 	return nil
 }
 
+func (c *testRangeController) SetVotersForWaitForEvalRaftMuLocked(
+	ctx context.Context, replicas rac2.ReplicaSet,
+) {
+	fmt.Fprintf(c.b, " RangeController.SetVotersForWaitForEvalRaftMuLocked(%s)\n", replicas)
+	c.voters = replicas
+}
+
 func (c *testRangeController) SetLeaseholderRaftMuLocked(
 	ctx context.Context, replica roachpb.ReplicaID,
 ) {
 	fmt.Fprintf(c.b, " RangeController.SetLeaseholderRaftMuLocked(%s)\n", replica)
 }
 
+func (c *testRangeController) ReplicaAdmittedStatesRaftMuLocked() map[roachpb.ReplicaID]rac2.ReplicaAdmittedState {
+	fmt.Fprintf(c.b, " RangeController.ReplicaAdmittedStatesRaftMuLocked\n")
+	states := make(map[roachpb.ReplicaID]rac2.ReplicaAdmittedState, len(c.admittedStates))
+	for id, state := range c.admittedStates {
+		states[id] = state
+	}
+	return states
+}
+
+// HasOutstandingTokensRaftMuLocked does not print, since it is also called
+// by an assertion that only runs in test builds.
+func (c *testRangeController) HasOutstandingTokensRaftMuLocked() bool {
+	return c.outstandingTokens
+}
+
+// WaitForEvalStatsRaftMuLocked does not print, since it is also called in
+// every Ready at the leader.
+func (c *testRangeController) WaitForEvalStatsRaftMuLocked() rac2.WaitForEvalStats {
+	return c.waitForEvalStats
+}
+
 func (c *testRangeController) CloseRaftMuLocked(ctx context.Context) {
 	fmt.Fprintf(c.b, " RangeController.CloseRaftMuLocked\n")
+	if !c.leakTokensOnClose {
+		c.outstandingTokens = false
+	}
+}
+
+// newTestProcessor returns a Processor for replica 5 of r3 on (n1,s2), of
+// tenant 4, which uses the test implementations of its dependencies. They
+// print to b. The options can be changed by modify, if non-nil, before the
+// Processor is created.
+func newTestProcessor(
+	b *strings.Builder, r *testReplica, modify func(opts *ProcessorOptions),
+) *processorImpl {
+	q := &testACWorkQueue{b: b}
+	opts := ProcessorOptions{
+		NodeID:                 1,
+		StoreID:                2,
+		RangeID:                3,
+		TenantID:               roachpb.MustMakeTenantID(4),
+		ReplicaID:              5,
+		Replica:                r,
+		RaftScheduler:          &testRaftScheduler{b: b},
+		AdmittedPiggybacker:    &testAdmittedPiggybacker{b: b},
+		ACWorkQueue:            q,
+		RangeControllerFactory: &testRangeControllerFactory{b: b},
+		Metrics:                NewMetrics(time.Minute),
+		Clock:                  hlc.NewClockForTesting(timeutil.NewManualTime(timeutil.Unix(0, 0))),
+		Settings:               cluster.MakeTestingClusterSettings(),
+		Knobs:                  &ProcessorTestingKnobs{},
+	}
+	if modify != nil {
+		modify(&opts)
+	}
+	p := NewProcessor(opts).(*processorImpl)
+	q.p = p
+	return p
+}
+
+// forceAdmittedRecomputeRaftMuLocked recomputes admitted, and if it advances,
+// sets it in the RaftNode and piggybacks it to the leader, like
+// HandleRaftReadyRaftMuLocked does with no entries. It allows tests to push
+// admitted without fabricating a Ready. It is a noop if the leader is not using
+// the v2 protocol.
+func (p *processorImpl) forceAdmittedRecomputeRaftMuLocked(ctx context.Context) {
+	p.opts.Replica.RaftMuAssertHeld()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.mu.destroyed || p.raftMu.raftNode == nil {
+		return
+	}
+	// Any scheduled processing of admitted is subsumed by this call.
+	p.mu.scheduledAdmittedProcessing = false
+	if p.mu.leader.rc == nil && !p.mu.follower.isLeaderUsingV2Protocol {
+		return
+	}
+	p.advanceAdmittedRaftMuLockedProcLocked(ctx)
 }
 
 func TestProcessorBasic(t *testing.T) {
@@ -249,8 +419,12 @@ func TestProcessorBasic(t *testing.T) {
 	var q testACWorkQueue
 	var rcFactory testRangeControllerFactory
 	var p *processorImpl
+	var clock *timeutil.ManualTime
+	var st *cluster.Settings
 	reset := func(enabled EnabledWhenLeaderLevel) {
 		b.Reset()
+		clock = timeutil.NewManualTime(timeutil.Unix(0, 0))
+		st = cluster.MakeTestingClusterSettings()
 		r = newTestReplica(&b)
 		sched = testRaftScheduler{b: &b}
 		piggybacker = testAdmittedPiggybacker{b: &b}
@@ -267,8 +441,20 @@ func TestProcessorBasic(t *testing.T) {
 			AdmittedPiggybacker:    &piggybacker,
 			ACWorkQueue:            &q,
 			RangeControllerFactory: &rcFactory,
+			Metrics:                NewMetrics(time.Minute),
+			Clock:                  hlc.NewClockForTesting(clock),
+			Settings:               st,
+			OnLeaderChange: func(old, new roachpb.ReplicaID, term uint64) {
+				fmt.Fprintf(&b, " OnLeaderChange(old=%s, new=%s, term=%d)\n", old, new, term)
+			},
+			OnAdmissionRejected: func(entry EntryForAdmission) {
+				fmt.Fprintf(&b, " OnAdmissionRejected(index=%d, pri=%s)\n",
+					entry.CallbackState.Index, entry.CallbackState.Priority)
+			},
+			Knobs:                  &ProcessorTestingKnobs{},
 			EnabledWhenLeaderLevel: enabled,
 		}).(*processorImpl)
+		q.p = p
 		fmt.Fprintf(&b, "n%s,s%s,r%s: replica=%s, tenant=%s, enabled-level=%s\n",
 			p.opts.NodeID, p.opts.StoreID, p.opts.RangeID, p.opts.ReplicaID, p.opts.TenantID,
 			enabledLevelString(p.mu.enabledWhenLeader))
@@ -319,6 +505,11 @@ func TestProcessorBasic(t *testing.T) {
 					d.ScanArgs(t, "my-leader-term", &myLeaderTerm)
 					r.raftNode.myLeaderTerm = myLeaderTerm
 				}
+				if d.HasArg("term") {
+					var term uint64
+					d.ScanArgs(t, "term", &term)
+					r.raftNode.term = term
+				}
 				if d.HasArg("leaseholder") {
 					var leaseholder int
 					d.ScanArgs(t, "leaseholder", &leaseholder)
@@ -333,7 +524,14 @@ func TestProcessorBasic(t *testing.T) {
 
 			case "set-enabled-level":
 				enabledLevel := parseEnabledLevel(t, d)
-				p.SetEnabledWhenLeaderRaftMuLocked(enabledLevel)
+				p.SetEnabledWhenLeaderRaftMuLocked(ctx, enabledLevel)
+				return builderStr()
+
+			case "force-set-enabled-level":
+				enabledLevel := parseEnabledLevel(t, d)
+				var allowRegression bool
+				d.ScanArgs(t, "allow-regression", &allowRegression)
+				p.ForceSetEnabledWhenLeaderRaftMuLocked(ctx, enabledLevel, allowRegression)
 				return builderStr()
 
 			case "get-enabled-level":
@@ -341,6 +539,12 @@ func TestProcessorBasic(t *testing.T) {
 				fmt.Fprintf(&b, "enabled-level: %s\n", enabledLevelString(enabledLevel))
 				return builderStr()
 
+			case "on-lease-changed":
+				var leaseholder int
+				d.ScanArgs(t, "leaseholder", &leaseholder)
+				p.OnLeaseChangedRaftMuLocked(ctx, roachpb.ReplicaID(leaseholder))
+				return builderStr()
+
 			case "on-desc-changed":
 				desc := parseRangeDescriptor(t, d)
 				p.OnDescChangedLocked(ctx, &desc)
@@ -360,11 +564,130 @@ func TestProcessorBasic(t *testing.T) {
 					var leaderTerm uint64
 					d.ScanArgs(t, "leader-term", &leaderTerm)
 					fmt.Fprintf(&b, "AdmitRaftEntries:\n")
-					isV2 := p.AdmitRaftEntriesFromMsgStorageAppendRaftMuLocked(ctx, leaderTerm, entries)
+					admitCtx, cancel := context.WithCancel(ctx)
+					q.cancel = cancel
+					isV2 := p.AdmitRaftEntriesFromMsgStorageAppendRaftMuLocked(admitCtx, leaderTerm, entries)
+					cancel()
 					fmt.Fprintf(&b, "leader-using-v2: %t\n", isV2)
 				}
 				return builderStr()
 
+			case "rebuild-waiting-state":
+				var arg string
+				d.ScanArgs(t, "entries", &arg)
+				entries := createEntries(t, parseEntryInfos(t, arg))
+				p.RebuildWaitingStateRaftMuLocked(ctx, entries)
+				return builderStr()
+
+			case "reconcile-waiting-state":
+				var arg string
+				d.ScanArgs(t, "entries", &arg)
+				entries := createEntries(t, parseEntryInfos(t, arg))
+				discrepancies := p.ReconcileWaitingStateRaftMuLocked(ctx, entries)
+				fmt.Fprintf(&b, "discrepancies: %d\n", len(discrepancies))
+				for _, disc := range discrepancies {
+					fmt.Fprintf(&b, " %s index=%d pri=%s\n", disc.Kind, disc.Index, disc.Priority)
+				}
+				return builderStr()
+
+			case "set-replica-admitted-state":
+				rc, ok := p.mu.leader.rc.(*testRangeController)
+				if !ok {
+					return "no RangeController\n"
+				}
+				var replicaID int
+				d.ScanArgs(t, "replica-id", &replicaID)
+				var state rac2.ReplicaAdmittedState
+				d.ScanArgs(t, "match", &state.Match)
+				d.ScanArgs(t, "next", &state.Next)
+				var arg string
+				d.ScanArgs(t, "admitted", &arg)
+				state.Admitted = parseAdmitted(t, arg)
+				if rc.admittedStates == nil {
+					rc.admittedStates = map[roachpb.ReplicaID]rac2.ReplicaAdmittedState{}
+				}
+				rc.admittedStates[roachpb.ReplicaID(replicaID)] = state
+				return builderStr()
+
+			case "set-rc-outstanding-tokens":
+				rc, ok := p.mu.leader.rc.(*testRangeController)
+				if !ok {
+					return "no RangeController\n"
+				}
+				d.ScanArgs(t, "value", &rc.outstandingTokens)
+				return builderStr()
+
+			case "set-rc-wait-for-eval-stats":
+				rc, ok := p.mu.leader.rc.(*testRangeController)
+				if !ok {
+					return "no RangeController\n"
+				}
+				d.ScanArgs(t, "num-waiting", &rc.waitForEvalStats.NumWaiting)
+				rc.waitForEvalStats.OldestWaitStart = time.Time{}
+				if d.HasArg("oldest-wait-start") {
+					var arg string
+					d.ScanArgs(t, "oldest-wait-start", &arg)
+					start, err := time.ParseDuration(arg)
+					require.NoError(t, err)
+					rc.waitForEvalStats.OldestWaitStart = timeutil.Unix(0, start.Nanoseconds())
+				}
+				return builderStr()
+
+			case "waiting-for-admission-by-priority":
+				counts := p.WaitingForAdmissionByPriorityRaftMuLocked()
+				fmt.Fprintf(&b, "waiting-for-admission:")
+				for pri := range counts {
+					fmt.Fprintf(&b, " %s: %d", raftpb.Priority(pri), counts[pri])
+				}
+				fmt.Fprintf(&b, "\n")
+				return builderStr()
+
+			case "wait-for-eval-stats":
+				stats := p.GetWaitForEvalStatsRaftMuLocked()
+				var oldest time.Duration
+				if !stats.OldestWaitStart.IsZero() {
+					oldest = stats.OldestWaitStart.Sub(timeutil.Unix(0, 0))
+				}
+				fmt.Fprintf(&b, "num-waiting: %d oldest-wait-start: %s metric: %d\n",
+					stats.NumWaiting, oldest, p.opts.Metrics.WaitingForEval.Value())
+				return builderStr()
+
+			case "has-outstanding-tokens":
+				fmt.Fprintf(&b, "outstanding-tokens: %t\n", p.HasOutstandingTokensRaftMuLocked())
+				return builderStr()
+
+			case "replica-admitted-state":
+				states := p.GetReplicaAdmittedStateRaftMuLocked()
+				ids := make([]roachpb.ReplicaID, 0, len(states))
+				for id := range states {
+					ids = append(ids, id)
+				}
+				slices.Sort(ids)
+				for _, id := range ids {
+					state := states[id]
+					fmt.Fprintf(&b, "replica %s: match: %d next: %d admitted: %s\n",
+						id, state.Match, state.Next, admittedString(state.Admitted))
+				}
+				return builderStr()
+
+			case "wait-for-eval":
+				if rc := p.mu.leader.rc; rc != nil {
+					require.NoError(t, rc.WaitForEval(ctx, admissionpb.NormalPri))
+				} else {
+					fmt.Fprintf(&b, "no RangeController\n")
+				}
+				return builderStr()
+
+			case "on-snapshot-applied":
+				var snapIndex uint64
+				d.ScanArgs(t, "snap-index", &snapIndex)
+				p.OnSnapshotAppliedRaftMuLocked(ctx, snapIndex)
+				return builderStr()
+
+			case "force-admitted-recompute":
+				p.forceAdmittedRecomputeRaftMuLocked(ctx)
+				return builderStr()
+
 			case "enqueue-piggybacked-admitted":
 				var from, to uint64
 				d.ScanArgs(t, "from", &from)
@@ -374,6 +697,12 @@ func TestProcessorBasic(t *testing.T) {
 					To:   raftpb.PeerID(to),
 					From: raftpb.PeerID(from),
 				}
+				if d.HasArg("context-size") {
+					// Used to synthesize an oversized message.
+					var size int
+					d.ScanArgs(t, "context-size", &size)
+					msg.Context = make([]byte, size)
+				}
 				p.EnqueuePiggybackedAdmittedAtLeader(msg)
 				return builderStr()
 
@@ -381,6 +710,11 @@ func TestProcessorBasic(t *testing.T) {
 				p.ProcessPiggybackedAdmittedAtLeaderRaftMuLocked(ctx)
 				return builderStr()
 
+			case "flush-piggybacked-admitted":
+				n := p.FlushPiggybackedAdmittedRaftMuLocked(ctx)
+				fmt.Fprintf(&b, "flushed: %d\n", n)
+				return builderStr()
+
 			case "side-channel":
 				var usingV2 bool
 				if d.HasArg("v2") {
@@ -405,6 +739,25 @@ func TestProcessorBasic(t *testing.T) {
 				p.SideChannelForPriorityOverrideAtFollowerRaftMuLocked(info)
 				return builderStr()
 
+			case "get-effective-priority":
+				var arg string
+				d.ScanArgs(t, "indices", &arg)
+				var pri int
+				d.ScanArgs(t, "pri", &pri)
+				for _, part := range strings.Split(arg, ",") {
+					index, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+					require.NoError(t, err)
+					effectivePri := p.GetEffectivePriorityRaftMuLocked(index, raftpb.Priority(pri))
+					fmt.Fprintf(&b, "index %d: %s\n", index, effectivePri)
+				}
+				return builderStr()
+
+			case "leader-protocol":
+				leaderTerm := p.GetLeaderTermRaftMuLocked()
+				usingV2 := p.IsLeaderUsingV2RaftMuLocked()
+				fmt.Fprintf(&b, "leader-term: %d leader-using-v2: %t\n", leaderTerm, usingV2)
+				return builderStr()
+
 			case "admitted-log-entry":
 				var replicaID int
 				d.ScanArgs(t, "replica-id", &replicaID)
@@ -414,23 +767,408 @@ func TestProcessorBasic(t *testing.T) {
 				d.ScanArgs(t, "index", &index)
 				var pri int
 				d.ScanArgs(t, "pri", &pri)
+				var enqueueTime time.Duration
+				if d.HasArg("enqueue-time") {
+					var arg string
+					d.ScanArgs(t, "enqueue-time", &arg)
+					var err error
+					enqueueTime, err = time.ParseDuration(arg)
+					require.NoError(t, err)
+				}
 				cb := EntryForAdmissionCallbackState{
-					StoreID:    2,
-					RangeID:    3,
-					ReplicaID:  roachpb.ReplicaID(replicaID),
-					LeaderTerm: leaderTerm,
-					Index:      index,
-					Priority:   raftpb.Priority(pri),
+					StoreID:     2,
+					RangeID:     3,
+					ReplicaID:   roachpb.ReplicaID(replicaID),
+					LeaderTerm:  leaderTerm,
+					Index:       index,
+					Priority:    raftpb.Priority(pri),
+					EnqueueTime: enqueueTime.Nanoseconds(),
 				}
 				p.AdmittedLogEntry(ctx, cb)
 				return builderStr()
 
+			case "metrics":
+				m := p.opts.Metrics
+				fmt.Fprintf(&b, "admitted: %d waiting: %d piggybacked-enqueued: %d "+
+					"piggybacked-dropped: %d leader-transitions: %d\n",
+					m.AdmittedEntries.Count(), m.WaitingForAdmission.Value(),
+					m.PiggybackedResponsesEnqueued.Count(), m.PiggybackedResponsesDropped.Count(),
+					m.LeaderTransitions.Count())
+				for _, wc := range []admissionpb.WorkClass{
+					admissionpb.RegularWorkClass,
+					admissionpb.ElasticWorkClass,
+				} {
+					count, sum := m.AdmissionWaitDuration[wc].CumulativeSnapshot().Total()
+					fmt.Fprintf(&b, "%s-wait-duration: count: %d sum: %s\n",
+						wc, count, time.Duration(sum))
+				}
+				fmt.Fprintf(&b, "skipped-undecodable: %d enqueued-piggybacked-dropped: %d "+
+					"stale-side-channel-ignored: %d admission-rejected: %d rc-recreated: %d "+
+					"v1-regular-pri: %d waiting-for-eval: %d\n",
+					m.SkippedUndecodableEntries.Count(), m.EnqueuedPiggybackedResponsesDropped.Count(),
+					m.StaleSideChannelInfoIgnored.Count(), m.AdmissionRejected.Count(),
+					m.RangeControllerRecreated.Count(), m.V1EncodingRegularPriority.Count(),
+					m.WaitingForEval.Value())
+				return builderStr()
+
+			case "follower-metrics":
+				m := p.opts.Metrics
+				fmt.Fprintf(&b, "followers-with-v1-leader: %d followers-with-v2-leader: %d\n",
+					m.FollowersWithV1Leader.Value(), m.FollowersWithV2Leader.Value())
+				return builderStr()
+
+			case "leader-not-in-replicas-duration":
+				fmt.Fprintf(&b, "leader-not-in-replicas: %s\n", p.GetLeaderNotInReplicasDuration())
+				return builderStr()
+
+			case "rc-term-recreations":
+				fmt.Fprintf(&b, "rc-term-recreations: %d\n",
+					p.opts.Metrics.RangeControllerTermRecreations.Count())
+				return builderStr()
+
+			case "set-tolerate-decode-errors":
+				var tolerate bool
+				d.ScanArgs(t, "value", &tolerate)
+				tolerateDecodeErrors.Override(ctx, &st.SV, tolerate)
+				return builderStr()
+
+			case "set-direct-send-admitted":
+				p.opts.DirectSendAdmitted = true
+				d.ScanArgs(t, "lag-threshold", &p.opts.DirectSendAdmittedLagThreshold)
+				return builderStr()
+
+			case "set-disable-piggybacking":
+				p.opts.DisablePiggybacking = true
+				return builderStr()
+
+			case "set-max-enqueued-piggybacked-bytes":
+				d.ScanArgs(t, "value", &p.opts.MaxEnqueuedPiggybackedResponsesBytes)
+				return builderStr()
+
+			case "set-rc-factory-fail":
+				d.ScanArgs(t, "value", &rcFactory.fail)
+				return builderStr()
+
+			case "set-rc-raft-event-fail":
+				d.ScanArgs(t, "value", &rcFactory.failRaftEvent)
+				return builderStr()
+
+			case "set-rc-error-threshold":
+				var threshold int64
+				d.ScanArgs(t, "value", &threshold)
+				rangeControllerErrorThreshold.Override(ctx, &st.SV, threshold)
+				return builderStr()
+
+			case "set-process-admitted-synchronously":
+				d.ScanArgs(t, "value", &p.opts.Knobs.ProcessAdmittedSynchronously)
+				return builderStr()
+
+			case "set-priority-mapper":
+				// Boosts LowPri to NormalPri, and uses the default mapping for
+				// the other priorities.
+				p.opts.PriorityMapper = func(pri raftpb.Priority) admissionpb.WorkPriority {
+					if pri == raftpb.LowPri {
+						pri = raftpb.NormalPri
+					}
+					return rac2.RaftToAdmissionPriority(pri)
+				}
+				return builderStr()
+
+			case "set-tenant-priority-floor":
+				var tenantID uint64
+				d.ScanArgs(t, "tenant", &tenantID)
+				var priStr string
+				d.ScanArgs(t, "pri", &priStr)
+				pri, ok := admissionpb.TestingReverseWorkPriorityDict[priStr]
+				require.True(t, ok)
+				if p.opts.TenantPriorityFloors == nil {
+					p.opts.TenantPriorityFloors = map[roachpb.TenantID]admissionpb.WorkPriority{}
+				}
+				p.opts.TenantPriorityFloors[roachpb.MustMakeTenantID(tenantID)] = pri
+				return builderStr()
+
+			case "set-decode-error-indices":
+				var arg string
+				d.ScanArgs(t, "indices", &arg)
+				errIndices := map[uint64]struct{}{}
+				for _, part := range strings.Split(arg, ",") {
+					index, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+					require.NoError(t, err)
+					errIndices[index] = struct{}{}
+				}
+				p.opts.Knobs.DecodeRaftAdmissionMeta = decodeWithErrorsAt(errIndices)
+				return builderStr()
+
+			case "set-admit-reject":
+				d.ScanArgs(t, "value", &q.reject)
+				return builderStr()
+
+			case "set-draining":
+				var draining bool
+				d.ScanArgs(t, "value", &draining)
+				p.SetDrainingRaftMuLocked(draining)
+				return builderStr()
+
+			case "set-admit-cancel-at":
+				d.ScanArgs(t, "index", &q.cancelAt)
+				return builderStr()
+
+			case "set-admit-immediately":
+				d.ScanArgs(t, "value", &q.admitImmediately)
+				return builderStr()
+
+			case "advance-clock":
+				var arg string
+				d.ScanArgs(t, "duration", &arg)
+				duration, err := time.ParseDuration(arg)
+				require.NoError(t, err)
+				clock.Advance(duration)
+				return builderStr()
+
+			case "inspect":
+				state := p.InspectRaftMuLocked(ctx)
+				fmt.Fprintf(&b, "leader: %s leaseholder: %s leader-node: %s stable: %d admitted: %s "+
+					"leader-using-v2: %t waiting: %d pending-creation: %t\n",
+					state.LeaderID, state.LeaseholderID, state.LeaderNodeID, state.LastObservedStableIndex,
+					admittedString(state.Admitted), state.IsLeaderUsingV2, state.NumWaitingForAdmission,
+					state.PendingRangeControllerCreation)
+				return builderStr()
+
 			default:
 				return fmt.Sprintf("unknown command: %s", d.Cmd)
 			}
 		})
 }
 
+// TestProcessorClampNegativeRequestedCount tests that an entry requesting a
+// negative number of admission tokens is clamped to zero before admission.
+func TestProcessorClampNegativeRequestedCount(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	var b strings.Builder
+	p := newTestProcessor(&b, newTestReplica(&b), nil /* modify */)
+	entry := EntryForAdmission{
+		TenantID:       roachpb.MustMakeTenantID(4),
+		Priority:       admissionpb.NormalPri,
+		CreateTime:     2,
+		RequestedCount: -100,
+		CallbackState: EntryForAdmissionCallbackState{
+			StoreID:   2,
+			RangeID:   3,
+			ReplicaID: 5,
+			Index:     21,
+			Priority:  raftpb.NormalPri,
+		},
+	}
+	p.clampRequestedCount(ctx, &entry)
+	require.Equal(t, int64(0), entry.RequestedCount)
+	require.Equal(t, int64(1), p.opts.Metrics.NegativeRequestedCount.Count())
+
+	// Non-negative counts are left unchanged.
+	for _, count := range []int64{0, 100} {
+		entry.RequestedCount = count
+		p.clampRequestedCount(ctx, &entry)
+		require.Equal(t, count, entry.RequestedCount)
+	}
+	require.Equal(t, int64(1), p.opts.Metrics.NegativeRequestedCount.Count())
+}
+
+func TestProcessorHandleRaftReadyTracing(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// No child spans are created when the context has no recording span.
+	_, sp := childSpanIfRecording(context.Background(), "foo")
+	require.Nil(t, sp)
+
+	var b strings.Builder
+	r := newTestReplica(&b)
+	r.raftNode.leader = 10
+	r.raftNode.term = 50
+	r.raftNode.stableIndex = 22
+	r.raftNode.nextUnstableIndex = 23
+	r.raftNode.admitted = [raftpb.NumPriorities]uint64{20, 20, 20, 20}
+	p := newTestProcessor(&b, r, nil /* modify */)
+	p.OnDescChangedLocked(context.Background(), &roachpb.RangeDescriptor{
+		InternalReplicas: []roachpb.ReplicaDescriptor{
+			{NodeID: 10, StoreID: 10, ReplicaID: 10},
+			{NodeID: 1, StoreID: 2, ReplicaID: 5},
+		},
+	})
+	p.SideChannelForPriorityOverrideAtFollowerRaftMuLocked(SideChannelInfoUsingRaftMessageRequest{
+		UsingV2Protocol: true,
+		LeaderTerm:      50,
+		First:           21,
+		Last:            22,
+	})
+
+	tr := tracing.NewTracer()
+	ctx, sp := tr.StartSpanCtx(context.Background(), "test",
+		tracing.WithRecording(tracingpb.RecordingVerbose))
+	p.HandleRaftReadyRaftMuLocked(ctx, nil)
+	rec := sp.FinishAndGetConfiguredRecording()
+	for _, op := range []string{
+		"replica_rac2.compute-admitted",
+		"replica_rac2.set-admitted",
+		"replica_rac2.piggyback-admitted",
+	} {
+		_, ok := rec.FindSpan(op)
+		require.True(t, ok, "span %s not found in %s", op, rec)
+	}
+	_, ok := rec.FindLogMessage(`admitted advanced from \[20 20 20 20\] to \[22 22 22 22\]`)
+	require.True(t, ok, "admitted delta not found in %s", rec)
+}
+
+// TestProcessorDestroyLeaksTokens tests that destroying the Processor, when
+// the RangeController does not return all its tokens on close, fails an
+// assertion in test builds.
+func TestProcessorDestroyLeaksTokens(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	if !buildutil.CrdbTestBuild {
+		skip.IgnoreLint(t, "assertion is only enabled in test builds")
+	}
+	ctx := context.Background()
+	var b strings.Builder
+	r := newTestReplica(&b)
+	r.raftNode.leader = 5
+	r.raftNode.myLeaderTerm = 50
+	r.raftNode.nextUnstableIndex = 21
+	p := newTestProcessor(&b, r, func(opts *ProcessorOptions) {
+		opts.RangeControllerFactory = &testRangeControllerFactory{b: &b, leakTokens: true}
+		opts.EnabledWhenLeaderLevel = EnabledWhenLeaderV2Encoding
+	})
+	p.OnDescChangedLocked(ctx, &roachpb.RangeDescriptor{
+		InternalReplicas: []roachpb.ReplicaDescriptor{{NodeID: 1, StoreID: 2, ReplicaID: 5}},
+	})
+	p.HandleRaftReadyRaftMuLocked(ctx, nil)
+	require.True(t, p.HasOutstandingTokensRaftMuLocked())
+	require.Panics(t, func() { p.OnDestroyRaftMuLocked(ctx) })
+}
+
+// TestProcessorLogsAdmissionStall tests that an entry waiting for admission
+// for longer than admissionStallLogThreshold is reported in the logs.
+func TestProcessorLogsAdmissionStall(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	testStartTs := timeutil.Now()
+	ctx := context.Background()
+	var b strings.Builder
+	r := newTestReplica(&b)
+	r.raftNode.leader = 5
+	r.raftNode.myLeaderTerm = 50
+	r.raftNode.stableIndex = 20
+	r.raftNode.nextUnstableIndex = 23
+	clock := timeutil.NewManualTime(timeutil.Unix(0, 0))
+	p := newTestProcessor(&b, r, func(opts *ProcessorOptions) {
+		opts.Clock = hlc.NewClockForTesting(clock)
+		admissionStallLogThreshold.Override(ctx, &opts.Settings.SV, time.Minute)
+		opts.EnabledWhenLeaderLevel = EnabledWhenLeaderV2Encoding
+	})
+	p.OnDescChangedLocked(ctx, &roachpb.RangeDescriptor{
+		InternalReplicas: []roachpb.ReplicaDescriptor{{NodeID: 1, StoreID: 2, ReplicaID: 5}},
+	})
+	p.HandleRaftReadyRaftMuLocked(ctx, nil)
+	require.True(t, p.IsLeaderUsingV2RaftMuLocked())
+
+	fetchStallEntries := func() int {
+		log.FlushFiles()
+		entries, err := log.FetchEntriesFromFiles(testStartTs.UnixNano(), math.MaxInt64, 100,
+			regexp.MustCompile(`admission stalled: oldest waiting entry index=21 pri=LowPri`),
+			log.WithMarkedSensitiveData)
+		require.NoError(t, err)
+		return len(entries)
+	}
+	admit := func(index uint64, pri raftpb.Priority) {
+		entries := createEntries(t, []entryInfo{{
+			encoding:   raftlog.EntryEncodingStandardWithACAndPriority,
+			index:      index,
+			term:       50,
+			pri:        pri,
+			createTime: 2,
+			length:     100,
+		}})
+		require.True(t, p.AdmitRaftEntriesFromMsgStorageAppendRaftMuLocked(ctx, 50, entries))
+	}
+	admit(21, raftpb.LowPri)
+	clock.Advance(30 * time.Second)
+	admit(22, raftpb.NormalPri)
+
+	// The entries have not been waiting long enough.
+	p.HandleRaftReadyRaftMuLocked(ctx, nil)
+	require.Zero(t, fetchStallEntries())
+
+	// The oldest entry has now been waiting for longer than the threshold.
+	clock.Advance(time.Minute)
+	p.HandleRaftReadyRaftMuLocked(ctx, nil)
+	require.Equal(t, 1, fetchStallEntries())
+}
+
+// decodeWithErrorsAt returns a stub for
+// ProcessorTestingKnobs.DecodeRaftAdmissionMeta that fails to decode the
+// entries at the given indices.
+func decodeWithErrorsAt(
+	indices map[uint64]struct{},
+) func(entry raftpb.Entry) (kvflowcontrolpb.RaftAdmissionMeta, error) {
+	return func(entry raftpb.Entry) (kvflowcontrolpb.RaftAdmissionMeta, error) {
+		if _, ok := indices[entry.Index]; ok {
+			return kvflowcontrolpb.RaftAdmissionMeta{},
+				errors.Newf("injected decode error at index %d", entry.Index)
+		}
+		return raftlog.DecodeRaftAdmissionMeta(entry.Data)
+	}
+}
+
+// TestProcessorDecodeErrorPanics tests that an entry whose admission metadata
+// cannot be decoded causes a panic, when decode errors are not tolerated.
+func TestProcessorDecodeErrorPanics(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	if !buildutil.CrdbTestBuild {
+		skip.IgnoreLint(t, "testing knobs are only honored in test builds")
+	}
+	var b strings.Builder
+	r := newTestReplica(&b)
+	p := newTestProcessor(&b, r, func(opts *ProcessorOptions) {
+		opts.Knobs.DecodeRaftAdmissionMeta = decodeWithErrorsAt(map[uint64]struct{}{22: {}})
+	})
+	p.SideChannelForPriorityOverrideAtFollowerRaftMuLocked(SideChannelInfoUsingRaftMessageRequest{
+		UsingV2Protocol: true,
+		LeaderTerm:      50,
+		First:           21,
+		Last:            22,
+	})
+	entries := createEntries(t, []entryInfo{
+		{
+			encoding:   raftlog.EntryEncodingStandardWithACAndPriority,
+			index:      21,
+			term:       50,
+			pri:        raftpb.NormalPri,
+			createTime: 2,
+			length:     100,
+		},
+		{
+			encoding:   raftlog.EntryEncodingStandardWithACAndPriority,
+			index:      22,
+			term:       50,
+			pri:        raftpb.NormalPri,
+			createTime: 2,
+			length:     100,
+		},
+	})
+	require.Panics(t, func() {
+		p.AdmitRaftEntriesFromMsgStorageAppendRaftMuLocked(context.Background(), 50, entries)
+	})
+	// The index 21 entry was submitted for admission before the panic.
+	require.Contains(t, b.String(), "Index:21")
+	require.NotContains(t, b.String(), "Index:22")
+}
+
 func parseEnabledLevel(t *testing.T, td *datadriven.TestData) EnabledWhenLeaderLevel {
 	if td.HasArg("enabled-level") {
 		var str string
@@ -486,6 +1224,11 @@ func parseRangeDescriptor(t *testing.T, td *datadriven.TestData) roachpb.RangeDe
 		replica := parseReplicaDescriptor(t, strings.TrimSpace(part))
 		desc.InternalReplicas = append(desc.InternalReplicas, replica)
 	}
+	if td.HasArg("generation") {
+		var generation int64
+		td.ScanArgs(t, "generation", &generation)
+		desc.Generation = roachpb.RangeGeneration(generation)
+	}
 	return desc
 }
 
@@ -520,7 +1263,10 @@ func parseReplicaDescriptor(t *testing.T, arg string) roachpb.ReplicaDescriptor
 }
 
 type entryInfo struct {
-	encoding   raftlog.EntryEncoding
+	encoding raftlog.EntryEncoding
+	// corruption, if non-empty, is one of "encoding" or "meta", and causes
+	// the corresponding part of the entry to be undecodable.
+	corruption string
 	index      uint64
 	term       uint64
 	pri        raftpb.Priority
@@ -568,6 +1314,16 @@ func createEntry(t *testing.T, info entryInfo) raftpb.Entry {
 	require.NoError(t, err)
 	data := append(cmdBufPrefix, metaBuf...)
 	data = append(data, cmdBuf...)
+	switch info.corruption {
+	case "encoding":
+		// Unknown encoding.
+		data[0] = 0x3F
+	case "meta":
+		// A varint that overflows.
+		for i := raftlog.RaftCommandPrefixLen; i < raftlog.RaftCommandPrefixLen+11; i++ {
+			data[i] = 0xFF
+		}
+	}
 	return raftpb.Entry{
 		Term:  info.term,
 		Index: info.index,
@@ -598,7 +1354,9 @@ func parseEntryInfos(t *testing.T, arg string) []entryInfo {
 func parseEntryInfo(t *testing.T, arg string) entryInfo {
 	parts := strings.Split(arg, "/")
 	require.Equal(t, 6, len(parts))
-	encoding := parseEntryEncoding(t, strings.TrimSpace(parts[0]))
+	// The encoding can have a suffix of the form "-corrupt-{encoding,meta}".
+	encodingStr, corruption, _ := strings.Cut(strings.TrimSpace(parts[0]), "-corrupt-")
+	encoding := parseEntryEncoding(t, encodingStr)
 	index, err := strconv.Atoi(strings.TrimPrefix(parts[1], "i"))
 	require.NoError(t, err)
 	term, err := strconv.Atoi(strings.TrimPrefix(parts[2], "t"))
@@ -611,6 +1369,7 @@ func parseEntryInfo(t *testing.T, arg string) entryInfo {
 	require.NoError(t, err)
 	return entryInfo{
 		encoding:   encoding,
+		corruption: corruption,
 		index:      uint64(index),
 		term:       uint64(term),
 		pri:        raftpb.Priority(pri),
@@ -636,3 +1395,42 @@ func parseEntryEncoding(t *testing.T, arg string) raftlog.EntryEncoding {
 	}
 	return raftlog.EntryEncodingEmpty
 }
+
+// BenchmarkProcessorAdvanceAdmitted measures the admitted advancement done
+// in each Ready iteration of a follower with a steady stream of writes,
+// where some of the iterations do not advance admitted.
+func BenchmarkProcessorAdvanceAdmitted(b *testing.B) {
+	var sb strings.Builder
+	r := newTestReplica(&sb)
+	p := NewProcessor(ProcessorOptions{
+		NodeID:              1,
+		StoreID:             2,
+		RangeID:             3,
+		ReplicaID:           5,
+		Replica:             r,
+		AdmittedPiggybacker: &testAdmittedPiggybacker{b: &sb},
+		Metrics:             NewMetrics(time.Minute),
+		Clock:               hlc.NewClockForTesting(timeutil.NewManualTime(timeutil.Unix(0, 0))),
+		Settings:            cluster.MakeTestingClusterSettings(),
+	}).(*processorImpl)
+	p.raftMu.raftNode = r.raftNode
+	p.mu.leaderNodeID = 10
+	p.mu.leaderStoreID = 10
+	const leaderTerm = 1
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index := uint64(i + 1)
+		p.mu.Lock()
+		// The entry at index is waiting for admission, so only the other
+		// priorities can advance.
+		p.mu.waitingForAdmissionState.add(leaderTerm, index, raftpb.LowPri, 0 /* addedAt */)
+		p.maybeAdvanceAdmittedRaftMuLockedProcLocked(ctx, index, r.raftNode.admitted)
+		// Nothing advances, since the stable index is unchanged.
+		p.maybeAdvanceAdmittedRaftMuLockedProcLocked(ctx, index, r.raftNode.admitted)
+		p.mu.waitingForAdmissionState.remove(leaderTerm, index, raftpb.LowPri)
+		p.maybeAdvanceAdmittedRaftMuLockedProcLocked(ctx, index, r.raftNode.admitted)
+		p.mu.Unlock()
+		sb.Reset()
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package storeliveness
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	slpb "github.com/cockroachdb/cockroach/pkg/kv/kvserver/storeliveness/storelivenesspb"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// epochAdvanceWindow is the duration over which the epoch advances of the
+// support for a store are tracked.
+const epochAdvanceWindow = 10 * time.Minute
+
+// flappingStore describes a store whose support epoch advanced repeatedly
+// within the tracking window.
+type flappingStore struct {
+	id slpb.StoreIdent
+	// epochAdvances is the number of times the support epoch advanced within the
+	// tracking window.
+	epochAdvances int
+}
+
+// epochAdvanceTracker tracks the recent epoch advances of the support for each
+// remote store, to help diagnose stores whose support is flapping (i.e.
+// repeatedly withdrawn and re-granted), e.g. due to an unstable network link.
+// The tracked advances are kept in memory only.
+type epochAdvanceTracker struct {
+	window time.Duration
+	// now is the clock used to timestamp the epoch advances; it can be
+	// overridden in tests.
+	now func() time.Time
+
+	mu struct {
+		syncutil.Mutex
+		// advances stores the times of the epoch advances within the window, in
+		// increasing order, for each store.
+		advances map[slpb.StoreIdent][]time.Time
+	}
+}
+
+func newEpochAdvanceTracker() *epochAdvanceTracker {
+	t := &epochAdvanceTracker{window: epochAdvanceWindow, now: timeutil.Now}
+	t.mu.advances = make(map[slpb.StoreIdent][]time.Time)
+	return t
+}
+
+// record records an epoch advance of the support for the given store.
+func (t *epochAdvanceTracker) record(id slpb.StoreIdent) {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.mu.advances[id] = append(t.pruneLocked(id, now), now)
+}
+
+// forget stops tracking the epoch advances of the given store.
+func (t *epochAdvanceTracker) forget(id slpb.StoreIdent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.mu.advances, id)
+}
+
+// pruneLocked drops the epoch advances of the given store that are older than
+// the window, and returns the remaining ones.
+func (t *epochAdvanceTracker) pruneLocked(id slpb.StoreIdent, now time.Time) []time.Time {
+	advances := t.mu.advances[id]
+	i := 0
+	for i < len(advances) && now.Sub(advances[i]) > t.window {
+		i++
+	}
+	advances = advances[i:]
+	if len(advances) == 0 {
+		delete(t.mu.advances, id)
+	} else {
+		t.mu.advances[id] = advances
+	}
+	return advances
+}
+
+// flapping returns the stores whose support epoch advanced more than
+// minAdvances times within the window, sorted by store.
+func (t *epochAdvanceTracker) flapping(minAdvances int) []flappingStore {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var stores []flappingStore
+	for id := range t.mu.advances {
+		if n := len(t.pruneLocked(id, now)); n > minAdvances {
+			stores = append(stores, flappingStore{id: id, epochAdvances: n})
+		}
+	}
+	slices.SortFunc(stores, func(a, b flappingStore) int {
+		return cmp.Or(
+			cmp.Compare(a.id.NodeID, b.id.NodeID),
+			cmp.Compare(a.id.StoreID, b.id.StoreID),
+		)
+	})
+	return stores
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package storeliveness
+
+import "github.com/cockroachdb/cockroach/pkg/util/metric"
+
+var (
+	metaSupportForCount = metric.Metadata{
+		Name:        "storeliveness.support_for_count",
+		Help:        "Number of remote stores for which the local store tracks support",
+		Measurement: "Stores",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaHeartbeatsHandled = metric.Metadata{
+		Name:        "storeliveness.heartbeats_handled",
+		Help:        "Number of Store Liveness heartbeats handled by the local store",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaHeartbeatsStale = metric.Metadata{
+		Name:        "storeliveness.heartbeats_stale",
+		Help:        "Number of Store Liveness heartbeats handled by the local store for an epoch lower than the supported one",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaSupportWithdrawn = metric.Metadata{
+		Name:        "storeliveness.support_withdrawn",
+		Help:        "Number of times the local store withdrew support for a remote store",
+		Measurement: "Withdrawals",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaEpochAdvances = metric.Metadata{
+		Name:        "storeliveness.epoch_advances",
+		Help:        "Number of times the epoch of the support provided by the local store for a remote store advanced",
+		Measurement: "Epochs",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaWithdrawalClockRegressions = metric.Metadata{
+		Name:        "storeliveness.withdrawal_clock_regressions",
+		Help:        "Number of times support was withdrawn at a timestamp below the max withdrawn timestamp",
+		Measurement: "Withdrawals",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaMaxWithdrawnAge = metric.Metadata{
+		Name:        "storeliveness.max_withdrawn_age",
+		Help:        "Time elapsed since the local store last withdrew support for a remote store",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+)
+
+// SupporterMetrics are the metrics of the support provided by a store for
+// other stores.
+type SupporterMetrics struct {
+	SupportForCount            *metric.Gauge
+	HeartbeatsHandled          *metric.Counter
+	HeartbeatsStale            *metric.Counter
+	SupportWithdrawn           *metric.Counter
+	EpochAdvances              *metric.Counter
+	WithdrawalClockRegressions *metric.Counter
+	MaxWithdrawnAge            *metric.Gauge
+}
+
+var _ metric.Struct = (*SupporterMetrics)(nil)
+
+// MetricStruct implements the metric.Struct interface.
+func (*SupporterMetrics) MetricStruct() {}
+
+func newSupporterMetrics() *SupporterMetrics {
+	return &SupporterMetrics{
+		SupportForCount:            metric.NewGauge(metaSupportForCount),
+		HeartbeatsHandled:          metric.NewCounter(metaHeartbeatsHandled),
+		HeartbeatsStale:            metric.NewCounter(metaHeartbeatsStale),
+		SupportWithdrawn:           metric.NewCounter(metaSupportWithdrawn),
+		EpochAdvances:              metric.NewCounter(metaEpochAdvances),
+		WithdrawalClockRegressions: metric.NewCounter(metaWithdrawalClockRegressions),
+		MaxWithdrawnAge:            metric.NewGauge(metaMaxWithdrawnAge),
+	}
+}
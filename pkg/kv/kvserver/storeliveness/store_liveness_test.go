@@ -24,7 +24,9 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/datadriven"
+	"github.com/stretchr/testify/require"
 )
 
 func TestStoreLiveness(t *testing.T) {
@@ -35,7 +37,22 @@ func TestStoreLiveness(t *testing.T) {
 
 	datadriven.Walk(
 		t, datapathutils.TestDataPath(t), func(t *testing.T, path string) {
-			ss := newSupporterStateHandler()
+			// epochAdvancesNow is the clock of the epoch advance tracker.
+			epochAdvancesNow := timeutil.Unix(0, 0)
+			// withdrawn holds the notifications of the support withdrawn callback,
+			// until they are printed.
+			var withdrawn []string
+			newSupporter := func() *supporterStateHandler {
+				ss := newSupporterStateHandler()
+				ss.epochAdvances.now = func() time.Time { return epochAdvancesNow }
+				ss.registerSupportWithdrawnCallback(func(id slpb.StoreIdent, epoch slpb.Epoch) {
+					// The withdrawal must be visible to the callback.
+					require.Equal(t, slpb.SupportState{Target: id, Epoch: epoch}, ss.getSupportFor(id))
+					withdrawn = append(withdrawn, fmt.Sprintf("%+v epoch:%d", id, epoch))
+				})
+				return ss
+			}
+			ss := newSupporter()
 			rs := newRequesterStateHandler()
 			datadriven.RunTest(
 				t, path, func(t *testing.T, d *datadriven.TestData) string {
@@ -46,8 +63,14 @@ func TestStoreLiveness(t *testing.T) {
 						return ""
 
 					case "remove-store":
+						// The store is gone for good (e.g. decommissioned), so the local
+						// store neither requests support from it nor supports it anymore.
 						remoteID := parseStoreID(t, d, "node-id", "store-id")
+						persisted := !d.HasArg("persist-fails")
 						rs.removeStore(remoteID)
+						ssfu := checkOutSupporterUpdate(ss)
+						ssfu.removeStore(remoteID)
+						ss.checkInUpdateIfPersisted(ssfu, persisted)
 						return ""
 
 					case "support-from":
@@ -60,6 +83,41 @@ func TestStoreLiveness(t *testing.T) {
 						supportState := ss.getSupportFor(remoteID)
 						return fmt.Sprintf("supporter state: %+v", supportState)
 
+					case "get-all-support-for":
+						var supportFor []string
+						for _, support := range ss.getAllSupportFor() {
+							supportFor = append(supportFor, fmt.Sprintf("%+v", support))
+						}
+						return strings.Join(supportFor, "\n")
+
+					case "max-withdrawn":
+						return fmt.Sprintf("max-withdrawn: %s", ss.getMaxWithdrawn())
+
+					case "advance-epoch-tracker-clock":
+						var by string
+						d.ScanArgs(t, "by", &by)
+						duration, err := time.ParseDuration(by)
+						if err != nil {
+							t.Errorf("can't parse duration %s; error: %v", by, err)
+						}
+						epochAdvancesNow = epochAdvancesNow.Add(duration)
+						return ""
+
+					case "flapping-stores":
+						var minAdvances int
+						d.ScanArgs(t, "min-advances", &minAdvances)
+						var stores []string
+						for _, fs := range ss.getFlappingStores(minAdvances) {
+							stores = append(stores, fmt.Sprintf("%+v epoch-advances:%d", fs.id, fs.epochAdvances))
+						}
+						return strings.Join(stores, "\n")
+
+					case "support-expiry-remaining":
+						remoteID := parseStoreID(t, d, "node-id", "store-id")
+						now := parseTimestamp(t, d, "now")
+						remaining := ss.getSupportExpiryRemaining(remoteID, hlc.ClockTimestamp(now))
+						return fmt.Sprintf("support expiry remaining: %s", remaining)
+
 					case "send-heartbeats":
 						now := parseTimestamp(t, d, "now")
 						var interval string
@@ -74,22 +132,52 @@ func TestStoreLiveness(t *testing.T) {
 						return fmt.Sprintf("heartbeats:\n%s", printMsgs(heartbeats))
 
 					case "handle-messages":
-						msgs := parseMsgs(t, d, storeID)
+						// NB: parsing the input overwrites the arguments of d.
+						persisted := !d.HasArg("persist-fails")
 						var responses []slpb.Message
 						rsfu := rs.checkOutUpdate()
-						ssfu := ss.checkOutUpdate()
-						for _, msg := range msgs {
-							switch msg.Type {
-							case slpb.MsgHeartbeat:
-								responses = append(responses, ssfu.handleHeartbeat(msg))
-							case slpb.MsgHeartbeatResp:
-								rsfu.handleHeartbeatResponse(msg)
+						ssfu := checkOutSupporterUpdate(ss)
+						// Consecutive heartbeats are handled together, and their responses
+						// must correspond one-to-one, in order.
+						var heartbeats []slpb.Message
+						handleHeartbeats := func() {
+							heartbeatResponses := ssfu.handleHeartbeats(heartbeats)
+							require.Len(t, heartbeatResponses, len(heartbeats))
+							for i := range heartbeats {
+								require.Equal(t, heartbeats[i].From, heartbeatResponses[i].To)
+							}
+							responses = append(responses, heartbeatResponses...)
+							heartbeats = heartbeats[:0]
+						}
+						// Each line of the input is either a message, or the removal of a
+						// store from the support provided in the same batch.
+						for _, line := range strings.Split(d.Input, "\n") {
+							var err error
+							d.Cmd, d.CmdArgs, err = datadriven.ParseLine(line)
+							if err != nil {
+								d.Fatalf(t, "error parsing message: %v", err)
+							}
+							switch d.Cmd {
+							case "msg":
+								msg := parseMsg(t, d, storeID)
+								switch msg.Type {
+								case slpb.MsgHeartbeat:
+									heartbeats = append(heartbeats, msg)
+								case slpb.MsgHeartbeatResp:
+									rsfu.handleHeartbeatResponse(msg)
+								default:
+									log.Errorf(context.Background(), "unexpected message type: %v", msg.Type)
+								}
+							case "remove-store":
+								handleHeartbeats()
+								ssfu.removeStore(parseStoreID(t, d, "node-id", "store-id"))
 							default:
-								log.Errorf(context.Background(), "unexpected message type: %v", msg.Type)
+								d.Fatalf(t, "expected \"msg\" or \"remove-store\", found %s", d.Cmd)
 							}
 						}
+						handleHeartbeats()
 						rs.checkInUpdate(rsfu)
-						ss.checkInUpdate(ssfu)
+						ss.checkInUpdateIfPersisted(ssfu, persisted)
 						if len(responses) > 0 {
 							return fmt.Sprintf("responses:\n%s", printMsgs(responses))
 						} else {
@@ -98,9 +186,31 @@ func TestStoreLiveness(t *testing.T) {
 
 					case "withdraw-support":
 						now := parseTimestamp(t, d, "now")
-						ssfu := ss.checkOutUpdate()
-						ssfu.withdrawSupport(hlc.ClockTimestamp(now))
-						ss.checkInUpdate(ssfu)
+						persisted := !d.HasArg("persist-fails")
+						ssfu := checkOutSupporterUpdate(ss)
+						withdrawn := ssfu.withdrawSupport(hlc.ClockTimestamp(now))
+						ss.checkInUpdateIfPersisted(ssfu, persisted)
+						if len(withdrawn) == 0 {
+							return ""
+						}
+						return fmt.Sprintf("withdrawn:\n%s", printStoreIDs(withdrawn))
+
+					case "pause-withdrawal":
+						ss.pauseWithdrawal(parseTimestamp(t, d, "until"))
+						return ""
+
+					case "gc-inactive":
+						now := parseTimestamp(t, d, "now")
+						var threshold string
+						d.ScanArgs(t, "threshold", &threshold)
+						gcThreshold, err := time.ParseDuration(threshold)
+						if err != nil {
+							t.Errorf("can't parse threshold duration %s; error: %v", threshold, err)
+						}
+						persisted := !d.HasArg("persist-fails")
+						ssfu := checkOutSupporterUpdate(ss)
+						ssfu.gcInactive(hlc.ClockTimestamp(now), gcThreshold)
+						ss.checkInUpdateIfPersisted(ssfu, persisted)
 						return ""
 
 					case "restart":
@@ -112,6 +222,65 @@ func TestStoreLiveness(t *testing.T) {
 						rs.checkInUpdate(rsfu)
 						return ""
 
+					case "support-withdrawn-notifications":
+						slices.Sort(withdrawn)
+						notifications := strings.Join(withdrawn, "\n")
+						withdrawn = nil
+						return notifications
+
+					case "supporter-metrics":
+						m := ss.metrics
+						return fmt.Sprintf(
+							"support-for-count: %d\nheartbeats-handled: %d\nheartbeats-stale: %d\n"+
+								"support-withdrawn: %d\nepoch-advances: %d\n"+
+								"withdrawal-clock-regressions: %d\nmax-withdrawn-age: %s",
+							m.SupportForCount.Value(), m.HeartbeatsHandled.Count(),
+							m.HeartbeatsStale.Count(), m.SupportWithdrawn.Count(),
+							m.EpochAdvances.Count(), m.WithdrawalClockRegressions.Count(),
+							time.Duration(m.MaxWithdrawnAge.Value()),
+						)
+
+					case "load-supporter-state":
+						// The supporter state is loaded from disk by a new handler, as
+						// after a restart.
+						var version int
+						d.ScanArgs(t, "version", &version)
+						meta := slpb.SupporterMeta{
+							MaxWithdrawn: hlc.ClockTimestamp(parseTimestamp(t, d, "max-withdrawn")),
+							Version:      uint32(version),
+						}
+						var supportFor, tombstones []slpb.SupportState
+						for _, line := range strings.Split(d.Input, "\n") {
+							var err error
+							d.Cmd, d.CmdArgs, err = datadriven.ParseLine(line)
+							if err != nil {
+								d.Fatalf(t, "error parsing support state: %v", err)
+							}
+							remoteID := parseStoreID(t, d, "node-id", "store-id")
+							var epoch int64
+							d.ScanArgs(t, "epoch", &epoch)
+							switch d.Cmd {
+							case "support":
+								supportFor = append(supportFor, slpb.SupportState{
+									Target:     remoteID,
+									Epoch:      slpb.Epoch(epoch),
+									Expiration: parseTimestamp(t, d, "expiration"),
+								})
+							case "tombstone":
+								tombstones = append(tombstones, slpb.SupportState{
+									Target: remoteID,
+									Epoch:  slpb.Epoch(epoch),
+								})
+							default:
+								d.Fatalf(t, "expected \"support\" or \"tombstone\", found %s", d.Cmd)
+							}
+						}
+						ss = newSupporter()
+						if err := ss.loadSupporterState(context.Background(), meta, supportFor, tombstones); err != nil {
+							return fmt.Sprintf("error: %v", err)
+						}
+						return ""
+
 					case "debug-requester-state":
 						return fmt.Sprintf(
 							"meta:\n%+v\nsupport from:\n%+v", rs.requesterState.meta,
@@ -119,9 +288,15 @@ func TestStoreLiveness(t *testing.T) {
 						)
 
 					case "debug-supporter-state":
+						var tombstones string
+						if len(ss.supporterState.tombstones) > 0 {
+							tombstones = fmt.Sprintf(
+								"\ntombstones:\n%+v", printTombstones(ss.supporterState.tombstones),
+							)
+						}
 						return fmt.Sprintf(
-							"meta:\n%+v\nsupport for:\n%+v", ss.supporterState.meta,
-							printSupportMap(ss.supporterState.supportFor),
+							"meta:\n%+v\nsupport for:\n%+v%s", ss.supporterState.meta,
+							printSupportMap(ss.supporterState.supportFor), tombstones,
 						)
 
 					default:
@@ -133,6 +308,89 @@ func TestStoreLiveness(t *testing.T) {
 	)
 }
 
+// BenchmarkWithdrawSupportNoop measures a periodic withdrawal pass that
+// doesn't withdraw support for any store.
+func BenchmarkWithdrawSupportNoop(b *testing.B) {
+	storeID := slpb.StoreIdent{NodeID: roachpb.NodeID(1), StoreID: roachpb.StoreID(1)}
+	for _, numStores := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("stores=%d", numStores), func(b *testing.B) {
+			ss := newSupporterStateHandler()
+			ssfu := ss.checkOutUpdate()
+			for i := 0; i < numStores; i++ {
+				ssfu.handleHeartbeat(slpb.Message{
+					Type:       slpb.MsgHeartbeat,
+					From:       slpb.StoreIdent{NodeID: roachpb.NodeID(i + 2), StoreID: roachpb.StoreID(i + 2)},
+					To:         storeID,
+					Epoch:      slpb.Epoch(1),
+					Expiration: hlc.Timestamp{WallTime: 1000},
+				})
+			}
+			ss.checkInUpdate(ssfu)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ssfu := ss.checkOutUpdate()
+				ssfu.withdrawSupport(hlc.ClockTimestamp{WallTime: 100})
+				ss.checkInUpdate(ssfu)
+			}
+		})
+	}
+}
+
+// TestCheckInUpdateIfPersisted verifies that a batch that failed to be
+// persisted can only be persisted again and checked in, without any further
+// updates. The retry itself is tested in testdata/supporter_persist_failure.
+func TestCheckInUpdateIfPersisted(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ss := newSupporterStateHandler()
+	ssfu := ss.checkOutUpdate()
+	ssfu.gcInactive(hlc.ClockTimestamp{WallTime: 100}, time.Second)
+	ss.checkInUpdateIfPersisted(ssfu, false /* persisted */)
+
+	ssfu = ss.checkOutUpdate()
+	require.True(t, ssfu.persistFailed)
+	require.Panics(t, func() { ssfu.withdrawSupport(hlc.ClockTimestamp{WallTime: 200}) })
+	require.Panics(t, func() { ssfu.gcInactive(hlc.ClockTimestamp{WallTime: 200}, time.Second) })
+	require.Panics(t, func() { ssfu.removeStore(slpb.StoreIdent{NodeID: 2, StoreID: 2}) })
+	ss.checkInUpdateIfPersisted(ssfu, true /* persisted */)
+
+	// The next update can be made once the batch is checked in.
+	ssfu = ss.checkOutUpdate()
+	require.False(t, ssfu.persistFailed)
+	ssfu.withdrawSupport(hlc.ClockTimestamp{WallTime: 200})
+	ss.checkInUpdate(ssfu)
+}
+
+// BenchmarkHandleHeartbeats measures handling a batch of heartbeats from many
+// stores.
+func BenchmarkHandleHeartbeats(b *testing.B) {
+	storeID := slpb.StoreIdent{NodeID: roachpb.NodeID(1), StoreID: roachpb.StoreID(1)}
+	for _, numStores := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("stores=%d", numStores), func(b *testing.B) {
+			ss := newSupporterStateHandler()
+			msgs := make([]slpb.Message, numStores)
+			for i := range msgs {
+				msgs[i] = slpb.Message{
+					Type:  slpb.MsgHeartbeat,
+					From:  slpb.StoreIdent{NodeID: roachpb.NodeID(i + 2), StoreID: roachpb.StoreID(i + 2)},
+					To:    storeID,
+					Epoch: slpb.Epoch(1),
+				}
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := range msgs {
+					msgs[j].Expiration = hlc.Timestamp{WallTime: int64(i + 1)}
+				}
+				ssfu := ss.checkOutUpdate()
+				ssfu.handleHeartbeats(msgs)
+				ss.checkInUpdate(ssfu)
+			}
+		})
+	}
+}
+
 func printMsgs(msgs []slpb.Message) string {
 	var sortedMsgs []string
 	for _, msg := range msgs {
@@ -152,6 +410,24 @@ func printSupportMap(m map[slpb.StoreIdent]slpb.SupportState) string {
 	return strings.Join(sortedSupportMap, "\n")
 }
 
+func printStoreIDs(ids []slpb.StoreIdent) string {
+	var sortedIDs []string
+	for _, id := range ids {
+		sortedIDs = append(sortedIDs, fmt.Sprintf("%+v", id))
+	}
+	slices.Sort(sortedIDs)
+	return strings.Join(sortedIDs, "\n")
+}
+
+func printTombstones(m map[slpb.StoreIdent]slpb.Epoch) string {
+	var sortedTombstones []string
+	for id, epoch := range m {
+		sortedTombstones = append(sortedTombstones, fmt.Sprintf("%+v Epoch:%d", id, epoch))
+	}
+	slices.Sort(sortedTombstones)
+	return strings.Join(sortedTombstones, "\n")
+}
+
 func parseStoreID(
 	t *testing.T, d *datadriven.TestData, nodeStr string, storeStr string,
 ) slpb.StoreIdent {
@@ -172,41 +448,28 @@ func parseTimestamp(t *testing.T, d *datadriven.TestData, timeStr string) hlc.Ti
 	return hlc.Timestamp{WallTime: wallTime}
 }
 
-func parseMsgs(t *testing.T, d *datadriven.TestData, storeIdent slpb.StoreIdent) []slpb.Message {
-	var msgs []slpb.Message
-	lines := strings.Split(d.Input, "\n")
-	for _, line := range lines {
-		var err error
-		d.Cmd, d.CmdArgs, err = datadriven.ParseLine(line)
-		if err != nil {
-			d.Fatalf(t, "error parsing message: %v", err)
-		}
-		if d.Cmd != "msg" {
-			d.Fatalf(t, "expected \"msg\", found %s", d.Cmd)
-		}
-		var msgTypeStr string
-		d.ScanArgs(t, "type", &msgTypeStr)
-		var msgType slpb.MessageType
-		switch msgTypeStr {
-		case slpb.MsgHeartbeat.String():
-			msgType = slpb.MsgHeartbeat
-		case slpb.MsgHeartbeatResp.String():
-			msgType = slpb.MsgHeartbeatResp
-		default:
-			d.Fatalf(t, "unexpected \"type\", found %s", msgTypeStr)
-		}
-		remoteID := parseStoreID(t, d, "from-node-id", "from-store-id")
-		var epoch int64
-		d.ScanArgs(t, "epoch", &epoch)
-		expiration := parseTimestamp(t, d, "expiration")
-		msg := slpb.Message{
-			Type:       msgType,
-			From:       remoteID,
-			To:         storeIdent,
-			Epoch:      slpb.Epoch(epoch),
-			Expiration: expiration,
-		}
-		msgs = append(msgs, msg)
+// parseMsg parses a message from the arguments of d.
+func parseMsg(t *testing.T, d *datadriven.TestData, storeIdent slpb.StoreIdent) slpb.Message {
+	var msgTypeStr string
+	d.ScanArgs(t, "type", &msgTypeStr)
+	var msgType slpb.MessageType
+	switch msgTypeStr {
+	case slpb.MsgHeartbeat.String():
+		msgType = slpb.MsgHeartbeat
+	case slpb.MsgHeartbeatResp.String():
+		msgType = slpb.MsgHeartbeatResp
+	default:
+		d.Fatalf(t, "unexpected \"type\", found %s", msgTypeStr)
+	}
+	remoteID := parseStoreID(t, d, "from-node-id", "from-store-id")
+	var epoch int64
+	d.ScanArgs(t, "epoch", &epoch)
+	expiration := parseTimestamp(t, d, "expiration")
+	return slpb.Message{
+		Type:       msgType,
+		From:       remoteID,
+		To:         storeIdent,
+		Epoch:      slpb.Epoch(epoch),
+		Expiration: expiration,
 	}
-	return msgs
 }
@@ -11,11 +11,31 @@
 package storeliveness
 
 import (
+	"cmp"
+	"context"
+	"slices"
 	"sync/atomic"
+	"time"
 
 	slpb "github.com/cockroachdb/cockroach/pkg/kv/kvserver/storeliveness/storelivenesspb"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+)
+
+// supporterStateVersion is the version of the format of the persisted
+// supporterState, recorded in SupporterMeta.Version. It must be incremented
+// whenever SupporterMeta or SupportState change in a way that older binaries
+// would misinterpret.
+//
+// Version 2 introduced supporterState.tombstones, which older binaries would
+// ignore, providing support again for epochs whose support was withdrawn.
+const supporterStateVersion uint32 = 2
+
+var (
+	logStaleHeartbeatEvery       = log.Every(10 * time.Second)
+	logWithdrawalRegressionEvery = log.Every(10 * time.Second)
 )
 
 // supporterState stores the core data structures for providing support.
@@ -26,6 +46,19 @@ type supporterState struct {
 	// supportFor stores the SupportState for each remote store for which this
 	// store has provided support.
 	supportFor map[slpb.StoreIdent]slpb.SupportState
+	// withdrawnAt stores the time at which support was withdrawn for each remote
+	// store in supportFor that is not currently supported. It is used to remove
+	// long-inactive stores from supportFor. It is not persisted to disk; after a
+	// restart, the inactivity of a store is measured from the first call to
+	// gcInactive.
+	withdrawnAt map[slpb.StoreIdent]hlc.ClockTimestamp
+	// tombstones stores, for each remote store removed from supportFor, the
+	// minimum epoch for which support can be provided to that store again. It
+	// ensures that support for an epoch is never provided again after it was
+	// withdrawn, even if the store was removed in the meantime. A store is
+	// never present in both supportFor and tombstones. It is persisted along
+	// with supportFor.
+	tombstones map[slpb.StoreIdent]slpb.Epoch
 }
 
 // supporterStateHandler is the main interface for handling support for other
@@ -35,14 +68,42 @@ type supporterState struct {
 //     ssfu.handleHeartbeat(msg slpb.Message)
 //     checkInUpdate(ssfu)
 //   - ssfu := checkOutUpdate()
+//     ssfu.handleHeartbeats(msgs []slpb.Message)
+//     checkInUpdate(ssfu)
+//   - ssfu := checkOutUpdate()
 //     ssfu.withdrawSupport(now hlc.ClockTimestamp)
 //     checkInUpdate(ssfu)
+//   - ssfu := checkOutUpdate()
+//     ssfu.gcInactive(now hlc.ClockTimestamp, threshold time.Duration)
+//     checkInUpdate(ssfu)
+//   - ssfu := checkOutUpdate()
+//     ssfu.removeStore(id slpb.StoreIdent)
+//     checkInUpdate(ssfu)
+//
+// If persisting the updates to disk can fail, checkInUpdateIfPersisted(ssfu,
+// persisted) is used instead of checkInUpdate. After a failure, the next
+// checkOutUpdate returns the same batch, with persistFailed set; it must be
+// persisted again and checked in before any other update is made:
+//   - ssfu := checkOutUpdate()
+//     if ssfu.persistFailed, persist ssfu again, then
+//     checkInUpdateIfPersisted(ssfu, persisted)
 //
 // Only one update can be in progress to ensure that multiple mutation methods
 // are not run concurrently.
 //
 // Adding a store to support is done automatically when a heartbeat from that
-// store is first received. Currently, a store is never removed.
+// store is first received. A store is removed by gcInactive once support for it
+// has been withdrawn for longer than a threshold, or explicitly by removeStore
+// (e.g. when the store is decommissioned). A removed store leaves a tombstone
+// behind, so that support is not provided again for the epochs for which it
+// was withdrawn.
+//
+// Interested subsystems can register a callback via
+// registerSupportWithdrawnCallback to be notified promptly when support for a
+// store is withdrawn, instead of polling getSupportFor.
+//
+// Support withdrawal can be paused temporarily via pauseWithdrawal (e.g.
+// during planned maintenance), while heartbeats are still handled.
 type supporterStateHandler struct {
 	// supporterState is the source of truth for provided support.
 	supporterState supporterState
@@ -57,22 +118,59 @@ type supporterStateHandler struct {
 	// A non-nil update implies there is no ongoing update; i.e. the referenced
 	// requesterStateForUpdate is available to be checked out.
 	update atomic.Pointer[supporterStateForUpdate]
+	// metrics are the metrics of the provided support. They are meant to be
+	// registered with the metric registry of the owner of supporterStateHandler.
+	metrics *SupporterMetrics
+	// epochAdvances tracks the recent epoch advances of the support for each
+	// store, to detect stores whose support is flapping.
+	epochAdvances *epochAdvanceTracker
+	// withdrawalPausedUntil is the timestamp until which withdrawSupport does
+	// not withdraw support. See pauseWithdrawal.
+	withdrawalPausedUntil atomic.Pointer[hlc.Timestamp]
+	// supportWithdrawnCallbacks are invoked for each store for which support
+	// was withdrawn, once the withdrawal has been checked in.
+	supportWithdrawnCallbacks struct {
+		syncutil.Mutex
+		fns []supportWithdrawnCallback
+	}
+}
+
+// pauseWithdrawal pauses the withdrawal of support until the given timestamp:
+// withdrawSupport is a no-op when called with an earlier timestamp. Heartbeats
+// are still handled while withdrawal is paused, so support that is extended in
+// the meantime is not withdrawn once the pause ends. An empty timestamp resumes
+// withdrawal immediately.
+func (ssh *supporterStateHandler) pauseWithdrawal(until hlc.Timestamp) {
+	ssh.withdrawalPausedUntil.Store(&until)
 }
 
+// supportWithdrawnCallback is invoked with the identity of a store for which
+// support was withdrawn, and the new epoch of the support for that store.
+type supportWithdrawnCallback func(id slpb.StoreIdent, epoch slpb.Epoch)
+
 func newSupporterStateHandler() *supporterStateHandler {
 	ssh := &supporterStateHandler{
 		supporterState: supporterState{
-			meta:       slpb.SupporterMeta{},
-			supportFor: make(map[slpb.StoreIdent]slpb.SupportState),
+			meta:        slpb.SupporterMeta{Version: supporterStateVersion},
+			supportFor:  make(map[slpb.StoreIdent]slpb.SupportState),
+			withdrawnAt: make(map[slpb.StoreIdent]hlc.ClockTimestamp),
+			tombstones:  make(map[slpb.StoreIdent]slpb.Epoch),
 		},
+		metrics:       newSupporterMetrics(),
+		epochAdvances: newEpochAdvanceTracker(),
 	}
 	ssh.update.Store(
 		&supporterStateForUpdate{
 			checkedIn: &ssh.supporterState,
 			inProgress: supporterState{
-				meta:       slpb.SupporterMeta{},
-				supportFor: make(map[slpb.StoreIdent]slpb.SupportState),
+				meta:        slpb.SupporterMeta{},
+				supportFor:  make(map[slpb.StoreIdent]slpb.SupportState),
+				withdrawnAt: make(map[slpb.StoreIdent]hlc.ClockTimestamp),
+				tombstones:  make(map[slpb.StoreIdent]slpb.Epoch),
 			},
+			removed:               make(map[slpb.StoreIdent]struct{}),
+			metrics:               ssh.metrics,
+			withdrawalPausedUntil: &ssh.withdrawalPausedUntil,
 		},
 	)
 	return ssh
@@ -93,14 +191,157 @@ type supporterStateForUpdate struct {
 	// have not yet been reflected in the checkedIn view. The inProgress view
 	// ensures that ongoing updates from the same batch see each other's changes.
 	inProgress supporterState
+	// withdrawn holds the SupportState of each store for which support was
+	// withdrawn in this batch. It is used to notify the registered
+	// supportWithdrawnCallbacks once the batch is checked in.
+	withdrawn []slpb.SupportState
+	// removed holds the stores removed from supportFor in this batch. A store
+	// that is both removed and present in inProgress.supportFor (e.g. because a
+	// heartbeat from it was handled after the removal) is not removed.
+	removed map[slpb.StoreIdent]struct{}
+	// heartbeatsHandled and heartbeatsStale count the heartbeats handled in this
+	// batch, and advanced holds the stores whose support epoch advanced in this
+	// batch. They are only reflected in supporterStateHandler.metrics and
+	// supporterStateHandler.epochAdvances once the batch is checked in, so that
+	// a batch that is persisted again after a failure is counted once.
+	heartbeatsHandled int64
+	heartbeatsStale   int64
+	advanced          []slpb.StoreIdent
+	// persistFailed is set if persisting this batch failed. The batch must then
+	// be persisted again and checked in, without making any further updates.
+	persistFailed bool
+	// metrics is a reference to supporterStateHandler.metrics.
+	metrics *SupporterMetrics
+	// withdrawalPausedUntil is a reference to
+	// supporterStateHandler.withdrawalPausedUntil.
+	withdrawalPausedUntil *atomic.Pointer[hlc.Timestamp]
+}
+
+// loadSupporterState initializes supporterState with the SupporterMeta,
+// SupportStates and tombstones loaded from disk. The tombstones are given as
+// SupportStates with the minimum epoch and an empty expiration. It must be
+// called before any update is checked out. If the state was persisted with a newer version of the format
+// than supporterStateVersion, e.g. by a newer binary before a downgrade, the
+// state is not loaded and an error is returned; the store must then refuse to
+// start, since misinterpreting the state could break the guarantees of Store
+// Liveness. A state with an older version is migrated to the current one.
+func (ssh *supporterStateHandler) loadSupporterState(
+	ctx context.Context, meta slpb.SupporterMeta, supportFor, tombstones []slpb.SupportState,
+) error {
+	if meta.Version > supporterStateVersion {
+		err := errors.Newf("persisted supporter state has version %d, newer than the supported version %d",
+			meta.Version, supporterStateVersion)
+		log.Errorf(ctx, "%v", err)
+		return err
+	}
+	// The states persisted with older versions have no tombstones, so migrating
+	// only requires recording the current version.
+	meta.Version = supporterStateVersion
+	ssh.mu.Lock()
+	defer ssh.mu.Unlock()
+	ssh.supporterState.meta = meta
+	for _, ss := range supportFor {
+		ssh.supporterState.supportFor[ss.Target] = ss
+	}
+	for _, ss := range tombstones {
+		ssh.supporterState.tombstones[ss.Target] = ss.Epoch
+	}
+	ssh.metrics.SupportForCount.Update(int64(len(ssh.supporterState.supportFor)))
+	return nil
 }
 
 // getSupportFor returns the SupportState corresponding to the given store in
-// supporterState.supportFor.
+// supporterState.supportFor. For a removed store, it returns the minimum epoch
+// for which support can be provided to the store again, without support.
 func (ssh *supporterStateHandler) getSupportFor(id slpb.StoreIdent) slpb.SupportState {
 	ssh.mu.RLock()
 	defer ssh.mu.RUnlock()
-	return ssh.supporterState.supportFor[id]
+	ss, ok := ssh.supporterState.supportFor[id]
+	if !ok {
+		if epoch, ok := ssh.supporterState.tombstones[id]; ok {
+			return slpb.SupportState{Target: id, Epoch: epoch}
+		}
+	}
+	return ss
+}
+
+// getMaxWithdrawn returns the maximum timestamp at which support was withdrawn,
+// as stored in supporterState.meta. Like getSupportFor, it reflects the
+// checked-in view, even while an update is in progress.
+func (ssh *supporterStateHandler) getMaxWithdrawn() hlc.Timestamp {
+	ssh.mu.RLock()
+	defer ssh.mu.RUnlock()
+	return ssh.supporterState.meta.MaxWithdrawn.ToTimestamp()
+}
+
+// getSupportExpiryRemaining returns how long from now the support for the
+// given store expires. It is negative if the support has expired but has not
+// been withdrawn yet, and zero if no support is provided for the store. Like
+// getSupportFor, it reflects the checked-in view, even while an update is in
+// progress.
+func (ssh *supporterStateHandler) getSupportExpiryRemaining(
+	id slpb.StoreIdent, now hlc.ClockTimestamp,
+) time.Duration {
+	ssh.mu.RLock()
+	defer ssh.mu.RUnlock()
+	ss, ok := ssh.supporterState.supportFor[id]
+	if !ok || ss.Expiration.IsEmpty() {
+		return 0
+	}
+	return time.Duration(ss.Expiration.WallTime - now.WallTime)
+}
+
+// getFlappingStores returns the stores whose support epoch advanced more than
+// minAdvances times recently, sorted by store. It is meant for debugging
+// unstable support, e.g. due to a flaky network link.
+func (ssh *supporterStateHandler) getFlappingStores(minAdvances int) []flappingStore {
+	return ssh.epochAdvances.flapping(minAdvances)
+}
+
+// getAllSupportFor returns a copy of the SupportState of all stores in
+// supporterState.supportFor, sorted by store. Like getSupportFor, it reflects
+// the checked-in view, even while an update is in progress.
+func (ssh *supporterStateHandler) getAllSupportFor() []slpb.SupportState {
+	ssh.mu.RLock()
+	supportFor := make([]slpb.SupportState, 0, len(ssh.supporterState.supportFor))
+	for _, ss := range ssh.supporterState.supportFor {
+		supportFor = append(supportFor, ss)
+	}
+	ssh.mu.RUnlock()
+	slices.SortFunc(supportFor, func(a, b slpb.SupportState) int {
+		return cmp.Or(
+			cmp.Compare(a.Target.NodeID, b.Target.NodeID),
+			cmp.Compare(a.Target.StoreID, b.Target.StoreID),
+		)
+	})
+	return supportFor
+}
+
+// registerSupportWithdrawnCallback registers a callback to be invoked whenever
+// support for a store is withdrawn. The callback is invoked once per store
+// whose support was withdrawn in a batch, after the batch has been checked in
+// and without holding supporterStateHandler.mu. The callback must not check out
+// an update from supporterStateHandler.
+func (ssh *supporterStateHandler) registerSupportWithdrawnCallback(fn supportWithdrawnCallback) {
+	ssh.supportWithdrawnCallbacks.Lock()
+	defer ssh.supportWithdrawnCallbacks.Unlock()
+	ssh.supportWithdrawnCallbacks.fns = append(ssh.supportWithdrawnCallbacks.fns, fn)
+}
+
+// notifySupportWithdrawn invokes the registered supportWithdrawnCallbacks for
+// each of the given stores.
+func (ssh *supporterStateHandler) notifySupportWithdrawn(withdrawn []slpb.SupportState) {
+	if len(withdrawn) == 0 {
+		return
+	}
+	ssh.supportWithdrawnCallbacks.Lock()
+	fns := ssh.supportWithdrawnCallbacks.fns
+	ssh.supportWithdrawnCallbacks.Unlock()
+	for _, ss := range withdrawn {
+		for _, fn := range fns {
+			fn(ss.Target, ss.Epoch)
+		}
+	}
 }
 
 // Functions for handling supporterState updates.
@@ -123,15 +364,53 @@ func (ssfu *supporterStateForUpdate) getSupportFor(
 ) (slpb.SupportState, bool) {
 	ss, ok := ssfu.inProgress.supportFor[storeID]
 	if !ok {
+		if _, removed := ssfu.removed[storeID]; removed {
+			return slpb.SupportState{}, false
+		}
 		ss, ok = ssfu.checkedIn.supportFor[storeID]
 	}
 	return ss, ok
 }
 
+// getTombstone returns the minimum epoch for which support can be provided to
+// the given store, if it was removed from supportFor, from the inProgress view;
+// if not present, it falls back to the checkedIn view. It returns zero for a
+// store that was never removed.
+func (ssfu *supporterStateForUpdate) getTombstone(storeID slpb.StoreIdent) slpb.Epoch {
+	if epoch, ok := ssfu.inProgress.tombstones[storeID]; ok {
+		return epoch
+	}
+	return ssfu.checkedIn.tombstones[storeID]
+}
+
 // reset clears the inProgress view of supporterStateForUpdate.
 func (ssfu *supporterStateForUpdate) reset() {
 	ssfu.inProgress.meta = slpb.SupporterMeta{}
-	clear(ssfu.inProgress.supportFor)
+	// Clearing a map takes time proportional to the size it once had, even if
+	// it is empty now, so only clear the maps that are not empty.
+	if len(ssfu.inProgress.supportFor) > 0 {
+		clear(ssfu.inProgress.supportFor)
+	}
+	if len(ssfu.inProgress.withdrawnAt) > 0 {
+		clear(ssfu.inProgress.withdrawnAt)
+	}
+	if len(ssfu.inProgress.tombstones) > 0 {
+		clear(ssfu.inProgress.tombstones)
+	}
+	if len(ssfu.removed) > 0 {
+		clear(ssfu.removed)
+	}
+	ssfu.withdrawn = ssfu.withdrawn[:0]
+	ssfu.heartbeatsHandled = 0
+	ssfu.heartbeatsStale = 0
+	ssfu.advanced = ssfu.advanced[:0]
+	ssfu.persistFailed = false
+}
+
+// assertUpdatable asserts that the batch can be updated; a batch that failed
+// to be persisted can only be persisted again and checked in.
+func (ssfu *supporterStateForUpdate) assertUpdatable() {
+	assert(!ssfu.persistFailed, "updating a batch that failed to be persisted")
 }
 
 // checkOutUpdate returns the supporterStateForUpdate referenced in
@@ -145,27 +424,92 @@ func (ssh *supporterStateHandler) checkOutUpdate() *supporterStateForUpdate {
 	return ssfu
 }
 
-// checkInUpdate updates the checkedIn view of supporterStateForUpdate with any
-// updates from the inProgress view. It clears the inProgress view, and swaps it
-// back in supporterStateHandler.update to be checked out by future updates.
+// checkInUpdate updates the metrics and the epochAdvances of the batch, and the
+// checkedIn view of supporterStateForUpdate with any updates from the
+// inProgress view, and removes the stores in
+// supporterStateForUpdate.removed, leaving their tombstones behind. Removals
+// are applied before the updates, so a store that is removed and then updated
+// in the same batch is kept, and its tombstone dropped. It clears
+// the inProgress view, and swaps it back in supporterStateHandler.update to be
+// checked out by future updates.
+// Once mu is released, it notifies the supportWithdrawnCallbacks of any support
+// withdrawn in this batch.
 func (ssh *supporterStateHandler) checkInUpdate(ssfu *supporterStateForUpdate) {
 	defer func() {
 		ssfu.reset()
 		ssh.update.Swap(ssfu)
 	}()
-	if ssfu.inProgress.meta == (slpb.SupporterMeta{}) && len(ssfu.inProgress.supportFor) == 0 {
+	defer ssh.notifySupportWithdrawn(ssfu.withdrawn)
+	ssh.metrics.HeartbeatsHandled.Inc(ssfu.heartbeatsHandled)
+	ssh.metrics.HeartbeatsStale.Inc(ssfu.heartbeatsStale)
+	ssh.metrics.SupportWithdrawn.Inc(int64(len(ssfu.withdrawn)))
+	ssh.metrics.EpochAdvances.Inc(int64(len(ssfu.advanced)))
+	for _, storeID := range ssfu.advanced {
+		ssh.epochAdvances.record(storeID)
+	}
+	// Reading from the checkedIn view without holding mu is safe here since
+	// there are no concurrent writes.
+	metaChanged := !ssfu.inProgress.meta.MaxWithdrawn.IsEmpty() &&
+		ssfu.inProgress.meta.MaxWithdrawn != ssfu.checkedIn.meta.MaxWithdrawn
+	supportForChanged := len(ssfu.inProgress.supportFor) > 0 ||
+		len(ssfu.inProgress.withdrawnAt) > 0 || len(ssfu.removed) > 0 ||
+		len(ssfu.inProgress.tombstones) > 0
+	// Avoid taking the write lock, which blocks concurrent readers, if nothing
+	// changed; e.g. a periodic withdrawal pass that didn't withdraw any support.
+	if !metaChanged && !supportForChanged {
 		return
 	}
 	ssh.mu.Lock()
 	defer ssh.mu.Unlock()
-	if ssfu.inProgress.meta != (slpb.SupporterMeta{}) {
-		if !ssfu.inProgress.meta.MaxWithdrawn.IsEmpty() {
-			ssfu.checkedIn.meta.MaxWithdrawn = ssfu.inProgress.meta.MaxWithdrawn
+	if metaChanged {
+		assert(ssfu.inProgress.meta.Version == supporterStateVersion,
+			"checking in a SupporterMeta with an unexpected version")
+		ssfu.checkedIn.meta = ssfu.inProgress.meta
+	}
+	if !supportForChanged {
+		return
+	}
+	for storeID := range ssfu.removed {
+		delete(ssfu.checkedIn.supportFor, storeID)
+		delete(ssfu.checkedIn.withdrawnAt, storeID)
+		if _, ok := ssfu.inProgress.supportFor[storeID]; !ok {
+			ssh.epochAdvances.forget(storeID)
 		}
 	}
+	for storeID, epoch := range ssfu.inProgress.tombstones {
+		ssfu.checkedIn.tombstones[storeID] = epoch
+	}
 	for storeID, ss := range ssfu.inProgress.supportFor {
 		ssfu.checkedIn.supportFor[storeID] = ss
+		delete(ssfu.checkedIn.tombstones, storeID)
+		if !ss.Expiration.IsEmpty() {
+			delete(ssfu.checkedIn.withdrawnAt, storeID)
+		}
+	}
+	for storeID, ts := range ssfu.inProgress.withdrawnAt {
+		ssfu.checkedIn.withdrawnAt[storeID] = ts
+	}
+	ssh.metrics.SupportForCount.Update(int64(len(ssfu.checkedIn.supportFor)))
+}
+
+// checkInUpdateIfPersisted is like checkInUpdate, for a batch whose
+// persistence to disk may have failed. If persisted is true, it is equivalent
+// to checkInUpdate. Otherwise, the checkedIn view is left unchanged, and the
+// inProgress view is kept intact, so that the batch can be persisted again;
+// supporterStateForUpdate is swapped back in supporterStateHandler.update with
+// persistFailed set. The retry must check it out, persist it again and check
+// it in, without making any further updates; this is asserted by the update
+// methods. The metrics, the epochAdvances and the supportWithdrawnCallbacks
+// are only updated once the batch is persisted.
+func (ssh *supporterStateHandler) checkInUpdateIfPersisted(
+	ssfu *supporterStateForUpdate, persisted bool,
+) {
+	if persisted {
+		ssh.checkInUpdate(ssfu)
+		return
 	}
+	ssfu.persistFailed = true
+	ssh.update.Swap(ssfu)
 }
 
 // Functions for handling heartbeats.
@@ -174,14 +518,33 @@ func (ssh *supporterStateHandler) checkInUpdate(ssfu *supporterStateForUpdate) {
 // view of supporterStateForUpdate only if there are any changes, and returns
 // a heartbeat response message.
 func (ssfu *supporterStateForUpdate) handleHeartbeat(msg slpb.Message) slpb.Message {
+	ssfu.assertUpdatable()
+	ssfu.heartbeatsHandled++
 	from := msg.From
 	ss, ok := ssfu.getSupportFor(from)
 	if !ok {
-		ss = slpb.SupportState{Target: from}
+		// A removed store can only be supported again for epochs at or above its
+		// tombstone; a heartbeat for a lower epoch is treated as stale.
+		ss = slpb.SupportState{Target: from, Epoch: ssfu.getTombstone(from)}
+	}
+	if msg.Epoch < ss.Epoch {
+		// A heartbeat for a stale epoch is expected after support for the epoch
+		// was withdrawn, until the requester learns about the new epoch from a
+		// heartbeat response. If the new epoch is supported already, the requester
+		// knows about it, so the heartbeat was reordered or there is a bug.
+		ssfu.heartbeatsStale++
+		if !ss.Expiration.IsEmpty() && logStaleHeartbeatEvery.ShouldLog() {
+			log.Warningf(context.Background(),
+				"received heartbeat from %+v for epoch %d while supporting epoch %d",
+				from, msg.Epoch, ss.Epoch)
+		}
 	}
 	ssNew := handleHeartbeat(ss, msg)
 	if ss != ssNew {
 		ssfu.inProgress.supportFor[from] = ssNew
+		if ok && ssNew.Epoch > ss.Epoch {
+			ssfu.recordEpochAdvance(from)
+		}
 	}
 	return slpb.Message{
 		Type:       slpb.MsgHeartbeatResp,
@@ -192,6 +555,25 @@ func (ssfu *supporterStateForUpdate) handleHeartbeat(msg slpb.Message) slpb.Mess
 	}
 }
 
+// handleHeartbeats handles a batch of heartbeat messages. Each message is
+// handled as in handleHeartbeat, against the same inProgress view of
+// supporterStateForUpdate. The returned heartbeat responses correspond
+// one-to-one, in order, to the given messages.
+func (ssfu *supporterStateForUpdate) handleHeartbeats(msgs []slpb.Message) []slpb.Message {
+	responses := make([]slpb.Message, len(msgs))
+	for i := range msgs {
+		responses[i] = ssfu.handleHeartbeat(msgs[i])
+	}
+	return responses
+}
+
+// recordEpochAdvance records an advance of the epoch of the support for the
+// given store, to be reflected in the metrics and the epochAdvances once the
+// batch is checked in.
+func (ssfu *supporterStateForUpdate) recordEpochAdvance(id slpb.StoreIdent) {
+	ssfu.advanced = append(ssfu.advanced, id)
+}
+
 // handleHeartbeat contains the core logic for updating the epoch and expiration
 // of a support requester upon receiving a heartbeat.
 func handleHeartbeat(ss slpb.SupportState, msg slpb.Message) slpb.SupportState {
@@ -210,23 +592,56 @@ func handleHeartbeat(ss slpb.SupportState, msg slpb.Message) slpb.SupportState {
 // Functions for withdrawing support.
 
 // withdrawSupport handles a single support withdrawal. It updates the
-// inProgress view of supporterStateForUpdate only if there are any changes.
-func (ssfu *supporterStateForUpdate) withdrawSupport(now hlc.ClockTimestamp) {
+// inProgress view of supporterStateForUpdate only if there are any changes, and
+// returns the stores for which support was withdrawn.
+func (ssfu *supporterStateForUpdate) withdrawSupport(now hlc.ClockTimestamp) []slpb.StoreIdent {
+	ssfu.assertUpdatable()
 	// Assert that there are no updates in ssfu.inProgress.supportFor to make
 	// sure we can iterate over ssfu.checkedIn.supportFor in the loop below.
 	assert(
-		len(ssfu.inProgress.supportFor) == 0, "reading from supporterStateForUpdate."+
-			"checkedIn.supportFor while supporterStateForUpdate.inProgress.supportFor is not empty",
+		len(ssfu.inProgress.supportFor) == 0 && len(ssfu.removed) == 0 &&
+			len(ssfu.inProgress.tombstones) == 0,
+		"reading from supporterStateForUpdate.checkedIn.supportFor while "+
+			"supporterStateForUpdate.inProgress.supportFor is not empty",
 	)
+	// If the clock regressed below MaxWithdrawn (e.g. across a restart), use
+	// MaxWithdrawn instead, as the clock should have been forwarded to it. This
+	// prevents the regressed clock from keeping support that has expired.
+	if maxWithdrawn := ssfu.getMeta().MaxWithdrawn; now.Less(maxWithdrawn) {
+		ssfu.metrics.WithdrawalClockRegressions.Inc(1)
+		if logWithdrawalRegressionEvery.ShouldLog() {
+			log.Warningf(context.Background(),
+				"withdrawing support at %s, below the max withdrawn timestamp %s", now, maxWithdrawn)
+		}
+		now = maxWithdrawn
+	}
+	// While withdrawal is paused, no support is withdrawn. Once the pause ends,
+	// the next call withdraws all the support that expired in the meantime.
+	if pausedUntil := ssfu.withdrawalPausedUntil.Load(); pausedUntil != nil &&
+		now.ToTimestamp().Less(*pausedUntil) {
+		return nil
+	}
+	var withdrawn []slpb.StoreIdent
 	for id, ss := range ssfu.checkedIn.supportFor {
 		ssNew := maybeWithdrawSupport(ss, now)
 		if ss != ssNew {
+			withdrawn = append(withdrawn, id)
 			ssfu.inProgress.supportFor[id] = ssNew
+			ssfu.withdrawn = append(ssfu.withdrawn, ssNew)
+			ssfu.inProgress.withdrawnAt[id] = now
+			ssfu.recordEpochAdvance(id)
 			if ssfu.getMeta().MaxWithdrawn.Less(now) {
 				ssfu.inProgress.meta.MaxWithdrawn.Forward(now)
+				// The inProgress meta is the one persisted, so it records the
+				// version of the format.
+				ssfu.inProgress.meta.Version = supporterStateVersion
 			}
 		}
 	}
+	if maxWithdrawn := ssfu.getMeta().MaxWithdrawn; !maxWithdrawn.IsEmpty() {
+		ssfu.metrics.MaxWithdrawnAge.Update(now.WallTime - maxWithdrawn.WallTime)
+	}
+	return withdrawn
 }
 
 // maybeWithdrawSupport contains the core logic for updating the epoch and
@@ -238,3 +653,66 @@ func maybeWithdrawSupport(ss slpb.SupportState, now hlc.ClockTimestamp) slpb.Sup
 	}
 	return ss
 }
+
+// Functions for removing stores.
+
+// removeStore removes the given store from supportFor. It is meant for stores
+// that are known to be gone for good (e.g. decommissioned); the local store
+// stops reporting any support it may still be providing for the removed store.
+//
+// The removed store leaves a tombstone, so that support is never provided
+// again for an epoch for which it was provided or withdrawn before the
+// removal. If support is still provided for the current epoch, the tombstone
+// is the next epoch.
+func (ssfu *supporterStateForUpdate) removeStore(id slpb.StoreIdent) {
+	ssfu.assertUpdatable()
+	ss, ok := ssfu.getSupportFor(id)
+	if !ok {
+		return
+	}
+	minEpoch := ss.Epoch
+	if !ss.Expiration.IsEmpty() {
+		minEpoch++
+	}
+	ssfu.inProgress.tombstones[id] = minEpoch
+	delete(ssfu.inProgress.supportFor, id)
+	delete(ssfu.inProgress.withdrawnAt, id)
+	if _, ok := ssfu.checkedIn.supportFor[id]; ok {
+		ssfu.removed[id] = struct{}{}
+	}
+}
+
+// gcInactive removes from supportFor the stores for which support has been
+// withdrawn for longer than the given threshold. It updates the inProgress view
+// of supporterStateForUpdate only if there are any changes.
+//
+// A removed store leaves a tombstone with its current epoch, for which support
+// has not been provided yet. If it sends a heartbeat again, support is only
+// provided for that epoch or a higher one, as if it had not been removed.
+func (ssfu *supporterStateForUpdate) gcInactive(now hlc.ClockTimestamp, threshold time.Duration) {
+	ssfu.assertUpdatable()
+	// Assert that there are no updates in ssfu.inProgress.supportFor to make
+	// sure we can iterate over ssfu.checkedIn.supportFor in the loop below.
+	assert(
+		len(ssfu.inProgress.supportFor) == 0 && len(ssfu.removed) == 0 &&
+			len(ssfu.inProgress.tombstones) == 0,
+		"reading from supporterStateForUpdate.checkedIn.supportFor while "+
+			"supporterStateForUpdate.inProgress.supportFor is not empty",
+	)
+	for id, ss := range ssfu.checkedIn.supportFor {
+		if !ss.Expiration.IsEmpty() {
+			continue
+		}
+		withdrawnAt, ok := ssfu.checkedIn.withdrawnAt[id]
+		if !ok {
+			// The withdrawal time is not known (e.g. after a restart); start
+			// measuring the inactivity of the store now.
+			ssfu.inProgress.withdrawnAt[id] = now
+			continue
+		}
+		if withdrawnAt.ToTimestamp().Add(threshold.Nanoseconds(), 0).Less(now.ToTimestamp()) {
+			ssfu.removed[id] = struct{}{}
+			ssfu.inProgress.tombstones[id] = ss.Epoch
+		}
+	}
+}
@@ -45,6 +45,8 @@ const (
 	PlaintextContentType = "text/plain"
 	// GzipEncoding is the gzip encoding.
 	GzipEncoding = "gzip"
+	// ZstdEncoding is the zstd encoding.
+	ZstdEncoding = "zstd"
 )
 
 type JSONOptions struct {
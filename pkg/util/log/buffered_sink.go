@@ -18,6 +18,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/cli/exit"
 	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
 )
 
@@ -28,8 +29,10 @@ import (
 // options.
 //
 // bufferedSink's output() method never blocks on the child (except when the
-// tryForceSync option is used). Instead, old messages are dropped if the buffer is
-// overflowing a configured limit.
+// tryForceSync option is used, e.g. for fatal errors). Instead, old messages are
+// dropped if the buffer is overflowing a configured limit (or new ones, if the
+// sink is non-blocking, except for tryForceSync messages; see
+// msgBuf.dropNewest).
 //
 // Should an error occur in the child sink, it's forwarded to the provided
 // onAsyncFlushErr (unless tryForceSync is requested, in which case the error is
@@ -40,6 +43,9 @@ import (
 type bufferedSink struct {
 	// child is the wrapped logSink.
 	child logSink
+	// sinkName is the name of the sink in the logging configuration, used
+	// to label its metrics.
+	sinkName string
 	// maxStaleness is the duration after which a flush is triggered.
 	// 0 disables this trigger.
 	maxStaleness time.Duration
@@ -67,6 +73,13 @@ type bufferedSink struct {
 		// timer is set when a flushAsync() call is scheduled to happen in the
 		// future.
 		timer *time.Timer
+		// dropReport tracks the messages dropped during the current
+		// reporting interval, to warn on stderr when too many are dropped.
+		dropReport struct {
+			start    time.Time
+			count    uint64
+			reported bool
+		}
 	}
 }
 
@@ -230,10 +243,16 @@ func (bs *bufferedSink) output(b []byte, opts sinkOutputOptions) error {
 		bs.mu.Lock()
 		defer bs.mu.Unlock()
 		// Append the message to the buffer.
-		err := bs.mu.buf.appendMsg(msg)
+		droppedBefore := bs.mu.buf.dropped
+		err := bs.mu.buf.appendMsg(msg, opts.tryForceSync /* evictOldest */)
+		bs.reportDropsLocked(bs.mu.buf.dropped - droppedBefore)
 		if err != nil {
 			// Release the msg buffer, since our append failed.
 			putBuffer(msg)
+			if errors.Is(err, errBufferFull) {
+				// The message was dropped, which was accounted for above.
+				return nil
+			}
 			return err
 		}
 
@@ -297,6 +316,76 @@ func (bs *bufferedSink) output(b []byte, opts sinkOutputOptions) error {
 	return nil
 }
 
+// flushSync flushes the buffered messages to the child sink and waits
+// for the flush to complete, returning the child sink's error. Unlike an
+// output() call with tryForceSync, it does not add a message to the
+// buffer, and it returns immediately if the buffer is empty. If a
+// synchronous flush is already scheduled, the buffered messages are
+// part of it and flushSync does not wait.
+func (bs *bufferedSink) flushSync() error {
+	var errC chan error
+	scheduled := func() bool {
+		bs.mu.Lock()
+		defer bs.mu.Unlock()
+		if len(bs.mu.buf.messages) == 0 || bs.mu.buf.errC != nil {
+			return false
+		}
+		errC = make(chan error)
+		bs.mu.buf.errC = errC
+		bs.flushAsyncLocked()
+		return true
+	}()
+	if !scheduled {
+		return nil
+	}
+	return <-errC
+}
+
+// bufferedSinkDropReportInterval and bufferedSinkDropReportThreshold
+// control when a bufferedSink warns on stderr about dropped messages: a
+// warning is printed when more than the threshold number of messages are
+// dropped within one interval.
+const bufferedSinkDropReportInterval = 10 * time.Second
+
+var bufferedSinkDropReportThreshold uint64 = 100
+
+// reportDropsLocked accounts for newly dropped messages in the metrics of
+// the sink, and prints a
+// warning on stderr, at most once per reporting interval, if the number
+// of messages dropped during the interval exceeds the threshold. This
+// makes it possible for operators to notice when a sink cannot keep up.
+func (bs *bufferedSink) reportDropsLocked(dropped uint64) {
+	if dropped == 0 {
+		return
+	}
+	r := &bs.mu.dropReport
+	now := timeutil.Now()
+	if now.Sub(r.start) >= bufferedSinkDropReportInterval {
+		r.start = now
+		r.count = 0
+		r.reported = false
+	}
+	if logging.metrics != nil {
+		logging.metrics.RecordBufferedSinkMessagesDropped(bs.sinkName, int64(dropped))
+	}
+	r.count += dropped
+	if r.count >= bufferedSinkDropReportThreshold && !r.reported {
+		r.reported = true
+		fmt.Fprintf(OrigStderr,
+			"%T: %d log messages dropped since %s because the buffer is full; "+
+				"check the health of the log sink or increase max-buffer-size\n",
+			bs.child, r.count, r.start.Format(time.RFC3339))
+	}
+}
+
+// droppedMessages returns the number of messages dropped by the sink
+// because its buffer was full or they exceeded the maximum buffer size.
+func (bs *bufferedSink) droppedMessages() uint64 {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.mu.buf.dropped
+}
+
 // flushAsyncLocked signals the flusher goroutine to flush.
 func (bs *bufferedSink) flushAsyncLocked() {
 	// Make a best-effort attempt to stop a scheduled future flush, if any.
@@ -394,6 +483,12 @@ type msgBuf struct {
 	// errC, if set, specifies that, when the buffer is flushed, the result of the
 	// flush (success or error) should be signaled on this channel.
 	errC chan<- error
+	// dropped is the number of messages that were dropped because the buffer
+	// was full or because they were too large to fit in it.
+	dropped uint64
+	// dropNewest, if set, causes appendMsg() to drop a message that does not
+	// fit in the buffer, instead of the oldest messages in the buffer.
+	dropNewest bool
 }
 
 // size returns the size of b's contents, in bytes.
@@ -404,12 +499,17 @@ func (b *msgBuf) size() uint64 {
 
 var errMsgTooLarge = errors.New("message dropped because it is too large")
 
+var errBufferFull = errors.New("message dropped because the buffer is full")
+
 // appendMsg appends msg to the buffer. If msg can't fit in the buffer,
 // errMsgTooLarge is returned.
 //
 // If the buffer is full, then we drop older messages in the buffer
-// until we have space for the new message.
-func (b *msgBuf) appendMsg(msg *buffer) error {
+// until we have space for the new message, unless dropNewest is set, in
+// which case msg is dropped and errBufferFull is returned. If
+// evictOldest is set, e.g. for fatal errors, the older messages are
+// dropped regardless of dropNewest, so that msg is not lost.
+func (b *msgBuf) appendMsg(msg *buffer, evictOldest bool) error {
 	msgLen := uint64(msg.Len())
 
 	// Make room for the new message, potentially by dropping the oldest messages
@@ -417,9 +517,17 @@ func (b *msgBuf) appendMsg(msg *buffer) error {
 	if b.maxSizeBytes > 0 {
 		if msgLen > b.maxSizeBytes {
 			// This message will never fit.
+			b.dropped++
+			incrementLogMetric(BufferedSinkMessagesDropped)
 			return errMsgTooLarge
 		}
 
+		if b.dropNewest && !evictOldest && b.size()+msgLen+1 > b.maxSizeBytes {
+			b.dropped++
+			incrementLogMetric(BufferedSinkMessagesDropped)
+			return errBufferFull
+		}
+
 		// The +1 accounts for a trailing newline.
 		for b.size()+msgLen+1 > b.maxSizeBytes {
 			b.dropFirstMsg()
@@ -497,6 +605,7 @@ func (b *msgBuf) dropFirstMsg() {
 	firstMsg := b.messages[0]
 	b.messages = b.messages[1:]
 	b.sizeBytes -= uint64(firstMsg.Len())
-	logging.metrics.IncrementCounter(BufferedSinkMessagesDropped, 1)
+	b.dropped++
+	incrementLogMetric(BufferedSinkMessagesDropped)
 	putBuffer(firstMsg)
 }
@@ -309,6 +309,72 @@ func TestBufferedSinkTryForceSync_SyncFlushAlreadyScheduled(t *testing.T) {
 	}
 }
 
+// Test that a call to output() with the tryForceSync option, e.g. for a fatal
+// error, waits for the flush and returns the child sink's error even if the
+// sink is non-blocking.
+func TestBufferedSinkTryForceSync_NonBlocking(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer Scope(t).Close(t)
+	sink, mock, cleanup := getMockBufferedSync(t, noMaxStaleness, noSizeTrigger, 1<<10, nil)
+	defer cleanup()
+	func() {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		sink.mu.buf.dropNewest = true
+	}()
+
+	ch := make(chan struct{})
+	message := []byte("fatal")
+	flushErr := errors.New("boom")
+	// Make the child sink block until ch is closed.
+	mock.EXPECT().
+		output(gomock.Eq(message), sinkOutputOptionsMatcher{tryForceSync: gomock.Eq(true)}).
+		Do(addArgs(func() { <-ch })).
+		Return(flushErr)
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- sink.output(message, sinkOutputOptions{tryForceSync: true})
+	}()
+	select {
+	case err := <-errC:
+		t.Fatalf("sink.output returned while child sync should be blocking: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(ch)
+	require.ErrorIs(t, <-errC, flushErr)
+}
+
+// Test that a tryForceSync message is not dropped by a non-blocking sink
+// whose buffer is full; the oldest messages are dropped instead.
+func TestBufferedSinkTryForceSync_NonBlockingFull(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer Scope(t).Close(t)
+	bufferMaxSize := uint64(1 << 10)
+	sink, mock, cleanup := getMockBufferedSync(t, noMaxStaleness, noSizeTrigger, bufferMaxSize, nil)
+	defer cleanup()
+	func() {
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		sink.mu.buf.dropNewest = true
+	}()
+
+	// Fill the buffer. Without a size trigger or max staleness, nothing is
+	// flushed.
+	filler := bytes.Repeat([]byte("a"), int(bufferMaxSize)-2)
+	require.NoError(t, sink.output(filler, sinkOutputOptions{}))
+	// A regular message is dropped, since the sink is non-blocking.
+	require.NoError(t, sink.output([]byte("dropped"), sinkOutputOptions{}))
+	require.Equal(t, uint64(1), sink.droppedMessages())
+
+	// A fatal message evicts the filler, and is flushed synchronously.
+	message := []byte("fatal")
+	mock.EXPECT().
+		output(gomock.Eq(message), sinkOutputOptionsMatcher{tryForceSync: gomock.Eq(true)})
+	require.NoError(t, sink.output(message, sinkOutputOptions{tryForceSync: true}))
+	require.Equal(t, uint64(2), sink.droppedMessages())
+}
+
 // Test that messages are buffered while a flush is in-flight.
 func TestBufferedSinkBlockedFlush(t *testing.T) {
 	defer leaktest.AfterTest(t)()
@@ -390,6 +456,59 @@ b9`), out)
 	}
 }
 
+// Test that messages dropped because the buffer is full, or because they
+// are too large, are counted, including in the metrics of the sink.
+func TestBufferedSinkDroppedMessages(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer Scope(t).Close(t)
+	m := &recordingLogMetrics{}
+	defer func(prev LogMetrics) { SetLogMetrics(prev) }(logging.metrics)
+	SetLogMetrics(m)
+	closer := newBufferedSinkCloser()
+	defer func() { require.NoError(t, closer.Close(defaultCloserTimeout)) }()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mock := NewMockLogSink(ctrl)
+	bufferMaxSize := uint64(20)
+	triggerSize := uint64(10)
+	sink := newBufferedSink(mock, noMaxStaleness, triggerSize, bufferMaxSize, false /* crashOnAsyncFlushErr */, nil)
+	sink.sinkName = "hung"
+	sink.Start(closer)
+
+	// The child sink hangs on the first flush, like a hung server would,
+	// until the test unblocks it.
+	flushSem := make(chan struct{})
+	mock.EXPECT().
+		output(gomock.Any(), gomock.Any()).
+		Do(func([]byte, sinkOutputOptions) {
+			flushSem <- struct{}{}
+			<-flushSem
+		})
+	mock.EXPECT().output(gomock.Any(), gomock.Any()).AnyTimes()
+	require.NoError(t, sink.output(bytes.Repeat([]byte("a"), int(triggerSize)), sinkOutputOptions{}))
+	select {
+	case <-flushSem:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected flush didn't happen")
+	}
+	require.Zero(t, sink.droppedMessages())
+
+	// With the flush hung, the buffer fills up. Each message takes 3 bytes
+	// including the newline, so only 6 of them fit in the buffer.
+	for i := 0; i < 10; i++ {
+		require.NoError(t, sink.output([]byte(fmt.Sprintf("b%d", i)), sinkOutputOptions{}))
+	}
+	require.Equal(t, uint64(4), sink.droppedMessages())
+	require.Equal(t, int64(4), m.droppedMessages("hung"))
+
+	// A message larger than the buffer is dropped too.
+	require.ErrorIs(t, sink.output(bytes.Repeat([]byte("c"), int(bufferMaxSize)+1), sinkOutputOptions{}), errMsgTooLarge)
+	require.Equal(t, uint64(5), sink.droppedMessages())
+	require.Equal(t, int64(5), m.droppedMessages("hung"))
+
+	flushSem <- struct{}{}
+}
+
 // Test that multiple messages with the tryForceSync option work.
 func TestBufferedSinkSyncFlush(t *testing.T) {
 	defer leaktest.AfterTest(t)()
@@ -553,7 +672,7 @@ func TestMsgBufFlushFormat(t *testing.T) {
 		for _, strMsg := range tc.bufferContents {
 			msg := getBuffer()
 			msg.WriteString(strMsg)
-			require.NoError(t, buf.appendMsg(msg))
+			require.NoError(t, buf.appendMsg(msg, false /* evictOldest */))
 		}
 
 		// Flush.
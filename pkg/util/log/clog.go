@@ -20,6 +20,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/base/serverident"
 	"github.com/cockroachdb/cockroach/pkg/cli/exit"
 	"github.com/cockroachdb/cockroach/pkg/util/allstacks"
 	"github.com/cockroachdb/cockroach/pkg/util/log/logpb"
@@ -169,6 +170,28 @@ type sinkInfo struct {
 	// redact and redactable memorize the input configuration
 	// that was used to create the editor above.
 	redact, redactable bool
+
+	// sampler, if set, drops a fraction of the entries that pass the
+	// threshold.
+	sampler *sinkSampler
+
+	// maxEntrySize, if positive, is the maximum size of a formatted
+	// entry. The message of larger entries is truncated.
+	maxEntrySize int
+
+	// observeIDs, if set, is called with the server identifiers of each
+	// entry output to this sink.
+	observeIDs func(serverident.IDPayload)
+
+	// formatKey identifies the format and format options of this sink,
+	// so that sinks with the same formatting can share the formatted
+	// entries. It is empty if the formatted entries cannot be shared.
+	// See sharesFormatWith.
+	formatKey string
+
+	// locality, if set, is the locality of the node that is added to the
+	// tags of every entry output to this sink.
+	locality *localityTags
 }
 
 type channelThresholds struct {
@@ -361,6 +384,9 @@ func (l *loggerT) outputLogEntry(entry logEntry) {
 		if entry.sev < s.threshold.get(entry.ch) || !s.sink.active() {
 			continue
 		}
+		if s.sampler != nil && !s.sampler.shouldForward(entry.sev) {
+			continue
+		}
 		editedEntry := entry
 
 		// Add a counter. This is important for e.g. the SQL audit logs.
@@ -371,8 +397,24 @@ func (l *loggerT) outputLogEntry(entry logEntry) {
 		// Process the redaction spec.
 		editedEntry.payload = maybeRedactEntry(editedEntry.payload, s.editor)
 
-		// Format the entry for this sink.
-		bufs.b[i] = s.formatter.formatEntry(editedEntry)
+		// Add the locality after the redaction, since it is not sensitive.
+		if s.locality != nil {
+			editedEntry.payload.tags = s.locality.prependTo(editedEntry.payload)
+		}
+
+		if s.observeIDs != nil {
+			s.observeIDs(entry.IDPayload)
+		}
+
+		// Format the entry for this sink, unless a previous sink has
+		// formatted it identically already.
+		if j := l.findSharedFormat(bufs, i, editedEntry.counter); j >= 0 {
+			bufs.b[i] = bufs.b[j]
+			bufs.shared[i] = true
+		} else {
+			bufs.b[i] = s.formatEntry(editedEntry)
+		}
+		bufs.counters[i] = editedEntry.counter
 		someSinkActive = true
 	}
 
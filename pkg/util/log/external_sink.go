@@ -0,0 +1,135 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/cli/exit"
+	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+)
+
+// ExternalSink is a log sink implemented outside of this package, so
+// that this package does not depend on the client library of its
+// destination. The implementations are registered by the init function
+// of their package, e.g. via RegisterKafkaSink.
+type ExternalSink interface {
+	// Output delivers the given formatted log entries. b is reused after
+	// Output returns, so it must be copied to be retained.
+	//
+	// The parent logger's outputMu is held during this operation: Output
+	// must not log.
+	Output(b []byte) error
+
+	// Close releases the resources of the sink, e.g. its connections. It
+	// is called when the logging configuration is torn down.
+	Close()
+}
+
+// externalSinkFactories holds the constructors of the external sinks,
+// registered via RegisterKafkaSink and RegisterGRPCOTLPSink.
+var externalSinkFactories struct {
+	syncutil.Mutex
+	kafka    func(sinkName string, c logconfig.KafkaSinkConfig) (ExternalSink, error)
+	grpcOTLP func(sinkName string, c logconfig.GRPCOTLPSinkConfig) (ExternalSink, error)
+}
+
+// RegisterKafkaSink registers the constructor of the Kafka sinks. It is
+// meant to be called from the init function of the implementing package,
+// i.e. pkg/util/log/logkafka. Until it is called, the configurations
+// with Kafka sinks cannot be applied.
+func RegisterKafkaSink(
+	newSink func(sinkName string, c logconfig.KafkaSinkConfig) (ExternalSink, error),
+) {
+	externalSinkFactories.Lock()
+	defer externalSinkFactories.Unlock()
+	externalSinkFactories.kafka = newSink
+}
+
+// getKafkaSinkFactory returns the constructor registered via
+// RegisterKafkaSink, or nil if there is none.
+func getKafkaSinkFactory() func(sinkName string, c logconfig.KafkaSinkConfig) (ExternalSink, error) {
+	externalSinkFactories.Lock()
+	defer externalSinkFactories.Unlock()
+	return externalSinkFactories.kafka
+}
+
+// RegisterGRPCOTLPSink registers the constructor of the gRPC OTLP sinks.
+// It is meant to be called from the init function of the implementing
+// package, i.e. pkg/util/log/logotlp. Until it is called, the
+// configurations with gRPC OTLP sinks cannot be applied.
+//
+// The log entries are passed to the Output method of the sinks as the
+// protobuf encoding of an OTLP ScopeLogs message holding their log
+// records.
+func RegisterGRPCOTLPSink(
+	newSink func(sinkName string, c logconfig.GRPCOTLPSinkConfig) (ExternalSink, error),
+) {
+	externalSinkFactories.Lock()
+	defer externalSinkFactories.Unlock()
+	externalSinkFactories.grpcOTLP = newSink
+}
+
+// getGRPCOTLPSinkFactory returns the constructor registered via
+// RegisterGRPCOTLPSink, or nil if there is none.
+func getGRPCOTLPSinkFactory() func(sinkName string, c logconfig.GRPCOTLPSinkConfig) (ExternalSink, error) {
+	externalSinkFactories.Lock()
+	defer externalSinkFactories.Unlock()
+	return externalSinkFactories.grpcOTLP
+}
+
+// externalSink adapts an ExternalSink to the logSink interface.
+type externalSink struct {
+	// sinkName is the name of the sink in the logging configuration.
+	sinkName string
+	// config is the configuration of the sink, e.g. a
+	// *logconfig.KafkaSinkConfig.
+	config interface{}
+	sink   ExternalSink
+}
+
+// active returns true if this sink is currently active.
+func (*externalSink) active() bool {
+	return true
+}
+
+// attachHints attaches some hints about the location of the message
+// to the stack message.
+func (*externalSink) attachHints(stacks []byte) []byte {
+	return stacks
+}
+
+// output emits some formatted bytes to this sink.
+// the sink is invited to perform an extra flush if indicated
+// by the argument. This is set to true for e.g. Fatal
+// entries.
+//
+// The parent logger's outputMu is held during this operation: log
+// sinks must not recursively call into logging when implementing
+// this method.
+func (es *externalSink) output(b []byte, opts sinkOutputOptions) error {
+	return es.sink.Output(b)
+}
+
+// exitCode returns the exit code to use if the logger decides
+// to terminate because of an error in output().
+func (*externalSink) exitCode() exit.Code {
+	return exit.LoggingNetCollectorUnavailable()
+}
+
+// asExternalSink returns the externalSink s, possibly wrapped in a
+// bufferedSink, or nil if s is not an external sink.
+func asExternalSink(s logSink) *externalSink {
+	if bs, ok := s.(*bufferedSink); ok {
+		s = bs.child
+	}
+	es, _ := s.(*externalSink)
+	return es
+}
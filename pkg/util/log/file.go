@@ -21,6 +21,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -77,6 +78,27 @@ type fileSink struct {
 	// logFileMaxSize is the maximum size of a log file in bytes.
 	logFileMaxSize int64
 
+	// rotationInterval, if non-zero, is the cadence at which log files
+	// are rotated regardless of their size.
+	rotationInterval time.Duration
+
+	// timeSource provides the current time for file creation and
+	// rotation. It can be overridden in tests.
+	timeSource timeutil.TimeSource
+
+	// compressRotatedFiles, if set, causes log files to be compressed
+	// asynchronously after they are rotated.
+	compressRotatedFiles bool
+
+	// compressWG tracks the compressions in progress.
+	compressWG sync.WaitGroup
+
+	// compressMu protects the set of files being compressed.
+	compressMu struct {
+		syncutil.Mutex
+		inProgress map[string]struct{}
+	}
+
 	// logFilesCombinedMaxSize is the maximum total size in bytes for log
 	// files generated by one logger. Note that this is only checked when
 	// log files are created, so the total size of log files might
@@ -153,6 +175,7 @@ func newFileSink(
 		getStartLines:           getStartLines,
 		filePermissions:         filePermissions,
 		logBytesWritten:         logBytesWritten,
+		timeSource:              timeutil.DefaultTimeSource{},
 	}
 	f.mu.logDir = dir
 	f.enabled.Store(dir != "")
@@ -0,0 +1,176 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log/logpb"
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+)
+
+// compressedFileSuffix is appended to the name of rotated log files
+// once they are compressed.
+const compressedFileSuffix = ".gz"
+
+// compressRotatedFile compresses a rotated log file asynchronously. The
+// uncompressed file is removed once the compressed file is complete, so
+// that an interrupted compression leaves the original file in place.
+// See cleanupInterruptedCompressions.
+func (l *fileSink) compressRotatedFile(path string) {
+	if !l.startCompression(path) {
+		return
+	}
+	l.runCompression(path)
+}
+
+// runCompression compresses the file in the background. The caller must
+// have marked the file as being compressed via startCompression.
+func (l *fileSink) runCompression(path string) {
+	l.compressWG.Add(1)
+	go func() {
+		defer l.compressWG.Done()
+		defer l.finishCompression(path)
+		if err := compressFile(path); err != nil {
+			fmt.Fprintf(OrigStderr, "log: unable to compress %s: %v\n", path, err)
+		}
+	}()
+}
+
+// startCompression marks the file as being compressed. It returns false
+// if a compression of this file is already in progress.
+func (l *fileSink) startCompression(path string) bool {
+	l.compressMu.Lock()
+	defer l.compressMu.Unlock()
+	if l.compressMu.inProgress == nil {
+		l.compressMu.inProgress = make(map[string]struct{})
+	}
+	if _, ok := l.compressMu.inProgress[path]; ok {
+		return false
+	}
+	l.compressMu.inProgress[path] = struct{}{}
+	return true
+}
+
+func (l *fileSink) finishCompression(path string) {
+	l.compressMu.Lock()
+	defer l.compressMu.Unlock()
+	delete(l.compressMu.inProgress, path)
+}
+
+// compressFile writes a gzip-compressed copy of the file at path to
+// path+compressedFileSuffix, then removes the original file.
+func compressFile(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dstPath := path + compressedFileSuffix
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			// Do not leave a partial compressed file behind.
+			_ = dst.Close()
+			err = errors.CombineErrors(err, os.Remove(dstPath))
+		}
+	}()
+	w := gzip.NewWriter(dst)
+	if _, err := io.Copy(w, src); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	// The compressed file must be durable before the original is
+	// removed.
+	if err := dst.Sync(); err != nil {
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// cleanupInterruptedCompressions finds the rotated log files for which a
+// compressed file exists alongside the original, which indicates that
+// the process crashed while compressing them. The partial compressed
+// files are removed and the compression is restarted.
+func (l *fileSink) cleanupInterruptedCompressions(dir string) {
+	_, compressed, err := l.listCompressedLogFiles(dir)
+	if err != nil {
+		fmt.Fprintf(OrigStderr, "log: unable to list compressed log files: %v\n", err)
+		return
+	}
+	for _, f := range compressed {
+		path := filepath.Join(dir, strings.TrimSuffix(f.Name, compressedFileSuffix))
+		if _, err := os.Stat(path); err != nil {
+			if !oserror.IsNotExist(err) {
+				fmt.Fprintf(OrigStderr, "log: %v\n", err)
+			}
+			continue
+		}
+		if !l.startCompression(path) {
+			// Still being compressed by this process.
+			continue
+		}
+		if err := os.Remove(path + compressedFileSuffix); err != nil {
+			fmt.Fprintf(OrigStderr, "log: unable to remove partial compressed file: %v\n", err)
+			l.finishCompression(path)
+			continue
+		}
+		l.runCompression(path)
+	}
+}
+
+// listCompressedLogFiles lists the compressed log files of this sink in
+// the given directory.
+func (l *fileSink) listCompressedLogFiles(dir string) (string, []logpb.FileInfo, error) {
+	var results []logpb.FileInfo
+	if dir == "" {
+		return "", nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !strings.HasSuffix(name, compressedFileSuffix) {
+			continue
+		}
+		details, err := ParseLogFilename(strings.TrimSuffix(name, compressedFileSuffix))
+		if err != nil || !l.nameGenerator.ownsFileByPrefix(details.Program) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		results = append(results, MakeFileInfo(details, info))
+	}
+	return dir, results, nil
+}
@@ -49,6 +49,14 @@ func (l *fileSink) gcOldFiles() {
 		return
 	}
 
+	// Rotated files that were compressed also count towards the limit.
+	_, compressedFiles, err := l.listCompressedLogFiles(dir)
+	if err != nil {
+		fmt.Fprintf(OrigStderr, "unable to GC compressed log files: %s\n", err)
+		return
+	}
+	allFiles = append(allFiles, compressedFiles...)
+
 	if len(allFiles) == 0 {
 		// Nothing to do.
 		return
@@ -16,7 +16,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
 )
 
@@ -31,6 +30,9 @@ type syncBuffer struct {
 	file         *os.File
 	lastRotation int64
 	nbytes       int64 // The number of bytes written to this file so far.
+	// nextRotation is the time after which the file is rotated, if the
+	// sink has a rotation interval.
+	nextRotation time.Time
 }
 
 // Sync implements the flushSyncWriter interface.
@@ -42,9 +44,11 @@ func (sb *syncBuffer) Sync() error {
 }
 
 func (sb *syncBuffer) Write(p []byte) (n int, err error) {
+	now := sb.fileSink.timeSource.Now()
 	maxFileSize := atomic.LoadInt64(&sb.fileSink.logFileMaxSize)
-	if maxFileSize > 0 && sb.nbytes+int64(len(p)) >= maxFileSize {
-		if err := sb.rotateFileLocked(timeutil.Now()); err != nil {
+	if (maxFileSize > 0 && sb.nbytes+int64(len(p)) >= maxFileSize) ||
+		(!sb.nextRotation.IsZero() && !now.Before(sb.nextRotation)) {
+		if err := sb.rotateFileLocked(now); err != nil {
 			return 0, err
 		}
 	}
@@ -83,7 +87,7 @@ func (l *fileSink) ensureFileLocked() error {
 //
 // Assumes that l.mu is held by the caller.
 func (l *fileSink) createFileLocked() error {
-	now := timeutil.Now()
+	now := l.timeSource.Now()
 	if l.mu.file == nil {
 		sb := &syncBuffer{
 			fileSink: l,
@@ -92,6 +96,15 @@ func (l *fileSink) createFileLocked() error {
 			return err
 		}
 		l.mu.file = sb
+		if l.compressRotatedFiles {
+			// Finish the compressions interrupted by a previous crash.
+			dir := l.mu.logDir
+			l.compressWG.Add(1)
+			go func() {
+				defer l.compressWG.Done()
+				l.cleanupInterruptedCompressions(dir)
+			}()
+		}
 	}
 	return nil
 }
@@ -204,6 +217,10 @@ func (sb *syncBuffer) rotateFileLocked(now time.Time) (err error) {
 	// At this point we're committed to the new file.
 	switchOverDone = true
 	sb.file, sb.Writer, sb.nbytes, sb.lastRotation = newFile, newWriter, nbytes, newLastRotation
+	if interval := sb.fileSink.rotationInterval; interval > 0 {
+		// Align the next rotation to a multiple of the interval.
+		sb.nextRotation = now.Truncate(interval).Add(interval)
+	}
 
 	// Now close the old file if any.
 	if oldFile != nil {
@@ -211,6 +228,9 @@ func (sb *syncBuffer) rotateFileLocked(now time.Time) (err error) {
 			// Ooof. We are likely leaking a file descriptor.
 			return errors.Wrap(err, "log: unable to close previous file")
 		}
+		if sb.fileSink.compressRotatedFiles {
+			sb.fileSink.compressRotatedFile(oldFile.Name())
+		}
 	}
 
 	// And create a symlink to the new file (best effort).
@@ -14,11 +14,18 @@
 package log
 
 import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log/logpb"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/stretchr/testify/require"
 )
 
 // TestLogFilenameParsing ensures that logName and parseLogFilename work as
@@ -89,3 +96,137 @@ func TestSelectFiles(t *testing.T) {
 		}
 	}
 }
+
+// TestFileRotationInterval checks that files are rotated when the
+// rotation interval elapses, and that the new file name reflects the
+// rotation time.
+func TestFileRotationInterval(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer Scope(t).Close(t)
+
+	dir := t.TempDir()
+	s := newFileSink(
+		dir, "rotation",
+		true, /* bufferedWrites */
+		0,    /* fileMaxSize: no size-based rotation */
+		0,    /* combinedMaxSize */
+		nil,  /* getStartLines */
+		0644, /* file mode */
+		nil,  /* logBytesWritten */
+	)
+	clock := timeutil.NewManualTime(time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC))
+	s.timeSource = clock
+	s.rotationInterval = 24 * time.Hour
+	defer func() { _ = s.closeFileLocked() }()
+
+	write := func() {
+		require.NoError(t, s.output([]byte("hello\n"), sinkOutputOptions{}))
+	}
+	fileTimes := func() []time.Time {
+		_, files, err := s.listLogFiles()
+		require.NoError(t, err)
+		var res []time.Time
+		for _, f := range files {
+			res = append(res, timeutil.Unix(0, f.Details.Time).UTC())
+		}
+		return res
+	}
+
+	// Before the end of the interval, which is aligned to UTC midnight,
+	// all the entries go to the same file.
+	write()
+	clock.Advance(59 * time.Minute)
+	write()
+	require.Len(t, fileTimes(), 1)
+
+	// Past the end of the interval, the next entry causes a rotation.
+	clock.Advance(2 * time.Minute)
+	write()
+	require.ElementsMatch(t, []time.Time{
+		time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 2, 0, 1, 0, 0, time.UTC),
+	}, fileTimes())
+}
+
+func TestCompressRotatedFiles(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer Scope(t).Close(t)
+
+	dir := t.TempDir()
+	s := newFileSink(
+		dir, "compress",
+		true, /* bufferedWrites */
+		0,    /* fileMaxSize: no size-based rotation */
+		0,    /* combinedMaxSize */
+		nil,  /* getStartLines */
+		0644, /* file mode */
+		nil,  /* logBytesWritten */
+	)
+	clock := timeutil.NewManualTime(time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC))
+	s.timeSource = clock
+	s.rotationInterval = time.Hour
+	s.compressRotatedFiles = true
+	defer func() { _ = s.closeFileLocked() }()
+
+	require.NoError(t, s.output([]byte("first\n"), sinkOutputOptions{}))
+	clock.Advance(2 * time.Hour)
+	require.NoError(t, s.output([]byte("second\n"), sinkOutputOptions{}))
+	s.compressWG.Wait()
+
+	// The active file is left uncompressed.
+	_, files, err := s.listLogFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, time.Date(2024, 1, 2, 1, 0, 0, 0, time.UTC),
+		timeutil.Unix(0, files[0].Details.Time).UTC())
+
+	// The rotated file was replaced by its compressed version.
+	_, compressed, err := s.listCompressedLogFiles(dir)
+	require.NoError(t, err)
+	require.Len(t, compressed, 1)
+	require.Equal(t, "first\n", readCompressedFile(t, filepath.Join(dir, compressed[0].Name)))
+}
+
+// TestCompressRotatedFilesCleanup verifies that a compression interrupted
+// by a crash is restarted.
+func TestCompressRotatedFilesCleanup(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer Scope(t).Close(t)
+
+	dir := t.TempDir()
+	s := newFileSink(
+		dir, "compress",
+		true, /* bufferedWrites */
+		0,    /* fileMaxSize */
+		0,    /* combinedMaxSize */
+		nil,  /* getStartLines */
+		0644, /* file mode */
+		nil,  /* logBytesWritten */
+	)
+	s.compressRotatedFiles = true
+
+	// Simulate a crash during the compression of a rotated file: both the
+	// original and a partial compressed file are present.
+	name, _ := s.nameGenerator.logName(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte("rotated\n"), 0644))
+	require.NoError(t, os.WriteFile(path+compressedFileSuffix, []byte("partial"), 0644))
+
+	s.cleanupInterruptedCompressions(dir)
+	s.compressWG.Wait()
+
+	_, err := os.Stat(path)
+	require.True(t, oserror.IsNotExist(err))
+	require.Equal(t, "rotated\n", readCompressedFile(t, path+compressedFileSuffix))
+}
+
+func readCompressedFile(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+	r, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(b)
+}
@@ -126,6 +126,9 @@ func ApplyConfig(
 	// logShutdownFn is the returned cleanup function, whose purpose
 	// is to tear down the work we are doing here.
 	logShutdownFn = func() {
+		// Deliver the entries buffered so far, e.g. the ones announcing the
+		// shutdown, while the sinks are still in place.
+		FlushAllSync()
 		// Reset the logging channels to default.
 		si := logging.stderrSinkInfoTemplate
 		logging.setChannelLoggers(make(map[Channel]*loggerT), &si)
@@ -138,6 +141,15 @@ func ApplyConfig(
 			logging.allLoggers.del(l)
 		}
 		for _, l := range sinkInfos {
+			if es := asExternalSink(l.sink); es != nil {
+				es.sink.Close()
+			}
+			if ss := asSyslogSink(l.sink); ss != nil {
+				ss.close()
+			}
+			if hs := asHTTPSink(l.sink); hs != nil {
+				hs.close()
+			}
 			logging.allSinkInfos.del(l)
 		}
 	}
@@ -277,6 +289,8 @@ func ApplyConfig(
 		case *formatCrdbV2:
 			t.colorProfile = nil
 		}
+		// The formatter no longer matches its configuration.
+		logging.stderrSinkInfoTemplate.formatKey = ""
 	}
 	logging.stderrSinkInfoTemplate.applyFilters(config.Sinks.Stderr.Channels)
 
@@ -321,6 +335,7 @@ func ApplyConfig(
 		if fc.Filter == severity.NONE || fc.Dir == nil {
 			continue
 		}
+		sinkName := fileGroupName
 		if fileGroupName == "default" {
 			fileGroupName = ""
 		}
@@ -336,7 +351,7 @@ func ApplyConfig(
 			return nil, err
 		}
 		fileSink.fatalOnLogStall = fatalOnLogStall
-		attachBufferWrapper(fileSinkInfo, fc.CommonSinkConfig.Buffering, closer)
+		attachBufferWrapper(fileSinkInfo, sinkName, fc.CommonSinkConfig.Buffering, closer)
 		attachSinkInfo(fileSinkInfo, &fc.Channels)
 
 		// Start the GC process. This ensures that old capture files get
@@ -345,7 +360,7 @@ func ApplyConfig(
 	}
 
 	// Create the fluent sinks.
-	for _, fc := range config.Sinks.FluentServers {
+	for sinkName, fc := range config.Sinks.FluentServers {
 		if fc.Filter == severity.NONE {
 			continue
 		}
@@ -353,21 +368,97 @@ func ApplyConfig(
 		if err != nil {
 			return nil, err
 		}
-		attachBufferWrapper(fluentSinkInfo, fc.CommonSinkConfig.Buffering, closer)
+		attachBufferWrapper(fluentSinkInfo, sinkName, fc.CommonSinkConfig.Buffering, closer)
 		attachSinkInfo(fluentSinkInfo, &fc.Channels)
 	}
 
 	// Create the HTTP sinks.
-	for _, fc := range config.Sinks.HTTPServers {
+	for sinkName, fc := range config.Sinks.HTTPServers {
 		if fc.Filter == severity.NONE {
 			continue
 		}
-		httpSinkInfo, err := newHTTPSinkInfo(*fc)
+		// A sink with per-channel addresses is instantiated as one sink
+		// per destination address.
+		for _, addrConfig := range splitHTTPSinkConfigByAddress(*fc) {
+			addrConfig := addrConfig
+			httpSinkInfo, err := newHTTPSinkInfo(sinkName, addrConfig)
+			if err != nil {
+				return nil, err
+			}
+			bufConfig := addrConfig.CommonSinkConfig.Buffering
+			switch *addrConfig.Format {
+			case formatNameJSONNDJSON:
+				// JSON entries are already newline-terminated, so concatenating
+				// them without a delimiter yields newline-delimited JSON.
+				noneFmt := logconfig.BufferFmtNone
+				bufConfig.Format = &noneFmt
+			case "crdb-v2", "crdb-v2-tty":
+				// Likewise, crdb-v2 entries are newline-terminated, and can be
+				// concatenated as-is into a stream that the crdb-v2 parser
+				// understands. A delimiter would add an empty line after each
+				// entry.
+				noneFmt := logconfig.BufferFmtNone
+				bufConfig.Format = &noneFmt
+			case formatNameOTLPJSON:
+				// The sink wraps the array of log records into an OTLP export
+				// request.
+				arrayFmt := logconfig.BufferFmtJsonArray
+				bufConfig.Format = &arrayFmt
+			}
+			if addrConfig.Envelope != nil {
+				// The sink wraps the array of entries into the envelope.
+				arrayFmt := logconfig.BufferFmtJsonArray
+				bufConfig.Format = &arrayFmt
+			}
+			attachBufferWrapper(httpSinkInfo, sinkName, bufConfig, closer)
+			attachSinkInfo(httpSinkInfo, &addrConfig.Channels)
+		}
+	}
+
+	// Create the Kafka sinks.
+	for sinkName, kc := range config.Sinks.KafkaServers {
+		if kc.Filter == severity.NONE {
+			continue
+		}
+		kafkaSinkInfo, err := newKafkaSinkInfo(sinkName, *kc)
 		if err != nil {
 			return nil, err
 		}
-		attachBufferWrapper(httpSinkInfo, fc.CommonSinkConfig.Buffering, closer)
-		attachSinkInfo(httpSinkInfo, &fc.Channels)
+		// The sink is not buffered, since each log entry is produced as its
+		// own message.
+		attachSinkInfo(kafkaSinkInfo, &kc.Channels)
+	}
+
+	// Create the syslog sinks.
+	for sinkName, sc := range config.Sinks.SyslogServers {
+		if sc.Filter == severity.NONE {
+			continue
+		}
+		syslogSinkInfo, err := newSyslogSinkInfo(sinkName, *sc)
+		if err != nil {
+			return nil, err
+		}
+		attachBufferWrapper(syslogSinkInfo, sinkName, sc.CommonSinkConfig.Buffering, closer)
+		attachSinkInfo(syslogSinkInfo, &sc.Channels)
+	}
+
+	// Create the gRPC OTLP sinks.
+	for sinkName, gc := range config.Sinks.GRPCOTLPServers {
+		if gc.Filter == severity.NONE {
+			continue
+		}
+		grpcOTLPSinkInfo, err := newGRPCOTLPSinkInfo(sinkName, *gc)
+		if err != nil {
+			return nil, err
+		}
+		// The entries are encoded as ScopeLogs messages, which can be
+		// concatenated as-is into a single message holding all their log
+		// records.
+		bufConfig := gc.CommonSinkConfig.Buffering
+		noneFmt := logconfig.BufferFmtNone
+		bufConfig.Format = &noneFmt
+		attachBufferWrapper(grpcOTLPSinkInfo, sinkName, bufConfig, closer)
+		attachSinkInfo(grpcOTLPSinkInfo, &gc.Channels)
 	}
 
 	// Prepend the interceptor sink to all channels.
@@ -404,6 +495,12 @@ func newFileSinkInfo(
 		fs.FileMode(*c.FilePermissions),
 		metrics.LogBytesWritten,
 	)
+	if c.RotationInterval != nil {
+		fileSink.rotationInterval = *c.RotationInterval
+	}
+	if c.CompressRotatedFiles != nil {
+		fileSink.compressRotatedFiles = *c.CompressRotatedFiles
+	}
 	info.sink = fileSink
 	return info, fileSink, nil
 }
@@ -421,22 +518,157 @@ func newFluentSinkInfo(c logconfig.FluentSinkConfig) (*sinkInfo, error) {
 	return info, nil
 }
 
-func newHTTPSinkInfo(c logconfig.HTTPSinkConfig) (*sinkInfo, error) {
+func newHTTPSinkInfo(sinkName string, c logconfig.HTTPSinkConfig) (*sinkInfo, error) {
 	info := &sinkInfo{}
 
 	if err := info.applyConfig(c.CommonSinkConfig); err != nil {
 		return nil, err
 	}
 	info.applyFilters(c.Channels)
+	if c.MaxMessageSize != nil {
+		info.maxEntrySize = int(*c.MaxMessageSize)
+	}
 
-	httpSink, err := newHTTPSink(c)
+	httpSink, err := newHTTPSink(sinkName, c)
 	if err != nil {
 		return nil, err
 	}
+	if httpSink.addressHasIDs || (httpSink.envelope != nil && httpSink.envelope.hasIDs) {
+		info.observeIDs = httpSink.observeIDs
+	}
 	info.sink = httpSink
 	return info, nil
 }
 
+func newKafkaSinkInfo(sinkName string, c logconfig.KafkaSinkConfig) (*sinkInfo, error) {
+	info := &sinkInfo{}
+
+	if err := info.applyConfig(c.CommonSinkConfig); err != nil {
+		return nil, err
+	}
+	info.applyFilters(c.Channels)
+
+	newKafkaSink := getKafkaSinkFactory()
+	if newKafkaSink == nil {
+		return nil, errors.Newf("kafka sink %q: kafka sinks are not supported by this program", sinkName)
+	}
+	kafkaSink, err := newKafkaSink(sinkName, c)
+	if err != nil {
+		return nil, err
+	}
+	info.sink = &externalSink{sinkName: sinkName, config: &c, sink: kafkaSink}
+	return info, nil
+}
+
+func newSyslogSinkInfo(sinkName string, c logconfig.SyslogSinkConfig) (*sinkInfo, error) {
+	info := &sinkInfo{}
+
+	if err := info.applyConfig(c.CommonSinkConfig); err != nil {
+		return nil, err
+	}
+	info.formatter = newFormatSyslog(info.formatter, c)
+	// The syslog formatter depends on the sink configuration.
+	info.formatKey = ""
+	info.applyFilters(c.Channels)
+
+	syslogSink, err := newSyslogSink(sinkName, c)
+	if err != nil {
+		return nil, err
+	}
+	info.sink = syslogSink
+	return info, nil
+}
+
+// asSyslogSink returns the syslogSink s, possibly wrapped in a
+// bufferedSink, or nil if s is not a syslog sink.
+func asSyslogSink(s logSink) *syslogSink {
+	if bs, ok := s.(*bufferedSink); ok {
+		s = bs.child
+	}
+	ss, _ := s.(*syslogSink)
+	return ss
+}
+
+func newGRPCOTLPSinkInfo(sinkName string, c logconfig.GRPCOTLPSinkConfig) (*sinkInfo, error) {
+	info := &sinkInfo{}
+
+	if err := info.applyConfig(c.CommonSinkConfig); err != nil {
+		return nil, err
+	}
+	// The log records are built directly from the entries, in the
+	// protobuf encoding expected by the sink.
+	info.formatter = formatOTLPProto{}
+	info.formatKey = ""
+	info.applyFilters(c.Channels)
+
+	newGRPCOTLPSink := getGRPCOTLPSinkFactory()
+	if newGRPCOTLPSink == nil {
+		return nil, errors.Newf("gRPC OTLP sink %q: gRPC OTLP sinks are not supported by this program", sinkName)
+	}
+	grpcOTLPSink, err := newGRPCOTLPSink(sinkName, c)
+	if err != nil {
+		return nil, err
+	}
+	info.sink = &externalSink{sinkName: sinkName, config: &c, sink: grpcOTLPSink}
+	return info, nil
+}
+
+// asHTTPSink returns the httpSink s, possibly wrapped in a
+// bufferedSink, or nil if s is not an HTTP sink.
+func asHTTPSink(s logSink) *httpSink {
+	if bs, ok := s.(*bufferedSink); ok {
+		s = bs.child
+	}
+	hs, _ := s.(*httpSink)
+	return hs
+}
+
+// splitHTTPSinkConfigByAddress returns one configuration per destination
+// address of the given HTTP sink, each selecting the channels routed to
+// that address. The default address of the sink receives the channels
+// that are not listed in ChannelAddresses. The channel placeholder in an
+// address is replaced by the name of each channel routed to it, which
+// results in one configuration per channel.
+func splitHTTPSinkConfigByAddress(c logconfig.HTTPSinkConfig) []logconfig.HTTPSinkConfig {
+	if len(c.ChannelAddresses) == 0 &&
+		!strings.Contains(*c.Address, logconfig.HTTPSinkChannelPlaceholder) {
+		return []logconfig.HTTPSinkConfig{c}
+	}
+	var addrs []string
+	chsByAddr := make(map[string]*logconfig.ChannelFilters)
+	// AllChannels is sorted, so the channel lists below are sorted too.
+	for _, ch := range c.Channels.AllChannels.Channels {
+		addr, ok := c.ChannelAddresses[ch.String()]
+		if !ok {
+			addr = *c.Address
+		}
+		addr = strings.ReplaceAll(addr, logconfig.HTTPSinkChannelPlaceholder, ch.String())
+		chs, ok := chsByAddr[addr]
+		if !ok {
+			chs = &logconfig.ChannelFilters{
+				ChannelFilters: make(map[logpb.Channel]logpb.Severity),
+			}
+			chsByAddr[addr] = chs
+			addrs = append(addrs, addr)
+		}
+		sev := c.Channels.ChannelFilters[ch]
+		chs.AddChannel(ch, sev)
+		chs.AllChannels.Channels = append(chs.AllChannels.Channels, ch)
+		chs.ChannelFilters[ch] = sev
+	}
+
+	res := make([]logconfig.HTTPSinkConfig, 0, len(addrs))
+	for _, addr := range addrs {
+		addr := addr
+		addrConfig := c
+		addrConfig.Address = &addr
+		addrConfig.Channels = *chsByAddr[addr]
+		addrConfig.ChannelAddresses = nil
+		res = append(res, addrConfig)
+	}
+	return res
+}
+
 // applyFilters applies the channel filters to a sinkInfo.
 func (l *sinkInfo) applyFilters(chs logconfig.ChannelFilters) {
 	for ch, threshold := range chs.ChannelFilters {
@@ -445,11 +677,14 @@ func (l *sinkInfo) applyFilters(chs logconfig.ChannelFilters) {
 }
 
 // attachBufferWrapper modifies s, wrapping its sink in a bufferedSink unless
-// bufConfig.IsNone().
+// bufConfig.IsNone(). sinkName labels the metrics of the bufferedSink.
 //
 // The provided closer needs to be closed to stop the bufferedSink internal goroutines.
 func attachBufferWrapper(
-	s *sinkInfo, bufConfig logconfig.CommonBufferSinkConfigWrapper, closer *bufferedSinkCloser,
+	s *sinkInfo,
+	sinkName string,
+	bufConfig logconfig.CommonBufferSinkConfigWrapper,
+	closer *bufferedSinkCloser,
 ) {
 	if bufConfig.IsNone() {
 		return
@@ -463,6 +698,10 @@ func attachBufferWrapper(
 		s.criticality, /* crashOnAsyncFlushErr */
 		bufConfig.Format,
 	)
+	bs.sinkName = sinkName
+	if bufConfig.NonBlocking != nil && *bufConfig.NonBlocking {
+		bs.mu.buf.dropNewest = true
+	}
 	bs.Start(closer)
 	s.sink = bs
 }
@@ -474,6 +713,10 @@ func (l *sinkInfo) applyConfig(c logconfig.CommonSinkConfig) error {
 	l.redactable = *c.Redactable
 	l.editor = getEditor(SelectEditMode(*c.Redact, *c.Redactable))
 	l.criticality = *c.Criticality
+	l.sampler = nil
+	if c.SampleRate != nil {
+		l.sampler = newSinkSampler(*c.SampleRate, c.SampleExemptSeverity)
+	}
 	f, ok := formatters[*c.Format]
 	if !ok {
 		return errors.WithHintf(errors.Newf("unknown format: %q", *c.Format),
@@ -485,6 +728,11 @@ func (l *sinkInfo) applyConfig(c logconfig.CommonSinkConfig) error {
 			return err
 		}
 	}
+	l.formatKey = makeFormatKey(c)
+	l.locality = nil
+	if c.IncludeLocality != nil && *c.IncludeLocality {
+		l.locality = makeLocalityTags(getNodeLocality())
+	}
 	return nil
 }
 
@@ -498,6 +746,14 @@ func (l *sinkInfo) describeAppliedConfig() (c logconfig.CommonSinkConfig) {
 	c.Criticality = &l.criticality
 	f := l.formatter.formatterName()
 	c.Format = &f
+	if l.sampler != nil {
+		c.SampleRate = &l.sampler.rate
+		c.SampleExemptSeverity = l.sampler.exemptSeverity
+	}
+	if l.locality != nil {
+		includeLocality := true
+		c.IncludeLocality = &includeLocality
+	}
 	bufferedSink, ok := l.sink.(*bufferedSink)
 	if ok {
 
@@ -593,6 +849,13 @@ func DescribeAppliedConfig() string {
 		}()
 		fc.Dir = &dir
 		fc.BufferedWrites = &fileSink.bufferedWrites
+		if fileSink.rotationInterval > 0 {
+			ri := fileSink.rotationInterval
+			fc.RotationInterval = &ri
+		}
+		if fileSink.compressRotatedFiles {
+			fc.CompressRotatedFiles = &fileSink.compressRotatedFiles
+		}
 
 		// Describe the connections to this file sink.
 		for ch, logger := range chans {
@@ -666,6 +929,43 @@ func DescribeAppliedConfig() string {
 		return nil
 	})
 
+	// Describe the Kafka sinks.
+	config.Sinks.KafkaServers = make(map[string]*logconfig.KafkaSinkConfig)
+	_ = logging.allSinkInfos.iter(func(l *sinkInfo) error {
+		es := asExternalSink(l.sink)
+		if es == nil {
+			return nil
+		}
+		if kc, ok := es.config.(*logconfig.KafkaSinkConfig); ok {
+			config.Sinks.KafkaServers[es.sinkName] = kc
+		}
+		return nil
+	})
+
+	// Describe the syslog sinks.
+	config.Sinks.SyslogServers = make(map[string]*logconfig.SyslogSinkConfig)
+	_ = logging.allSinkInfos.iter(func(l *sinkInfo) error {
+		ss := asSyslogSink(l.sink)
+		if ss == nil {
+			return nil
+		}
+		config.Sinks.SyslogServers[ss.sinkName] = ss.config
+		return nil
+	})
+
+	// Describe the gRPC OTLP sinks.
+	config.Sinks.GRPCOTLPServers = make(map[string]*logconfig.GRPCOTLPSinkConfig)
+	_ = logging.allSinkInfos.iter(func(l *sinkInfo) error {
+		es := asExternalSink(l.sink)
+		if es == nil {
+			return nil
+		}
+		if gc, ok := es.config.(*logconfig.GRPCOTLPSinkConfig); ok {
+			config.Sinks.GRPCOTLPServers[es.sinkName] = gc
+		}
+		return nil
+	})
+
 	// Note: we cannot return 'config' directly, because this captures
 	// certain variables from the loggers by reference and thus could be
 	// invalidated by concurrent uses of ApplyConfig().
@@ -31,12 +31,18 @@ import (
 )
 
 type testIDPayload struct {
+	clusterID  string
+	nodeID     string
 	tenantID   string
 	tenantName string
 }
 
 func (t testIDPayload) ServerIdentityString(key serverident.ServerIdentificationKey) string {
 	switch key {
+	case serverident.IdentifyClusterID:
+		return t.clusterID
+	case serverident.IdentifyKVNodeID:
+		return t.nodeID
 	case serverident.IdentifyTenantID:
 		return t.tenantID
 	case serverident.IdentifyTenantName:
@@ -29,6 +29,9 @@ import (
 	"github.com/cockroachdb/redact"
 )
 
+// formatNameJSONNDJSON is the name of the newline-delimited JSON format.
+const formatNameJSONNDJSON = "json-ndjson"
+
 type formatJSONFull struct {
 	// fluentTag, if set, will include a Fluent tag in the JSON output.
 	fluentTag bool
@@ -38,6 +41,91 @@ type formatJSONFull struct {
 	datetimeFormat string
 	// loc controls the timezone of the extra timestamp field "datetime".
 	loc *time.Location
+	// ndjson, if set, marks the output as newline-delimited JSON. Entries
+	// are already newline-terminated; this only changes the name, the
+	// content type and how buffered entries are concatenated.
+	ndjson bool
+	// callerFunction, if set, includes the name of the function where
+	// the event was emitted.
+	callerFunction bool
+	// fields selects the fields of the tags and of the structured events
+	// that are included in the output.
+	fields jsonFieldFilter
+}
+
+// jsonFieldFilter selects the fields of the tags and structured events
+// emitted by the JSON formats.
+type jsonFieldFilter struct {
+	// include, if non-empty, lists the only fields that are emitted.
+	include map[string]struct{}
+	// exclude lists the fields that are never emitted. It takes
+	// precedence over include.
+	exclude map[string]struct{}
+}
+
+// active returns true if some fields are filtered.
+func (f jsonFieldFilter) active() bool {
+	return len(f.include) > 0 || len(f.exclude) > 0
+}
+
+// keep returns true if the field with the given name is emitted.
+func (f jsonFieldFilter) keep(field string) bool {
+	if _, ok := f.exclude[field]; ok {
+		return false
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	_, ok := f.include[field]
+	return ok
+}
+
+// parseFieldList parses a comma-separated list of field names.
+func parseFieldList(v string) map[string]struct{} {
+	fields := make(map[string]struct{})
+	for _, field := range strings.Split(v, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields[field] = struct{}{}
+		}
+	}
+	return fields
+}
+
+// filterJSONFields returns the fields of the given structured event
+// payload, without the enclosing braces, that are kept by the filter.
+// The payload is dropped entirely if it cannot be parsed, so that no
+// excluded field is emitted.
+func filterJSONFields(payload string, keep func(field string) bool) string {
+	dec := json.NewDecoder(strings.NewReader("{" + payload + "}"))
+	if _, err := dec.Token(); err != nil {
+		return ""
+	}
+	var res []byte
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return ""
+		}
+		key, ok := t.(string)
+		if !ok {
+			return ""
+		}
+		var val json.RawMessage
+		if err := dec.Decode(&val); err != nil {
+			return ""
+		}
+		if !keep(key) {
+			continue
+		}
+		if len(res) > 0 {
+			res = append(res, ',')
+		}
+		res = append(res, '"')
+		res = jsonbytes.EncodeString(res, key)
+		res = append(res, `":`...)
+		res = append(res, val...)
+	}
+	return string(res)
 }
 
 func (f *formatJSONFull) setOption(k string, v string) error {
@@ -53,6 +141,17 @@ func (f *formatJSONFull) setOption(k string, v string) error {
 		}
 		return nil
 
+	case "caller-function":
+		switch v {
+		case "true":
+			f.callerFunction = true
+		case "false":
+			f.callerFunction = false
+		default:
+			return errors.Newf("unknown caller-function value: %q", redact.Safe(v))
+		}
+		return nil
+
 	case "tag-style":
 		switch v {
 		case "compact":
@@ -88,6 +187,15 @@ func (f *formatJSONFull) setOption(k string, v string) error {
 			}
 		}
 		return nil
+
+	case "include-fields":
+		f.fields.include = parseFieldList(v)
+		return nil
+
+	case "exclude-fields":
+		f.fields.exclude = parseFieldList(v)
+		return nil
+
 	default:
 		return errors.Newf("unknown option: %q", redact.Safe(k))
 	}
@@ -102,12 +210,30 @@ func (f formatJSONFull) formatterName() string {
 	if f.tags == tagCompact {
 		buf.WriteString("-compact")
 	}
+	if f.ndjson {
+		buf.WriteString("-ndjson")
+	}
 	return buf.String()
 }
 
-func (formatJSONFull) contentType() string { return "application/json" }
+func (f formatJSONFull) contentType() string {
+	if f.ndjson {
+		return "application/x-ndjson"
+	}
+	return "application/json"
+}
 
 func (f formatJSONFull) doc() string {
+	if f.ndjson {
+		return `This format name is an alias for 'json' which
+additionally guarantees that buffered entries are flushed as
+newline-delimited JSON (ND-JSON): each entry is a JSON object on its
+own line, regardless of the buffering format.
+
+Network sinks using this format set the content type to
+` + "`application/x-ndjson`" + `.
+`
+	}
 	if f.formatterName() != "json" {
 		var buf strings.Builder
 		fmt.Fprintf(&buf, `This format name is an alias for 'json' with
@@ -136,7 +262,7 @@ Each entry contains at least the following fields:
 
 	keys := make([]string, 0, len(jsonTags))
 	for c := range jsonTags {
-		if strings.IndexByte(serverIdentifierFields, c) != -1 {
+		if strings.IndexByte(serverIdentifierFields+optionalFields, c) != -1 {
 			continue
 		}
 		keys = append(keys, string(c))
@@ -179,7 +305,7 @@ Additionally, the following fields are conditionally present:
 | Field name if ` + "`tag-style: compact`" + ` is specified | Field name if ` + "`tag-style: verbose`" + ` is specified | Description |
 |-------|-------|-------------|
 `)
-	for _, k := range serverIdentifierFields {
+	for _, k := range serverIdentifierFields + optionalFields {
 		b := byte(k)
 		fmt.Fprintf(&buf, "| `%s` | `%s` | %s |\n",
 			jsonTags[b].tags[tagCompact],
@@ -210,6 +336,9 @@ Additional options recognized via ` + "`format-options`" + `:
 | ` + "`datetime-timezone`" + ` | The timezone to use for the ` + "`datetime`" + ` field. The value can be any timezone name recognized by the Go standard library. Default is ` + "`UTC`" + ` |
 | ` + "`tag-style`" + ` | The tags to include in the envelope. The value can be ` + "`compact`" + ` (one letter tags) or ` + "`verbose`" + ` (long-form tags). Default is ` + "`verbose`" + `. |
 | ` + "`fluent-tag`" + ` | Whether to produce an additional field called ` + "`tag`" + ` for Fluent compatibility. Default is ` + "`false`" + `. |
+| ` + "`caller-function`" + ` | Whether to produce an additional field called ` + "`function`" + ` with the name of the function where the event was emitted. Default is ` + "`false`" + `. |
+| ` + "`include-fields`" + ` | A comma-separated list of the fields of the ` + "`tags`" + ` and ` + "`event`" + ` payloads to include in the output. Other fields are omitted. Default is to include all the fields. |
+| ` + "`exclude-fields`" + ` | A comma-separated list of the fields of the ` + "`tags`" + ` and ` + "`event`" + ` payloads to omit from the output. Takes precedence over ` + "`include-fields`" + `. |
 
 `)
 
@@ -239,6 +368,8 @@ var jsonTags = map[byte]struct {
 		"The name of the source file where the event was emitted.", true},
 	'l': {[2]string{"l", "line"},
 		"The line number where the event was emitted in the source.", true},
+	'F': {[2]string{"F", "function"},
+		"(Only if the option `caller-function: true` is given.) The name of the function where the event was emitted.", true},
 	'n': {[2]string{"n", "entry_counter"},
 		"The entry number on this logging sink, relative to the last process restart.", false},
 	'r': {[2]string{"r", "redactable"},
@@ -260,6 +391,10 @@ var jsonTags = map[byte]struct {
 
 const serverIdentifierFields = "NxqTV"
 
+// optionalFields are the fields only reported when enabled via format
+// options.
+const optionalFields = "F"
+
 type tagChoice int
 
 const (
@@ -448,6 +583,13 @@ func (f formatJSONFull) formatEntry(entry logEntry) *buffer {
 	buf.WriteString(`":`)
 	n = buf.someDigits(0, entry.line)
 	buf.Write(buf.tmp[:n])
+	if f.callerFunction {
+		buf.WriteString(`,"`)
+		buf.WriteString(jtags['F'].tags[f.tags])
+		buf.WriteString(`":"`)
+		escapeString(buf, entry.fun)
+		buf.WriteByte('"')
+	}
 
 	if !entry.header {
 		// Entry counter.
@@ -474,13 +616,21 @@ func (f formatJSONFull) formatEntry(entry logEntry) *buffer {
 	// Tags.
 	if entry.payload.tags != nil {
 		buf.WriteString(`,"tags":{`)
-		entry.payload.tags.formatJSONToBuffer(buf)
+		if f.fields.active() {
+			entry.payload.tags.formatFilteredJSONToBuffer(buf, f.fields.keep)
+		} else {
+			entry.payload.tags.formatJSONToBuffer(buf)
+		}
 		buf.WriteByte('}')
 	}
 
 	if entry.structured {
 		buf.WriteString(`,"event":{`)
-		buf.WriteString(entry.payload.message) // Already JSON.
+		if f.fields.active() {
+			buf.WriteString(filterJSONFields(entry.payload.message, f.fields.keep))
+		} else {
+			buf.WriteString(entry.payload.message) // Already JSON.
+		}
 		buf.WriteByte('}')
 	} else {
 		// Message.
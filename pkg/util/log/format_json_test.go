@@ -13,8 +13,10 @@ package log
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -29,6 +31,7 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/logtags"
 	"github.com/kr/pretty"
+	"github.com/stretchr/testify/require"
 )
 
 func TestJSONFormats(t *testing.T) {
@@ -105,6 +108,87 @@ func TestJSONFormats(t *testing.T) {
 
 }
 
+// TestJSONFormatCallerFunction verifies that the caller-function option
+// reports the function name alongside the goroutine and source location.
+func TestJSONFormatCallerFunction(t *testing.T) {
+	f := &formatJSONFull{}
+	require.NoError(t, f.setOption("caller-function", "true"))
+
+	_, _, line, _ := runtime.Caller(0)
+	e := makeUnstructuredEntry(context.Background(), severity.INFO, channel.DEV, 0, true, "hello")
+	line++ // The entry is created on the line after runtime.Caller.
+
+	b := f.formatEntry(e)
+	defer putBuffer(b)
+	var res struct {
+		Goroutine int64  `json:"goroutine"`
+		File      string `json:"file"`
+		Line      int    `json:"line"`
+		Function  string `json:"function"`
+	}
+	require.NoError(t, json.Unmarshal(b.Bytes(), &res))
+	require.Equal(t, e.gid, res.Goroutine)
+	require.NotZero(t, res.Goroutine)
+	require.True(t, strings.HasSuffix(res.File, "log/format_json_test.go"), res.File)
+	require.Equal(t, line, res.Line)
+	require.Equal(t, "TestJSONFormatCallerFunction", res.Function)
+
+	// The field is omitted by default.
+	b2 := (&formatJSONFull{}).formatEntry(e)
+	defer putBuffer(b2)
+	require.NotContains(t, b2.String(), `"function"`)
+}
+
+func TestJSONFormatFieldFilter(t *testing.T) {
+	ctx := logtags.AddTag(context.Background(), "client_ip", "1.2.3.4")
+	ctx = logtags.AddTag(ctx, "user", "root")
+	e := makeStructuredEntry(ctx, severity.INFO, channel.DEV, 0, &logpb.TestingStructuredLogEvent{
+		CommonEventDetails: logpb.CommonEventDetails{
+			Timestamp: 123,
+			EventType: "rename_database",
+		},
+		Event: "hello",
+	})
+
+	format := func(opts map[string]string) (res struct {
+		Tags  map[string]interface{} `json:"tags"`
+		Event map[string]interface{} `json:"event"`
+	}) {
+		f := &formatJSONFull{}
+		for k, v := range opts {
+			require.NoError(t, f.setOption(k, v))
+		}
+		b := f.formatEntry(e)
+		defer putBuffer(b)
+		require.NoError(t, json.Unmarshal(b.Bytes(), &res), b.String())
+		return res
+	}
+	keys := func(m map[string]interface{}) []string {
+		res := make([]string, 0, len(m))
+		for k := range m {
+			res = append(res, k)
+		}
+		return res
+	}
+
+	res := format(nil)
+	require.ElementsMatch(t, []string{"client_ip", "user"}, keys(res.Tags))
+	require.ElementsMatch(t, []string{"Timestamp", "EventType", "Event"}, keys(res.Event))
+
+	res = format(map[string]string{"exclude-fields": "client_ip, EventType"})
+	require.ElementsMatch(t, []string{"user"}, keys(res.Tags))
+	require.ElementsMatch(t, []string{"Timestamp", "Event"}, keys(res.Event))
+
+	res = format(map[string]string{"include-fields": "user,Event"})
+	require.ElementsMatch(t, []string{"user"}, keys(res.Tags))
+	require.ElementsMatch(t, []string{"Event"}, keys(res.Event))
+
+	// The exclusions take precedence.
+	res = format(map[string]string{"include-fields": "user,Event", "exclude-fields": "user"})
+	require.Empty(t, res.Tags)
+	require.ElementsMatch(t, []string{"Event"}, keys(res.Event))
+}
+
 func TestJsonDecode(t *testing.T) {
 	datadriven.RunTest(t, "testdata/parse_json",
 		func(t *testing.T, td *datadriven.TestData) string {
@@ -0,0 +1,229 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"strconv"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log/severity"
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/redact"
+)
+
+// formatNameOTLPJSON is the name of the OpenTelemetry log record format.
+const formatNameOTLPJSON = "otlp-json"
+
+// formatOTLPJSON emits each log entry as an OpenTelemetry (OTLP) log
+// record, in the JSON encoding of the OTLP protocol.
+type formatOTLPJSON struct{}
+
+func (formatOTLPJSON) formatterName() string { return formatNameOTLPJSON }
+
+func (formatOTLPJSON) contentType() string { return "application/json" }
+
+func (formatOTLPJSON) setOption(k string, _ string) error {
+	return errors.Newf("unknown option: %q", redact.Safe(k))
+}
+
+func (formatOTLPJSON) doc() string {
+	return `This format emits each log entry as an OpenTelemetry log record
+(` + "`LogRecord`" + `), in the JSON encoding of the OTLP protocol.
+
+Network sinks using this format wrap the log records into an OTLP
+export request, so that they can send logs directly to the
+` + "`/v1/logs`" + ` endpoint of an OTLP/HTTP collector. The buffered
+entries of a sink are sent together in a single export request.
+The content type is set to ` + "`application/json`" + `.
+
+Each log record contains the following fields:
+
+| Field | Description |
+|-------|-------------|
+| ` + "`timeUnixNano`" + ` | The timestamp of the event. |
+| ` + "`observedTimeUnixNano`" + ` | Ditto. |
+| ` + "`severityNumber`" + ` | The OTLP severity number: 9 for INFO, 13 for WARNING, 17 for ERROR, 21 for FATAL. |
+| ` + "`severityText`" + ` | The severity of the event. |
+| ` + "`body`" + ` | The message of the event as a string. For structured events, this is the JSON payload of the event. |
+| ` + "`attributes`" + ` | The attributes of the event, listed below. |
+
+The following attributes are reported:
+
+| Attribute | Description |
+|-----------|-------------|
+| ` + "`crdb.channel`" + ` | The name of the logging channel where the event was sent. |
+| ` + "`crdb.goroutine`" + ` | The identifier of the goroutine where the event was emitted. |
+| ` + "`code.filepath`" + ` | The name of the source file where the event was emitted. |
+| ` + "`code.lineno`" + ` | The line number where the event was emitted in the source. |
+| ` + "`crdb.entry_counter`" + ` | The entry number on this logging sink, relative to the last process restart. |
+| ` + "`crdb.redactable`" + ` | Whether the payload is redactable. |
+| ` + "`crdb.structured`" + ` | Whether the event is structured. |
+| ` + "`crdb.header`" + ` | Set for the *header* entries written at the beginning of each log sink, which have no channel and severity. |
+| ` + "`crdb.cluster_id`" + `, ` + "`crdb.node_id`" + `, ` + "`crdb.tenant_id`" + `, ` + "`crdb.tenant_name`" + `, ` + "`crdb.instance_id`" + ` | The server identifiers, once known. |
+| ` + "`crdb.version`" + ` | The binary version with which the event was generated. |
+| ` + "`crdb.tag.<name>`" + ` | The logging context tags for the entry. |
+| ` + "`exception.stacktrace`" + ` | Goroutine stacks, for fatal events. |
+`
+}
+
+// otlpSeverityNumber returns the OTLP severity number for the given
+// severity.
+func otlpSeverityNumber(sev Severity) int {
+	switch sev {
+	case severity.INFO:
+		return 9
+	case severity.WARNING:
+		return 13
+	case severity.ERROR:
+		return 17
+	case severity.FATAL:
+		return 21
+	default:
+		return 0 // unspecified
+	}
+}
+
+func (formatOTLPJSON) formatEntry(entry logEntry) *buffer {
+	buf := getBuffer()
+	ts := strconv.FormatInt(entry.ts, 10)
+	buf.WriteString(`{"timeUnixNano":"`)
+	buf.WriteString(ts)
+	buf.WriteString(`","observedTimeUnixNano":"`)
+	buf.WriteString(ts)
+	buf.WriteByte('"')
+	if !entry.header {
+		buf.WriteString(`,"severityNumber":`)
+		buf.WriteString(strconv.Itoa(otlpSeverityNumber(entry.sev)))
+		buf.WriteString(`,"severityText":"`)
+		escapeString(buf, entry.sev.String())
+		buf.WriteByte('"')
+	}
+
+	buf.WriteString(`,"body":{"stringValue":"`)
+	escapeString(buf, otlpBody(entry))
+	buf.WriteString(`"}`)
+
+	buf.WriteString(`,"attributes":[`)
+	appendOTLPAttributes(entry, &otlpAttributeWriter{buf: buf})
+	buf.WriteString("]}\n")
+	return buf
+}
+
+// otlpBody returns the body of the OTLP log record of the given entry.
+func otlpBody(entry logEntry) string {
+	if entry.structured {
+		return "{" + entry.payload.message + "}"
+	}
+	return entry.payload.message
+}
+
+// otlpAttributeAppender appends the attributes of an OTLP log record,
+// in a given encoding.
+type otlpAttributeAppender interface {
+	stringAttr(key, val string)
+	intAttr(key string, val int64)
+	boolAttr(key string, val bool)
+}
+
+// appendOTLPAttributes appends the attributes of the OTLP log record of
+// the given entry to a.
+func appendOTLPAttributes(entry logEntry, a otlpAttributeAppender) {
+	if entry.header {
+		a.boolAttr("crdb.header", true)
+	} else {
+		a.stringAttr("crdb.channel", entry.ch.String())
+	}
+	a.intAttr("crdb.goroutine", entry.gid)
+	a.stringAttr("code.filepath", entry.file)
+	a.intAttr("code.lineno", int64(entry.line))
+	if !entry.header {
+		a.intAttr("crdb.entry_counter", int64(entry.counter))
+	}
+	a.boolAttr("crdb.redactable", entry.payload.redactable)
+	if entry.structured {
+		a.boolAttr("crdb.structured", true)
+	}
+	for _, id := range []struct{ key, val string }{
+		{"crdb.cluster_id", entry.ClusterID},
+		{"crdb.node_id", entry.NodeID},
+		{"crdb.tenant_id", entry.TenantID},
+		{"crdb.tenant_name", entry.TenantName},
+		{"crdb.instance_id", entry.SQLInstanceID},
+		{"crdb.version", entry.version},
+	} {
+		if id.val != "" {
+			a.stringAttr(id.key, id.val)
+		}
+	}
+	if entry.payload.tags != nil {
+		fi := formattableTagsIterator{tags: []byte(entry.payload.tags)}
+		for {
+			key, val, done := fi.next()
+			if done {
+				break
+			}
+			a.stringAttr("crdb.tag."+string(key), string(val))
+		}
+	}
+	if len(entry.stacks) > 0 {
+		a.stringAttr("exception.stacktrace", string(entry.stacks))
+	}
+}
+
+// otlpAttributeWriter writes the elements of an OTLP attribute list.
+type otlpAttributeWriter struct {
+	buf   *buffer
+	count int
+}
+
+func (a *otlpAttributeWriter) start(key string, valueType string) {
+	if a.count > 0 {
+		a.buf.WriteByte(',')
+	}
+	a.count++
+	a.buf.WriteString(`{"key":"`)
+	escapeString(a.buf, key)
+	a.buf.WriteString(`","value":{"`)
+	a.buf.WriteString(valueType)
+	a.buf.WriteString(`":`)
+}
+
+func (a *otlpAttributeWriter) stringAttr(key, val string) {
+	a.start(key, "stringValue")
+	a.buf.WriteByte('"')
+	escapeString(a.buf, val)
+	a.buf.WriteString(`"}}`)
+}
+
+// intAttr writes an integer attribute. As per the JSON encoding of
+// protobuf, 64-bit integers are encoded as strings.
+func (a *otlpAttributeWriter) intAttr(key string, val int64) {
+	a.start(key, "intValue")
+	a.buf.WriteByte('"')
+	a.buf.WriteString(strconv.FormatInt(val, 10))
+	a.buf.WriteString(`"}}`)
+}
+
+func (a *otlpAttributeWriter) boolAttr(key string, val bool) {
+	a.start(key, "boolValue")
+	a.buf.WriteString(strconv.FormatBool(val))
+	a.buf.WriteString(`}}`)
+}
+
+// otlpExportRequestEnvelope returns the JSON text surrounding the array
+// of log records of an OTLP export request.
+func otlpExportRequestEnvelope() (prefix, suffix string) {
+	b := getBuffer()
+	defer putBuffer(b)
+	b.WriteString(`{"resourceLogs":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"`)
+	escapeString(b, fileNameConstants.program)
+	b.WriteString(`"}}]},"scopeLogs":[{"scope":{"name":"cockroach"},"logRecords":`)
+	return b.String(), `}]}]}`
+}
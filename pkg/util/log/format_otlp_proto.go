@@ -0,0 +1,99 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"fmt"
+
+	otlpcommonpb "github.com/cockroachdb/cockroach/pkg/obsservice/obspb/opentelemetry-proto/common/v1"
+	otlplogspb "github.com/cockroachdb/cockroach/pkg/obsservice/obspb/opentelemetry-proto/logs/v1"
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/redact"
+)
+
+// formatOTLPProto emits each log entry as an OpenTelemetry (OTLP) log
+// record, in the protobuf encoding of the OTLP protocol. It is used by
+// the gRPC OTLP sinks, and is not selectable in the logging
+// configuration.
+//
+// Each record is encoded as the log_records field of an OTLP ScopeLogs
+// message. Since the concatenation of protobuf encodings is the encoding
+// of the merged messages, the concatenation of the entries formatted by
+// a buffered sink with the "none" buffer format is the encoding of a
+// ScopeLogs message containing all their log records.
+type formatOTLPProto struct{}
+
+func (formatOTLPProto) formatterName() string { return "otlp-proto" }
+
+func (formatOTLPProto) contentType() string { return "application/x-protobuf" }
+
+func (formatOTLPProto) setOption(k string, _ string) error {
+	return errors.Newf("unknown option: %q", redact.Safe(k))
+}
+
+func (formatOTLPProto) doc() string { return "" }
+
+func (formatOTLPProto) formatEntry(entry logEntry) *buffer {
+	r := &otlplogspb.LogRecord{
+		TimeUnixNano:         uint64(entry.ts),
+		ObservedTimeUnixNano: uint64(entry.ts),
+		Body:                 otlpStringValue(otlpBody(entry)),
+	}
+	if !entry.header {
+		r.SeverityNumber = otlplogspb.SeverityNumber(otlpSeverityNumber(entry.sev))
+		r.SeverityText = entry.sev.String()
+	}
+	a := otlpAttributeList{}
+	appendOTLPAttributes(entry, &a)
+	r.Attributes = a.attrs
+
+	scopeLogs := otlplogspb.ScopeLogs{LogRecords: []*otlplogspb.LogRecord{r}}
+	buf := getBuffer()
+	b, err := scopeLogs.Marshal()
+	if err != nil {
+		// The record only contains valid fields, so this is not expected.
+		// The error is reported in the output rather than dropping the
+		// entry silently; the record is then rejected by the collector.
+		fmt.Fprintf(buf, "error encoding OTLP log record: %v", err)
+		return buf
+	}
+	_, _ = buf.Write(b)
+	return buf
+}
+
+// otlpAttributeList builds the attributes of an OTLP log record.
+type otlpAttributeList struct {
+	attrs []*otlpcommonpb.KeyValue
+}
+
+var _ otlpAttributeAppender = (*otlpAttributeList)(nil)
+
+func (a *otlpAttributeList) stringAttr(key, val string) {
+	a.attrs = append(a.attrs, &otlpcommonpb.KeyValue{Key: key, Value: otlpStringValue(val)})
+}
+
+func (a *otlpAttributeList) intAttr(key string, val int64) {
+	a.attrs = append(a.attrs, &otlpcommonpb.KeyValue{
+		Key:   key,
+		Value: &otlpcommonpb.AnyValue{Value: &otlpcommonpb.AnyValue_IntValue{IntValue: val}},
+	})
+}
+
+func (a *otlpAttributeList) boolAttr(key string, val bool) {
+	a.attrs = append(a.attrs, &otlpcommonpb.KeyValue{
+		Key:   key,
+		Value: &otlpcommonpb.AnyValue{Value: &otlpcommonpb.AnyValue_BoolValue{BoolValue: val}},
+	})
+}
+
+func otlpStringValue(s string) *otlpcommonpb.AnyValue {
+	return &otlpcommonpb.AnyValue{Value: &otlpcommonpb.AnyValue_StringValue{StringValue: s}}
+}
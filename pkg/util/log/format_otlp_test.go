@@ -0,0 +1,190 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/base/serverident"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log/channel"
+	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
+	"github.com/cockroachdb/cockroach/pkg/util/log/severity"
+	"github.com/cockroachdb/logtags"
+	"github.com/stretchr/testify/require"
+)
+
+// otlpAnyValue is the JSON encoding of an OTLP AnyValue.
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue"`
+	IntValue    *string `json:"intValue"`
+	BoolValue   *bool   `json:"boolValue"`
+}
+
+// otlpLogRecord is the JSON encoding of an OTLP LogRecord.
+type otlpLogRecord struct {
+	TimeUnixNano         string       `json:"timeUnixNano"`
+	ObservedTimeUnixNano string       `json:"observedTimeUnixNano"`
+	SeverityNumber       int          `json:"severityNumber"`
+	SeverityText         string       `json:"severityText"`
+	Body                 otlpAnyValue `json:"body"`
+	Attributes           []struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	} `json:"attributes"`
+}
+
+func (r otlpLogRecord) attr(t *testing.T, key string) otlpAnyValue {
+	for _, a := range r.Attributes {
+		if a.Key == key {
+			return a.Value
+		}
+	}
+	t.Fatalf("attribute %q not found", key)
+	return otlpAnyValue{}
+}
+
+// otlpExportRequest is the JSON encoding of an OTLP
+// ExportLogsServiceRequest.
+type otlpExportRequest struct {
+	ResourceLogs []struct {
+		ScopeLogs []struct {
+			LogRecords []otlpLogRecord `json:"logRecords"`
+		} `json:"scopeLogs"`
+	} `json:"resourceLogs"`
+}
+
+func TestOTLPFormat(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	ctx := logtags.AddTag(context.Background(), "n", "1")
+	e := makeUnstructuredEntry(ctx, severity.WARNING, channel.OPS, 0, false, "hello %s", "world")
+	e.counter = 42
+	e.IDPayload = serverident.IDPayload{NodeID: "3", ClusterID: "abc"}
+
+	b := formatOTLPJSON{}.formatEntry(e)
+	defer putBuffer(b)
+	var r otlpLogRecord
+	require.NoError(t, json.Unmarshal(b.Bytes(), &r))
+
+	ts := strconv.FormatInt(e.ts, 10)
+	require.Equal(t, ts, r.TimeUnixNano)
+	require.Equal(t, ts, r.ObservedTimeUnixNano)
+	require.Equal(t, 13, r.SeverityNumber)
+	require.Equal(t, "WARNING", r.SeverityText)
+	require.Equal(t, "hello world", *r.Body.StringValue)
+	require.Equal(t, "OPS", *r.attr(t, "crdb.channel").StringValue)
+	require.Equal(t, strconv.FormatInt(e.gid, 10), *r.attr(t, "crdb.goroutine").IntValue)
+	require.Equal(t, e.file, *r.attr(t, "code.filepath").StringValue)
+	require.Equal(t, strconv.Itoa(e.line), *r.attr(t, "code.lineno").IntValue)
+	require.Equal(t, "42", *r.attr(t, "crdb.entry_counter").IntValue)
+	require.False(t, *r.attr(t, "crdb.redactable").BoolValue)
+	require.Equal(t, "3", *r.attr(t, "crdb.node_id").StringValue)
+	require.Equal(t, "abc", *r.attr(t, "crdb.cluster_id").StringValue)
+	require.Equal(t, "1", *r.attr(t, "crdb.tag.n").StringValue)
+}
+
+// TestHTTPSinkOTLP verifies that an HTTP sink using the otlp-json format
+// sends OTLP export requests, with and without buffering.
+func TestHTTPSinkOTLP(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	reqC := make(chan otlpExportRequest, 10)
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("unexpected content type %q", ct)
+		}
+		var req otlpExportRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("invalid request %q: %v", body, err)
+			return
+		}
+		reqC <- req
+	}))
+	defer s.Close()
+
+	records := func(req otlpExportRequest) (res []string) {
+		require.Len(t, req.ResourceLogs, 1)
+		require.Len(t, req.ResourceLogs[0].ScopeLogs, 1)
+		for _, r := range req.ResourceLogs[0].ScopeLogs[0].LogRecords {
+			res = append(res, *r.Body.StringValue)
+		}
+		return res
+	}
+
+	format := formatNameOTLPJSON
+	timeout := 5 * time.Second
+	tb := true
+	hour := time.Hour
+	triggerSize := logconfig.ByteSize(1 << 20)
+	maxBufferSize := logconfig.ByteSize(1 << 30)
+	for _, buffering := range []logconfig.CommonBufferSinkConfigWrapper{
+		disabledBufferingCfg,
+		{CommonBufferSinkConfig: logconfig.CommonBufferSinkConfig{
+			MaxStaleness:     &hour,
+			FlushTriggerSize: &triggerSize,
+			MaxBufferSize:    &maxBufferSize,
+		}},
+	} {
+		cfg := logconfig.DefaultConfig()
+		cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+			"otlp": {
+				HTTPDefaults: logconfig.HTTPDefaults{
+					Address:           &s.URL,
+					Timeout:           &timeout,
+					Compression:       &logconfig.NoneCompression,
+					DisableKeepAlives: &tb,
+					CommonSinkConfig: logconfig.CommonSinkConfig{
+						Format:    &format,
+						Buffering: buffering,
+					},
+				},
+				Channels: logconfig.SelectChannels(channel.OPS),
+			},
+		}
+		require.NoError(t, cfg.Validate(&sc.logDir))
+
+		TestingResetActive()
+		cleanup, err := ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+		require.NoError(t, err)
+
+		Ops.Infof(context.Background(), "first")
+		Ops.Infof(context.Background(), "second")
+		require.NoError(t, flushAllSync(context.Background()))
+		cleanup()
+
+		var got []string
+		for len(reqC) > 0 {
+			for _, msg := range records(<-reqC) {
+				// Ignore the entries logged by the logging system itself.
+				if msg == "first" || msg == "second" {
+					got = append(got, msg)
+				}
+			}
+		}
+		require.Equal(t, []string{"first", "second"}, got)
+	}
+}
@@ -43,6 +43,7 @@ var formatParsers = map[string]string{
 	"json-compact":        "json-compact",
 	"json-fluent":         "json",
 	"json-fluent-compact": "json-compact",
+	"json-ndjson":         "json",
 }
 
 var formatters = func() map[string]func() logFormatter {
@@ -76,6 +77,8 @@ var formatters = func() map[string]func() logFormatter {
 	r(func() logFormatter { return &formatJSONFull{fluentTag: true, tags: tagVerbose} })
 	r(func() logFormatter { return &formatJSONFull{tags: tagCompact} })
 	r(func() logFormatter { return &formatJSONFull{tags: tagVerbose} })
+	r(func() logFormatter { return &formatJSONFull{tags: tagVerbose, ndjson: true} })
+	r(func() logFormatter { return &formatOTLPJSON{} })
 	return m
 }()
 
@@ -220,15 +220,25 @@ func writeTagToBuffer(buf *buffer, k, v []byte) {
 // separated by commas, in JSON. Special JSON characters in the
 // keys/values get escaped.
 func (f formattableTags) formatJSONToBuffer(buf *buffer) {
+	f.formatFilteredJSONToBuffer(buf, nil)
+}
+
+// formatFilteredJSONToBuffer is like formatJSONToBuffer, but only emits
+// the tags for which keep returns true. A nil keep emits all the tags.
+func (f formattableTags) formatFilteredJSONToBuffer(buf *buffer, keep func(key string) bool) {
 	fi := formattableTagsIterator{tags: []byte(f)}
-	for i := 0; ; i++ {
+	for i := 0; ; {
 		key, val, done := fi.next()
 		if done {
 			break
 		}
+		if keep != nil && !keep(string(key)) {
+			continue
+		}
 		if i > 0 {
 			buf.WriteByte(',')
 		}
+		i++
 		buf.WriteByte('"')
 		escapeString(buf, string(key))
 		buf.WriteString(`":"`)
@@ -13,43 +13,113 @@ package log
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/cli/exit"
 	"github.com/cockroachdb/cockroach/pkg/util/httputil"
 	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
 	"github.com/cockroachdb/errors"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/net/http2"
 )
 
+// defaultHTTPSinkCircuitBreakerCooldown is the cooldown of the circuit
+// breaker if it is enabled without specifying one.
+const defaultHTTPSinkCircuitBreakerCooldown = 10 * time.Second
+
+// defaultHTTPSinkDialKeepAlive is the TCP keep-alive period of the
+// connections of sinks with a custom dial timeout. It matches the one of
+// http.DefaultTransport.
+const defaultHTTPSinkDialKeepAlive = 30 * time.Second
+
 // TODO: HTTP requests should be bound to context via http.NewRequestWithContext
 // Proper logging context to be decided/designed.
-func newHTTPSink(c logconfig.HTTPSinkConfig) (*httpSink, error) {
+func newHTTPSink(sinkName string, c logconfig.HTTPSinkConfig) (*httpSink, error) {
 	transport, ok := http.DefaultTransport.(*http.Transport)
 	if !ok {
 		return nil, errors.AssertionFailedf("http.DefaultTransport is not a http.Transport: %T", http.DefaultTransport)
 	}
 	transport = transport.Clone()
 	transport.DisableKeepAlives = *c.DisableKeepAlives
+	if c.DialTimeout != nil {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   *c.DialTimeout,
+			KeepAlive: defaultHTTPSinkDialKeepAlive,
+		}).DialContext
+	}
+	if c.ResponseHeaderTimeout != nil {
+		transport.ResponseHeaderTimeout = *c.ResponseHeaderTimeout
+	}
+	if c.Proxy != nil {
+		proxyURL, err := url.Parse(*c.Proxy)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing proxy URL")
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	} else if c.ProxyFromEnv != nil && !*c.ProxyFromEnv {
+		transport.Proxy = nil
+	} else {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
 	hs := &httpSink{
 		client: http.Client{
 			Transport: transport,
 			Timeout:   *c.Timeout,
 		},
-		address:     *c.Address,
+		sinkName:    sinkName,
+		addresses:   logconfig.SplitHTTPSinkAddresses(*c.Address),
 		doRequest:   doPost,
 		contentType: "application/octet-stream",
 	}
+	if c.IdempotencyKeyHeader != nil {
+		hs.idempotencyKeyHeader = *c.IdempotencyKeyHeader
+	}
+	for _, address := range hs.addresses {
+		if addressHasServerIDs(address) {
+			hs.addressHasIDs = true
+		}
+	}
 
-	if *c.UnsafeTLS {
-		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	if c.MaxInFlight != nil && *c.MaxInFlight > 0 {
+		hs.inFlight = make(chan struct{}, *c.MaxInFlight)
+		if !c.Buffering.IsNone() && c.Buffering.MaxStaleness != nil {
+			hs.inFlightWait = *c.Buffering.MaxStaleness
+		}
+	}
+
+	if c.CircuitBreakerThreshold != nil && *c.CircuitBreakerThreshold > 0 {
+		hs.breaker = &httpSinkBreaker{
+			threshold: *c.CircuitBreakerThreshold,
+			cooldown:  defaultHTTPSinkCircuitBreakerCooldown,
+		}
+		if c.CircuitBreakerCooldown != nil {
+			hs.breaker.cooldown = *c.CircuitBreakerCooldown
+		}
+	}
+
+	tlsConfig, err := makeHTTPSinkTLSConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+	if c.ForceHTTP2 != nil && *c.ForceHTTP2 {
+		configureHTTPSinkHTTP2(transport)
 	}
 
-	if string(*c.Method) == http.MethodGet {
+	hs.method = string(*c.Method)
+	if hs.method == http.MethodGet {
 		hs.doRequest = doGet
 	}
 
@@ -61,6 +131,47 @@ func newHTTPSink(c logconfig.HTTPSinkConfig) (*httpSink, error) {
 	if f.contentType() != "" {
 		hs.contentType = f.contentType()
 	}
+	if *c.Format == formatNameOTLPJSON {
+		// The log records are sent wrapped in an OTLP export request.
+		// Buffered entries are flushed as a JSON array already (see
+		// ApplyConfig); otherwise each record is sent in an array of its
+		// own.
+		hs.bodyPrefix, hs.bodySuffix = otlpExportRequestEnvelope()
+		if c.Buffering.IsNone() {
+			hs.bodyPrefix += "["
+			hs.bodySuffix = "]" + hs.bodySuffix
+		}
+	}
+	if c.Envelope != nil {
+		// The entries are wrapped in the envelope. As above, buffered
+		// entries are flushed as a JSON array already.
+		hs.envelope = newHTTPSinkEnvelope(c.Envelope, c.Buffering.IsNone())
+		hs.bodySuffix = "}"
+		if c.Buffering.IsNone() {
+			hs.bodySuffix = "]}"
+		} else {
+			hs.bodyIsArray = true
+		}
+	}
+
+	if c.HeartbeatInterval != nil && *c.HeartbeatInterval > 0 {
+		hs.heartbeat = startHTTPSinkHeartbeat(hs, *c.HeartbeatInterval)
+	}
+
+	if c.CompressionAuto != nil && *c.CompressionAuto {
+		hs.compressionNegotiator = newHTTPSinkCompressionNegotiator()
+	}
+
+	if c.DeadLetterDir != nil {
+		maxSize := int64(defaultDeadLetterMaxSize)
+		if c.DeadLetterMaxSize != nil {
+			maxSize = int64(*c.DeadLetterMaxSize)
+		}
+		hs.deadLetter = newHTTPSinkDeadLetter(sinkName, *c.Address, *c.DeadLetterDir, maxSize)
+		if c.DeadLetterReplay != nil && *c.DeadLetterReplay {
+			hs.deadLetter.startReplay(hs.deliver)
+		}
+	}
 
 	hs.config = &c
 
@@ -85,17 +196,156 @@ func newHTTPSink(c logconfig.HTTPSinkConfig) (*httpSink, error) {
 	return hs, nil
 }
 
+// configureHTTPSinkHTTP2 configures the transport to send requests
+// using HTTP/2. For https addresses, HTTP/2 is negotiated via ALPN. For
+// http addresses, requests are sent over cleartext HTTP/2 (h2c) with
+// prior knowledge, i.e. without an upgrade from HTTP/1.1.
+func configureHTTPSinkHTTP2(transport *http.Transport) {
+	// The transport does not attempt HTTP/2 by default when a custom
+	// TLS configuration or dialer is set.
+	transport.ForceAttemptHTTP2 = true
+
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{KeepAlive: defaultHTTPSinkDialKeepAlive}).DialContext
+	}
+	transport.RegisterProtocol("http", &http2.Transport{
+		AllowHTTP: true,
+		// The h2c connections are established without TLS.
+		DialTLSContext: func(
+			ctx context.Context, network, addr string, _ *tls.Config,
+		) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		},
+	})
+}
+
+// makeHTTPSinkTLSConfig returns the TLS configuration to use for the
+// given sink, or nil if the transport's default should be used.
+func makeHTTPSinkTLSConfig(c logconfig.HTTPSinkConfig) (*tls.Config, error) {
+	if !*c.UnsafeTLS && c.ClientCert == nil && c.CACert == nil {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: *c.UnsafeTLS}
+	if c.ClientCert != nil {
+		cert, err := tls.LoadX509KeyPair(*c.ClientCert, *c.ClientKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if c.CACert != nil {
+		caPEM, err := os.ReadFile(*c.CACert)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading CA certificate")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.Newf("no valid certificates found in %s", *c.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
 type httpSink struct {
-	client      http.Client
-	address     string
+	client http.Client
+	// sinkName is the name of the sink in the logging configuration.
+	sinkName string
+	// addresses are the endpoints to which requests are sent. Requests go
+	// to the last endpoint that succeeded, and fail over to the next ones
+	// in order.
+	addresses   []string
 	contentType string
-	doRequest   func(sink *httpSink, logEntry []byte) (*http.Response, error)
-	config      *logconfig.HTTPSinkConfig
+	// doRequest sends a request with the given entries to the given
+	// address. idempotencyKey, if not empty, identifies the batch of
+	// entries across the attempts to deliver it.
+	doRequest func(sink *httpSink, address string, logEntry []byte, idempotencyKey string) (*http.Response, error)
+	config    *logconfig.HTTPSinkConfig
+	// method is the HTTP method of the requests.
+	method string
 	// staticHeaders holds all the config headers defined by direct values.
 	staticHeaders map[string]string
+	// idempotencyKeyHeader, if set, is the name of the header carrying
+	// the idempotency key of each batch of entries.
+	idempotencyKeyHeader string
 	// dynamicHeaders holds all the config headers defined by values from files.
 	// It will be nil if there are no filepaths provided.
 	dynamicHeaders *dynamicHeaders
+	// inFlight is a semaphore bounding the number of concurrent outstanding
+	// requests. It is nil if there is no limit.
+	inFlight chan struct{}
+	// inFlightWait is how long a request waits for a slot in inFlight before
+	// being dropped. Zero means waiting indefinitely.
+	inFlightWait time.Duration
+	// breaker drops requests after repeated failures. It is nil if the
+	// circuit breaker is disabled.
+	breaker *httpSinkBreaker
+	// current is the index in addresses of the endpoint that last
+	// succeeded.
+	current atomic.Int32
+	// bodyPrefix and bodySuffix, if set, surround the formatted entries
+	// in the body of each request.
+	bodyPrefix, bodySuffix string
+	// envelope, if set, replaces bodyPrefix with the beginning of the
+	// configured envelope.
+	envelope *httpSinkEnvelope
+	// bodyIsArray is true if the body wrapping expects the entries to be
+	// flushed as a JSON array, i.e. if the entries of a buffered sink are
+	// wrapped in an envelope.
+	bodyIsArray bool
+	// deadLetter, if set, stores the entries that could not be
+	// delivered.
+	deadLetter *httpSinkDeadLetter
+	// heartbeat, if set, sends heartbeat records when no requests are
+	// sent.
+	heartbeat *httpSinkHeartbeat
+	// lastSent is the time, in nanoseconds, at which the last request
+	// was sent.
+	lastSent atomic.Int64
+	// compressionNegotiator, if set, selects the compression based on
+	// the encodings supported by the server.
+	compressionNegotiator *httpSinkCompressionNegotiator
+	// addressHasIDs is true if some address contains the placeholder of
+	// a server identifier, which is replaced by the identifier in ids.
+	addressHasIDs bool
+	ids           atomic.Pointer[httpSinkIDs]
+}
+
+// httpSinkHeaderFuncs holds the header callbacks registered via
+// SetHTTPSinkHeaderFunc, indexed by sink name.
+var httpSinkHeaderFuncs struct {
+	syncutil.Mutex
+	m map[string]func() map[string]string
+}
+
+// SetHTTPSinkHeaderFunc registers a callback computing headers to attach
+// to each request sent by the HTTP sink with the given name in the
+// logging configuration. The callback is invoked once per request, in
+// addition to the statically configured headers. A nil fn removes a
+// previously registered callback.
+//
+// The callback is invoked while logging; it must not log, and should
+// return quickly.
+func SetHTTPSinkHeaderFunc(sinkName string, fn func() map[string]string) {
+	httpSinkHeaderFuncs.Lock()
+	defer httpSinkHeaderFuncs.Unlock()
+	if fn == nil {
+		delete(httpSinkHeaderFuncs.m, sinkName)
+		return
+	}
+	if httpSinkHeaderFuncs.m == nil {
+		httpSinkHeaderFuncs.m = make(map[string]func() map[string]string)
+	}
+	httpSinkHeaderFuncs.m[sinkName] = fn
+}
+
+// getHTTPSinkHeaderFunc returns the header callback registered for the
+// given sink, or nil if there is none.
+func getHTTPSinkHeaderFunc(sinkName string) func() map[string]string {
+	httpSinkHeaderFuncs.Lock()
+	defer httpSinkHeaderFuncs.Unlock()
+	return httpSinkHeaderFuncs.m[sinkName]
 }
 
 type dynamicHeaders struct {
@@ -114,28 +364,284 @@ type dynamicHeaders struct {
 // The parent logger's outputMu is held during this operation: log
 // sinks must not recursively call into logging when implementing
 // this method.
-func (hs *httpSink) output(b []byte, opt sinkOutputOptions) (err error) {
-	resp, err := hs.doRequest(hs, b)
-	if err != nil {
-		return err
+func (hs *httpSink) output(b []byte, opt sinkOutputOptions) error {
+	// The idempotency key identifies the batch across all the attempts to
+	// deliver it, including its replay from the dead-letter files.
+	idempotencyKey := hs.newIdempotencyKey()
+	if err := hs.deliver(b, idempotencyKey); err != nil {
+		if errors.Is(err, errHTTPSinkRequestDropped) {
+			return hs.writeDeadLetter(b, idempotencyKey)
+		}
+		return errors.CombineErrors(err, hs.writeDeadLetter(b, idempotencyKey))
+	}
+	if hs.deadLetter != nil {
+		// The server is reachable, so the dead-lettered entries, if any,
+		// can be replayed.
+		hs.deadLetter.maybeReplay()
+	}
+	return nil
+}
+
+// newIdempotencyKey returns a new idempotency key for a batch of
+// entries, or an empty string if the requests do not carry one.
+func (hs *httpSink) newIdempotencyKey() string {
+	if hs.idempotencyKeyHeader == "" {
+		return ""
 	}
+	return uuid.MakeV4().String()
+}
 
-	if resp.StatusCode >= 400 {
-		return HTTPLogError{
+// errHTTPSinkRequestDropped is returned by deliver when the request is
+// not sent, because of the in-flight limit or the circuit breaker.
+var errHTTPSinkRequestDropped = errors.New("HTTP sink request dropped")
+
+// deliver sends the given batch of formatted entries to the server,
+// subject to the in-flight limit and the circuit breaker, and returns an
+// error if they were not delivered. It is used both for the live entries
+// and for the replay of the dead-lettered ones.
+func (hs *httpSink) deliver(b []byte, idempotencyKey string) (err error) {
+	if hs.inFlight != nil {
+		if !hs.acquireInFlight() {
+			incrementLogMetric(HTTPSinkRequestsDropped)
+			return errHTTPSinkRequestDropped
+		}
+		defer func() { <-hs.inFlight }()
+	}
+
+	if hs.breaker != nil {
+		if !hs.breaker.allow() {
+			incrementLogMetric(HTTPSinkCircuitBreakerDropped)
+			return errHTTPSinkRequestDropped
+		}
+		defer func() { hs.breaker.record(err == nil) }()
+	}
+
+	return hs.send(b, idempotencyKey)
+}
+
+// send sends the given formatted entries to the server, and returns an
+// error if they were not delivered. The requests carry the given
+// idempotency key, if any.
+func (hs *httpSink) send(b []byte, idempotencyKey string) error {
+	body := b
+	bodyPrefix := hs.bodyPrefix
+	if hs.envelope != nil {
+		bodyPrefix = hs.envelopePrefix()
+	}
+	if bodyPrefix != "" || hs.bodySuffix != "" {
+		body = make([]byte, 0, len(bodyPrefix)+len(b)+len(hs.bodySuffix))
+		body = append(body, bodyPrefix...)
+		body = append(body, b...)
+		body = append(body, hs.bodySuffix...)
+	}
+
+	hs.lastSent.Store(timeutil.Now().UnixNano())
+	address, resp, err := hs.doRequestWithFailover(body, idempotencyKey)
+	if err == nil && resp.StatusCode >= 400 {
+		err = HTTPLogError{
 			StatusCode: resp.StatusCode,
-			Address:    hs.address,
+			Address:    address,
 		}
 	}
-	return nil
+	return err
+}
+
+// writeDeadLetter stores a batch of entries that could not be delivered,
+// along with its idempotency key, in the dead-letter files, if
+// configured.
+func (hs *httpSink) writeDeadLetter(b []byte, idempotencyKey string) error {
+	if hs.deadLetter == nil {
+		return nil
+	}
+	return hs.deadLetter.write(b, idempotencyKey)
+}
+
+// close stops the heartbeats and the replay of the dead-lettered
+// entries, and closes the dead-letter file, if any.
+func (hs *httpSink) close() {
+	if hs.heartbeat != nil {
+		hs.heartbeat.stop()
+	}
+	if hs.deadLetter != nil {
+		hs.deadLetter.close()
+	}
+}
+
+// doRequestWithFailover sends the request to the current endpoint and,
+// if that fails with a connection error or a 5xx response, to the
+// following endpoints in order. The endpoint that succeeds becomes the
+// current one. It returns the address of the last endpoint tried. All
+// the requests carry the given idempotency key, if any.
+func (hs *httpSink) doRequestWithFailover(
+	b []byte, idempotencyKey string,
+) (address string, resp *http.Response, err error) {
+	start := int(hs.current.Load())
+	for i := 0; i < len(hs.addresses); i++ {
+		idx := (start + i) % len(hs.addresses)
+		address = hs.resolveAddress(hs.addresses[idx])
+		reqStart := timeutil.Now()
+		resp, err = hs.doRequest(hs, address, b, idempotencyKey)
+		if logging.metrics != nil {
+			logging.metrics.RecordHTTPSinkRequest(
+				hs.sinkName, httpSinkStatusClass(resp, err), timeutil.Since(reqStart))
+		}
+		if err == nil && resp.StatusCode < 500 {
+			if idx != start && hs.current.CompareAndSwap(int32(start), int32(idx)) {
+				incrementLogMetric(HTTPSinkFailovers)
+			}
+			return address, resp, nil
+		}
+	}
+	return address, resp, err
+}
+
+// httpSinkStatusClass returns the status class of the outcome of a
+// request.
+func httpSinkStatusClass(resp *http.Response, err error) HTTPSinkStatusClass {
+	switch {
+	case err != nil:
+		return HTTPSinkStatusError
+	case resp.StatusCode >= 500:
+		return HTTPSinkStatus5xx
+	case resp.StatusCode >= 400:
+		return HTTPSinkStatus4xx
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return HTTPSinkStatus2xx
+	default:
+		return HTTPSinkStatusOther
+	}
 }
 
-func doPost(hs *httpSink, b []byte) (*http.Response, error) {
+// incrementLogMetric increments the given log metric by one, if the
+// metrics have been injected.
+func incrementLogMetric(m Metric) {
+	if logging.metrics != nil {
+		logging.metrics.IncrementCounter(m, 1)
+	}
+}
+
+// httpSinkBreakerState is the state of an httpSinkBreaker.
+type httpSinkBreakerState int
+
+const (
+	// httpSinkBreakerClosed lets all requests through.
+	httpSinkBreakerClosed httpSinkBreakerState = iota
+	// httpSinkBreakerOpen drops all requests until the cooldown expires.
+	httpSinkBreakerOpen
+	// httpSinkBreakerHalfOpen lets a single probe request through, whose
+	// outcome decides whether the breaker closes or opens again.
+	httpSinkBreakerHalfOpen
+)
+
+// httpSinkBreaker is a circuit breaker which stops an HTTP sink from
+// attempting requests to a server which repeatedly failed them.
+type httpSinkBreaker struct {
+	// threshold is the number of consecutive failures opening the breaker.
+	threshold int
+	// cooldown is how long the breaker stays open before letting a probe
+	// request through.
+	cooldown time.Duration
+	mu       struct {
+		syncutil.Mutex
+		state               httpSinkBreakerState
+		consecutiveFailures int
+		// openedAt is when the breaker was last opened.
+		openedAt time.Time
+		// probing is true while the probe request of a half-open breaker
+		// is in flight.
+		probing bool
+	}
+}
+
+// allow returns whether a request may be attempted. If it returns true,
+// the caller must report the outcome of the request via record.
+func (b *httpSinkBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.mu.state {
+	case httpSinkBreakerClosed:
+		return true
+	case httpSinkBreakerOpen:
+		if timeutil.Since(b.mu.openedAt) < b.cooldown {
+			return false
+		}
+		b.mu.state = httpSinkBreakerHalfOpen
+		b.mu.probing = true
+		return true
+	default:
+		if b.mu.probing {
+			return false
+		}
+		b.mu.probing = true
+		return true
+	}
+}
+
+// record reports the outcome of a request allowed by allow.
+func (b *httpSinkBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.mu.probing = false
+	if success {
+		b.mu.consecutiveFailures = 0
+		if b.mu.state != httpSinkBreakerClosed {
+			b.mu.state = httpSinkBreakerClosed
+			incrementLogMetric(HTTPSinkCircuitBreakerClosed)
+		}
+		return
+	}
+	b.mu.consecutiveFailures++
+	if b.mu.state == httpSinkBreakerHalfOpen ||
+		(b.mu.state == httpSinkBreakerClosed && b.mu.consecutiveFailures >= b.threshold) {
+		b.mu.state = httpSinkBreakerOpen
+		b.mu.openedAt = timeutil.Now()
+		incrementLogMetric(HTTPSinkCircuitBreakerOpened)
+	}
+}
+
+// acquireInFlight waits for a slot for a new request, for at most
+// hs.inFlightWait if set. It returns false if no slot was acquired, in
+// which case the request should be dropped.
+func (hs *httpSink) acquireInFlight() bool {
+	if hs.inFlightWait == 0 {
+		hs.inFlight <- struct{}{}
+		return true
+	}
+	select {
+	case hs.inFlight <- struct{}{}:
+		return true
+	default:
+	}
+	t := time.NewTimer(hs.inFlightWait)
+	defer t.Stop()
+	select {
+	case hs.inFlight <- struct{}{}:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+// doPost sends the entries in the body of a request using the configured
+// method, i.e. POST or PUT.
+func doPost(
+	hs *httpSink, address string, b []byte, idempotencyKey string,
+) (*http.Response, error) {
 	var buf = bytes.Buffer{}
 	var req *http.Request
 
-	if *hs.config.Compression == logconfig.GzipCompression {
-		g := gzip.NewWriter(&buf)
-		_, err := g.Write(b)
+	compression := hs.compressionFor(address)
+	switch compression {
+	case logconfig.GzipCompression:
+		level := gzip.DefaultCompression
+		if hs.config.CompressionLevel != nil {
+			level = *hs.config.CompressionLevel
+		}
+		g, err := gzip.NewWriterLevel(&buf, level)
+		if err != nil {
+			return nil, err
+		}
+		_, err = g.Write(b)
 		if err != nil {
 			return nil, err
 		}
@@ -143,19 +649,50 @@ func doPost(hs *httpSink, b []byte) (*http.Response, error) {
 		if err != nil {
 			return nil, err
 		}
-	} else {
+	case logconfig.ZstdCompression:
+		z, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		_, err = z.Write(b)
+		if err != nil {
+			return nil, err
+		}
+		err = z.Close()
+		if err != nil {
+			return nil, err
+		}
+	default:
 		buf.Write(b)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, hs.address, &buf)
+	req, err := http.NewRequest(hs.method, address, &buf)
 	if err != nil {
 		return nil, err
 	}
 
-	if *hs.config.Compression == logconfig.GzipCompression {
+	switch compression {
+	case logconfig.GzipCompression:
 		req.Header.Add(httputil.ContentEncodingHeader, httputil.GzipEncoding)
+	case logconfig.ZstdCompression:
+		req.Header.Add(httputil.ContentEncodingHeader, httputil.ZstdEncoding)
+	}
+
+	hs.addHeaders(req)
+	if idempotencyKey != "" {
+		req.Header.Add(hs.idempotencyKeyHeader, idempotencyKey)
 	}
+	req.Header.Add(httputil.ContentTypeHeader, hs.contentType)
+	resp, err := hs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close() // don't care about content
+	return resp, nil
+}
 
+// addHeaders adds the configured headers to the request.
+func (hs *httpSink) addHeaders(req *http.Request) {
 	// Add both the staticHeaders and dynamicHeaders to the request.
 	for k, v := range hs.staticHeaders {
 		req.Header.Add(k, v)
@@ -170,17 +707,16 @@ func doPost(hs *httpSink, b []byte) (*http.Response, error) {
 			}
 		}()
 	}
-	req.Header.Add(httputil.ContentTypeHeader, hs.contentType)
-	resp, err := hs.client.Do(req)
-	if err != nil {
-		return nil, err
+	// Add the headers computed by the registered callback, if any.
+	if fn := getHTTPSinkHeaderFunc(hs.sinkName); fn != nil {
+		for k, v := range fn() {
+			req.Header.Add(k, v)
+		}
 	}
-	resp.Body.Close() // don't care about content
-	return resp, nil
 }
 
-func doGet(hs *httpSink, b []byte) (*http.Response, error) {
-	resp, err := hs.client.Get(hs.address + "?" + url.QueryEscape(string(b)))
+func doGet(hs *httpSink, address string, b []byte, _ string) (*http.Response, error) {
+	resp, err := hs.client.Get(address + "?" + url.QueryEscape(string(b)))
 	if err != nil {
 		return nil, err
 	}
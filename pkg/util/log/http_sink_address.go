@@ -0,0 +1,88 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/base/serverident"
+	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
+)
+
+// httpSinkUnknownID replaces the placeholder of a server identifier in
+// the address of an HTTP sink, until the identifier is known.
+const httpSinkUnknownID = "unknown"
+
+// httpSinkIDs are the server identifiers substituted in the address of
+// an HTTP sink.
+type httpSinkIDs struct {
+	clusterID, nodeID string
+}
+
+// addressHasServerIDs returns true if the address contains the
+// placeholder of a server identifier.
+func addressHasServerIDs(address string) bool {
+	return strings.Contains(address, logconfig.HTTPSinkClusterIDPlaceholder) ||
+		strings.Contains(address, logconfig.HTTPSinkNodeIDPlaceholder)
+}
+
+// observeIDs records the server identifiers of an entry output to the
+// sink, if they are known. The channel placeholder is not handled here,
+// since it is replaced when the sink is created; see
+// splitHTTPSinkConfigByAddress.
+func (hs *httpSink) observeIDs(ids serverident.IDPayload) {
+	if ids.ClusterID == "" && ids.NodeID == "" {
+		return
+	}
+	var next httpSinkIDs
+	cur := hs.ids.Load()
+	if cur != nil {
+		next = *cur
+	}
+	if ids.ClusterID != "" {
+		next.clusterID = ids.ClusterID
+	}
+	if ids.NodeID != "" {
+		next.nodeID = ids.NodeID
+	}
+	if cur == nil || next != *cur {
+		hs.ids.Store(&next)
+	}
+}
+
+// serverIDs returns the server identifiers observed so far, or
+// httpSinkUnknownID for the ones that are not known yet.
+func (hs *httpSink) serverIDs() (clusterID, nodeID string) {
+	clusterID, nodeID = httpSinkUnknownID, httpSinkUnknownID
+	if ids := hs.ids.Load(); ids != nil {
+		if ids.clusterID != "" {
+			clusterID = ids.clusterID
+		}
+		if ids.nodeID != "" {
+			nodeID = ids.nodeID
+		}
+	}
+	return clusterID, nodeID
+}
+
+// resolveAddress replaces the placeholders of the server identifiers
+// in the given address.
+func (hs *httpSink) resolveAddress(address string) string {
+	if !hs.addressHasIDs {
+		return address
+	}
+	clusterID, nodeID := hs.serverIDs()
+	return strings.NewReplacer(
+		logconfig.HTTPSinkClusterIDPlaceholder, url.PathEscape(clusterID),
+		logconfig.HTTPSinkNodeIDPlaceholder, url.PathEscape(nodeID),
+	).Replace(address)
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/httputil"
+	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// httpSinkCompressionProbeInterval is how long the encodings supported by
+// a server are cached before the server is probed again.
+const httpSinkCompressionProbeInterval = 10 * time.Minute
+
+// httpSinkCompressionPreference lists the compressions that can be
+// negotiated, from the most to the least preferred.
+var httpSinkCompressionPreference = []struct {
+	compression, encoding string
+}{
+	{logconfig.ZstdCompression, httputil.ZstdEncoding},
+	{logconfig.GzipCompression, httputil.GzipEncoding},
+}
+
+// httpSinkCompressionNegotiator selects the compression of the requests
+// sent to each server, based on the encodings that the server advertises
+// in the Accept-Encoding header of its response to an OPTIONS request.
+type httpSinkCompressionNegotiator struct {
+	probeInterval time.Duration
+
+	mu struct {
+		syncutil.Mutex
+		// byAddress caches the compression selected for each server.
+		byAddress map[string]negotiatedCompression
+	}
+}
+
+type negotiatedCompression struct {
+	compression string
+	probedAt    time.Time
+}
+
+func newHTTPSinkCompressionNegotiator() *httpSinkCompressionNegotiator {
+	n := &httpSinkCompressionNegotiator{probeInterval: httpSinkCompressionProbeInterval}
+	n.mu.byAddress = make(map[string]negotiatedCompression)
+	return n
+}
+
+// compressionFor returns the compression to use for the requests sent
+// to the given address.
+func (hs *httpSink) compressionFor(address string) string {
+	n := hs.compressionNegotiator
+	if n == nil {
+		return *hs.config.Compression
+	}
+	now := timeutil.Now()
+	n.mu.Lock()
+	cached, ok := n.mu.byAddress[address]
+	n.mu.Unlock()
+	if ok && now.Sub(cached.probedAt) < n.probeInterval {
+		return cached.compression
+	}
+
+	// The probe is performed without holding the lock, so that a slow
+	// server does not block the requests to other servers.
+	compression := hs.probeCompression(address)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.mu.byAddress[address] = negotiatedCompression{compression: compression, probedAt: now}
+	return compression
+}
+
+// probeCompression sends an OPTIONS request to the server and returns
+// the most preferred compression among the encodings it accepts. The
+// configured compression is used if the server cannot be probed or
+// does not advertise the encodings it accepts.
+func (hs *httpSink) probeCompression(address string) string {
+	req, err := http.NewRequest(http.MethodOptions, address, nil)
+	if err != nil {
+		return *hs.config.Compression
+	}
+	hs.addHeaders(req)
+	resp, err := hs.client.Do(req)
+	if err != nil {
+		return *hs.config.Compression
+	}
+	resp.Body.Close() // don't care about content
+	acceptEncoding := resp.Header.Values(httputil.AcceptEncodingHeader)
+	if len(acceptEncoding) == 0 {
+		return *hs.config.Compression
+	}
+	accepted := parseAcceptEncoding(acceptEncoding)
+	for _, c := range httpSinkCompressionPreference {
+		if accepted[c.encoding] {
+			return c.compression
+		}
+	}
+	return logconfig.NoneCompression
+}
+
+// parseAcceptEncoding returns the set of encodings listed in the given
+// Accept-Encoding header values, excluding the ones with a zero
+// quality value.
+func parseAcceptEncoding(values []string) map[string]bool {
+	accepted := make(map[string]bool)
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			encoding, params, _ := strings.Cut(part, ";")
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if encoding == "" {
+				continue
+			}
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if f, err := strconv.ParseFloat(q, 64); err == nil && f == 0 {
+					continue
+				}
+			}
+			accepted[encoding] = true
+		}
+	}
+	return accepted
+}
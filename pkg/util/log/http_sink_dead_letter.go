@@ -0,0 +1,408 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log/logpb"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// defaultDeadLetterMaxSize is the maximum combined size of the
+// dead-letter files of an HTTP sink, if not configured otherwise.
+const defaultDeadLetterMaxSize = 100 << 20 // 100MiB
+
+// deadLetterFileMaxSize is the size after which a new dead-letter file
+// is started, so that the oldest entries can be evicted.
+const deadLetterFileMaxSize = 10 << 20 // 10MiB
+
+// deadLetterFileMode is the permission mode of the dead-letter files.
+const deadLetterFileMode = 0640
+
+// deadLetterReplayInterval is the minimum interval between the requests
+// sent to replay the dead-lettered entries, so that the replay does not
+// overwhelm a server that just recovered.
+const deadLetterReplayInterval = 10 * time.Millisecond
+
+// httpSinkDeadLetter stores the batches of log entries that an HTTP sink
+// could not deliver in files, so that they can be replayed later. The
+// files are named like log files, with a prefix derived from the sink
+// name and address (see deadLetterFileGroupName). When the combined size
+// of the files exceeds the maximum size, the oldest files are removed.
+//
+// Each batch is stored as a record holding its idempotency key and its
+// entries, each prefixed with its length as a uvarint, so that the batch
+// is replayed whole, with the same idempotency key as the attempts that
+// failed. See appendDeadLetterRecord.
+type httpSinkDeadLetter struct {
+	dir           string
+	maxSize       int64
+	fileMaxSize   int64
+	nameGenerator fileNameGenerator
+
+	mu struct {
+		syncutil.Mutex
+		// file is the file currently written to, or nil if no file was
+		// created yet.
+		file *os.File
+		// size is the number of bytes written to file.
+		size int64
+		// lastRotation is the timestamp of the name of the last file
+		// created. See create().
+		lastRotation int64
+	}
+
+	// replay is only used if the replay of the dead-lettered entries is
+	// enabled. See startReplay.
+	replay struct {
+		// send delivers the given batch of entries to the server, with the
+		// given idempotency key.
+		send     func(b []byte, idempotencyKey string) error
+		interval time.Duration
+		// pending is true if some dead-letter files may need to be
+		// replayed.
+		pending atomic.Bool
+		// ch is signaled to start a replay.
+		ch    chan struct{}
+		stopC chan struct{}
+		// done is closed when the replay goroutine exits.
+		done chan struct{}
+	}
+}
+
+func newHTTPSinkDeadLetter(
+	sinkName string, address string, dir string, maxSize int64,
+) *httpSinkDeadLetter {
+	d := &httpSinkDeadLetter{
+		dir:           dir,
+		maxSize:       maxSize,
+		fileMaxSize:   deadLetterFileMaxSize,
+		nameGenerator: makeFileNameGenerator(deadLetterFileGroupName(sinkName, address)),
+	}
+	if d.fileMaxSize > maxSize {
+		d.fileMaxSize = maxSize
+	}
+	return d
+}
+
+// deadLetterFileGroupName returns the file group name of the dead-letter
+// files of the HTTP sink with the given name and address.
+//
+// A sink configured with channel-addresses, or with the channel
+// placeholder in its address, is split into one httpSink per address,
+// all with the same sink name (see splitHTTPSinkConfigByAddress). The
+// hash of the address distinguishes their files, so that each of them
+// only evicts and replays the entries that were meant for its address.
+func deadLetterFileGroupName(sinkName string, address string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(address))
+	return fmt.Sprintf("http-dead-letter-%s-%08x", sinkName, h.Sum32())
+}
+
+// appendDeadLetterRecord appends the dead-letter record of the given batch
+// of entries and its idempotency key to buf.
+func appendDeadLetterRecord(buf []byte, b []byte, idempotencyKey string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(idempotencyKey)))
+	buf = append(buf, idempotencyKey...)
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// decodeDeadLetterRecord decodes the first dead-letter record of buf, and
+// returns the remainder of buf. It returns ok=false if buf does not start
+// with a complete record, e.g. because the process stopped while writing
+// it.
+func decodeDeadLetterRecord(
+	buf []byte,
+) (b []byte, idempotencyKey string, rest []byte, ok bool) {
+	keyLen, n := binary.Uvarint(buf)
+	if n <= 0 || keyLen > uint64(len(buf)-n) {
+		return nil, "", nil, false
+	}
+	buf = buf[n:]
+	idempotencyKey, buf = string(buf[:keyLen]), buf[keyLen:]
+	bLen, n := binary.Uvarint(buf)
+	if n <= 0 || bLen > uint64(len(buf)-n) {
+		return nil, "", nil, false
+	}
+	buf = buf[n:]
+	return buf[:bLen], idempotencyKey, buf[bLen:], true
+}
+
+// write appends the record of the given batch of entries and its
+// idempotency key to the current dead-letter file.
+func (d *httpSinkDeadLetter) write(b []byte, idempotencyKey string) error {
+	record := appendDeadLetterRecord(nil, b, idempotencyKey)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.mu.file == nil || d.mu.size+int64(len(record)) > d.fileMaxSize {
+		if err := d.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	n, err := d.mu.file.Write(record)
+	d.mu.size += int64(n)
+	d.replay.pending.Store(true)
+	return errors.Wrap(err, "writing dead-letter file")
+}
+
+// startReplay starts a goroutine that replays the dead-lettered batches
+// using send, when signaled by maybeReplay. The goroutine is stopped by
+// close.
+func (d *httpSinkDeadLetter) startReplay(send func(b []byte, idempotencyKey string) error) {
+	d.replay.send = send
+	d.replay.interval = deadLetterReplayInterval
+	d.replay.ch = make(chan struct{}, 1)
+	d.replay.stopC = make(chan struct{})
+	d.replay.done = make(chan struct{})
+	// The files left over by a previous process are replayed too.
+	if files, err := d.listFiles(); err == nil && len(files) > 0 {
+		d.replay.pending.Store(true)
+	}
+	go func() {
+		defer close(d.replay.done)
+		for {
+			select {
+			case <-d.replay.stopC:
+				return
+			case <-d.replay.ch:
+			}
+			d.replayFiles()
+		}
+	}()
+}
+
+// maybeReplay is called when the server is reachable, and signals the
+// replay goroutine if there are entries to replay. It does not block.
+func (d *httpSinkDeadLetter) maybeReplay() {
+	if d.replay.ch == nil || !d.replay.pending.Load() {
+		return
+	}
+	select {
+	case d.replay.ch <- struct{}{}:
+	default:
+		// A replay is already signaled.
+	}
+}
+
+// replayFiles sends the batches of the dead-letter files, oldest first,
+// waiting for the replay interval before each request. A file is removed
+// once all its batches were delivered. If a batch is not delivered, the
+// replay stops, and the undelivered batches are kept to be replayed once
+// the server is reachable again. If the process stops during the replay,
+// the delivered batches of the file being replayed are kept, and are
+// delivered again by the next process.
+func (d *httpSinkDeadLetter) replayFiles() {
+	// Stop writing to the current file, so that it can be replayed. The
+	// entries that are not delivered from now on are written to a new file,
+	// which is replayed next time.
+	func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.replay.pending.Store(false)
+		if err := d.closeLocked(); err != nil {
+			fmt.Fprintf(OrigStderr, "log: unable to close dead-letter file: %v\n", err)
+		}
+	}()
+	files, err := d.listFiles()
+	if err != nil {
+		fmt.Fprintf(OrigStderr, "log: unable to list dead-letter files: %v\n", err)
+		return
+	}
+	files = selectFilesInGroup(files, math.MaxInt64)
+	ticker := time.NewTicker(d.replay.interval)
+	defer ticker.Stop()
+	// The files are sorted newest first.
+	for i := len(files) - 1; i >= 0; i-- {
+		if !d.replayFile(files[i].Name, ticker) {
+			d.replay.pending.Store(true)
+			return
+		}
+	}
+}
+
+// replayFile sends the batches of the given dead-letter file, one batch
+// per request, and removes the file once they are all delivered. It
+// returns false if some batches were not delivered.
+func (d *httpSinkDeadLetter) replayFile(name string, ticker *time.Ticker) bool {
+	path := filepath.Join(d.dir, name)
+	if d.isCurrentFile(name) {
+		// The file was created after the replay started, and is still
+		// being written to.
+		return false
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		// The file may have been removed by gcLocked in the meantime.
+		return errors.Is(err, fs.ErrNotExist)
+	}
+	for rest := b; len(rest) > 0; {
+		batch, idempotencyKey, next, ok := decodeDeadLetterRecord(rest)
+		if !ok {
+			// The last record is incomplete, because the process stopped
+			// while writing it. It cannot be replayed.
+			fmt.Fprintf(OrigStderr, "log: dropping incomplete record of dead-letter file %s\n", name)
+			break
+		}
+		select {
+		case <-d.replay.stopC:
+			d.keepUndelivered(path, rest)
+			return false
+		case <-ticker.C:
+		}
+		if err := d.replay.send(batch, idempotencyKey); err != nil {
+			d.keepUndelivered(path, rest)
+			return false
+		}
+		rest = next
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(OrigStderr, "log: unable to remove dead-letter file: %v\n", err)
+	}
+	return true
+}
+
+// isCurrentFile returns true if the given file is the one currently
+// written to.
+func (d *httpSinkDeadLetter) isCurrentFile(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.mu.file != nil && filepath.Base(d.mu.file.Name()) == name
+}
+
+// keepUndelivered replaces the contents of the given dead-letter file
+// with the records of the batches that were not delivered.
+func (d *httpSinkDeadLetter) keepUndelivered(path string, undelivered []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := os.Stat(path); err != nil {
+		// The file was removed by gcLocked in the meantime.
+		return
+	}
+	// The file is replaced atomically, so that no entries are lost if the
+	// process stops in the meantime. The temporary file is not considered
+	// a dead-letter file by listFiles.
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, undelivered, deadLetterFileMode)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		fmt.Fprintf(OrigStderr, "log: unable to update dead-letter file: %v\n", err)
+	}
+}
+
+// rotateLocked closes the current file, if any, starts a new one and
+// removes the oldest files in excess of the maximum size.
+func (d *httpSinkDeadLetter) rotateLocked() error {
+	if err := d.closeLocked(); err != nil {
+		fmt.Fprintf(OrigStderr, "log: unable to close dead-letter file: %v\n", err)
+	}
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return errors.Wrap(err, "creating dead-letter directory")
+	}
+	f, updatedRotation, _, _, err := create(
+		d.dir, d.nameGenerator, timeutil.Now(), d.mu.lastRotation, deadLetterFileMode)
+	if err != nil {
+		return err
+	}
+	d.mu.file = f
+	d.mu.size = 0
+	d.mu.lastRotation = updatedRotation
+	d.gcLocked()
+	return nil
+}
+
+// gcLocked removes the oldest dead-letter files so that the combined
+// size of the files stays below the maximum size. The current file is
+// always kept.
+func (d *httpSinkDeadLetter) gcLocked() {
+	files, err := d.listFiles()
+	if err != nil {
+		fmt.Fprintf(OrigStderr, "log: unable to GC dead-letter files: %v\n", err)
+		return
+	}
+	files = selectFilesInGroup(files, math.MaxInt64)
+	current := filepath.Base(d.mu.file.Name())
+	var sum int64
+	for _, f := range files {
+		if f.Name == current {
+			continue
+		}
+		sum += f.SizeBytes
+		if sum+d.fileMaxSize <= d.maxSize {
+			continue
+		}
+		if err := os.Remove(filepath.Join(d.dir, f.Name)); err != nil {
+			fmt.Fprintln(OrigStderr, err)
+		}
+	}
+}
+
+// listFiles lists the dead-letter files of this sink.
+func (d *httpSinkDeadLetter) listFiles() ([]logpb.FileInfo, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	var results []logpb.FileInfo
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		details, err := ParseLogFilename(entry.Name())
+		if err != nil || !d.nameGenerator.ownsFileByPrefix(details.Program) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		results = append(results, MakeFileInfo(details, info))
+	}
+	return results, nil
+}
+
+func (d *httpSinkDeadLetter) closeLocked() error {
+	if d.mu.file == nil {
+		return nil
+	}
+	err := d.mu.file.Close()
+	d.mu.file = nil
+	return err
+}
+
+// close stops the replay, if any, and closes the current dead-letter
+// file.
+func (d *httpSinkDeadLetter) close() {
+	if d.replay.stopC != nil {
+		close(d.replay.stopC)
+		<-d.replay.done
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.closeLocked(); err != nil {
+		fmt.Fprintf(OrigStderr, "log: unable to close dead-letter file: %v\n", err)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
+)
+
+// httpSinkEnvelope wraps the entries sent by an HTTP sink in a JSON
+// object, along with the configured fields.
+type httpSinkEnvelope struct {
+	// fields are sorted by key, so that the envelope is deterministic.
+	fields []httpSinkEnvelopeField
+	// hasIDs is true if some field value contains the placeholder of a
+	// server identifier.
+	hasIDs bool
+	// openArray is true if the entries are not already flushed as a
+	// JSON array, i.e. if the sink is not buffered.
+	openArray bool
+}
+
+type httpSinkEnvelopeField struct {
+	key, value string
+}
+
+func newHTTPSinkEnvelope(fields map[string]string, openArray bool) *httpSinkEnvelope {
+	e := &httpSinkEnvelope{openArray: openArray}
+	for k, v := range fields {
+		e.fields = append(e.fields, httpSinkEnvelopeField{key: k, value: v})
+		if addressHasServerIDs(v) {
+			e.hasIDs = true
+		}
+	}
+	sort.Slice(e.fields, func(i, j int) bool { return e.fields[i].key < e.fields[j].key })
+	return e
+}
+
+// envelopePrefix returns the JSON text preceding the array of entries
+// in the envelope, with the placeholders of the server identifiers
+// replaced.
+func (hs *httpSink) envelopePrefix() string {
+	var r *strings.Replacer
+	if hs.envelope.hasIDs {
+		clusterID, nodeID := hs.serverIDs()
+		r = strings.NewReplacer(
+			logconfig.HTTPSinkClusterIDPlaceholder, clusterID,
+			logconfig.HTTPSinkNodeIDPlaceholder, nodeID,
+		)
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	buf.WriteByte('{')
+	for _, f := range hs.envelope.fields {
+		v := f.value
+		if r != nil {
+			v = r.Replace(v)
+		}
+		buf.WriteByte('"')
+		escapeString(buf, f.key)
+		buf.WriteString(`":"`)
+		escapeString(buf, v)
+		buf.WriteString(`",`)
+	}
+	buf.WriteByte('"')
+	buf.WriteString(logconfig.HTTPSinkEnvelopeRecordsKey)
+	buf.WriteString(`":`)
+	if hs.envelope.openArray {
+		buf.WriteByte('[')
+	}
+	return buf.String()
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// httpSinkHeartbeat sends a heartbeat record to the server of an HTTP
+// sink when no request was sent for the heartbeat interval, so that the
+// server does not consider the node unavailable when there are no logs.
+type httpSinkHeartbeat struct {
+	hs       *httpSink
+	interval time.Duration
+	stopC    chan struct{}
+	// done is closed when the heartbeat goroutine exits.
+	done chan struct{}
+}
+
+func startHTTPSinkHeartbeat(hs *httpSink, interval time.Duration) *httpSinkHeartbeat {
+	h := &httpSinkHeartbeat{
+		hs:       hs,
+		interval: interval,
+		stopC:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go h.run()
+	return h
+}
+
+func (h *httpSinkHeartbeat) run() {
+	defer close(h.done)
+	// The sink is considered idle since it was created.
+	h.hs.lastSent.CompareAndSwap(0, timeutil.Now().UnixNano())
+	timer := time.NewTimer(h.interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-h.stopC:
+			return
+		case <-timer.C:
+		}
+		now := timeutil.Now()
+		idle := now.Sub(timeutil.Unix(0, h.hs.lastSent.Load()))
+		if idle >= h.interval {
+			// The heartbeat is best effort: an error is not reported, since
+			// the failures of the regular requests are.
+			record := makeHTTPSinkHeartbeatRecord(now)
+			if h.hs.bodyIsArray {
+				// The record is wrapped like a batch of entries.
+				record = append(append([]byte{'['}, record...), ']')
+			}
+			_ = h.hs.send(record, h.hs.newIdempotencyKey())
+			idle = 0
+		}
+		timer.Reset(h.interval - idle)
+	}
+}
+
+// stop stops the heartbeats, and waits for the heartbeat goroutine to
+// exit.
+func (h *httpSinkHeartbeat) stop() {
+	close(h.stopC)
+	<-h.done
+}
+
+// makeHTTPSinkHeartbeatRecord returns the heartbeat record sent at the
+// given time. The timestamp is encoded like in the JSON formats.
+func makeHTTPSinkHeartbeatRecord(now time.Time) []byte {
+	ts := now.UnixNano()
+	return []byte(fmt.Sprintf(`{"type":"heartbeat","timestamp":"%d.%09d"}`,
+		ts/int64(time.Second), ts%int64(time.Second)))
+}
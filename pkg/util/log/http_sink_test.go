@@ -12,24 +12,44 @@ package log
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
 	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/base/serverident"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log/channel"
 	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
+	"github.com/cockroachdb/cockroach/pkg/util/log/logpb"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/logtags"
+	"github.com/cockroachdb/redact"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"golang.org/x/sys/unix"
 )
 
@@ -57,6 +77,20 @@ func testBase(
 	hangServer bool,
 	deadline time.Duration,
 	recall time.Duration,
+) {
+	testBaseWithServer(t, defaults, fn, hangServer, deadline, recall, httptest.NewServer)
+}
+
+// testBaseWithServer is like testBase, but uses the provided function to
+// start the HTTP server receiving the logging events.
+func testBaseWithServer(
+	t *testing.T,
+	defaults logconfig.HTTPDefaults,
+	fn func(header http.Header, body string) error,
+	hangServer bool,
+	deadline time.Duration,
+	recall time.Duration,
+	newServer func(http.Handler) *httptest.Server,
 ) {
 	sc := ScopeWithoutShowLogs(t)
 	defer sc.Close(t)
@@ -94,7 +128,7 @@ func testBase(
 
 	// Start the HTTP server that receives the logging events from the
 	// test.
-	s2 := httptest.NewServer(http.HandlerFunc(handler))
+	s2 := newServer(http.HandlerFunc(handler))
 	defer s2.Close()
 	defaults.Address = &s2.URL
 
@@ -204,6 +238,50 @@ func TestHTTPSinkTimeout(t *testing.T) {
 	testBase(t, defaults, nil /* testFn */, true /* hangServer */, 10*time.Second, time.Duration(0))
 }
 
+// TestHTTPSinkResponseHeaderTimeout verifies that a request to a server
+// which accepts the connection but delays the response headers is
+// aborted after the response header timeout, well before the overall
+// timeout.
+func TestHTTPSinkResponseHeaderTimeout(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	unblock := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer s.Close()
+	defer close(unblock)
+
+	timeout := time.Minute
+	dialTimeout := 5 * time.Second
+	responseHeaderTimeout := 100 * time.Millisecond
+	tb := true
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:               &s.URL,
+				Timeout:               &timeout,
+				DialTimeout:           &dialTimeout,
+				ResponseHeaderTimeout: &responseHeaderTimeout,
+				DisableKeepAlives:     &tb,
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+	hs, err := newHTTPSink("ops", *cfg.Sinks.HTTPServers["ops"])
+	require.NoError(t, err)
+
+	start := timeutil.Now()
+	err = hs.output([]byte("hello world"), sinkOutputOptions{})
+	require.ErrorContains(t, err, "timeout awaiting response headers")
+	require.Less(t, timeutil.Since(start), 10*time.Second)
+}
+
 // TestHTTPSinkContentTypeJSON verifies that the HTTP sink content type
 // header is set to `application/json` when the format is json.
 func TestHTTPSinkContentTypeJSON(t *testing.T) {
@@ -270,6 +348,67 @@ func TestHTTPSinkContentTypePlainText(t *testing.T) {
 	testBase(t, defaults, testFn, false /* hangServer */, time.Duration(0), time.Duration(0))
 }
 
+// TestHTTPSinkContentTypeCrdbV2 verifies that the crdb-v2 format is sent
+// as plain text that the crdb-v2 parser understands.
+func TestHTTPSinkContentTypeCrdbV2(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	timeout := 5 * time.Second
+	tb := true
+	format := "crdb-v2"
+	expectedContentType := "text/plain"
+	defaults := logconfig.HTTPDefaults{
+		Timeout: &timeout,
+
+		// We need to disable keepalives otherwise the HTTP server in the
+		// test will let an async goroutine run waiting for more requests.
+		DisableKeepAlives: &tb,
+		CommonSinkConfig: logconfig.CommonSinkConfig{
+			Format:    &format,
+			Buffering: disabledBufferingCfg,
+		},
+	}
+
+	testFn := func(header http.Header, body string) error {
+		t.Log(body)
+		contentType := header.Get("Content-Type")
+		if contentType != expectedContentType {
+			return errors.Newf("mismatched content type: expected %s, got %s", expectedContentType, contentType)
+		}
+		messages, err := decodeCrdbV2Messages(body)
+		if err != nil {
+			return err
+		}
+		if len(messages) != 1 || !strings.Contains(messages[0], "hello world") {
+			return errors.Newf("expected a single hello world entry, got %q", messages)
+		}
+		return nil
+	}
+
+	testBase(t, defaults, testFn, false /* hangServer */, time.Duration(0), time.Duration(0))
+}
+
+// decodeCrdbV2Messages parses the given crdb-v2 formatted entries, and
+// returns their messages.
+func decodeCrdbV2Messages(body string) ([]string, error) {
+	decoder, err := NewEntryDecoderWithFormat(
+		strings.NewReader(body), WithMarkedSensitiveData, "crdb-v2")
+	if err != nil {
+		return nil, err
+	}
+	var messages []string
+	for {
+		var e logpb.Entry
+		if err := decoder.Decode(&e); err != nil {
+			if err == io.EOF {
+				return messages, nil
+			}
+			return nil, err
+		}
+		messages = append(messages, e.Message)
+	}
+}
+
 func TestHTTPSinkHeadersAndCompression(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 
@@ -355,3 +494,2042 @@ func TestHTTPSinkHeadersAndCompression(t *testing.T) {
 
 	testBase(t, defaults, testFn, false /* hangServer */, time.Duration(0), 1*time.Second)
 }
+
+func TestHTTPSinkZstdCompression(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	timeout := 5 * time.Second
+	tb := true
+	format := "json"
+	expectedContentEncoding := logconfig.ZstdCompression
+	defaults := logconfig.HTTPDefaults{
+		Timeout: &timeout,
+
+		// We need to disable keepalives otherwise the HTTP server in the
+		// test will let an async goroutine run waiting for more requests.
+		DisableKeepAlives: &tb,
+		CommonSinkConfig: logconfig.CommonSinkConfig{
+			Format:    &format,
+			Buffering: disabledBufferingCfg,
+		},
+
+		Compression: &logconfig.ZstdCompression,
+		Headers:     map[string]string{"X-CRDB-TEST": "secret-value"},
+	}
+
+	testFn := func(header http.Header, body string) error {
+		t.Log(body)
+		contentEncoding := header.Get("Content-Encoding")
+		if contentEncoding != expectedContentEncoding {
+			return errors.Newf("mismatched content encoding: expected %s, got %s", expectedContentEncoding, contentEncoding)
+		}
+
+		var isZstdCompressed = func(dat []byte) bool {
+			zstdPrefix := []byte("\x28\xB5\x2F\xFD")
+			return bytes.HasPrefix(dat, zstdPrefix)
+		}
+
+		if !isZstdCompressed([]byte(body)) {
+			return errors.New("expected zstd compressed body")
+		}
+		if header.Get("X-CRDB-TEST") != "secret-value" {
+			return errors.New("expected to find special header in request")
+		}
+		return nil
+	}
+
+	testBase(t, defaults, testFn, false /* hangServer */, time.Duration(0), time.Duration(0))
+}
+
+// TestHTTPSinkClientCertificate verifies that the sink presents the
+// configured client certificate to a server requiring mutual TLS, and
+// verifies the server against the configured CA certificate.
+func TestHTTPSinkClientCertificate(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	tempDir := t.TempDir()
+	clientCertPath := filepath.Join(tempDir, "client.crt")
+	clientKeyPath := filepath.Join(tempDir, "client.key")
+	caCertPath := filepath.Join(tempDir, "ca.crt")
+
+	// Generate a self-signed client certificate, which the server trusts
+	// directly.
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	clientTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "log-client"},
+		NotBefore:    timeutil.Now().Add(-time.Hour),
+		NotAfter:     timeutil.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTmpl, clientTmpl, &clientKey.PublicKey, clientKey)
+	require.NoError(t, err)
+	clientCert, err := x509.ParseCertificate(clientDER)
+	require.NoError(t, err)
+	clientKeyDER, err := x509.MarshalECPrivateKey(clientKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(clientCertPath,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientDER}), 0600))
+	require.NoError(t, os.WriteFile(clientKeyPath,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: clientKeyDER}), 0600))
+
+	newServer := func(handler http.Handler) *httptest.Server {
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(clientCert)
+		s := httptest.NewUnstartedServer(handler)
+		s.TLS = &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  clientCAs,
+		}
+		s.StartTLS()
+		// The sink verifies the server's (self-signed) certificate using
+		// the CA certificate file.
+		require.NoError(t, os.WriteFile(caCertPath,
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}), 0600))
+		return s
+	}
+
+	timeout := 5 * time.Second
+	tb := true
+	defaults := logconfig.HTTPDefaults{
+		Timeout:     &timeout,
+		Compression: &logconfig.NoneCompression,
+		ClientCert:  &clientCertPath,
+		ClientKey:   &clientKeyPath,
+		CACert:      &caCertPath,
+
+		// We need to disable keepalives otherwise the HTTP server in the
+		// test will let an async goroutine run waiting for more requests.
+		DisableKeepAlives: &tb,
+		CommonSinkConfig: logconfig.CommonSinkConfig{
+			Buffering: disabledBufferingCfg,
+		},
+	}
+
+	testFn := func(_ http.Header, body string) error {
+		t.Log(body)
+		if !strings.Contains(body, `"message":"hello world"`) {
+			return errors.New("Log message not found in request")
+		}
+		return nil
+	}
+
+	testBaseWithServer(t, defaults, testFn, false /* hangServer */, time.Duration(0), time.Duration(0), newServer)
+}
+
+// TestHTTPSinkMaxInFlight verifies that the number of concurrent requests
+// issued by an HTTP sink shared by several channels never exceeds the
+// configured limit.
+func TestHTTPSinkMaxInFlight(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	const maxInFlight = 2
+	var inFlight, maxSeen, received atomic.Int32
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			prev := maxSeen.Load()
+			if n <= prev || maxSeen.CompareAndSwap(prev, n) {
+				break
+			}
+		}
+		// Slow down the handler so that requests pile up at the sink.
+		time.Sleep(50 * time.Millisecond)
+		received.Add(1)
+	}
+	s := httptest.NewServer(http.HandlerFunc(handler))
+	defer s.Close()
+
+	timeout := 5 * time.Second
+	tb := true
+	limit := maxInFlight
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:     &s.URL,
+				Timeout:     &timeout,
+				Compression: &logconfig.NoneCompression,
+				MaxInFlight: &limit,
+				// We need to disable keepalives otherwise the HTTP server in the
+				// test will let an async goroutine run waiting for more requests.
+				DisableKeepAlives: &tb,
+				CommonSinkConfig: logconfig.CommonSinkConfig{
+					Buffering: disabledBufferingCfg,
+				},
+			},
+			Channels: logconfig.SelectChannels(channel.OPS, channel.HEALTH, channel.STORAGE, channel.SESSIONS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+
+	TestingResetActive()
+	cleanup, err := ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+	require.NoError(t, err)
+	defer cleanup()
+
+	// Log concurrently on all the channels. Each channel has its own logger,
+	// so the sink sees concurrent requests.
+	const numPerChannel = 5
+	var wg sync.WaitGroup
+	for _, l := range []func(ctx context.Context, format string, args ...interface{}){
+		Ops.Infof, Health.Infof, Storage.Infof, Sessions.Infof,
+	} {
+		wg.Add(1)
+		go func(logf func(ctx context.Context, format string, args ...interface{})) {
+			defer wg.Done()
+			for i := 0; i < numPerChannel; i++ {
+				logf(context.Background(), "hello world %d", i)
+			}
+		}(l)
+	}
+	wg.Wait()
+
+	require.GreaterOrEqual(t, received.Load(), int32(4*numPerChannel))
+	require.LessOrEqual(t, maxSeen.Load(), int32(maxInFlight))
+}
+
+// TestHTTPSinkHeaderFunc verifies that the headers computed by the callback
+// registered via SetHTTPSinkHeaderFunc are attached to each request, and
+// are computed anew for each request.
+func TestHTTPSinkHeaderFunc(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	var counter atomic.Int64
+	SetHTTPSinkHeaderFunc("ops", func() map[string]string {
+		return map[string]string{"X-CRDB-REQUEST-ID": strconv.FormatInt(counter.Add(1), 10)}
+	})
+	defer SetHTTPSinkHeaderFunc("ops", nil)
+
+	timeout := 5 * time.Second
+	tb := true
+	defaults := logconfig.HTTPDefaults{
+		Timeout:     &timeout,
+		Compression: &logconfig.NoneCompression,
+		Headers:     map[string]string{"X-CRDB-TEST": "secret-value"},
+
+		// We need to disable keepalives otherwise the HTTP server in the
+		// test will let an async goroutine run waiting for more requests.
+		DisableKeepAlives: &tb,
+		CommonSinkConfig: logconfig.CommonSinkConfig{
+			Buffering: disabledBufferingCfg,
+		},
+	}
+
+	seenIDs := make(map[string]struct{})
+	testFn := func(header http.Header, body string) error {
+		t.Log(body)
+		if header.Get("X-CRDB-TEST") != "secret-value" {
+			return errors.New("expected to find static header in request")
+		}
+		id := header.Get("X-CRDB-REQUEST-ID")
+		if id == "" {
+			return errors.New("expected to find dynamic header in request")
+		}
+		if _, ok := seenIDs[id]; ok {
+			t.Errorf("request ID %s seen in more than one request", id)
+		}
+		seenIDs[id] = struct{}{}
+		return nil
+	}
+
+	testBase(t, defaults, testFn, false /* hangServer */, time.Duration(0), 100*time.Millisecond)
+	require.GreaterOrEqual(t, len(seenIDs), 2)
+}
+
+// TestHTTPSinkNDJSON verifies that the json-ndjson format flushes buffered
+// entries as newline-delimited JSON.
+func TestHTTPSinkNDJSON(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	var mu syncutil.Mutex
+	var bodies []string
+	var contentTypes []string
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+		contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+	}
+	s := httptest.NewServer(http.HandlerFunc(handler))
+	defer s.Close()
+
+	timeout := 5 * time.Second
+	tb := true
+	format := "json-ndjson"
+	// Buffer the entries until explicitly flushed below.
+	maxStaleness := time.Hour
+	triggerSize := logconfig.ByteSize(1 << 20)
+	maxBufferSize := logconfig.ByteSize(2 << 20)
+	bufferFmt := logconfig.BufferFmtNewline
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:     &s.URL,
+				Timeout:     &timeout,
+				Compression: &logconfig.NoneCompression,
+				// We need to disable keepalives otherwise the HTTP server in the
+				// test will let an async goroutine run waiting for more requests.
+				DisableKeepAlives: &tb,
+				CommonSinkConfig: logconfig.CommonSinkConfig{
+					Format: &format,
+					Buffering: logconfig.CommonBufferSinkConfigWrapper{
+						CommonBufferSinkConfig: logconfig.CommonBufferSinkConfig{
+							MaxStaleness:     &maxStaleness,
+							FlushTriggerSize: &triggerSize,
+							MaxBufferSize:    &maxBufferSize,
+							Format:           &bufferFmt,
+						},
+					},
+				},
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+
+	TestingResetActive()
+	cleanup, err := ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+	require.NoError(t, err)
+	defer cleanup()
+
+	for i := 0; i < 3; i++ {
+		Ops.Infof(context.Background(), "ndjson message %d", i)
+	}
+	FlushAllSync()
+
+	mu.Lock()
+	defer mu.Unlock()
+	var matching int
+	for i, body := range bodies {
+		require.Equal(t, "application/x-ndjson", contentTypes[i])
+		require.True(t, strings.HasSuffix(body, "\n"), "expected newline-terminated body: %q", body)
+		for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+			var entry map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(line), &entry), "invalid JSON line: %q", line)
+			if msg, ok := entry["message"].(string); ok && strings.HasPrefix(msg, "ndjson message") {
+				matching++
+			}
+		}
+	}
+	require.Equal(t, 3, matching)
+}
+
+// TestHTTPSinkCrdbV2Buffered verifies that the crdb-v2 format flushes
+// buffered entries as a stream that the crdb-v2 parser understands.
+func TestHTTPSinkCrdbV2Buffered(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	var mu syncutil.Mutex
+	var bodies []string
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+	}
+	s := httptest.NewServer(http.HandlerFunc(handler))
+	defer s.Close()
+
+	timeout := 5 * time.Second
+	tb := true
+	format := "crdb-v2"
+	// Buffer the entries until explicitly flushed below.
+	maxStaleness := time.Hour
+	triggerSize := logconfig.ByteSize(1 << 20)
+	maxBufferSize := logconfig.ByteSize(2 << 20)
+	bufferFmt := logconfig.BufferFmtNewline
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:     &s.URL,
+				Timeout:     &timeout,
+				Compression: &logconfig.NoneCompression,
+				// We need to disable keepalives otherwise the HTTP server in the
+				// test will let an async goroutine run waiting for more requests.
+				DisableKeepAlives: &tb,
+				CommonSinkConfig: logconfig.CommonSinkConfig{
+					Format: &format,
+					Buffering: logconfig.CommonBufferSinkConfigWrapper{
+						CommonBufferSinkConfig: logconfig.CommonBufferSinkConfig{
+							MaxStaleness:     &maxStaleness,
+							FlushTriggerSize: &triggerSize,
+							MaxBufferSize:    &maxBufferSize,
+							Format:           &bufferFmt,
+						},
+					},
+				},
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+
+	TestingResetActive()
+	cleanup, err := ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+	require.NoError(t, err)
+	defer cleanup()
+
+	for i := 0; i < 3; i++ {
+		Ops.Infof(context.Background(), "crdb-v2 message %d", i)
+	}
+	FlushAllSync()
+
+	mu.Lock()
+	defer mu.Unlock()
+	var matching int
+	for _, body := range bodies {
+		require.NotContains(t, body, "\n\n", "unexpected empty line in body: %q", body)
+		messages, err := decodeCrdbV2Messages(body)
+		require.NoError(t, err)
+		for _, msg := range messages {
+			if strings.Contains(msg, "crdb-v2 message") {
+				matching++
+			}
+		}
+	}
+	require.Equal(t, 3, matching)
+}
+
+// TestHTTPSinkCircuitBreaker verifies that the circuit breaker opens after
+// the configured number of consecutive failures, short-circuits requests
+// while open, and closes again after a successful probe.
+func TestHTTPSinkCircuitBreaker(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	var requests atomic.Int32
+	var fail atomic.Bool
+	fail.Store(true)
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if fail.Load() {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}
+	s := httptest.NewServer(http.HandlerFunc(handler))
+	defer s.Close()
+
+	timeout := 5 * time.Second
+	tb := true
+	threshold := 3
+	cooldown := time.Hour
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:                 &s.URL,
+				Timeout:                 &timeout,
+				DisableKeepAlives:       &tb,
+				CircuitBreakerThreshold: &threshold,
+				CircuitBreakerCooldown:  &cooldown,
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+	hs, err := newHTTPSink("ops", *cfg.Sinks.HTTPServers["ops"])
+	require.NoError(t, err)
+
+	// The first failures are attempted, and open the breaker.
+	for i := 0; i < threshold; i++ {
+		require.Error(t, hs.output([]byte("hello world"), sinkOutputOptions{}))
+	}
+	require.Equal(t, int32(threshold), requests.Load())
+
+	// While the breaker is open, requests are dropped without reaching the
+	// server.
+	for i := 0; i < 5; i++ {
+		require.NoError(t, hs.output([]byte("hello world"), sinkOutputOptions{}))
+	}
+	require.Equal(t, int32(threshold), requests.Load())
+
+	// Expire the cooldown. The probe request fails, which opens the breaker
+	// again.
+	expireCooldown := func() {
+		hs.breaker.mu.Lock()
+		defer hs.breaker.mu.Unlock()
+		hs.breaker.mu.openedAt = hs.breaker.mu.openedAt.Add(-cooldown)
+	}
+	expireCooldown()
+	require.Error(t, hs.output([]byte("hello world"), sinkOutputOptions{}))
+	require.Equal(t, int32(threshold+1), requests.Load())
+	require.NoError(t, hs.output([]byte("hello world"), sinkOutputOptions{}))
+	require.Equal(t, int32(threshold+1), requests.Load())
+
+	// Once the server recovers, the probe request succeeds and closes the
+	// breaker.
+	fail.Store(false)
+	expireCooldown()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, hs.output([]byte("hello world"), sinkOutputOptions{}))
+	}
+	require.Equal(t, int32(threshold+4), requests.Load())
+}
+
+// TestHTTPSinkCompressionLevel verifies that a body compressed with a
+// configured gzip compression level decompresses correctly.
+func TestHTTPSinkCompressionLevel(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	timeout := 5 * time.Second
+	tb := true
+	level := gzip.BestSpeed
+	defaults := logconfig.HTTPDefaults{
+		Timeout:          &timeout,
+		Compression:      &logconfig.GzipCompression,
+		CompressionLevel: &level,
+
+		// We need to disable keepalives otherwise the HTTP server in the
+		// test will let an async goroutine run waiting for more requests.
+		DisableKeepAlives: &tb,
+		CommonSinkConfig: logconfig.CommonSinkConfig{
+			Buffering: disabledBufferingCfg,
+		},
+	}
+
+	testFn := func(header http.Header, body string) error {
+		if contentEncoding := header.Get("Content-Encoding"); contentEncoding != logconfig.GzipCompression {
+			return errors.Newf("mismatched content encoding: expected %s, got %s", logconfig.GzipCompression, contentEncoding)
+		}
+		r, err := gzip.NewReader(strings.NewReader(body))
+		if err != nil {
+			return err
+		}
+		decompressed, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		t.Log(string(decompressed))
+		if !strings.Contains(string(decompressed), `"message":"hello world"`) {
+			return errors.New("Log message not found in request")
+		}
+		return nil
+	}
+
+	testBase(t, defaults, testFn, false /* hangServer */, time.Duration(0), time.Duration(0))
+}
+
+// TestHTTPSinkFailover verifies that requests fail over to the next
+// configured address when the current one returns errors, and stick to
+// the address that succeeded.
+func TestHTTPSinkFailover(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	var primaryRequests, secondaryRequests atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		primaryRequests.Add(1)
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		secondaryRequests.Add(1)
+	}))
+	defer secondary.Close()
+
+	timeout := 5 * time.Second
+	tb := true
+	address := primary.URL + "," + secondary.URL
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:           &address,
+				Timeout:           &timeout,
+				DisableKeepAlives: &tb,
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+	hs, err := newHTTPSink("ops", *cfg.Sinks.HTTPServers["ops"])
+	require.NoError(t, err)
+
+	// The first request fails over to the secondary.
+	require.NoError(t, hs.output([]byte("hello world"), sinkOutputOptions{}))
+	require.Equal(t, int32(1), primaryRequests.Load())
+	require.Equal(t, int32(1), secondaryRequests.Load())
+
+	// Subsequent requests stick to the secondary.
+	for i := 0; i < 3; i++ {
+		require.NoError(t, hs.output([]byte("hello world"), sinkOutputOptions{}))
+	}
+	require.Equal(t, int32(1), primaryRequests.Load())
+	require.Equal(t, int32(4), secondaryRequests.Load())
+}
+
+// TestHTTPSinkMethodPut verifies that the entries are sent in the body of
+// PUT requests when the method is configured.
+func TestHTTPSinkMethodPut(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	type request struct {
+		method string
+		body   string
+	}
+	requests := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		requests <- request{method: r.Method, body: string(body)}
+	}))
+	defer server.Close()
+
+	timeout := 5 * time.Second
+	tb := true
+	method := logconfig.HTTPSinkMethod(http.MethodPut)
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:           &server.URL,
+				Method:            &method,
+				Timeout:           &timeout,
+				DisableKeepAlives: &tb,
+				Compression:       &logconfig.NoneCompression,
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+	hs, err := newHTTPSink("ops", *cfg.Sinks.HTTPServers["ops"])
+	require.NoError(t, err)
+
+	require.NoError(t, hs.output([]byte("hello world"), sinkOutputOptions{}))
+	req := <-requests
+	require.Equal(t, http.MethodPut, req.method)
+	require.Equal(t, "hello world", req.body)
+}
+
+// TestHTTPSinkIdempotencyKey verifies that all the attempts to deliver a
+// batch of entries carry the same idempotency key, and that different
+// batches carry different keys.
+func TestHTTPSinkIdempotencyKey(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	const header = "X-Idempotency-Key"
+	var mu syncutil.Mutex
+	var primaryKeys, secondaryKeys []string
+	primary := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		primaryKeys = append(primaryKeys, r.Header.Get(header))
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		secondaryKeys = append(secondaryKeys, r.Header.Get(header))
+	}))
+	defer secondary.Close()
+
+	timeout := 5 * time.Second
+	tb := true
+	headerName := header
+	address := primary.URL + "," + secondary.URL
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:              &address,
+				Timeout:              &timeout,
+				DisableKeepAlives:    &tb,
+				IdempotencyKeyHeader: &headerName,
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+	hs, err := newHTTPSink("ops", *cfg.Sinks.HTTPServers["ops"])
+	require.NoError(t, err)
+
+	// The first batch is retried on the secondary, with the same key.
+	require.NoError(t, hs.output([]byte("hello world"), sinkOutputOptions{}))
+	// The next batch is sent to the secondary only, with a new key.
+	require.NoError(t, hs.output([]byte("hello again"), sinkOutputOptions{}))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, primaryKeys, 1)
+	require.Len(t, secondaryKeys, 2)
+	require.NotEmpty(t, primaryKeys[0])
+	require.Equal(t, primaryKeys[0], secondaryKeys[0])
+	require.NotEmpty(t, secondaryKeys[1])
+	require.NotEqual(t, secondaryKeys[0], secondaryKeys[1])
+}
+
+// TestHTTPSinkProxy verifies that requests are sent through the
+// configured proxy.
+func TestHTTPSinkProxy(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	// The fake proxy records the requests it receives, instead of
+	// forwarding them.
+	proxied := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		select {
+		case proxied <- r.URL.String():
+		default:
+		}
+	}))
+	defer proxy.Close()
+
+	timeout := 5 * time.Second
+	tb := true
+	address := "http://logs.example.com/ingest"
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:           &address,
+				Proxy:             &proxy.URL,
+				Timeout:           &timeout,
+				DisableKeepAlives: &tb,
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+	hs, err := newHTTPSink("ops", *cfg.Sinks.HTTPServers["ops"])
+	require.NoError(t, err)
+
+	require.NoError(t, hs.output([]byte("hello world"), sinkOutputOptions{}))
+	select {
+	case u := <-proxied:
+		// Requests sent through a proxy carry the absolute URL of the target.
+		require.Equal(t, address, u)
+	default:
+		t.Fatal("expected the request to go through the proxy")
+	}
+}
+
+// TestHTTPSinkStripRedactionMarkers verifies that sensitive information
+// is never sent by a sink configured with strip-redaction-markers.
+func TestHTTPSinkStripRedactionMarkers(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	var mu syncutil.Mutex
+	var bodies []string
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+	}
+	s := httptest.NewServer(http.HandlerFunc(handler))
+	defer s.Close()
+
+	timeout := 5 * time.Second
+	tb := true
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:           &s.URL,
+				Timeout:           &timeout,
+				Compression:       &logconfig.NoneCompression,
+				DisableKeepAlives: &tb,
+				CommonSinkConfig: logconfig.CommonSinkConfig{
+					StripRedactionMarkers: &tb,
+					Buffering:             disabledBufferingCfg,
+				},
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+
+	TestingResetActive()
+	cleanup, err := ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+	require.NoError(t, err)
+	defer cleanup()
+
+	const secret = "hunter2"
+	Ops.Infof(context.Background(), "the secret is %s", secret)
+
+	mu.Lock()
+	defer mu.Unlock()
+	var found bool
+	for _, body := range bodies {
+		require.NotContains(t, body, secret)
+		require.NotContains(t, body, string(redact.StartMarker()))
+		require.NotContains(t, body, string(redact.EndMarker()))
+		if strings.Contains(body, "the secret is") {
+			found = true
+		}
+	}
+	require.True(t, found, "expected the log entry to be sent")
+}
+
+// recordingLogMetrics is a LogMetrics implementation recording the HTTP
+// sink requests and the messages dropped by buffered sinks.
+type recordingLogMetrics struct {
+	TestLogMetricsImpl
+	mu struct {
+		syncutil.Mutex
+		requests map[string]map[HTTPSinkStatusClass]int
+		dropped  map[string]int64
+	}
+}
+
+func (m *recordingLogMetrics) RecordBufferedSinkMessagesDropped(sinkName string, count int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mu.dropped == nil {
+		m.mu.dropped = make(map[string]int64)
+	}
+	m.mu.dropped[sinkName] += count
+}
+
+// droppedMessages returns the number of messages dropped by the given
+// buffered sink.
+func (m *recordingLogMetrics) droppedMessages(sinkName string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mu.dropped[sinkName]
+}
+
+func (m *recordingLogMetrics) RecordHTTPSinkRequest(
+	sinkName string, status HTTPSinkStatusClass, latency time.Duration,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mu.requests == nil {
+		m.mu.requests = make(map[string]map[HTTPSinkStatusClass]int)
+	}
+	if m.mu.requests[sinkName] == nil {
+		m.mu.requests[sinkName] = make(map[HTTPSinkStatusClass]int)
+	}
+	m.mu.requests[sinkName][status]++
+}
+
+// TestHTTPSinkRequestMetrics verifies that the requests of an HTTP sink
+// are recorded by status class.
+func TestHTTPSinkRequestMetrics(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	m := &recordingLogMetrics{}
+	defer func(prev LogMetrics) { SetLogMetrics(prev) }(logging.metrics)
+	SetLogMetrics(m)
+
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer s.Close()
+
+	timeout := 5 * time.Second
+	tb := true
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"collector": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:           &s.URL,
+				Timeout:           &timeout,
+				DisableKeepAlives: &tb,
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+	hs, err := newHTTPSink("collector", *cfg.Sinks.HTTPServers["collector"])
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		require.Error(t, hs.output([]byte("hello world"), sinkOutputOptions{}))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	require.Equal(t, map[HTTPSinkStatusClass]int{HTTPSinkStatus5xx: 2}, m.mu.requests["collector"])
+}
+
+// TestHTTPSinkFlushOnShutdown verifies that the entries buffered by an
+// HTTP sink are delivered by a synchronous flush and by the shutdown of
+// the logging configuration.
+func TestHTTPSinkFlushOnShutdown(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	var mu syncutil.Mutex
+	var bodies []string
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+	}))
+	defer s.Close()
+	received := func(msg string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, body := range bodies {
+			if strings.Contains(body, msg) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Buffer the entries until they are flushed explicitly.
+	maxStaleness := time.Hour
+	triggerSize := logconfig.ByteSize(1 << 20)
+	maxBufferSize := logconfig.ByteSize(1 << 30)
+	timeout := 5 * time.Second
+	tb := true
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:           &s.URL,
+				Timeout:           &timeout,
+				Compression:       &logconfig.NoneCompression,
+				DisableKeepAlives: &tb,
+				CommonSinkConfig: logconfig.CommonSinkConfig{
+					Buffering: logconfig.CommonBufferSinkConfigWrapper{
+						CommonBufferSinkConfig: logconfig.CommonBufferSinkConfig{
+							MaxStaleness:     &maxStaleness,
+							FlushTriggerSize: &triggerSize,
+							MaxBufferSize:    &maxBufferSize,
+						},
+					},
+				},
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+
+	TestingResetActive()
+	cleanup, err := ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	Ops.Infof(ctx, "first message")
+	require.False(t, received("first message"))
+	require.NoError(t, flushAllSync(ctx))
+	require.True(t, received("first message"))
+
+	Ops.Infof(ctx, "node shutting down")
+	require.False(t, received("node shutting down"))
+	cleanup()
+	require.True(t, received("node shutting down"))
+}
+
+// TestHTTPSinkNonBlockingBuffer verifies that, when the buffer of the sink
+// is non-blocking, the logging calls do not block while the server hangs and
+// the buffer is full, and the entries that do not fit are dropped.
+func TestHTTPSinkNonBlockingBuffer(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	// The server hangs until the end of the test.
+	hung := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer s.Close()
+
+	maxStaleness := time.Hour
+	triggerSize := logconfig.ByteSize(1 << 10)
+	maxBufferSize := logconfig.ByteSize(1<<20 + 1<<14)
+	timeout := time.Minute
+	tb := true
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:           &s.URL,
+				Timeout:           &timeout,
+				Compression:       &logconfig.NoneCompression,
+				DisableKeepAlives: &tb,
+				CommonSinkConfig: logconfig.CommonSinkConfig{
+					Buffering: logconfig.CommonBufferSinkConfigWrapper{
+						CommonBufferSinkConfig: logconfig.CommonBufferSinkConfig{
+							MaxStaleness:     &maxStaleness,
+							FlushTriggerSize: &triggerSize,
+							MaxBufferSize:    &maxBufferSize,
+							NonBlocking:      &tb,
+						},
+					},
+				},
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+
+	TestingResetActive()
+	cleanup, err := ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+	require.NoError(t, err)
+	defer cleanup()
+	// Closing the sink waits for the flush in progress, so the server must
+	// stop hanging first.
+	defer close(hung)
+
+	// The first entries are flushed to the hung server. The next ones
+	// saturate the buffer.
+	ctx := context.Background()
+	msg := strings.Repeat("x", 1000)
+	var maxDuration time.Duration
+	for i := 0; i < 2000; i++ {
+		start := timeutil.Now()
+		Ops.Infof(ctx, "%d %s", i, msg)
+		if d := timeutil.Since(start); d > maxDuration {
+			maxDuration = d
+		}
+	}
+	require.Less(t, maxDuration, time.Second)
+
+	var dropped uint64
+	require.NoError(t, logging.allSinkInfos.iterBufferedSinks(func(bs *bufferedSink) error {
+		dropped += bs.droppedMessages()
+		return nil
+	}))
+	require.NotZero(t, dropped)
+}
+
+// TestHTTPSinkChannelAddresses verifies that the entries of a sink with
+// per-channel addresses are sent to the address configured for their
+// channel, and that other channels use the default address.
+func TestHTTPSinkChannelAddresses(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	type server struct {
+		*httptest.Server
+		mu     syncutil.Mutex
+		bodies []string
+	}
+	newServer := func() *server {
+		s := &server{}
+		s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.bodies = append(s.bodies, string(body))
+		}))
+		return s
+	}
+	received := func(s *server, msg string) bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, body := range s.bodies {
+			if strings.Contains(body, msg) {
+				return true
+			}
+		}
+		return false
+	}
+	defaultServer := newServer()
+	defer defaultServer.Close()
+	devServer := newServer()
+	defer devServer.Close()
+
+	timeout := 5 * time.Second
+	tb := true
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"routed": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:           &defaultServer.URL,
+				Timeout:           &timeout,
+				Compression:       &logconfig.NoneCompression,
+				DisableKeepAlives: &tb,
+			},
+			Channels:         logconfig.SelectChannels(channel.DEV, channel.OPS),
+			ChannelAddresses: map[string]string{"dev": devServer.URL},
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+	require.Equal(t, map[string]string{"DEV": devServer.URL},
+		cfg.Sinks.HTTPServers["routed"].ChannelAddresses)
+
+	TestingResetActive()
+	cleanup, err := ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+	require.NoError(t, err)
+	defer cleanup()
+
+	ctx := context.Background()
+	Dev.Infof(ctx, "dev message")
+	Ops.Infof(ctx, "ops message")
+	require.NoError(t, flushAllSync(ctx))
+
+	require.True(t, received(devServer, "dev message"))
+	require.False(t, received(devServer, "ops message"))
+	require.True(t, received(defaultServer, "ops message"))
+	require.False(t, received(defaultServer, "dev message"))
+}
+
+// TestHTTPSinkAddressTemplate verifies that the placeholders in the
+// address of an HTTP sink are replaced by the server identifiers and the
+// channel of the entries.
+func TestHTTPSinkAddressTemplate(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	var mu syncutil.Mutex
+	paths := make(map[string]string)
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		paths[string(body)] = r.URL.Path
+	}))
+	defer s.Close()
+	pathOf := func(msg string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		for body, path := range paths {
+			if strings.Contains(body, msg) {
+				return path
+			}
+		}
+		return ""
+	}
+
+	address := s.URL + "/logs/{cluster_id}/{node_id}/{channel}"
+	timeout := 5 * time.Second
+	tb := true
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"templated": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:           &address,
+				Timeout:           &timeout,
+				Compression:       &logconfig.NoneCompression,
+				DisableKeepAlives: &tb,
+			},
+			Channels: logconfig.SelectChannels(channel.DEV),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+
+	TestingResetActive()
+	cleanup, err := ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+	require.NoError(t, err)
+	defer cleanup()
+
+	// The identifiers are unknown until an entry carries them.
+	ctx := context.Background()
+	Dev.Infof(ctx, "anonymous message")
+	require.NoError(t, flushAllSync(ctx))
+	require.Equal(t, "/logs/unknown/unknown/DEV", pathOf("anonymous message"))
+
+	ctx = context.WithValue(ctx, serverident.ServerIdentificationContextKey{},
+		testIDPayload{clusterID: "abc", nodeID: "7"})
+	Dev.Infof(ctx, "identified message")
+	require.NoError(t, flushAllSync(ctx))
+	require.Equal(t, "/logs/abc/7/DEV", pathOf("identified message"))
+}
+
+// TestHTTPSinkSampleRate verifies that only a fraction of the entries
+// are sent to a sink configured with a sample rate, and that entries at
+// or above the exempt severity are all sent.
+func TestHTTPSinkSampleRate(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	defer func(prev int64) { sinkSamplerSeedForTesting = prev }(sinkSamplerSeedForTesting)
+	sinkSamplerSeedForTesting = 42
+
+	var mu syncutil.Mutex
+	var bodies []string
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+	}))
+	defer s.Close()
+	count := func(msg string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		n := 0
+		for _, body := range bodies {
+			n += strings.Count(body, msg)
+		}
+		return n
+	}
+
+	timeout := 5 * time.Second
+	tb := true
+	sampleRate := 0.1
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:           &s.URL,
+				Timeout:           &timeout,
+				Compression:       &logconfig.NoneCompression,
+				DisableKeepAlives: &tb,
+				CommonSinkConfig: logconfig.CommonSinkConfig{
+					SampleRate: &sampleRate,
+				},
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+
+	TestingResetActive()
+	cleanup, err := ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+	require.NoError(t, err)
+	defer cleanup()
+
+	ctx := context.Background()
+	for i := 0; i < 1000; i++ {
+		Ops.Infof(ctx, "sampled info message")
+		if i%50 == 0 {
+			Ops.Errorf(ctx, "exempt error message")
+		}
+	}
+	require.NoError(t, flushAllSync(ctx))
+
+	infoCount := count("sampled info message")
+	require.Greater(t, infoCount, 50)
+	require.Less(t, infoCount, 150)
+	require.Equal(t, 20, count("exempt error message"))
+}
+
+// TestHTTPSinkMaxMessageSize verifies that entries larger than the
+// maximum message size are truncated, and remain valid JSON.
+func TestHTTPSinkMaxMessageSize(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	var mu syncutil.Mutex
+	var bodies []string
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+	}))
+	defer s.Close()
+
+	timeout := 5 * time.Second
+	tb := true
+	maxMessageSize := logconfig.ByteSize(1024)
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:           &s.URL,
+				Timeout:           &timeout,
+				Compression:       &logconfig.NoneCompression,
+				DisableKeepAlives: &tb,
+				MaxMessageSize:    &maxMessageSize,
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+
+	TestingResetActive()
+	cleanup, err := ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+	require.NoError(t, err)
+	defer cleanup()
+
+	ctx := context.Background()
+	Ops.Infof(ctx, "large message: %s", strings.Repeat("x", 10000))
+	Ops.Infof(ctx, "small message")
+	require.NoError(t, flushAllSync(ctx))
+
+	mu.Lock()
+	defer mu.Unlock()
+	var truncated, small bool
+	for _, body := range bodies {
+		for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+			var entry map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(line), &entry), "invalid JSON: %s", line)
+			msg, _ := entry["message"].(string)
+			if strings.HasPrefix(msg, "large message") {
+				truncated = true
+				require.LessOrEqual(t, len(line)+1, int(maxMessageSize))
+				require.Regexp(t, `\.\.\.\[truncated \d+ bytes\]$`, msg)
+			} else if msg == "small message" {
+				small = true
+			}
+		}
+	}
+	require.True(t, truncated)
+	require.True(t, small)
+}
+
+// TestHTTPSinkForceHTTP2 verifies that requests are sent using HTTP/2
+// over a single connection when force-http2 is set, both over TLS and
+// over cleartext TCP.
+func TestHTTPSinkForceHTTP2(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	for _, useTLS := range []bool{false, true} {
+		t.Run("tls="+strconv.FormatBool(useTLS), func(t *testing.T) {
+			var protos []string
+			var newConns atomic.Int32
+			var mu syncutil.Mutex
+			var handler http.Handler = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				protos = append(protos, r.Proto)
+			})
+			if !useTLS {
+				handler = h2c.NewHandler(handler, &http2.Server{})
+			}
+			s := httptest.NewUnstartedServer(handler)
+			s.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					newConns.Add(1)
+				}
+			}
+			if useTLS {
+				s.EnableHTTP2 = true
+				s.StartTLS()
+			} else {
+				s.Start()
+			}
+			defer s.Close()
+
+			timeout := 5 * time.Second
+			tb, fb := true, false
+			cfg := logconfig.DefaultConfig()
+			cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+				"ops": {
+					HTTPDefaults: logconfig.HTTPDefaults{
+						Address:           &s.URL,
+						Timeout:           &timeout,
+						DisableKeepAlives: &fb,
+						UnsafeTLS:         &tb,
+						ForceHTTP2:        &tb,
+					},
+					Channels: logconfig.SelectChannels(channel.OPS),
+				},
+			}
+			require.NoError(t, cfg.Validate(&sc.logDir))
+			hs, err := newHTTPSink("ops", *cfg.Sinks.HTTPServers["ops"])
+			require.NoError(t, err)
+			defer hs.client.CloseIdleConnections()
+
+			for i := 0; i < 3; i++ {
+				require.NoError(t, hs.output([]byte("hello world"), sinkOutputOptions{}))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			require.Equal(t, []string{"HTTP/2.0", "HTTP/2.0", "HTTP/2.0"}, protos)
+			require.Equal(t, int32(1), newConns.Load())
+		})
+	}
+}
+
+// TestHTTPSinkDeadLetter verifies that the entries that the sink fails
+// to deliver are written to the dead-letter directory, and that the
+// oldest dead-letter files are removed beyond the maximum size.
+func TestHTTPSinkDeadLetter(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	// readDeadLetters returns the names of the dead-letter files, and the
+	// batches they store.
+	readDeadLetters := func(t *testing.T, dir string) (names []string, batches []string) {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		for _, e := range entries {
+			names = append(names, e.Name())
+			b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+			require.NoError(t, err)
+			for len(b) > 0 {
+				batch, _, rest, ok := decodeDeadLetterRecord(b)
+				require.True(t, ok)
+				batches = append(batches, string(batch))
+				b = rest
+			}
+		}
+		return names, batches
+	}
+
+	newSink := func(t *testing.T, dir string, maxSize logconfig.ByteSize) *httpSink {
+		timeout := 5 * time.Second
+		tb := true
+		cfg := logconfig.DefaultConfig()
+		cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+			"ops": {
+				HTTPDefaults: logconfig.HTTPDefaults{
+					Address:           &s.URL,
+					Timeout:           &timeout,
+					DisableKeepAlives: &tb,
+					DeadLetterDir:     &dir,
+					DeadLetterMaxSize: &maxSize,
+				},
+				Channels: logconfig.SelectChannels(channel.OPS),
+			},
+		}
+		require.NoError(t, cfg.Validate(&sc.logDir))
+		hs, err := newHTTPSink("ops", *cfg.Sinks.HTTPServers["ops"])
+		require.NoError(t, err)
+		return hs
+	}
+
+	t.Run("write", func(t *testing.T) {
+		dir := filepath.Join(sc.logDir, "dead-letter")
+		hs := newSink(t, dir, 1<<20)
+		defer hs.close()
+
+		for i := 0; i < 3; i++ {
+			err := hs.output([]byte(fmt.Sprintf("undelivered entry %d\n", i)), sinkOutputOptions{})
+			require.True(t, errors.HasType(err, HTTPLogError{}))
+		}
+		names, batches := readDeadLetters(t, dir)
+		require.Len(t, names, 1)
+		require.True(t, strings.HasPrefix(names[0], "cockroach-http-dead-letter-ops-"), names[0])
+		require.Equal(t,
+			[]string{"undelivered entry 0\n", "undelivered entry 1\n", "undelivered entry 2\n"},
+			batches)
+	})
+
+	t.Run("evict", func(t *testing.T) {
+		dir := filepath.Join(sc.logDir, "dead-letter-evict")
+		// Each entry fills a dead-letter file, and only two files fit in
+		// the dead-letter directory.
+		entry := strings.Repeat("x", 40) + "\n"
+		recordSize := len(appendDeadLetterRecord(nil, []byte(entry), ""))
+		hs := newSink(t, dir, logconfig.ByteSize(2*recordSize))
+		defer hs.close()
+		hs.deadLetter.fileMaxSize = int64(recordSize)
+
+		for i := 0; i < 5; i++ {
+			require.Error(t, hs.output([]byte(entry), sinkOutputOptions{}))
+		}
+		names, batches := readDeadLetters(t, dir)
+		require.Len(t, names, 2)
+		require.Equal(t, []string{entry, entry}, batches)
+	})
+
+	t.Run("channel addresses", func(t *testing.T) {
+		// The sink is split into one httpSink per address, which must not
+		// share their dead-letter files.
+		devServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer devServer.Close()
+
+		dir := filepath.Join(sc.logDir, "dead-letter-channels")
+		timeout := 5 * time.Second
+		tb := true
+		cfg := logconfig.DefaultConfig()
+		cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+			"routed": {
+				HTTPDefaults: logconfig.HTTPDefaults{
+					Address:           &s.URL,
+					Timeout:           &timeout,
+					DisableKeepAlives: &tb,
+					DeadLetterDir:     &dir,
+				},
+				Channels:         logconfig.SelectChannels(channel.DEV, channel.OPS),
+				ChannelAddresses: map[string]string{"dev": devServer.URL},
+			},
+		}
+		require.NoError(t, cfg.Validate(&sc.logDir))
+		addrConfigs := splitHTTPSinkConfigByAddress(*cfg.Sinks.HTTPServers["routed"])
+		require.Len(t, addrConfigs, 2)
+
+		var sinks []*httpSink
+		for _, c := range addrConfigs {
+			hs, err := newHTTPSink("routed", c)
+			require.NoError(t, err)
+			defer hs.close()
+			sinks = append(sinks, hs)
+		}
+		for i, hs := range sinks {
+			err := hs.output([]byte(fmt.Sprintf("entry for %s\n", *addrConfigs[i].Address)), sinkOutputOptions{})
+			require.Error(t, err)
+		}
+
+		names, _ := readDeadLetters(t, dir)
+		require.Len(t, names, 2)
+		for i, hs := range sinks {
+			files, err := hs.deadLetter.listFiles()
+			require.NoError(t, err)
+			require.Len(t, files, 1)
+			b, err := os.ReadFile(filepath.Join(dir, files[0].Name))
+			require.NoError(t, err)
+			batch, _, _, ok := decodeDeadLetterRecord(b)
+			require.True(t, ok)
+			require.Equal(t, fmt.Sprintf("entry for %s\n", *addrConfigs[i].Address), string(batch))
+		}
+	})
+}
+
+// TestHTTPSinkDeadLetterReplay verifies that the dead-lettered batches
+// are replayed in order, whole and with their idempotency key, once the
+// server is reachable again, and that the batches that are not delivered
+// are kept.
+func TestHTTPSinkDeadLetterReplay(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	var mu syncutil.Mutex
+	var up bool
+	// reject, if set, is a body that is rejected even when the server is
+	// up.
+	var reject string
+	var received []string
+	// keys holds the idempotency keys of the requests, indexed by body.
+	keys := make(map[string][]string)
+	const keyHeader = "X-Idempotency-Key"
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		keys[string(body)] = append(keys[string(body)], r.Header.Get(keyHeader))
+		if !up || string(body) == reject {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received = append(received, string(body))
+	}))
+	defer s.Close()
+	setServer := func(isUp bool, rejectBody string) {
+		mu.Lock()
+		defer mu.Unlock()
+		up, reject = isUp, rejectBody
+	}
+	getReceived := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), received...)
+	}
+
+	dir := filepath.Join(sc.logDir, "dead-letter")
+	timeout := 5 * time.Second
+	tb := true
+	compression := logconfig.NoneCompression
+	headerName := keyHeader
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:              &s.URL,
+				Timeout:              &timeout,
+				Compression:          &compression,
+				DisableKeepAlives:    &tb,
+				DeadLetterDir:        &dir,
+				DeadLetterReplay:     &tb,
+				IdempotencyKeyHeader: &headerName,
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+	hs, err := newHTTPSink("ops", *cfg.Sinks.HTTPServers["ops"])
+	require.NoError(t, err)
+	defer hs.close()
+
+	numDeadLetterFiles := func() int {
+		files, err := hs.deadLetter.listFiles()
+		require.NoError(t, err)
+		return len(files)
+	}
+
+	// The server is down, so the batches are dead-lettered. Each batch
+	// holds several entries.
+	batch := func(i int) string {
+		return fmt.Sprintf("entry %d.0\nentry %d.1\n", i, i)
+	}
+	for i := 0; i < 3; i++ {
+		err := hs.output([]byte(batch(i)), sinkOutputOptions{})
+		require.True(t, errors.HasType(err, HTTPLogError{}))
+	}
+	require.Equal(t, 1, numDeadLetterFiles())
+
+	// The server comes up, but rejects batch 1. The replay delivers batch
+	// 0, and keeps the remaining batches.
+	setServer(true, batch(1))
+	require.NoError(t, hs.output([]byte("live entry 0\n"), sinkOutputOptions{}))
+	require.Eventually(t, func() bool {
+		return len(getReceived()) == 2 && hs.deadLetter.replay.pending.Load()
+	}, 10*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"live entry 0\n", batch(0)}, getReceived())
+	require.Equal(t, 1, numDeadLetterFiles())
+
+	// The server accepts all the batches, and the remaining batches are
+	// replayed in order. The dead-letter file is removed.
+	setServer(true, "")
+	require.NoError(t, hs.output([]byte("live entry 1\n"), sinkOutputOptions{}))
+	require.Eventually(t, func() bool {
+		return numDeadLetterFiles() == 0
+	}, 10*time.Second, 10*time.Millisecond)
+	require.Equal(t,
+		[]string{"live entry 0\n", batch(0), "live entry 1\n", batch(1), batch(2)},
+		getReceived())
+
+	// All the attempts to deliver a batch carry the same idempotency key,
+	// which differs between batches.
+	mu.Lock()
+	defer mu.Unlock()
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		batchKeys := keys[batch(i)]
+		require.NotEmpty(t, batchKeys[0])
+		for _, k := range batchKeys {
+			require.Equal(t, batchKeys[0], k)
+		}
+		require.False(t, seen[batchKeys[0]])
+		seen[batchKeys[0]] = true
+	}
+}
+
+// TestHTTPSinkCompressionAuto verifies that the sink selects the
+// compression among the encodings advertised by the server, and probes
+// the server again after the probe interval.
+func TestHTTPSinkCompressionAuto(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	var mu syncutil.Mutex
+	advertised := "gzip"
+	var probes int
+	var encodings []string
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodOptions {
+			probes++
+			rw.Header().Set("Accept-Encoding", advertised)
+			return
+		}
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+	}))
+	defer s.Close()
+
+	timeout := 5 * time.Second
+	tb := true
+	zstdCompression := logconfig.ZstdCompression
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:           &s.URL,
+				Timeout:           &timeout,
+				DisableKeepAlives: &tb,
+				Compression:       &zstdCompression,
+				CompressionAuto:   &tb,
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+	hs, err := newHTTPSink("ops", *cfg.Sinks.HTTPServers["ops"])
+	require.NoError(t, err)
+
+	// The server only accepts gzip: the sink falls back from zstd. The
+	// result of the probe is cached.
+	for i := 0; i < 2; i++ {
+		require.NoError(t, hs.output([]byte("hello world"), sinkOutputOptions{}))
+	}
+	mu.Lock()
+	require.Equal(t, 1, probes)
+	require.Equal(t, []string{"gzip", "gzip"}, encodings)
+	advertised = "gzip, zstd"
+	encodings = nil
+	mu.Unlock()
+
+	// Once the probe interval has elapsed, the server is probed again.
+	hs.compressionNegotiator.probeInterval = 0
+	require.NoError(t, hs.output([]byte("hello world"), sinkOutputOptions{}))
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, 2, probes)
+	require.Equal(t, []string{"zstd"}, encodings)
+}
+
+// TestHTTPSinkExcludeFields verifies that the tags excluded via the
+// format options of the sink are not sent to the server.
+func TestHTTPSinkExcludeFields(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	var mu syncutil.Mutex
+	var bodies []string
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+	}))
+	defer s.Close()
+
+	timeout := 5 * time.Second
+	tb := true
+	format := "json"
+	defaults := logconfig.HTTPDefaults{
+		Address:           &s.URL,
+		Timeout:           &timeout,
+		Compression:       &logconfig.NoneCompression,
+		DisableKeepAlives: &tb,
+		CommonSinkConfig: logconfig.CommonSinkConfig{
+			Format:        &format,
+			FormatOptions: map[string]string{"exclude-fields": "client_ip"},
+		},
+	}
+	zeroBytes := logconfig.ByteSize(0)
+	zeroDuration := time.Duration(0)
+	defaults.Buffering = logconfig.CommonBufferSinkConfigWrapper{
+		CommonBufferSinkConfig: logconfig.CommonBufferSinkConfig{
+			MaxStaleness:     &zeroDuration,
+			FlushTriggerSize: &zeroBytes,
+			MaxBufferSize:    &zeroBytes,
+		},
+	}
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: defaults,
+			Channels:     logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+
+	TestingResetActive()
+	cleanup, err := ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+	require.NoError(t, err)
+	defer cleanup()
+
+	ctx := logtags.AddTag(context.Background(), "client_ip", "1.2.3.4")
+	ctx = logtags.AddTag(ctx, "user", "root")
+	Ops.Infof(ctx, "filtered message")
+
+	// The sink is not buffered: the request was sent synchronously.
+	mu.Lock()
+	defer mu.Unlock()
+	var body string
+	for _, b := range bodies {
+		if strings.Contains(b, "filtered message") {
+			body = b
+		}
+	}
+	var entry struct {
+		Tags    map[string]string `json:"tags"`
+		Message string            `json:"message"`
+		File    string            `json:"file"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &entry), body)
+	require.NotContains(t, body, "client_ip")
+	require.NotContains(t, body, "1.2.3.4")
+	require.Len(t, entry.Tags, 1)
+	require.Contains(t, entry.Tags["user"], "root")
+	require.Equal(t, "filtered message", entry.Message)
+	require.NotEmpty(t, entry.File)
+}
+
+// TestHTTPSinkEnvelope verifies that the buffered entries are sent
+// wrapped in the configured envelope.
+func TestHTTPSinkEnvelope(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	var mu syncutil.Mutex
+	var bodies []string
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+	}))
+	defer s.Close()
+
+	timeout := 5 * time.Second
+	tb := true
+	// Buffer the entries until they are flushed explicitly.
+	maxStaleness := time.Hour
+	bufferSize := logconfig.ByteSize(1 << 20)
+	defaults := logconfig.HTTPDefaults{
+		Address:           &s.URL,
+		Timeout:           &timeout,
+		Compression:       &logconfig.NoneCompression,
+		DisableKeepAlives: &tb,
+		Envelope: map[string]string{
+			"source":     "crdb",
+			"cluster_id": "{cluster_id}",
+			"node_id":    "{node_id}",
+		},
+	}
+	defaults.Buffering = logconfig.CommonBufferSinkConfigWrapper{
+		CommonBufferSinkConfig: logconfig.CommonBufferSinkConfig{
+			MaxStaleness:     &maxStaleness,
+			FlushTriggerSize: &bufferSize,
+			MaxBufferSize:    &bufferSize,
+		},
+	}
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: defaults,
+			Channels:     logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+
+	TestingResetActive()
+	cleanup, err := ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+	require.NoError(t, err)
+	defer cleanup()
+
+	ctx := context.WithValue(context.Background(), serverident.ServerIdentificationContextKey{},
+		testIDPayload{clusterID: "abc", nodeID: "7"})
+	Ops.Infof(ctx, "first enveloped message")
+	Ops.Infof(ctx, "second enveloped message")
+	require.NoError(t, flushAllSync(ctx))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, bodies, 1)
+	var envelope struct {
+		Source    string `json:"source"`
+		ClusterID string `json:"cluster_id"`
+		NodeID    string `json:"node_id"`
+		Records   []struct {
+			Message string `json:"message"`
+		} `json:"records"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(bodies[0]), &envelope), bodies[0])
+	require.Equal(t, "crdb", envelope.Source)
+	require.Equal(t, "abc", envelope.ClusterID)
+	require.Equal(t, "7", envelope.NodeID)
+	require.Len(t, envelope.Records, 2)
+	require.Equal(t, "first enveloped message", envelope.Records[0].Message)
+	require.Equal(t, "second enveloped message", envelope.Records[1].Message)
+}
+
+// TestHTTPSinkIncludeLocality verifies that the locality of the node is
+// added to the entries of a sink configured with include-locality, as
+// structured fields in JSON formats, and as a prefix of the tags in text
+// formats.
+func TestHTTPSinkIncludeLocality(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	defer SetNodeLocality(getNodeLocality())
+	SetNodeLocality("region=us-east1,zone=us-east1-b")
+
+	for _, tc := range []struct {
+		format   string
+		expected string
+	}{
+		{format: "json", expected: `"tags":{"region":"us-east1","zone":"us-east1-b"}`},
+		{format: "crdb-v2", expected: `region=us-east1,zone=us-east1-b]`},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			timeout := 5 * time.Second
+			tb := true
+			format := tc.format
+			defaults := logconfig.HTTPDefaults{
+				Timeout: &timeout,
+
+				// We need to disable keepalives otherwise the HTTP server in the
+				// test will let an async goroutine run waiting for more requests.
+				DisableKeepAlives: &tb,
+				CommonSinkConfig: logconfig.CommonSinkConfig{
+					Format:          &format,
+					Buffering:       disabledBufferingCfg,
+					IncludeLocality: &tb,
+				},
+			}
+
+			testFn := func(_ http.Header, body string) error {
+				t.Log(body)
+				if !strings.Contains(body, tc.expected) {
+					return errors.Newf("expected %q in body", tc.expected)
+				}
+				return nil
+			}
+
+			testBase(t, defaults, testFn, false /* hangServer */, time.Duration(0), time.Duration(0))
+		})
+	}
+}
+
+// TestHTTPSinkHeartbeat verifies that the sink sends heartbeat records
+// when there are no log entries to send, and that a buffered sink with an
+// envelope sends them as an array of records.
+func TestHTTPSinkHeartbeat(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	for _, envelope := range []bool{false, true} {
+		t.Run(fmt.Sprintf("envelope=%t", envelope), func(t *testing.T) {
+			testHTTPSinkHeartbeat(t, sc, envelope)
+		})
+	}
+}
+
+func testHTTPSinkHeartbeat(t *testing.T, sc *TestLogScope, envelope bool) {
+	var mu syncutil.Mutex
+	var bodies []string
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+	}))
+	defer s.Close()
+
+	timeout := 5 * time.Second
+	tb := true
+	heartbeatInterval := 10 * time.Millisecond
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.HTTPServers = map[string]*logconfig.HTTPSinkConfig{
+		"ops": {
+			HTTPDefaults: logconfig.HTTPDefaults{
+				Address:           &s.URL,
+				Timeout:           &timeout,
+				Compression:       &logconfig.NoneCompression,
+				DisableKeepAlives: &tb,
+				HeartbeatInterval: &heartbeatInterval,
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	expectedPrefix := `{"type":"heartbeat","timestamp":"`
+	if envelope {
+		cfg.Sinks.HTTPServers["ops"].Envelope = map[string]string{"source": "crdb"}
+		expectedPrefix = `{"source":"crdb","records":[{"type":"heartbeat","timestamp":"`
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+	require.False(t, cfg.Sinks.HTTPServers["ops"].Buffering.IsNone())
+
+	TestingResetActive()
+	cleanup, err := ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+	require.NoError(t, err)
+	defer cleanup()
+
+	// Nothing is logged, but heartbeats are sent.
+	var heartbeat string
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, body := range bodies {
+			if strings.HasPrefix(body, expectedPrefix) {
+				heartbeat = body
+				return true
+			}
+		}
+		return false
+	}, 10*time.Second, 10*time.Millisecond)
+	require.True(t, json.Valid([]byte(heartbeat)), heartbeat)
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/redact"
+)
+
+// nodeLocality is the locality of the node, as set by SetNodeLocality.
+var nodeLocality struct {
+	syncutil.Mutex
+	locality string
+}
+
+// SetNodeLocality sets the locality of the node, which is added to the
+// entries output to the sinks configured with include-locality. The
+// locality is specified as comma-separated key=value pairs, as with the
+// --locality flag.
+//
+// The locality is resolved once when the sinks are created, so this must
+// be called before ApplyConfig.
+func SetNodeLocality(locality string) {
+	nodeLocality.Lock()
+	defer nodeLocality.Unlock()
+	nodeLocality.locality = locality
+}
+
+func getNodeLocality() string {
+	nodeLocality.Lock()
+	defer nodeLocality.Unlock()
+	return nodeLocality.locality
+}
+
+// localityTags is the encoding of the locality of the node as log tags.
+type localityTags struct {
+	// redactable and nonRedactable are the encodings used for redactable
+	// and non-redactable entries, respectively. See formattableTags.
+	redactable, nonRedactable formattableTags
+}
+
+// makeLocalityTags encodes the given locality, specified as
+// comma-separated key=value pairs.
+func makeLocalityTags(locality string) *localityTags {
+	lt := &localityTags{}
+	for _, tier := range strings.Split(locality, ",") {
+		key, value, _ := strings.Cut(tier, "=")
+		if key == "" {
+			continue
+		}
+		// The locality is not sensitive, so the value is considered safe
+		// in redactable entries.
+		lt.redactable = escapeNulBytes(lt.redactable, key)
+		lt.redactable = append(lt.redactable, 0)
+		lt.redactable = escapeNulBytes(lt.redactable, string(redact.Sprint(redact.Safe(value))))
+		lt.redactable = append(lt.redactable, 0)
+		lt.nonRedactable = escapeNulBytes(lt.nonRedactable, key)
+		lt.nonRedactable = append(lt.nonRedactable, 0)
+		lt.nonRedactable = escapeNulBytes(lt.nonRedactable, value)
+		lt.nonRedactable = append(lt.nonRedactable, 0)
+	}
+	return lt
+}
+
+// prependTo returns the tags of the given payload, preceded by the
+// locality tags.
+func (lt *localityTags) prependTo(payload entryPayload) formattableTags {
+	tags := lt.nonRedactable
+	if payload.redactable {
+		tags = lt.redactable
+	}
+	if len(tags) == 0 {
+		return payload.tags
+	}
+	res := make(formattableTags, 0, len(tags)+len(payload.tags))
+	res = append(res, tags...)
+	return append(res, payload.tags...)
+}
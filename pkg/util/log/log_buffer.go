@@ -34,8 +34,17 @@ func getBuffer() *buffer {
 const stdNumSinksPerChannel = 5
 
 type bufferSlice struct {
-	b        []*buffer
-	prealloc [stdNumSinksPerChannel]*buffer
+	b []*buffer
+	// counters contains, for each non-nil buffer in b, the counter of the
+	// entry formatted in it.
+	counters []uint64
+	// shared is true for each buffer in b that is also present at a lower
+	// index, when sinks share the formatted entry.
+	shared []bool
+
+	prealloc         [stdNumSinksPerChannel]*buffer
+	preallocCounters [stdNumSinksPerChannel]uint64
+	preallocShared   [stdNumSinksPerChannel]bool
 }
 
 // getBufferSlice returns a new ready-to-use slice of buffers.
@@ -43,8 +52,12 @@ func getBufferSlice(numBuffers int) *bufferSlice {
 	bs := logging.bufSlicePool.Get().(*bufferSlice)
 	if numBuffers > stdNumSinksPerChannel {
 		bs.b = make([]*buffer, numBuffers)
+		bs.counters = make([]uint64, numBuffers)
+		bs.shared = make([]bool, numBuffers)
 	} else {
 		bs.b = bs.prealloc[:numBuffers]
+		bs.counters = bs.preallocCounters[:numBuffers]
+		bs.shared = bs.preallocShared[:numBuffers]
 	}
 	return bs
 }
@@ -56,10 +69,16 @@ func newBufferSlice() interface{} { return &bufferSlice{} }
 // It also releases the buffers if there are any remaining.
 func putBufferSlice(bs *bufferSlice) {
 	for i := range bs.b {
-		putBuffer(bs.b[i])
+		if !bs.shared[i] {
+			putBuffer(bs.b[i])
+		}
 		bs.b[i] = nil
+		bs.counters[i] = 0
+		bs.shared[i] = false
 	}
 	bs.b = nil
+	bs.counters = nil
+	bs.shared = nil
 	logging.bufSlicePool.Put(bs)
 }
 
@@ -72,6 +72,8 @@ type logEntry struct {
 	// The file/line where the event was generated.
 	file string
 	line int
+	// The name of the function where the event was generated.
+	fun string
 
 	// The entry counter. Populated by outputLogEntry().
 	counter uint64
@@ -202,8 +204,8 @@ func makeEntry(ctx context.Context, s Severity, c Channel, depth int) (res logEn
 		gid:       goid.Get(),
 	}
 
-	// Populate file/lineno.
-	res.file, res.line, _ = caller.Lookup(depth + 1)
+	// Populate file/lineno and function.
+	res.file, res.line, res.fun = caller.Lookup(depth + 1)
 
 	return res
 }
@@ -18,6 +18,7 @@ import (
 
 	"github.com/cockroachdb/cockroach/pkg/util/envutil"
 	"github.com/cockroachdb/cockroach/pkg/util/sysutil"
+	"github.com/cockroachdb/errors"
 )
 
 // flushSyncWriter is the interface satisfied by logging destinations.
@@ -38,45 +39,64 @@ func FlushFiles() {
 	})
 }
 
+// flushAllSyncTimeout bounds the time FlushAllSync waits for the
+// buffered sinks to flush, so that an unavailable sink does not hold up
+// the crash reporting or the shutdown of the process for too long.
+const flushAllSyncTimeout = 3 * time.Second
+
 // FlushAllSync explicitly flushes all asynchronous buffered logging sinks,
 // including pending log file I/O and buffered network sinks.
 //
 // NB: This is a synchronous operation, and will block until all flushes
-// have completed. Generally only recommended for use in crash reporting
-// and shutdown scenarios. Note that `tryForceSync` is best effort, so the
-// possibility exists that a buffered log sink is unable to block until
-// the flush completes. In such a case though, the expectation that a flush
-// is already imminent for that sink.
-//
-// Each sink we attempt to flush is attempted with a timeout.
+// have completed, or until flushAllSyncTimeout has elapsed. Generally only
+// recommended for use in crash reporting and shutdown scenarios. The
+// errors encountered, if any, are printed on stderr: they are not logged
+// using the logging system, as they are unlikely to make it to the
+// destination sink anyway (there's a good chance we're flushing as part
+// of handling a panic).
 func FlushAllSync() {
+	ctx, cancel := context.WithTimeout(context.Background(), flushAllSyncTimeout)
+	defer cancel()
+	if err := flushAllSync(ctx); err != nil {
+		fmt.Fprintf(OrigStderr, "# WARNING: %s\n", err.Error())
+	}
+}
+
+// flushAllSync synchronously flushes the log files and all the buffered
+// logging sinks, waiting for the flushes to complete or for ctx to be
+// done, whichever comes first. The buffered sinks are flushed
+// concurrently, so that an unavailable sink does not delay the others. It
+// returns the errors encountered, if any.
+//
+// If a synchronous flush of a sink is already scheduled, the buffered
+// messages are part of it, and flushAllSync does not wait for it.
+func flushAllSync(ctx context.Context) error {
 	FlushFiles()
+	var sinks []*bufferedSink
 	_ = logging.allSinkInfos.iterBufferedSinks(func(bs *bufferedSink) error {
-		// Trigger a synchronous flush by calling output on the bufferedSink
-		// with a `tryForceSync` option.
-		doneCh := make(chan struct{})
+		sinks = append(sinks, bs)
+		return nil
+	})
+	// errC is buffered so that the flushes do not leak goroutines if we
+	// stop waiting for them.
+	errC := make(chan error, len(sinks))
+	for _, bs := range sinks {
+		bs := bs
 		go func() {
-			err := bs.output([]byte{}, sinkOutputOptions{tryForceSync: true})
-			if err != nil {
-				fmt.Printf("Error draining buffered log sink %T: %v\n", bs.child, err)
-			}
-			doneCh <- struct{}{}
+			errC <- errors.Wrapf(bs.flushSync(), "flushing buffered log sink %T", bs.child)
 		}()
-		// Don't wait forever if the underlying sink happens to be unavailable.
-		// Set a timeout to avoid holding up the panic handle process for too long.
+	}
+	var err error
+	for range sinks {
 		select {
-		case <-time.After(3 * time.Second):
-			fmt.Printf("Timed out waiting on buffered log sink %T to drain.\n", bs.child)
-		case <-doneCh:
+		case flushErr := <-errC:
+			err = errors.CombineErrors(err, flushErr)
+		case <-ctx.Done():
+			return errors.CombineErrors(err,
+				errors.Wrap(ctx.Err(), "waiting for buffered log sinks to flush"))
 		}
-		// We don't want to let errors stop us from iterating and flushing
-		// the remaining buffered log sinks. Nor do we want to log the error
-		// using the logging system, as it's unlikely to make it to the
-		// destination sink anyway (there's a good chance we're flushing
-		// as part of handling a panic). If an error occurs, it will be displayed.
-		// Regardless, we return nil so the iteration continues.
-		return nil
-	})
+	}
+	return err
 }
 
 func init() {
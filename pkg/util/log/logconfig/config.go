@@ -43,6 +43,19 @@ const DefaultFluentFormat = `json-fluent-compact`
 // when not specified in a configuration.
 const DefaultHTTPFormat = `json-compact`
 
+// DefaultKafkaFormat is the entry format for Kafka sinks
+// when not specified in a configuration.
+const DefaultKafkaFormat = `json-compact`
+
+// DefaultSyslogFormat is the entry format for syslog sinks
+// when not specified in a configuration.
+const DefaultSyslogFormat = `crdb-v2`
+
+// GRPCOTLPFormat is the entry format for gRPC OTLP sinks. The
+// entries are sent as the log records of an OTLP export request, with
+// the fields of this format, so no other format is supported.
+const GRPCOTLPFormat = `otlp-json`
+
 // DefaultFilePerms is the default permissions used in file-defaults. It
 // is applied literally via os.Chmod, without considering the umask.
 const DefaultFilePerms = FilePermissions(0o640)
@@ -80,6 +93,29 @@ http-defaults:
       max-staleness: 5s	
       flush-trigger-size: 1mib
       max-buffer-size: 50mib
+kafka-defaults:
+    filter: INFO
+    format: ` + DefaultKafkaFormat + `
+    redactable: true
+    exit-on-error: false
+    timeout: 2s
+syslog-defaults:
+    filter: INFO
+    format: ` + DefaultSyslogFormat + `
+    redactable: true
+    exit-on-error: false
+    facility: user
+    app-name: cockroach
+grpc-otlp-defaults:
+    filter: INFO
+    format: ` + GRPCOTLPFormat + `
+    redactable: true
+    exit-on-error: false
+    timeout: 2s
+    buffering:
+      max-staleness: 5s
+      flush-trigger-size: 1mib
+      max-buffer-size: 50mib
 sinks:
   stderr:
     filter: NONE
@@ -133,6 +169,21 @@ type Config struct {
 	// configuration value.
 	HTTPDefaults HTTPDefaults `yaml:"http-defaults,omitempty"`
 
+	// KafkaDefaults represents the default configuration for Kafka sinks,
+	// inherited when a specific Kafka sink config does not provide a
+	// configuration value.
+	KafkaDefaults KafkaDefaults `yaml:"kafka-defaults,omitempty"`
+
+	// SyslogDefaults represents the default configuration for syslog
+	// sinks, inherited when a specific syslog sink config does not
+	// provide a configuration value.
+	SyslogDefaults SyslogDefaults `yaml:"syslog-defaults,omitempty"`
+
+	// GRPCOTLPDefaults represents the default configuration for gRPC
+	// OTLP sinks, inherited when a specific gRPC OTLP sink config does
+	// not provide a configuration value.
+	GRPCOTLPDefaults GRPCOTLPDefaults `yaml:"grpc-otlp-defaults,omitempty"`
+
 	// Sinks represents the sink configurations.
 	Sinks SinkConfig `yaml:",omitempty"`
 
@@ -196,6 +247,13 @@ type CommonBufferSinkConfig struct {
 	// is started. Only one flushing operation is active at a time.
 	MaxBufferSize *ByteSize `yaml:"max-buffer-size"`
 
+	// NonBlocking causes the messages that do not fit in the buffer to be
+	// dropped, instead of the oldest buffered messages. Fatal errors still
+	// wait for the buffer to be flushed, so that their messages are not
+	// lost. The dropped messages are counted in the log.buffered.messages.dropped
+	// metric. Requires a non-zero max-buffer-size. Defaults to false.
+	NonBlocking *bool `yaml:"non-blocking,omitempty"`
+
 	// Format describes how the buffer output should be formatted.
 	// Currently 2 options:
 	// newline: default option - separates buffer entries with newline char
@@ -234,6 +292,13 @@ type CommonSinkConfig struct {
 	// to strip sensitive data reliably.
 	Redactable *bool `yaml:",omitempty"`
 
+	// StripRedactionMarkers is translated to tweaks to the other
+	// settings for this sink during validation. It enables `redact` and
+	// disables `redactable`, so that sensitive information is removed
+	// and no redaction markers are emitted. This is suitable for sinks
+	// that deliver logs to untrusted destinations.
+	StripRedactionMarkers *bool `yaml:"strip-redaction-markers,omitempty"`
+
 	// Criticality indicates whether the logging system should terminate
 	// the process if an error is encountered while writing to this
 	// sink.
@@ -245,6 +310,24 @@ type CommonSinkConfig struct {
 	// from `crdb-v1` to `crdb-v1-count`.
 	Auditable *bool `yaml:",omitempty"`
 
+	// SampleRate, when set, is the fraction of log entries, between 0
+	// and 1, that are forwarded to this sink. The entries to forward are
+	// selected at random. Entries at or above the severity configured by
+	// `sample-exempt-severity` are always forwarded.
+	SampleRate *float64 `yaml:"sample-rate,omitempty"`
+
+	// SampleExemptSeverity is the minimum severity of the log entries that
+	// are always forwarded to this sink when `sample-rate` is set.
+	// Defaults to WARNING.
+	SampleExemptSeverity logpb.Severity `yaml:"sample-exempt-severity,omitempty"`
+
+	// IncludeLocality indicates whether to add the locality of the node,
+	// as specified with the `--locality` flag, to every log entry emitted to
+	// this sink. The locality key/value pairs are added as tags, which are
+	// emitted as fields of the `tags` object in JSON formats, and before the
+	// other tags in text formats.
+	IncludeLocality *bool `yaml:"include-locality,omitempty"`
+
 	// Buffering configures buffering for this log sink, or NONE to explicitly disable.
 	Buffering CommonBufferSinkConfigWrapper `yaml:",omitempty"`
 }
@@ -257,6 +340,12 @@ type SinkConfig struct {
 	FluentServers map[string]*FluentSinkConfig `yaml:"fluent-servers,omitempty"`
 	// HTTPServers represents the list of configured http sinks.
 	HTTPServers map[string]*HTTPSinkConfig `yaml:"http-servers,omitempty"`
+	// KafkaServers represents the list of configured Kafka sinks.
+	KafkaServers map[string]*KafkaSinkConfig `yaml:"kafka-servers,omitempty"`
+	// SyslogServers represents the list of configured syslog sinks.
+	SyslogServers map[string]*SyslogSinkConfig `yaml:"syslog-servers,omitempty"`
+	// GRPCOTLPServers represents the list of configured gRPC OTLP sinks.
+	GRPCOTLPServers map[string]*GRPCOTLPSinkConfig `yaml:"grpc-otlp-servers,omitempty"`
 	// Stderr represents the configuration for the stderr sink.
 	Stderr StderrSinkConfig `yaml:",omitempty"`
 }
@@ -419,6 +508,21 @@ type FileDefaults struct {
 	// Setting this to false flushes log writes upon every entry.
 	BufferedWrites *bool `yaml:"buffered-writes,omitempty"`
 
+	// RotationInterval, if set, causes log files to be rotated at the
+	// specified cadence, in addition to the rotation triggered by
+	// max-file-size. Rotations are aligned to multiples of the interval
+	// in UTC, so that e.g. an interval of 24h rotates files at UTC
+	// midnight. The rotation occurs upon the first log entry
+	// written after the interval boundary. If zero, files are only
+	// rotated by size.
+	RotationInterval *time.Duration `yaml:"rotation-interval,omitempty"`
+
+	// CompressRotatedFiles, if set, causes log files to be compressed
+	// with gzip after they are rotated. The compressed files have the
+	// .gz extension. The file currently being written to is never
+	// compressed. Defaults to false.
+	CompressRotatedFiles *bool `yaml:"compress-rotated-files,omitempty"`
+
 	// CommonSinkConfig is the configuration common to all sinks. Note
 	// that although the idiom in Go is to place embedded fields at the
 	// beginning of a struct, we purposefully deviate from the idiom
@@ -488,6 +592,7 @@ type FileSinkConfig struct {
 }
 
 var GzipCompression = "gzip"
+var ZstdCompression = "zstd"
 var NoneCompression = "none"
 
 // HTTPDefaults refresents the configuration defaults for HTTP sinks.
@@ -496,26 +601,136 @@ type HTTPDefaults struct {
 	// host/address and port parts are separated with a colon. IPv6
 	// numeric addresses should be included within square brackets,
 	// e.g.: [::1]:1234.
+	//
+	// Multiple addresses can be specified, separated by commas. Requests
+	// are sent to the first address until it fails with a connection
+	// error or a 5xx response, in which case the sink fails over to the
+	// next address, and so on. The sink then sticks to the last address
+	// which succeeded until it fails.
+	//
+	// The address can contain the placeholders {cluster_id}, {node_id}
+	// and {channel}, which are replaced in each request by the cluster
+	// ID and node ID of the server, and the name of the logging channel
+	// of the entries, e.g. https://collector/logs/{cluster_id}/{node_id}.
+	// The identifiers are replaced by "unknown" until they are known.
 	Address *string `yaml:",omitempty"`
 
-	// Method is the HTTP method to be used.  POST and GET are
-	// supported; defaults to POST.
+	// Method is the HTTP method to be used. POST, PUT and GET are
+	// supported; defaults to POST. With PUT, the entries are sent in the
+	// body of the requests as with POST.
 	Method *HTTPSinkMethod `yaml:",omitempty"`
 
 	// UnsafeTLS enables certificate authentication to be bypassed.
 	// Defaults to false.
 	UnsafeTLS *bool `yaml:"unsafe-tls,omitempty"`
 
-	// Timeout is the HTTP timeout.
+	// ClientCert is the path to a PEM-encoded client certificate to
+	// present to the server for mutual TLS. Must be specified together
+	// with client-key.
+	ClientCert *string `yaml:"client-cert,omitempty"`
+
+	// ClientKey is the path to the PEM-encoded private key of the client
+	// certificate. Must be specified together with client-cert.
+	ClientKey *string `yaml:"client-key,omitempty"`
+
+	// CACert is the path to a PEM-encoded CA certificate used to verify
+	// the server's certificate. Defaults to the system's root CAs.
+	CACert *string `yaml:"ca-cert,omitempty"`
+
+	// Timeout is the HTTP timeout. It bounds each request as a whole,
+	// including the connection, the response headers and the body.
 	// Defaults to 0 for no timeout.
 	Timeout *time.Duration `yaml:",omitempty"`
 
+	// DialTimeout is the maximum amount of time to wait for a connection
+	// to the server to be established. Defaults to 30s; the overall
+	// timeout also applies.
+	DialTimeout *time.Duration `yaml:"dial-timeout,omitempty"`
+
+	// ResponseHeaderTimeout is the maximum amount of time to wait for the
+	// response headers of the server after the request was sent.
+	// Defaults to 0 for no limit other than the overall timeout.
+	ResponseHeaderTimeout *time.Duration `yaml:"response-header-timeout,omitempty"`
+
 	// DisableKeepAlives causes the logging sink to re-establish a new
 	// connection for every outgoing log message. This option is
 	// intended for testing only and can cause excessive network
 	// overhead in production systems.
 	DisableKeepAlives *bool `yaml:"disable-keep-alives,omitempty"`
 
+	// ForceHTTP2 causes the requests to be sent using HTTP/2 over
+	// persistent connections, which are reused across requests. For
+	// https addresses, HTTP/2 is negotiated during the TLS handshake. For
+	// http addresses, HTTP/2 is used over cleartext TCP (h2c) and the
+	// server must support it; proxies are not used in that case. Cannot
+	// be combined with disable-keep-alives. Defaults to false.
+	ForceHTTP2 *bool `yaml:"force-http2,omitempty"`
+
+	// Proxy is the URL of an HTTP proxy through which requests are sent,
+	// e.g. http://proxy.example.com:3128. Takes precedence over
+	// proxy-from-env.
+	Proxy *string `yaml:",omitempty"`
+
+	// ProxyFromEnv, if proxy is not set, causes the proxy to be selected
+	// using the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+	// Defaults to true.
+	ProxyFromEnv *bool `yaml:"proxy-from-env,omitempty"`
+
+	// MaxInFlight bounds the number of concurrent outstanding requests
+	// to the server. When the limit is reached, additional requests wait
+	// for up to the buffering max-staleness (indefinitely if buffering
+	// is disabled) and are then dropped. Note that with
+	// disable-keep-alives, each in-flight request also holds its own
+	// connection, so this also bounds the number of open connections.
+	// Defaults to 0 for no limit.
+	MaxInFlight *int `yaml:"max-in-flight,omitempty"`
+
+	// CircuitBreakerThreshold is the number of consecutive failed
+	// requests after which the sink stops sending requests, dropping
+	// log messages for the circuit-breaker-cooldown period. After the
+	// cooldown, a single request is attempted; if it succeeds, the sink
+	// resumes normal operation, otherwise the cooldown starts anew.
+	// Defaults to 0 to disable the circuit breaker.
+	CircuitBreakerThreshold *int `yaml:"circuit-breaker-threshold,omitempty"`
+
+	// CircuitBreakerCooldown is how long the circuit breaker drops log
+	// messages after opening. Defaults to 10s.
+	CircuitBreakerCooldown *time.Duration `yaml:"circuit-breaker-cooldown,omitempty"`
+
+	// HeartbeatInterval, when set, causes a heartbeat record to be sent to
+	// the server when no request was sent for this long, so that the server
+	// can tell an idle node from an unavailable one. The heartbeat record
+	// is a JSON object with the field `"type":"heartbeat"` and the
+	// timestamp at which it was sent, regardless of the format of the sink.
+	// With an `envelope`, it is sent as the only element of the records
+	// array. Heartbeats are not supported with the `otlp-json` format.
+	// Defaults to 0 for no heartbeats.
+	HeartbeatInterval *time.Duration `yaml:"heartbeat-interval,omitempty"`
+
+	// DeadLetterDir is the directory where the log entries that could
+	// not be delivered are stored, including the ones dropped due to
+	// max-in-flight or the circuit breaker, so that they can be replayed
+	// later. The files are named like log files, with the prefix
+	// `cockroach-http-dead-letter-<sink name>-<address hash>`, so that the
+	// addresses of a sink configured with `channel-addresses` use separate
+	// files. Not set by default.
+	DeadLetterDir *string `yaml:"dead-letter-dir,omitempty"`
+
+	// DeadLetterMaxSize is the maximum combined size of the files in the
+	// dead-letter directory of the sink. The oldest files are removed
+	// when the size is exceeded. Defaults to 100MiB.
+	DeadLetterMaxSize *ByteSize `yaml:"dead-letter-max-size,omitempty"`
+
+	// DeadLetterReplay causes the entries stored in the dead-letter
+	// directory to be sent again once the server is reachable, i.e. after
+	// a request succeeds. The entries are sent in the order in which they
+	// were stored, in the same batches as the requests that failed, at a
+	// limited rate, subject to max-in-flight and the circuit breaker. The
+	// files are removed once all their entries were delivered, so some
+	// entries may be delivered twice if the process stops during the
+	// replay. Requires dead-letter-dir. Defaults to false.
+	DeadLetterReplay *bool `yaml:"dead-letter-replay,omitempty"`
+
 	// Headers is a list of headers to attach to each HTTP request
 	Headers map[string]string `yaml:",omitempty,flow"`
 
@@ -523,13 +738,58 @@ type HTTPDefaults struct {
 	// attached to each HTTP request
 	FileBasedHeaders map[string]string `yaml:"file-based-headers,omitempty,flow"`
 
-	// Compression can be "none" or "gzip" to enable gzip compression.
-	// Set to "gzip" by default.
+	// IdempotencyKeyHeader, when set, is the name of a header carrying a
+	// random UUID identifying each batch of entries sent by the sink, so
+	// that the server can deduplicate the batches it receives more than
+	// once. The key is the same for all the attempts to deliver a batch,
+	// including the failovers to the other addresses, and differs between
+	// batches. The batches replayed from the dead-letter directory are
+	// sent with the same keys as the attempts that failed. Not used with
+	// the GET method. Not set by default.
+	IdempotencyKeyHeader *string `yaml:"idempotency-key-header,omitempty"`
+
+	// Compression can be "none", "gzip" to enable gzip compression or
+	// "zstd" to enable zstd compression. Set to "gzip" by default.
 	Compression *string `yaml:",omitempty"`
 
+	// CompressionLevel is the gzip compression level, from 1 (best speed)
+	// to 9 (best compression). 0 disables compression, -1 uses the default
+	// level and -2 uses Huffman-only compression. Only used with gzip
+	// compression. Defaults to -1.
+	CompressionLevel *int `yaml:"compression-level,omitempty"`
+
+	// CompressionAuto enables the negotiation of the compression with
+	// the server. The sink sends an OPTIONS request to the server and
+	// selects the best compression among the encodings listed in the
+	// Accept-Encoding header of the response, preferring zstd over gzip
+	// over none. The server is probed again every 10 minutes. If the
+	// server does not list the encodings it accepts, the configured
+	// compression is used. Defaults to false.
+	CompressionAuto *bool `yaml:"compression-auto,omitempty"`
+
+	// MaxMessageSize is the maximum size of a formatted log entry. The
+	// message of larger entries is truncated to fit, and a suffix
+	// indicating the number of bytes removed is appended to it. The
+	// entries remain valid in the configured format. Defaults to 0 for no
+	// limit.
+	MaxMessageSize *ByteSize `yaml:"max-message-size,omitempty"`
+
+	// Envelope, if set, causes the entries sent in each request to be
+	// wrapped in a JSON object containing the given fields, followed by
+	// the array of entries under the key "records", e.g.
+	// {"source":"crdb","records":[...]}. The placeholders {cluster_id}
+	// and {node_id} in the field values are replaced by the identifiers
+	// of the server, as in the address. Only supported with the JSON
+	// formats.
+	Envelope map[string]string `yaml:",omitempty,flow"`
+
 	CommonSinkConfig `yaml:",inline"`
 }
 
+// HTTPSinkEnvelopeRecordsKey is the key of the array of log entries in
+// the envelope of an HTTP sink.
+const HTTPSinkEnvelopeRecordsKey = "records"
+
 // HTTPSinkConfig represents the configuration for one http sink.
 //
 // User-facing documentation follows.
@@ -573,6 +833,297 @@ type HTTPSinkConfig struct {
 
 	HTTPDefaults `yaml:",inline"`
 
+	// ChannelAddresses maps channel names to the address of the HTTP
+	// server that receives the entries of that channel, instead of the
+	// address configured for the sink. The channels must be selected by
+	// this sink. Entries on other channels are sent to the default
+	// address.
+	ChannelAddresses map[string]string `yaml:"channel-addresses,omitempty"`
+
+	// sinkName is populated during validation.
+	sinkName string
+}
+
+// KafkaDefaults represents the configuration defaults for Kafka sinks.
+type KafkaDefaults struct {
+	// Brokers is the list of network addresses of the Kafka brokers
+	// used to bootstrap the connection to the cluster. The host/address
+	// and port parts are separated with a colon.
+	Brokers []string `yaml:",omitempty,flow"`
+
+	// Topic is the Kafka topic to which log entries are produced.
+	Topic *string `yaml:",omitempty"`
+
+	// Timeout is the timeout for producing each message.
+	// Defaults to 2s.
+	Timeout *time.Duration `yaml:",omitempty"`
+
+	// TLS enables TLS for the connections to the brokers.
+	// Defaults to false.
+	TLS *bool `yaml:"tls,omitempty"`
+
+	// UnsafeTLS enables certificate authentication of the brokers to be
+	// bypassed. Only used if tls is enabled. Defaults to false.
+	UnsafeTLS *bool `yaml:"unsafe-tls,omitempty"`
+
+	// CACert is the path to a PEM-encoded CA certificate used to verify
+	// the brokers' certificates. Only used if tls is enabled. Defaults to
+	// the system's root CAs.
+	CACert *string `yaml:"ca-cert,omitempty"`
+
+	// SASLUser is the user name to authenticate with using SASL/PLAIN.
+	// Must be specified together with sasl-password.
+	SASLUser *string `yaml:"sasl-user,omitempty"`
+
+	// SASLPassword is the password to authenticate with using
+	// SASL/PLAIN. Must be specified together with sasl-user.
+	SASLPassword *string `yaml:"sasl-password,omitempty"`
+
+	CommonSinkConfig `yaml:",inline"`
+}
+
+// KafkaSinkConfig represents the configuration for one Kafka sink.
+//
+// User-facing documentation follows.
+// TITLE: Output to Kafka
+//
+// This sink type causes logging data to be produced as messages to a
+// topic of a Kafka cluster.
+//
+// The configuration key under the `sinks` key in the YAML
+// configuration is `kafka-servers`. Example configuration:
+//
+//	sinks:
+//	   kafka-servers:
+//	      health:
+//	         channels: HEALTH
+//	         brokers: [kafka1:9092, kafka2:9092]
+//	         topic: cockroach-health
+//
+// Every new Kafka sink configured automatically inherits the configuration set in the `kafka-defaults` section.
+//
+// Each log entry is produced as one message, formatted according to the
+// sink's format. Buffering is not supported by Kafka sinks.
+//
+// The default output format for Kafka sinks is
+// `json-compact`. [Other supported formats.](log-formats.html)
+//
+// {{site.data.alerts.callout_info}}
+// Run `cockroach debug check-log-config` to verify the effect of defaults inheritance.
+// {{site.data.alerts.end}}
+type KafkaSinkConfig struct {
+	// Channels is the list of logging channels that use this sink.
+	Channels ChannelFilters `yaml:",omitempty,flow"`
+
+	KafkaDefaults `yaml:",inline"`
+
+	// sinkName is populated during validation.
+	sinkName string
+}
+
+// The placeholders that can be used in the address of an HTTP sink.
+const (
+	HTTPSinkClusterIDPlaceholder = "{cluster_id}"
+	HTTPSinkNodeIDPlaceholder    = "{node_id}"
+	HTTPSinkChannelPlaceholder   = "{channel}"
+)
+
+// SplitHTTPSinkAddresses returns the list of addresses of an HTTP sink,
+// given the value of its address field.
+func SplitHTTPSinkAddresses(address string) []string {
+	addresses := strings.Split(address, ",")
+	for i := range addresses {
+		addresses[i] = strings.TrimSpace(addresses[i])
+	}
+	return addresses
+}
+
+// SyslogFacilities maps the facility names accepted in the
+// configuration of syslog sinks to their numeric codes, as defined in
+// RFC 5424.
+var SyslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// SyslogDefaults represents the configuration defaults for syslog sinks.
+type SyslogDefaults struct {
+	// Facility is the syslog facility reported for log entries, e.g.
+	// "user", "daemon" or "local0" through "local7". Defaults to "user".
+	Facility *string `yaml:",omitempty"`
+
+	// AppName is the APP-NAME field reported for log entries.
+	// Defaults to "cockroach".
+	AppName *string `yaml:"app-name,omitempty"`
+
+	// Timeout is the timeout for connecting to the syslog server and for
+	// writing each message. Defaults to 2s.
+	Timeout *time.Duration `yaml:",omitempty"`
+
+	// TLS enables TLS for the connection to the syslog server. Only
+	// supported with the tcp protocol. Defaults to false.
+	TLS *bool `yaml:"tls,omitempty"`
+
+	// UnsafeTLS enables certificate authentication of the syslog server
+	// to be bypassed. Only used if tls is enabled. Defaults to false.
+	UnsafeTLS *bool `yaml:"unsafe-tls,omitempty"`
+
+	// CACert is the path to a PEM-encoded CA certificate used to verify
+	// the syslog server's certificate. Only used if tls is enabled.
+	// Defaults to the system's root CAs.
+	CACert *string `yaml:"ca-cert,omitempty"`
+
+	CommonSinkConfig `yaml:",inline"`
+}
+
+// SyslogSinkConfig represents the configuration for one syslog sink.
+//
+// User-facing documentation follows.
+// TITLE: Output to syslog servers
+//
+// This sink type causes logging data to be sent over the network to a
+// syslog server, using the message format defined in
+// [RFC 5424](https://www.rfc-editor.org/rfc/rfc5424).
+//
+// The configuration key under the `sinks` key in the YAML
+// configuration is `syslog-servers`. Example configuration:
+//
+//	sinks:
+//	   syslog-servers:
+//	      health:
+//	         channels: HEALTH
+//	         net: udp
+//	         address: 127.0.0.1:514
+//	         facility: local0
+//
+// Every new syslog sink configured automatically inherits the configuration set in the `syslog-defaults` section.
+//
+// The syslog severity of each message is derived from the severity of
+// the log entry, and the MSGID field is set to the logging channel. The
+// message body is the log entry formatted according to the sink's
+// format. Buffering is not supported by syslog sinks.
+//
+// Over UDP, each message is sent in its own datagram. Over TCP and unix
+// sockets, messages are framed using octet counting, as described in
+// [RFC 6587](https://www.rfc-editor.org/rfc/rfc6587).
+//
+// The default output format for syslog sinks is
+// `crdb-v2`. [Other supported formats.](log-formats.html)
+//
+// {{site.data.alerts.callout_info}}
+// Run `cockroach debug check-log-config` to verify the effect of defaults inheritance.
+// {{site.data.alerts.end}}
+type SyslogSinkConfig struct {
+	// Channels is the list of logging channels that use this sink.
+	Channels ChannelFilters `yaml:",omitempty,flow"`
+
+	// Net is the protocol for the syslog server. Can be "udp", "tcp",
+	// "unix", etc. Defaults to "udp".
+	Net string `yaml:",omitempty"`
+
+	// Address is the network address of the syslog server. The
+	// host/address and port parts are separated with a colon. IPv6
+	// numeric addresses should be included within square brackets,
+	// e.g.: [::1]:514. For the unix protocol, this is the path to
+	// the socket.
+	Address string `yaml:""`
+
+	SyslogDefaults `yaml:",inline"`
+
+	// sinkName is populated during validation.
+	sinkName string
+}
+
+// GRPCOTLPDefaults represents the configuration defaults for gRPC OTLP
+// sinks.
+type GRPCOTLPDefaults struct {
+	// Timeout is the timeout for each export request sent to the
+	// collector. Defaults to 2s.
+	Timeout *time.Duration `yaml:",omitempty"`
+
+	// TLS enables TLS for the connection to the collector.
+	// Defaults to false.
+	TLS *bool `yaml:"tls,omitempty"`
+
+	// UnsafeTLS enables certificate authentication of the collector to
+	// be bypassed. Only used if tls is enabled. Defaults to false.
+	UnsafeTLS *bool `yaml:"unsafe-tls,omitempty"`
+
+	// CACert is the path to a PEM-encoded CA certificate used to verify
+	// the collector's certificate. Only used if tls is enabled. Defaults
+	// to the system's root CAs.
+	CACert *string `yaml:"ca-cert,omitempty"`
+
+	// Headers is a list of headers to attach as gRPC metadata to each
+	// export request, e.g. for authentication.
+	Headers map[string]string `yaml:",omitempty,flow"`
+
+	CommonSinkConfig `yaml:",inline"`
+}
+
+// GRPCOTLPSinkConfig represents the configuration for one gRPC OTLP
+// sink.
+//
+// User-facing documentation follows.
+// TITLE: Output to OpenTelemetry collectors over gRPC
+//
+// This sink type causes logging data to be sent over the network to an
+// OpenTelemetry collector, using the OTLP/gRPC protocol.
+//
+// The configuration key under the `sinks` key in the YAML
+// configuration is `grpc-otlp-servers`. Example configuration:
+//
+//	sinks:
+//	   grpc-otlp-servers:
+//	      health:
+//	         channels: HEALTH
+//	         address: collector:4317
+//	         headers: {authorization: "Bearer XXX"}
+//
+// Every new gRPC OTLP sink configured automatically inherits the configuration set in the `grpc-otlp-defaults` section.
+//
+// Each log entry is converted to an OTLP log record. The buffered
+// entries of a sink are sent together in a single
+// `ExportLogsServiceRequest`; if buffering is disabled, each entry is
+// sent in its own request.
+//
+// The output format for gRPC OTLP sinks is always `otlp-json`: the log
+// records have the same fields as the entries of that format, and are
+// sent in the OTLP protobuf encoding.
+//
+// {{site.data.alerts.callout_info}}
+// Run `cockroach debug check-log-config` to verify the effect of defaults inheritance.
+// {{site.data.alerts.end}}
+type GRPCOTLPSinkConfig struct {
+	// Channels is the list of logging channels that use this sink.
+	Channels ChannelFilters `yaml:",omitempty,flow"`
+
+	// Address is the network address of the OTLP gRPC endpoint of the
+	// collector. The host/address and port parts are separated with a
+	// colon. IPv6 numeric addresses should be included within square
+	// brackets, e.g.: [::1]:4317.
+	Address string `yaml:""`
+
+	GRPCOTLPDefaults `yaml:",inline"`
+
 	// sinkName is populated during validation.
 	sinkName string
 }
@@ -1132,7 +1683,7 @@ func (w CommonBufferSinkConfigWrapper) IsNone() bool {
 		(w.MaxBufferSize != nil && *w.MaxBufferSize == 0)
 }
 
-// HTTPSinkMethod is a string restricted to "POST" and "GET"
+// HTTPSinkMethod is a string restricted to "POST", "PUT" and "GET"
 type HTTPSinkMethod string
 
 var _ constrainedString = (*HTTPSinkMethod)(nil)
@@ -1152,6 +1703,7 @@ func (HTTPSinkMethod) AllowedSet() []string {
 	return []string{
 		http.MethodGet,
 		http.MethodPost,
+		http.MethodPut,
 	}
 }
 
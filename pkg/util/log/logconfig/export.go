@@ -247,6 +247,114 @@ func (c *Config) Export(onlyChans ChannelList) (string, string) {
 		}
 	}
 
+	// Collect Kafka sinks.
+	sortedNames = nil
+	for sinkName := range c.Sinks.KafkaServers {
+		sortedNames = append(sortedNames, sinkName)
+	}
+	sort.Strings(sortedNames)
+
+	for _, name := range sortedNames {
+		cfg := c.Sinks.KafkaServers[name]
+		if cfg.Filter == logpb.Severity_NONE {
+			continue
+		}
+		key := fmt.Sprintf("k__%s", name)
+		target, thisprocs, thislinks := process(key, cfg.CommonSinkConfig)
+		origTarget := target
+		hasLink := false
+		for _, ch := range cfg.Channels.AllChannels.Channels {
+			if !chanSel.HasChannel(ch) {
+				continue
+			}
+			sev := cfg.Channels.ChannelFilters[ch]
+			if sev == logpb.Severity_NONE {
+				continue
+			}
+			hasLink = true
+			target, thisprocs, thislinks = addFilter(origTarget, thisprocs, thislinks, sev)
+			links = append(links, fmt.Sprintf("%s --> %s", ch, target))
+		}
+		if hasLink {
+			processing = append(processing, thisprocs...)
+			links = append(links, thislinks...)
+			servers[name] = fmt.Sprintf("queue %s as \"kafka: %s\"",
+				key, *cfg.Topic)
+		}
+	}
+
+	// Collect syslog sinks.
+	sortedNames = nil
+	for sinkName := range c.Sinks.SyslogServers {
+		sortedNames = append(sortedNames, sinkName)
+	}
+	sort.Strings(sortedNames)
+
+	for _, name := range sortedNames {
+		cfg := c.Sinks.SyslogServers[name]
+		if cfg.Filter == logpb.Severity_NONE {
+			continue
+		}
+		key := fmt.Sprintf("sl__%s", name)
+		target, thisprocs, thislinks := process(key, cfg.CommonSinkConfig)
+		origTarget := target
+		hasLink := false
+		for _, ch := range cfg.Channels.AllChannels.Channels {
+			if !chanSel.HasChannel(ch) {
+				continue
+			}
+			sev := cfg.Channels.ChannelFilters[ch]
+			if sev == logpb.Severity_NONE {
+				continue
+			}
+			hasLink = true
+			target, thisprocs, thislinks = addFilter(origTarget, thisprocs, thislinks, sev)
+			links = append(links, fmt.Sprintf("%s --> %s", ch, target))
+		}
+		if hasLink {
+			processing = append(processing, thisprocs...)
+			links = append(links, thislinks...)
+			servers[name] = fmt.Sprintf("queue %s as \"syslog: %s:%s\"",
+				key, cfg.Net, cfg.Address)
+		}
+	}
+
+	// Collect gRPC OTLP sinks.
+	sortedNames = nil
+	for sinkName := range c.Sinks.GRPCOTLPServers {
+		sortedNames = append(sortedNames, sinkName)
+	}
+	sort.Strings(sortedNames)
+
+	for _, name := range sortedNames {
+		cfg := c.Sinks.GRPCOTLPServers[name]
+		if cfg.Filter == logpb.Severity_NONE {
+			continue
+		}
+		key := fmt.Sprintf("g__%s", name)
+		target, thisprocs, thislinks := process(key, cfg.CommonSinkConfig)
+		origTarget := target
+		hasLink := false
+		for _, ch := range cfg.Channels.AllChannels.Channels {
+			if !chanSel.HasChannel(ch) {
+				continue
+			}
+			sev := cfg.Channels.ChannelFilters[ch]
+			if sev == logpb.Severity_NONE {
+				continue
+			}
+			hasLink = true
+			target, thisprocs, thislinks = addFilter(origTarget, thisprocs, thislinks, sev)
+			links = append(links, fmt.Sprintf("%s --> %s", ch, target))
+		}
+		if hasLink {
+			processing = append(processing, thisprocs...)
+			links = append(links, thislinks...)
+			servers[name] = fmt.Sprintf("queue %s as \"grpc-otlp: %s\"",
+				key, cfg.Address)
+		}
+	}
+
 	// Export the stderr redirects.
 	if c.Sinks.Stderr.Filter != logpb.Severity_NONE {
 		target, thisprocs, thislinks := process("stderr", c.Sinks.Stderr.CommonSinkConfig)
@@ -12,8 +12,11 @@ package logconfig
 
 import (
 	"bytes"
+	"compress/gzip"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"reflect"
 	"sort"
@@ -117,13 +120,85 @@ func (c *Config) Validate(defaultLogDir *string) (resErr error) {
 		Compression: &GzipCompression,
 	}
 
+	baseKafkaDefaults := KafkaDefaults{
+		CommonSinkConfig: CommonSinkConfig{
+			Format: func() *string { s := DefaultKafkaFormat; return &s }(),
+			// Buffering is disabled by default, so that each log entry is
+			// produced as its own message.
+			Buffering: CommonBufferSinkConfigWrapper{
+				CommonBufferSinkConfig: CommonBufferSinkConfig{
+					MaxStaleness:     &zeroDuration,
+					FlushTriggerSize: &zeroByteSize,
+					MaxBufferSize:    &zeroByteSize,
+					Format:           &bufferFmt,
+				},
+			},
+		},
+		TLS:       &bf,
+		UnsafeTLS: &bf,
+		Timeout: func() *time.Duration {
+			twoS := 2 * time.Second
+			return &twoS
+		}(),
+	}
+
+	baseSyslogDefaults := SyslogDefaults{
+		CommonSinkConfig: CommonSinkConfig{
+			Format: func() *string { s := DefaultSyslogFormat; return &s }(),
+			// Buffering is disabled by default, so that each log entry is
+			// sent as its own syslog message.
+			Buffering: CommonBufferSinkConfigWrapper{
+				CommonBufferSinkConfig: CommonBufferSinkConfig{
+					MaxStaleness:     &zeroDuration,
+					FlushTriggerSize: &zeroByteSize,
+					MaxBufferSize:    &zeroByteSize,
+					Format:           &bufferFmt,
+				},
+			},
+		},
+		Facility:  func() *string { s := "user"; return &s }(),
+		AppName:   func() *string { s := "cockroach"; return &s }(),
+		TLS:       &bf,
+		UnsafeTLS: &bf,
+		Timeout: func() *time.Duration {
+			twoS := 2 * time.Second
+			return &twoS
+		}(),
+	}
+
+	baseGRPCOTLPDefaults := GRPCOTLPDefaults{
+		CommonSinkConfig: CommonSinkConfig{
+			Format: func() *string { s := GRPCOTLPFormat; return &s }(),
+			Buffering: CommonBufferSinkConfigWrapper{
+				CommonBufferSinkConfig: CommonBufferSinkConfig{
+					MaxStaleness:     &defaultBufferedStaleness,
+					FlushTriggerSize: &defaultFlushTriggerSize,
+					MaxBufferSize:    &defaultMaxBufferSize,
+					Format:           &bufferFmt,
+				},
+			},
+		},
+		TLS:       &bf,
+		UnsafeTLS: &bf,
+		Timeout: func() *time.Duration {
+			twoS := 2 * time.Second
+			return &twoS
+		}(),
+	}
+
 	propagateCommonDefaults(&baseFileDefaults.CommonSinkConfig, baseCommonSinkConfig)
 	propagateCommonDefaults(&baseFluentDefaults.CommonSinkConfig, baseCommonSinkConfig)
 	propagateCommonDefaults(&baseHTTPDefaults.CommonSinkConfig, baseCommonSinkConfig)
+	propagateCommonDefaults(&baseKafkaDefaults.CommonSinkConfig, baseCommonSinkConfig)
+	propagateCommonDefaults(&baseSyslogDefaults.CommonSinkConfig, baseCommonSinkConfig)
+	propagateCommonDefaults(&baseGRPCOTLPDefaults.CommonSinkConfig, baseCommonSinkConfig)
 
 	propagateFileDefaults(&c.FileDefaults, baseFileDefaults)
 	propagateFluentDefaults(&c.FluentDefaults, baseFluentDefaults)
 	propagateHTTPDefaults(&c.HTTPDefaults, baseHTTPDefaults)
+	propagateKafkaDefaults(&c.KafkaDefaults, baseKafkaDefaults)
+	propagateSyslogDefaults(&c.SyslogDefaults, baseSyslogDefaults)
+	propagateGRPCOTLPDefaults(&c.GRPCOTLPDefaults, baseGRPCOTLPDefaults)
 
 	// Normalize the directory.
 	if err := normalizeDir(&c.FileDefaults.Dir); err != nil {
@@ -165,6 +240,39 @@ func (c *Config) Validate(defaultLogDir *string) (resErr error) {
 		}
 	}
 
+	for sinkName, kc := range c.Sinks.KafkaServers {
+		if kc == nil {
+			kc = &KafkaSinkConfig{Channels: SelectChannels()}
+			c.Sinks.KafkaServers[sinkName] = kc
+		}
+		kc.sinkName = sinkName
+		if err := c.validateKafkaSinkConfig(kc); err != nil {
+			fmt.Fprintf(&errBuf, "kafka server %q: %v\n", sinkName, err)
+		}
+	}
+
+	for sinkName, sc := range c.Sinks.SyslogServers {
+		if sc == nil {
+			sc = &SyslogSinkConfig{Channels: SelectChannels()}
+			c.Sinks.SyslogServers[sinkName] = sc
+		}
+		sc.sinkName = sinkName
+		if err := c.validateSyslogSinkConfig(sc); err != nil {
+			fmt.Fprintf(&errBuf, "syslog server %q: %v\n", sinkName, err)
+		}
+	}
+
+	for sinkName, gc := range c.Sinks.GRPCOTLPServers {
+		if gc == nil {
+			gc = &GRPCOTLPSinkConfig{Channels: SelectChannels()}
+			c.Sinks.GRPCOTLPServers[sinkName] = gc
+		}
+		gc.sinkName = sinkName
+		if err := c.validateGRPCOTLPSinkConfig(gc); err != nil {
+			fmt.Fprintf(&errBuf, "grpc-otlp server %q: %v\n", sinkName, err)
+		}
+	}
+
 	// Defaults for stderr.
 	if c.Sinks.Stderr.Filter == logpb.Severity_UNKNOWN {
 		c.Sinks.Stderr.Filter = logpb.Severity_NONE
@@ -177,6 +285,7 @@ func (c *Config) Validate(defaultLogDir *string) (resErr error) {
 		c.Sinks.Stderr.Criticality = &bt
 	}
 	c.Sinks.Stderr.Auditable = nil
+	applyStripRedactionMarkers(&c.Sinks.Stderr.CommonSinkConfig)
 	// The format parameter for stderr is set to `crdb-v2-tty` and cannot be changed.
 	// See docs: https://www.cockroachlabs.com/docs/stable/configure-logs#output-to-stderr
 	if *c.Sinks.Stderr.Format != DefaultStderrFormat {
@@ -253,6 +362,45 @@ func (c *Config) Validate(defaultLogDir *string) (resErr error) {
 			fmt.Fprintf(&errBuf, "http server %q: %v\n", sinkName, err)
 			continue
 		}
+		if err := validateHTTPChannelAddresses(fc); err != nil {
+			fmt.Fprintf(&errBuf, "http server %q: %v\n", sinkName, err)
+		}
+	}
+
+	for sinkName, kc := range c.Sinks.KafkaServers {
+		if len(kc.Channels.Filters) == 0 {
+			fmt.Fprintf(&errBuf, "kafka server %q: no channel selected\n", sinkName)
+		}
+		// Propagate the sink-wide default filter to all channels that don't
+		// have a filter yet.
+		if err := kc.Channels.Validate(kc.Filter); err != nil {
+			fmt.Fprintf(&errBuf, "kafka server %q: %v\n", sinkName, err)
+			continue
+		}
+	}
+
+	for sinkName, sc := range c.Sinks.SyslogServers {
+		if len(sc.Channels.Filters) == 0 {
+			fmt.Fprintf(&errBuf, "syslog server %q: no channel selected\n", sinkName)
+		}
+		// Propagate the sink-wide default filter to all channels that don't
+		// have a filter yet.
+		if err := sc.Channels.Validate(sc.Filter); err != nil {
+			fmt.Fprintf(&errBuf, "syslog server %q: %v\n", sinkName, err)
+			continue
+		}
+	}
+
+	for sinkName, gc := range c.Sinks.GRPCOTLPServers {
+		if len(gc.Channels.Filters) == 0 {
+			fmt.Fprintf(&errBuf, "grpc-otlp server %q: no channel selected\n", sinkName)
+		}
+		// Propagate the sink-wide default filter to all channels that don't
+		// have a filter yet.
+		if err := gc.Channels.Validate(gc.Filter); err != nil {
+			fmt.Fprintf(&errBuf, "grpc-otlp server %q: %v\n", sinkName, err)
+			continue
+		}
 	}
 
 	// If capture-stray-errors was enabled, then perform some additional
@@ -360,6 +508,30 @@ func (c *Config) Validate(defaultLogDir *string) (resErr error) {
 		}
 	}
 
+	// Elide all the Kafka sinks where all channels have
+	// severity set to NONE.
+	for sinkName, kc := range c.Sinks.KafkaServers {
+		if kc.Channels.noChannelsSelected() {
+			delete(c.Sinks.KafkaServers, sinkName)
+		}
+	}
+
+	// Elide all the syslog sinks where all channels have
+	// severity set to NONE.
+	for sinkName, sc := range c.Sinks.SyslogServers {
+		if sc.Channels.noChannelsSelected() {
+			delete(c.Sinks.SyslogServers, sinkName)
+		}
+	}
+
+	// Elide all the gRPC OTLP sinks where all channels have
+	// severity set to NONE.
+	for sinkName, gc := range c.Sinks.GRPCOTLPServers {
+		if gc.Channels.noChannelsSelected() {
+			delete(c.Sinks.GRPCOTLPServers, sinkName)
+		}
+	}
+
 	return nil
 }
 
@@ -377,6 +549,9 @@ func (c *Config) newFileSinkConfig(groupName string) *FileSinkConfig {
 
 func (c *Config) validateFileSinkConfig(fc *FileSinkConfig) error {
 	propagateFileDefaults(&fc.FileDefaults, c.FileDefaults)
+	if fc.RotationInterval != nil && *fc.RotationInterval < 0 {
+		return errors.New("rotation-interval cannot be negative")
+	}
 	if !fc.Buffering.IsNone() {
 		if fc.BufferedWrites != nil && *fc.BufferedWrites {
 			return errors.Newf(`Unable to use "buffered-writes" in conjunction with a "buffering" configuration. ` +
@@ -411,17 +586,26 @@ func (c *Config) validateFileSinkConfig(fc *FileSinkConfig) error {
 		}
 	}
 	fc.Auditable = nil
+	applyStripRedactionMarkers(&fc.CommonSinkConfig)
 
 	return c.ValidateCommonSinkConfig(fc.CommonSinkConfig)
 }
 
 // ValidateCommonSinkConfig validates a CommonSinkConfig.
 func (c *Config) ValidateCommonSinkConfig(conf CommonSinkConfig) error {
+	if conf.SampleRate != nil && (*conf.SampleRate <= 0 || *conf.SampleRate > 1) {
+		return errors.Newf("sample-rate must be greater than 0 and at most 1, got %v", *conf.SampleRate)
+	}
+
 	b := conf.Buffering
 	if b.IsNone() {
 		return nil
 	}
 
+	if b.NonBlocking != nil && *b.NonBlocking && (b.MaxBufferSize == nil || *b.MaxBufferSize == 0) {
+		return errors.New("non-blocking requires max-buffer-size")
+	}
+
 	const minSlackBytes = 1 << 20 // 1MB
 
 	if b.FlushTriggerSize != nil && b.MaxBufferSize != nil {
@@ -461,17 +645,147 @@ func (c *Config) validateFluentSinkConfig(fc *FluentSinkConfig) error {
 		fc.Criticality = &bt
 	}
 	fc.Auditable = nil
+	applyStripRedactionMarkers(&fc.CommonSinkConfig)
 
 	return c.ValidateCommonSinkConfig(fc.CommonSinkConfig)
 }
 
+// validateHTTPChannelAddresses checks the per-channel addresses of an
+// HTTP sink, and normalizes the channel names. The channel filters of
+// the sink must have been validated already.
+func validateHTTPChannelAddresses(hsc *HTTPSinkConfig) error {
+	if len(hsc.ChannelAddresses) == 0 {
+		return nil
+	}
+	addrs := make(map[string]string, len(hsc.ChannelAddresses))
+	for chName, address := range hsc.ChannelAddresses {
+		chs, err := selectChannels(false /* invert */, []string{chName})
+		if err != nil {
+			return errors.Wrap(err, "channel-addresses")
+		}
+		if len(chs) != 1 {
+			return errors.Newf("channel-addresses: expected a single channel name, got %q", chName)
+		}
+		ch := chs[0]
+		if !hsc.Channels.AllChannels.HasChannel(ch) {
+			return errors.Newf("channel-addresses: channel %s is not selected by this sink", ch)
+		}
+		if _, ok := addrs[ch.String()]; ok {
+			return errors.Newf("channel-addresses: duplicate channel name: %q", chName)
+		}
+		if address == "" {
+			return errors.Newf("channel-addresses: address for channel %s cannot be empty", ch)
+		}
+		for _, a := range SplitHTTPSinkAddresses(address) {
+			if a == "" {
+				return errors.Newf("channel-addresses: empty address in list %q", address)
+			}
+			if err := validateHTTPSinkAddressPlaceholders(a); err != nil {
+				return errors.Wrap(err, "channel-addresses")
+			}
+		}
+		addrs[ch.String()] = address
+	}
+	hsc.ChannelAddresses = addrs
+	return nil
+}
+
+// validateHTTPSinkAddressPlaceholders checks that the placeholders in
+// the given HTTP sink address are well-formed and supported.
+func validateHTTPSinkAddressPlaceholders(address string) error {
+	rest := address
+	for {
+		i := strings.IndexAny(rest, "{}")
+		if i < 0 {
+			return nil
+		}
+		if rest[i] == '}' {
+			return errors.Newf("unbalanced '}' in address %q", address)
+		}
+		n := strings.IndexByte(rest[i:], '}')
+		if n < 0 {
+			return errors.Newf("unterminated placeholder in address %q", address)
+		}
+		switch p := rest[i : i+n+1]; p {
+		case HTTPSinkClusterIDPlaceholder, HTTPSinkNodeIDPlaceholder, HTTPSinkChannelPlaceholder:
+		default:
+			return errors.Newf("unknown placeholder %s in address %q, expected one of %s, %s or %s",
+				p, address, HTTPSinkClusterIDPlaceholder, HTTPSinkNodeIDPlaceholder,
+				HTTPSinkChannelPlaceholder)
+		}
+		rest = rest[i+n+1:]
+	}
+}
+
 func (c *Config) validateHTTPSinkConfig(hsc *HTTPSinkConfig) error {
 	propagateHTTPDefaults(&hsc.HTTPDefaults, c.HTTPDefaults)
 	if hsc.Address == nil || len(*hsc.Address) == 0 {
 		return errors.New("address cannot be empty")
 	}
-	if *hsc.Compression != GzipCompression && *hsc.Compression != NoneCompression {
-		return errors.New("compression must be 'gzip' or 'none'")
+	for _, address := range SplitHTTPSinkAddresses(*hsc.Address) {
+		if address == "" {
+			return errors.Newf("empty address in list %q", *hsc.Address)
+		}
+		if err := validateHTTPSinkAddressPlaceholders(address); err != nil {
+			return err
+		}
+	}
+	if hsc.CompressionLevel != nil &&
+		(*hsc.CompressionLevel < gzip.HuffmanOnly || *hsc.CompressionLevel > gzip.BestCompression) {
+		return errors.Newf("compression-level must be between %d and %d, got %d",
+			gzip.HuffmanOnly, gzip.BestCompression, *hsc.CompressionLevel)
+	}
+	if hsc.Proxy != nil {
+		u, err := url.Parse(*hsc.Proxy)
+		if err != nil {
+			return errors.Wrap(err, "invalid proxy")
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return errors.Newf("proxy scheme must be 'http', 'https' or 'socks5', got %q", u.Scheme)
+		}
+		if u.Host == "" {
+			return errors.Newf("proxy must include a host, got %q", *hsc.Proxy)
+		}
+	}
+	if hsc.DialTimeout != nil && *hsc.DialTimeout < 0 {
+		return errors.New("dial-timeout cannot be negative")
+	}
+	if hsc.ResponseHeaderTimeout != nil && *hsc.ResponseHeaderTimeout < 0 {
+		return errors.New("response-header-timeout cannot be negative")
+	}
+	if err := normalizeDir(&hsc.DeadLetterDir); err != nil {
+		return errors.Wrap(err, "dead-letter-dir")
+	}
+	if hsc.DeadLetterMaxSize != nil && *hsc.DeadLetterMaxSize == 0 {
+		return errors.New("dead-letter-max-size must be positive")
+	}
+	if hsc.DeadLetterReplay != nil && *hsc.DeadLetterReplay && hsc.DeadLetterDir == nil {
+		return errors.New("dead-letter-replay requires dead-letter-dir")
+	}
+	if hsc.ForceHTTP2 != nil && *hsc.ForceHTTP2 && *hsc.DisableKeepAlives {
+		return errors.New("force-http2 cannot be used together with disable-keep-alives")
+	}
+	if hsc.MaxInFlight != nil && *hsc.MaxInFlight < 0 {
+		return errors.New("max-in-flight cannot be negative")
+	}
+	if hsc.CircuitBreakerThreshold != nil && *hsc.CircuitBreakerThreshold < 0 {
+		return errors.New("circuit-breaker-threshold cannot be negative")
+	}
+	if hsc.CircuitBreakerCooldown != nil && *hsc.CircuitBreakerCooldown <= 0 {
+		return errors.New("circuit-breaker-cooldown must be positive")
+	}
+	if hsc.HeartbeatInterval != nil && *hsc.HeartbeatInterval < 0 {
+		return errors.New("heartbeat-interval cannot be negative")
+	}
+	if (hsc.ClientCert == nil) != (hsc.ClientKey == nil) {
+		return errors.New("client-cert and client-key must be specified together")
+	}
+	switch *hsc.Compression {
+	case GzipCompression, ZstdCompression, NoneCompression:
+	default:
+		return errors.Newf("compression must be 'gzip', 'zstd' or 'none', got %q", *hsc.Compression)
 	}
 	// If both header types are populated, make sure theres no duplicate keys
 	if hsc.Headers != nil && hsc.FileBasedHeaders != nil {
@@ -481,9 +795,167 @@ func (c *Config) validateHTTPSinkConfig(hsc *HTTPSinkConfig) error {
 			}
 		}
 	}
+	if hsc.IdempotencyKeyHeader != nil {
+		key := *hsc.IdempotencyKeyHeader
+		if key == "" {
+			return errors.New("idempotency-key-header cannot be empty")
+		}
+		_, inHeaders := hsc.Headers[key]
+		_, inFileBasedHeaders := hsc.FileBasedHeaders[key]
+		if inHeaders || inFileBasedHeaders {
+			return errors.Newf("idempotency-key-header %s is also set in headers or file-based-headers", key)
+		}
+	}
+	if hsc.Envelope != nil {
+		if !strings.HasPrefix(*hsc.Format, "json") {
+			return errors.Newf("envelope is only supported with the JSON formats, got %q", *hsc.Format)
+		}
+		if _, ok := hsc.Envelope[HTTPSinkEnvelopeRecordsKey]; ok {
+			return errors.Newf("envelope cannot contain the field %q", HTTPSinkEnvelopeRecordsKey)
+		}
+	}
+	if hsc.HeartbeatInterval != nil && *hsc.HeartbeatInterval > 0 && *hsc.Format == "otlp-json" {
+		// The heartbeat record is not an OTLP log record.
+		return errors.New("heartbeat-interval is not supported with the otlp-json format")
+	}
+	applyStripRedactionMarkers(&hsc.CommonSinkConfig)
 	return c.ValidateCommonSinkConfig(hsc.CommonSinkConfig)
 }
 
+func (c *Config) validateKafkaSinkConfig(kc *KafkaSinkConfig) error {
+	propagateKafkaDefaults(&kc.KafkaDefaults, c.KafkaDefaults)
+	if len(kc.Brokers) == 0 {
+		return errors.New("brokers cannot be empty")
+	}
+	for i, broker := range kc.Brokers {
+		broker = strings.TrimSpace(broker)
+		host, port, err := net.SplitHostPort(broker)
+		if err != nil {
+			return errors.Wrapf(err, "invalid broker address %q", broker)
+		}
+		if host == "" || port == "" {
+			return errors.Newf("invalid broker address %q: host and port are required", broker)
+		}
+		kc.Brokers[i] = broker
+	}
+	if kc.Topic == nil || len(*kc.Topic) == 0 {
+		return errors.New("topic cannot be empty")
+	}
+	if (kc.SASLUser == nil) != (kc.SASLPassword == nil) {
+		return errors.New("sasl-user and sasl-password must be specified together")
+	}
+	// Each log entry is produced as its own message, which a buffered
+	// batch of entries would break.
+	if !kc.Buffering.IsNone() {
+		return errors.New("buffering is not supported by kafka sinks")
+	}
+
+	// Apply the auditable flag if set.
+	if *kc.Auditable {
+		bt := true
+		kc.Criticality = &bt
+	}
+	kc.Auditable = nil
+	applyStripRedactionMarkers(&kc.CommonSinkConfig)
+
+	return c.ValidateCommonSinkConfig(kc.CommonSinkConfig)
+}
+
+func (c *Config) validateSyslogSinkConfig(sc *SyslogSinkConfig) error {
+	propagateSyslogDefaults(&sc.SyslogDefaults, c.SyslogDefaults)
+	sc.Net = strings.ToLower(strings.TrimSpace(sc.Net))
+	switch sc.Net {
+	case "tcp", "tcp4", "tcp6":
+	case "udp", "udp4", "udp6":
+	case "unix", "unixgram":
+	case "":
+		sc.Net = "udp"
+	default:
+		return errors.Newf("unknown protocol: %q", sc.Net)
+	}
+	sc.Address = strings.TrimSpace(sc.Address)
+	if sc.Address == "" {
+		return errors.New("empty address")
+	}
+	if *sc.TLS && !strings.HasPrefix(sc.Net, "tcp") {
+		return errors.Newf("tls is only supported with the tcp protocol, got %q", sc.Net)
+	}
+	facility := strings.ToLower(strings.TrimSpace(*sc.Facility))
+	if _, ok := SyslogFacilities[facility]; !ok {
+		return errors.Newf("unknown facility: %q", *sc.Facility)
+	}
+	sc.Facility = &facility
+	// RFC 5424 restricts APP-NAME to at most 48 printable ASCII
+	// characters.
+	if len(*sc.AppName) == 0 || len(*sc.AppName) > 48 {
+		return errors.Newf("app-name must be between 1 and 48 characters, got %q", *sc.AppName)
+	}
+	for _, r := range *sc.AppName {
+		if r <= ' ' || r > '~' {
+			return errors.Newf("app-name must only contain printable ASCII characters, got %q", *sc.AppName)
+		}
+	}
+	// Each log entry is sent as its own syslog message, which a buffered
+	// batch of entries would break.
+	if !sc.Buffering.IsNone() {
+		return errors.New("buffering is not supported by syslog sinks")
+	}
+
+	// Apply the auditable flag if set.
+	if *sc.Auditable {
+		bt := true
+		sc.Criticality = &bt
+	}
+	sc.Auditable = nil
+	applyStripRedactionMarkers(&sc.CommonSinkConfig)
+
+	return c.ValidateCommonSinkConfig(sc.CommonSinkConfig)
+}
+
+func (c *Config) validateGRPCOTLPSinkConfig(gc *GRPCOTLPSinkConfig) error {
+	propagateGRPCOTLPDefaults(&gc.GRPCOTLPDefaults, c.GRPCOTLPDefaults)
+	gc.Address = strings.TrimSpace(gc.Address)
+	if gc.Address == "" {
+		return errors.New("empty address")
+	}
+	host, port, err := net.SplitHostPort(gc.Address)
+	if err != nil {
+		return errors.Wrapf(err, "invalid address %q", gc.Address)
+	}
+	if host == "" || port == "" {
+		return errors.Newf("invalid address %q: host and port are required", gc.Address)
+	}
+	if *gc.Format != GRPCOTLPFormat {
+		return errors.Newf("unsupported format %q, only %q is supported", *gc.Format, GRPCOTLPFormat)
+	}
+	for k := range gc.Headers {
+		if k == "" {
+			return errors.New("header names cannot be empty")
+		}
+	}
+
+	// Apply the auditable flag if set.
+	if *gc.Auditable {
+		bt := true
+		gc.Criticality = &bt
+	}
+	gc.Auditable = nil
+	applyStripRedactionMarkers(&gc.CommonSinkConfig)
+
+	return c.ValidateCommonSinkConfig(gc.CommonSinkConfig)
+}
+
+// applyStripRedactionMarkers translates the strip-redaction-markers
+// flag, if set, into the redact and redactable settings it implies.
+func applyStripRedactionMarkers(c *CommonSinkConfig) {
+	if c.StripRedactionMarkers != nil && *c.StripRedactionMarkers {
+		bt, bf := true, false
+		c.Redact = &bt
+		c.Redactable = &bf
+	}
+	c.StripRedactionMarkers = nil
+}
+
 func normalizeDir(dir **string) error {
 	if *dir == nil {
 		return nil
@@ -518,6 +990,18 @@ func propagateHTTPDefaults(target *HTTPDefaults, source HTTPDefaults) {
 	propagateDefaults(target, source)
 }
 
+func propagateKafkaDefaults(target *KafkaDefaults, source KafkaDefaults) {
+	propagateDefaults(target, source)
+}
+
+func propagateSyslogDefaults(target *SyslogDefaults, source SyslogDefaults) {
+	propagateDefaults(target, source)
+}
+
+func propagateGRPCOTLPDefaults(target *GRPCOTLPDefaults, source GRPCOTLPDefaults) {
+	propagateDefaults(target, source)
+}
+
 // propagateDefaults takes (target *T, source T) where T is a struct
 // and sets zero-valued exported fields in target to the values
 // from source (recursively for struct-valued fields).
@@ -63,6 +63,9 @@ func clearExpectedValues(c *Config) {
 	c.FileDefaults = FileDefaults{}
 	c.FluentDefaults = FluentDefaults{}
 	c.HTTPDefaults = HTTPDefaults{}
+	c.KafkaDefaults = KafkaDefaults{}
+	c.SyslogDefaults = SyslogDefaults{}
+	c.GRPCOTLPDefaults = GRPCOTLPDefaults{}
 
 	for _, f := range c.Sinks.FileGroups {
 		if *f.Dir == "/default-dir" {
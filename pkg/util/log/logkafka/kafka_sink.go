@@ -0,0 +1,153 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+// Package logkafka implements the Kafka log sinks, configured in the
+// kafka-servers section of the logging configuration. It is separate from
+// pkg/util/log so that the logging package does not depend on the Kafka
+// client library. Importing it registers the sink via
+// log.RegisterKafkaSink.
+package logkafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+
+	"github.com/IBM/sarama"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+)
+
+func init() {
+	log.RegisterKafkaSink(func(sinkName string, c logconfig.KafkaSinkConfig) (log.ExternalSink, error) {
+		return newKafkaSink(sinkName, c)
+	})
+}
+
+// kafkaProducer is the subset of sarama.SyncProducer used by kafkaSink.
+type kafkaProducer interface {
+	SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error)
+	Close() error
+}
+
+var _ kafkaProducer = sarama.SyncProducer(nil)
+
+// kafkaProducerFactoryForTesting, if set, replaces the creation of the
+// producers of Kafka sinks.
+var kafkaProducerFactoryForTesting func() (kafkaProducer, error)
+
+// kafkaSink produces log entries as messages to a Kafka topic.
+type kafkaSink struct {
+	// sinkName is the name of the sink in the logging configuration.
+	sinkName string
+	topic    string
+	// newProducer creates the producer. It is called lazily, so that
+	// unavailable brokers do not prevent the logging configuration from
+	// being applied.
+	newProducer func() (kafkaProducer, error)
+
+	mu struct {
+		syncutil.Mutex
+		producer kafkaProducer
+	}
+}
+
+func newKafkaSink(sinkName string, c logconfig.KafkaSinkConfig) (*kafkaSink, error) {
+	saramaConfig, err := makeKafkaSinkSaramaConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	brokers := c.Brokers
+	ks := &kafkaSink{
+		sinkName: sinkName,
+		topic:    *c.Topic,
+		newProducer: func() (kafkaProducer, error) {
+			return sarama.NewSyncProducer(brokers, saramaConfig)
+		},
+	}
+	if kafkaProducerFactoryForTesting != nil {
+		ks.newProducer = kafkaProducerFactoryForTesting
+	}
+	return ks, nil
+}
+
+// makeKafkaSinkSaramaConfig returns the producer configuration for the
+// given sink.
+func makeKafkaSinkSaramaConfig(c logconfig.KafkaSinkConfig) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	// Required by the sync producer.
+	config.Producer.Return.Successes = true
+	config.Producer.Timeout = *c.Timeout
+	config.Net.DialTimeout = *c.Timeout
+	config.Net.ReadTimeout = *c.Timeout
+	config.Net.WriteTimeout = *c.Timeout
+
+	if *c.TLS {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = &tls.Config{InsecureSkipVerify: *c.UnsafeTLS}
+		if c.CACert != nil {
+			caPEM, err := os.ReadFile(*c.CACert)
+			if err != nil {
+				return nil, errors.Wrap(err, "reading CA certificate")
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caPEM) {
+				return nil, errors.Newf("no valid certificates found in %s", *c.CACert)
+			}
+			config.Net.TLS.Config.RootCAs = pool
+		}
+	}
+
+	if c.SASLUser != nil {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		config.Net.SASL.User = *c.SASLUser
+		config.Net.SASL.Password = *c.SASLPassword
+	}
+	return config, config.Validate()
+}
+
+var _ log.ExternalSink = (*kafkaSink)(nil)
+
+// Output implements the log.ExternalSink interface. Each log entry is
+// produced as its own message, since the sink is not buffered.
+func (ks *kafkaSink) Output(b []byte) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.mu.producer == nil {
+		p, err := ks.newProducer()
+		if err != nil {
+			return errors.Wrapf(err, "connecting to kafka brokers for sink %q", ks.sinkName)
+		}
+		ks.mu.producer = p
+	}
+	// The producer may retain the value until the message is sent, while
+	// b is reused by the caller.
+	value := make([]byte, len(b))
+	copy(value, b)
+	_, _, err := ks.mu.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: ks.topic,
+		Value: sarama.ByteEncoder(value),
+	})
+	return err
+}
+
+// Close implements the log.ExternalSink interface. It closes the
+// producer, if it was created.
+func (ks *kafkaSink) Close() {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.mu.producer != nil {
+		_ = ks.mu.producer.Close()
+		ks.mu.producer = nil
+	}
+}
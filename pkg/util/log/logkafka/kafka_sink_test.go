@@ -0,0 +1,113 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package logkafka
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/log/channel"
+	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/stretchr/testify/require"
+)
+
+// mockKafkaProducer is a kafkaProducer recording the produced messages.
+type mockKafkaProducer struct {
+	mu struct {
+		syncutil.Mutex
+		messages []*sarama.ProducerMessage
+		closed   bool
+	}
+}
+
+var _ kafkaProducer = (*mockKafkaProducer)(nil)
+
+func (p *mockKafkaProducer) SendMessage(
+	msg *sarama.ProducerMessage,
+) (partition int32, offset int64, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.messages = append(p.mu.messages, msg)
+	return 0, int64(len(p.mu.messages) - 1), nil
+}
+
+func (p *mockKafkaProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.closed = true
+	return nil
+}
+
+// TestKafkaSink verifies that log entries are produced as messages to the
+// configured topic.
+func TestKafkaSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := log.ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	producer := &mockKafkaProducer{}
+	kafkaProducerFactoryForTesting = func() (kafkaProducer, error) {
+		return producer, nil
+	}
+	defer func() { kafkaProducerFactoryForTesting = nil }()
+
+	topic := "cockroach-ops"
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.KafkaServers = map[string]*logconfig.KafkaSinkConfig{
+		"ops": {
+			KafkaDefaults: logconfig.KafkaDefaults{
+				Brokers: []string{"localhost:9092"},
+				Topic:   &topic,
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	logDir := sc.GetDirectory()
+	require.NoError(t, cfg.Validate(&logDir))
+
+	log.TestingResetActive()
+	cleanup, err := log.ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+	require.NoError(t, err)
+
+	const numMessages = 2
+	for i := 0; i < numMessages; i++ {
+		log.Ops.Infof(context.Background(), "kafka test message %d", i)
+	}
+
+	func() {
+		producer.mu.Lock()
+		defer producer.mu.Unlock()
+		var found int
+		for _, m := range producer.mu.messages {
+			require.Equal(t, topic, m.Topic)
+			value, err := m.Value.Encode()
+			require.NoError(t, err)
+			// Each message contains a single log entry.
+			require.Equal(t, 1, strings.Count(string(value), "\n"))
+			if strings.Contains(string(value), "kafka test message") {
+				found++
+			}
+		}
+		require.Equal(t, numMessages, found)
+	}()
+
+	// The producer is closed when the logging configuration is torn down.
+	cleanup()
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+	require.True(t, producer.mu.closed)
+}
@@ -11,8 +11,13 @@
 package logmetrics
 
 import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/metric/aggmetric"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/errors"
 	io_prometheus_client "github.com/prometheus/client_model/go"
 )
@@ -50,7 +55,14 @@ var (
 	}
 	bufferedSinkMessagesDropped = metric.Metadata{
 		Name:        "log.buffered.messages.dropped",
-		Help:        "Count of log messages that are dropped by buffered log sinks. When CRDB attempts to buffer a log message in a buffered log sink whose buffer is already full, it drops the oldest buffered messages to make space for the new message",
+		Help:        "Count of log messages that are dropped by buffered log sinks. When CRDB attempts to buffer a log message in a buffered log sink whose buffer is already full, it drops the oldest buffered messages to make space for the new message. Messages larger than the maximum buffer size are also dropped and counted",
+		Measurement: "Messages",
+		Unit:        metric.Unit_COUNT,
+		MetricType:  io_prometheus_client.MetricType_COUNTER,
+	}
+	bufferedSinkMessagesDroppedBySink = metric.Metadata{
+		Name:        "log.buffered.sink.messages.dropped",
+		Help:        "Count of log messages that are dropped by buffered log sinks, by sink, because the buffer is full or the messages are larger than the maximum buffer size",
 		Measurement: "Messages",
 		Unit:        metric.Unit_COUNT,
 		MetricType:  io_prometheus_client.MetricType_COUNTER,
@@ -62,6 +74,55 @@ var (
 		Unit:        metric.Unit_COUNT,
 		MetricType:  io_prometheus_client.MetricType_COUNTER,
 	}
+	httpSinkRequestsDropped = metric.Metadata{
+		Name:        "log.http.sink.requests.dropped",
+		Help:        "Number of requests dropped by http-server logging sinks since the limit on in-flight requests was reached",
+		Measurement: "Requests",
+		Unit:        metric.Unit_COUNT,
+		MetricType:  io_prometheus_client.MetricType_COUNTER,
+	}
+	httpSinkCircuitBreakerOpened = metric.Metadata{
+		Name:        "log.http.sink.circuit_breaker.opened",
+		Help:        "Number of times the circuit breaker of http-server logging sinks opened after repeated request failures",
+		Measurement: "Transitions",
+		Unit:        metric.Unit_COUNT,
+		MetricType:  io_prometheus_client.MetricType_COUNTER,
+	}
+	httpSinkCircuitBreakerClosed = metric.Metadata{
+		Name:        "log.http.sink.circuit_breaker.closed",
+		Help:        "Number of times the circuit breaker of http-server logging sinks closed after a successful probe request",
+		Measurement: "Transitions",
+		Unit:        metric.Unit_COUNT,
+		MetricType:  io_prometheus_client.MetricType_COUNTER,
+	}
+	httpSinkCircuitBreakerDropped = metric.Metadata{
+		Name:        "log.http.sink.circuit_breaker.dropped",
+		Help:        "Number of requests dropped by http-server logging sinks since their circuit breaker was open",
+		Measurement: "Requests",
+		Unit:        metric.Unit_COUNT,
+		MetricType:  io_prometheus_client.MetricType_COUNTER,
+	}
+	httpSinkFailovers = metric.Metadata{
+		Name:        "log.http.sink.failovers",
+		Help:        "Number of times http-server logging sinks switched to another of their configured addresses",
+		Measurement: "Failovers",
+		Unit:        metric.Unit_COUNT,
+		MetricType:  io_prometheus_client.MetricType_COUNTER,
+	}
+	httpSinkRequests = metric.Metadata{
+		Name:        "log.http.sink.requests",
+		Help:        "Number of requests sent by http-server logging sinks, by sink and by status class of the response (2xx, 4xx, 5xx, other, or error if there was no response)",
+		Measurement: "Requests",
+		Unit:        metric.Unit_COUNT,
+		MetricType:  io_prometheus_client.MetricType_COUNTER,
+	}
+	httpSinkRequestLatency = metric.Metadata{
+		Name:        "log.http.sink.request.latency",
+		Help:        "Latency of the requests sent by http-server logging sinks, by sink",
+		Measurement: "Latency",
+		Unit:        metric.Unit_NANOSECONDS,
+		MetricType:  io_prometheus_client.MetricType_HISTOGRAM,
+	}
 )
 
 // Inject our singleton logMetricsRegistry into the logging
@@ -85,6 +146,40 @@ type logMetricsRegistry struct {
 	// counters contains references to all the counters tracked by logMetricsRegistry,
 	// indexed by the log.Metric type.
 	counters []*metric.Counter
+
+	// httpSinkRequests and httpSinkRequestLatency track the requests of
+	// http-server sinks, labeled by sink name.
+	httpSinkRequests       *aggmetric.AggCounter
+	httpSinkRequestLatency *aggmetric.AggHistogram
+
+	// bufferedSinkMessagesDropped tracks the messages dropped by buffered
+	// sinks, labeled by sink name.
+	bufferedSinkMessagesDropped *aggmetric.AggCounter
+
+	mu struct {
+		syncutil.Mutex
+		// httpSinks contains the child metrics of each http-server sink,
+		// created on its first request.
+		httpSinks map[string]*httpSinkMetrics
+		// bufferedSinksDropped contains the child counter of each buffered
+		// sink, created on its first dropped message.
+		bufferedSinksDropped map[string]*aggmetric.Counter
+	}
+}
+
+// httpSinkMetrics are the child metrics of a single http-server sink.
+type httpSinkMetrics struct {
+	requests [len(httpSinkStatusClasses)]*aggmetric.Counter
+	latency  *aggmetric.Histogram
+}
+
+// httpSinkStatusClasses lists all the log.HTTPSinkStatusClass values.
+var httpSinkStatusClasses = [...]log.HTTPSinkStatusClass{
+	log.HTTPSinkStatus2xx,
+	log.HTTPSinkStatus4xx,
+	log.HTTPSinkStatus5xx,
+	log.HTTPSinkStatusOther,
+	log.HTTPSinkStatusError,
 }
 
 var _ log.LogMetrics = (*logMetricsRegistry)(nil)
@@ -92,13 +187,26 @@ var _ log.LogMetrics = (*logMetricsRegistry)(nil)
 func newLogMetricsRegistry() *logMetricsRegistry {
 	return &logMetricsRegistry{
 		counters: []*metric.Counter{
-			log.FluentSinkConnectionAttempt: metric.NewCounter(fluentSinkConnAttempts),
-			log.FluentSinkConnectionError:   metric.NewCounter(fluentSinkConnErrors),
-			log.FluentSinkWriteAttempt:      metric.NewCounter(fluentSinkWriteAttempts),
-			log.FluentSinkWriteError:        metric.NewCounter(fluentSinkWriteErrors),
-			log.BufferedSinkMessagesDropped: metric.NewCounter(bufferedSinkMessagesDropped),
-			log.LogMessageCount:             metric.NewCounter(logMessageCount),
+			log.FluentSinkConnectionAttempt:   metric.NewCounter(fluentSinkConnAttempts),
+			log.FluentSinkConnectionError:     metric.NewCounter(fluentSinkConnErrors),
+			log.FluentSinkWriteAttempt:        metric.NewCounter(fluentSinkWriteAttempts),
+			log.FluentSinkWriteError:          metric.NewCounter(fluentSinkWriteErrors),
+			log.BufferedSinkMessagesDropped:   metric.NewCounter(bufferedSinkMessagesDropped),
+			log.LogMessageCount:               metric.NewCounter(logMessageCount),
+			log.HTTPSinkRequestsDropped:       metric.NewCounter(httpSinkRequestsDropped),
+			log.HTTPSinkCircuitBreakerOpened:  metric.NewCounter(httpSinkCircuitBreakerOpened),
+			log.HTTPSinkCircuitBreakerClosed:  metric.NewCounter(httpSinkCircuitBreakerClosed),
+			log.HTTPSinkCircuitBreakerDropped: metric.NewCounter(httpSinkCircuitBreakerDropped),
+			log.HTTPSinkFailovers:             metric.NewCounter(httpSinkFailovers),
 		},
+		httpSinkRequests: aggmetric.NewCounter(httpSinkRequests, "sink", "status"),
+		httpSinkRequestLatency: aggmetric.NewHistogram(metric.HistogramOptions{
+			Mode:         metric.HistogramModePreferHdrLatency,
+			Metadata:     httpSinkRequestLatency,
+			Duration:     base.DefaultHistogramWindowInterval(),
+			BucketConfig: metric.IOLatencyBuckets,
+		}, "sink"),
+		bufferedSinkMessagesDropped: aggmetric.NewCounter(bufferedSinkMessagesDroppedBySink, "sink"),
 	}
 }
 
@@ -116,6 +224,9 @@ func NewRegistry() *metric.Registry {
 	for _, c := range logMetricsReg.counters {
 		reg.AddMetric(c)
 	}
+	reg.AddMetric(logMetricsReg.httpSinkRequests)
+	reg.AddMetric(logMetricsReg.httpSinkRequestLatency)
+	reg.AddMetric(logMetricsReg.bufferedSinkMessagesDropped)
 	return reg
 }
 
@@ -125,3 +236,55 @@ func NewRegistry() *metric.Registry {
 func (l *logMetricsRegistry) IncrementCounter(metric log.Metric, amount int64) {
 	l.counters[metric].Inc(amount)
 }
+
+// RecordHTTPSinkRequest implements the log.LogMetrics interface.
+func (l *logMetricsRegistry) RecordHTTPSinkRequest(
+	sinkName string, status log.HTTPSinkStatusClass, latency time.Duration,
+) {
+	m := l.getHTTPSinkMetrics(sinkName)
+	m.requests[status].Inc(1)
+	m.latency.RecordValue(latency.Nanoseconds())
+}
+
+// getHTTPSinkMetrics returns the child metrics of the given http-server
+// sink, creating them if needed.
+func (l *logMetricsRegistry) getHTTPSinkMetrics(sinkName string) *httpSinkMetrics {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if m, ok := l.mu.httpSinks[sinkName]; ok {
+		return m
+	}
+	m := &httpSinkMetrics{
+		latency: l.httpSinkRequestLatency.AddChild(sinkName),
+	}
+	for _, status := range httpSinkStatusClasses {
+		m.requests[status] = l.httpSinkRequests.AddChild(sinkName, status.String())
+	}
+	if l.mu.httpSinks == nil {
+		l.mu.httpSinks = make(map[string]*httpSinkMetrics)
+	}
+	l.mu.httpSinks[sinkName] = m
+	return m
+}
+
+// RecordBufferedSinkMessagesDropped implements the log.LogMetrics
+// interface.
+func (l *logMetricsRegistry) RecordBufferedSinkMessagesDropped(sinkName string, count int64) {
+	l.getBufferedSinkDroppedCounter(sinkName).Inc(count)
+}
+
+// getBufferedSinkDroppedCounter returns the child counter of the given
+// buffered sink, creating it if needed.
+func (l *logMetricsRegistry) getBufferedSinkDroppedCounter(sinkName string) *aggmetric.Counter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c, ok := l.mu.bufferedSinksDropped[sinkName]; ok {
+		return c
+	}
+	c := l.bufferedSinkMessagesDropped.AddChild(sinkName)
+	if l.mu.bufferedSinksDropped == nil {
+		l.mu.bufferedSinksDropped = make(map[string]*aggmetric.Counter)
+	}
+	l.mu.bufferedSinksDropped[sinkName] = c
+	return c
+}
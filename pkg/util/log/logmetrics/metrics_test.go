@@ -12,6 +12,7 @@ package logmetrics
 
 import (
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
@@ -49,3 +50,34 @@ func TestNewRegistry(t *testing.T) {
 			}, "expected NewRegistry() to panic with nil logMetricsReg package-level var")
 	})
 }
+
+func TestRecordHTTPSinkRequest(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	l := newLogMetricsRegistry()
+	l.RecordHTTPSinkRequest("a", log.HTTPSinkStatus5xx, time.Millisecond)
+	l.RecordHTTPSinkRequest("a", log.HTTPSinkStatus5xx, time.Millisecond)
+	l.RecordHTTPSinkRequest("b", log.HTTPSinkStatus2xx, time.Millisecond)
+
+	require.Equal(t, int64(2), l.mu.httpSinks["a"].requests[log.HTTPSinkStatus5xx].Value())
+	require.Zero(t, l.mu.httpSinks["a"].requests[log.HTTPSinkStatus2xx].Value())
+	require.Equal(t, int64(1), l.mu.httpSinks["b"].requests[log.HTTPSinkStatus2xx].Value())
+	require.Equal(t, int64(3), l.httpSinkRequests.Count())
+	count, _ := l.httpSinkRequestLatency.CumulativeSnapshot().Total()
+	require.Equal(t, int64(3), count)
+}
+
+func TestRecordBufferedSinkMessagesDropped(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	l := newLogMetricsRegistry()
+	l.RecordBufferedSinkMessagesDropped("a", 2)
+	l.RecordBufferedSinkMessagesDropped("a", 3)
+	l.RecordBufferedSinkMessagesDropped("b", 1)
+
+	require.Equal(t, int64(5), l.mu.bufferedSinksDropped["a"].Value())
+	require.Equal(t, int64(1), l.mu.bufferedSinksDropped["b"].Value())
+	require.Equal(t, int64(6), l.bufferedSinkMessagesDropped.Count())
+}
@@ -0,0 +1,164 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+// Package logotlp implements the gRPC OTLP log sinks, configured in the
+// grpc-otlp-servers section of the logging configuration. It is separate from
+// pkg/util/log so that the logging package does not depend on gRPC.
+// Importing it registers the sink via log.RegisterGRPCOTLPSink.
+package logotlp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"time"
+
+	otlpcollectorpb "github.com/cockroachdb/cockroach/pkg/obsservice/obspb/opentelemetry-proto/collector/logs/v1"
+	otlpcommonpb "github.com/cockroachdb/cockroach/pkg/obsservice/obspb/opentelemetry-proto/common/v1"
+	otlplogspb "github.com/cockroachdb/cockroach/pkg/obsservice/obspb/opentelemetry-proto/logs/v1"
+	otlpresourcepb "github.com/cockroachdb/cockroach/pkg/obsservice/obspb/opentelemetry-proto/resource/v1"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+func init() {
+	log.RegisterGRPCOTLPSink(func(sinkName string, c logconfig.GRPCOTLPSinkConfig) (log.ExternalSink, error) {
+		return newGRPCOTLPSink(sinkName, c)
+	})
+}
+
+// grpcOTLPSink sends log entries to an OpenTelemetry collector, using
+// the OTLP/gRPC protocol.
+//
+// The log package formats each entry as an OTLP log record, in the
+// protobuf encoding of a ScopeLogs message. The buffered entries are
+// flushed as the concatenation of their encodings, which is itself the
+// encoding of a ScopeLogs message, and sent in a single export request.
+type grpcOTLPSink struct {
+	// sinkName is the name of the sink in the logging configuration.
+	sinkName string
+	address  string
+	timeout  time.Duration
+	// md is attached to each export request.
+	md       metadata.MD
+	dialOpts []grpc.DialOption
+	resource *otlpresourcepb.Resource
+
+	mu struct {
+		syncutil.Mutex
+		// conn is created lazily, so that an unavailable collector does
+		// not prevent the logging configuration from being applied.
+		conn   *grpc.ClientConn
+		client otlpcollectorpb.LogsServiceClient
+	}
+}
+
+var _ log.ExternalSink = (*grpcOTLPSink)(nil)
+
+func newGRPCOTLPSink(sinkName string, c logconfig.GRPCOTLPSinkConfig) (*grpcOTLPSink, error) {
+	gs := &grpcOTLPSink{
+		sinkName: sinkName,
+		address:  c.Address,
+		timeout:  *c.Timeout,
+		md:       metadata.New(c.Headers),
+		resource: &otlpresourcepb.Resource{
+			Attributes: []*otlpcommonpb.KeyValue{{
+				Key:   "service.name",
+				Value: &otlpcommonpb.AnyValue{Value: &otlpcommonpb.AnyValue_StringValue{StringValue: filepath.Base(os.Args[0])}},
+			}},
+		},
+	}
+	creds := insecure.NewCredentials()
+	if *c.TLS {
+		tlsConfig := &tls.Config{InsecureSkipVerify: *c.UnsafeTLS}
+		if c.CACert != nil {
+			caPEM, err := os.ReadFile(*c.CACert)
+			if err != nil {
+				return nil, errors.Wrap(err, "reading CA certificate")
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caPEM) {
+				return nil, errors.Newf("no valid certificates found in %s", *c.CACert)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	gs.dialOpts = []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	return gs, nil
+}
+
+// Output implements the log.ExternalSink interface. b is the encoding of
+// a ScopeLogs message holding the log records of the entries.
+func (gs *grpcOTLPSink) Output(b []byte) error {
+	scopeLogs := &otlplogspb.ScopeLogs{}
+	if err := protoutil.Unmarshal(b, scopeLogs); err != nil {
+		return errors.Wrapf(err, "decoding log records for sink %q", gs.sinkName)
+	}
+	if len(scopeLogs.LogRecords) == 0 {
+		return nil
+	}
+	scopeLogs.Scope = &otlpcommonpb.InstrumentationScope{Name: "cockroach"}
+	req := &otlpcollectorpb.ExportLogsServiceRequest{
+		ResourceLogs: []*otlplogspb.ResourceLogs{{
+			Resource:  gs.resource,
+			ScopeLogs: []*otlplogspb.ScopeLogs{scopeLogs},
+		}},
+	}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.mu.conn == nil {
+		// Note that Dial is non-blocking.
+		conn, err := grpc.Dial(gs.address, gs.dialOpts...)
+		if err != nil {
+			return errors.Wrapf(err, "connecting to OTLP collector for sink %q", gs.sinkName)
+		}
+		gs.mu.conn = conn
+		gs.mu.client = otlpcollectorpb.NewLogsServiceClient(conn)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gs.timeout)
+	defer cancel()
+	if len(gs.md) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, gs.md)
+	}
+	// Wait for the connection to be ready, within the timeout, rather
+	// than failing while the collector is being dialed.
+	resp, err := gs.mu.client.Export(ctx, req, grpc.WaitForReady(true))
+	if err != nil {
+		return err
+	}
+	if ps := resp.PartialSuccess; ps != nil && ps.RejectedLogRecords > 0 {
+		return errors.Newf("OTLP collector rejected %d log records: %s",
+			ps.RejectedLogRecords, ps.ErrorMessage)
+	}
+	return nil
+}
+
+// Close implements the log.ExternalSink interface. It closes the
+// connection to the collector, if it was created.
+func (gs *grpcOTLPSink) Close() {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.mu.conn != nil {
+		_ = gs.mu.conn.Close() // nolint:grpcconnclose
+		gs.mu.conn = nil
+		gs.mu.client = nil
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package logotlp
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	otlpcollectorpb "github.com/cockroachdb/cockroach/pkg/obsservice/obspb/opentelemetry-proto/collector/logs/v1"
+	otlplogspb "github.com/cockroachdb/cockroach/pkg/obsservice/obspb/opentelemetry-proto/logs/v1"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/log/channel"
+	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// testOTLPCollector is an OTLP logs service recording the export
+// requests it receives.
+type testOTLPCollector struct {
+	mu struct {
+		syncutil.Mutex
+		requests []*otlpcollectorpb.ExportLogsServiceRequest
+		md       []metadata.MD
+	}
+}
+
+var _ otlpcollectorpb.LogsServiceServer = (*testOTLPCollector)(nil)
+
+func (c *testOTLPCollector) Export(
+	ctx context.Context, req *otlpcollectorpb.ExportLogsServiceRequest,
+) (*otlpcollectorpb.ExportLogsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mu.requests = append(c.mu.requests, req)
+	c.mu.md = append(c.mu.md, md)
+	return &otlpcollectorpb.ExportLogsServiceResponse{}, nil
+}
+
+// TestGRPCOTLPSink verifies that the buffered log entries are sent to
+// the collector as log records of a single export request, along with
+// the configured headers.
+func TestGRPCOTLPSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := log.ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	collector := &testOTLPCollector{}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	otlpcollectorpb.RegisterLogsServiceServer(srv, collector)
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.GRPCOTLPServers = map[string]*logconfig.GRPCOTLPSinkConfig{
+		"collector": {
+			Address: lis.Addr().String(),
+			GRPCOTLPDefaults: logconfig.GRPCOTLPDefaults{
+				Headers: map[string]string{"authorization": "Bearer test"},
+			},
+			Channels: logconfig.SelectChannels(channel.DEV),
+		},
+	}
+	logDir := sc.GetDirectory()
+	require.NoError(t, cfg.Validate(&logDir))
+
+	log.TestingResetActive()
+	cleanup, err := log.ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+	require.NoError(t, err)
+	defer cleanup()
+
+	ctx := context.Background()
+	log.Dev.Infof(ctx, "otlp info message")
+	log.Dev.Warningf(ctx, "otlp warning message")
+	log.FlushAllSync()
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	require.Len(t, collector.mu.requests, 1)
+	require.Equal(t, []string{"Bearer test"}, collector.mu.md[0].Get("authorization"))
+
+	resourceLogs := collector.mu.requests[0].ResourceLogs
+	require.Len(t, resourceLogs, 1)
+	require.Len(t, resourceLogs[0].ScopeLogs, 1)
+	records := resourceLogs[0].ScopeLogs[0].LogRecords
+	require.Len(t, records, 2)
+
+	for i, exp := range []struct {
+		body     string
+		severity otlplogspb.SeverityNumber
+	}{
+		{"otlp info message", otlplogspb.SeverityNumber_SEVERITY_NUMBER_INFO},
+		{"otlp warning message", otlplogspb.SeverityNumber_SEVERITY_NUMBER_WARN},
+	} {
+		r := records[i]
+		require.Equal(t, exp.body, r.Body.GetStringValue())
+		require.Equal(t, exp.severity, r.SeverityNumber)
+		require.NotZero(t, r.TimeUnixNano)
+		var ch string
+		for _, a := range r.Attributes {
+			if a.Key == "crdb.channel" {
+				ch = a.Value.GetStringValue()
+			}
+		}
+		require.Equal(t, channel.DEV.String(), ch)
+	}
+}
@@ -10,6 +10,8 @@
 
 package log
 
+import "time"
+
 // LogMetrics enables the registration and recording of metrics
 // within the log package.
 //
@@ -30,6 +32,16 @@ type LogMetrics interface {
 	// for the given MetricName within its own scope. See
 	// pkg/util/log/logmetrics for details.
 	IncrementCounter(metric Metric, amount int64)
+
+	// RecordHTTPSinkRequest records the latency and the status class of
+	// a request sent by the http-server sink with the given name in the
+	// logging configuration.
+	RecordHTTPSinkRequest(sinkName string, status HTTPSinkStatusClass, latency time.Duration)
+
+	// RecordBufferedSinkMessagesDropped records messages dropped by the
+	// buffered sink with the given name in the logging configuration,
+	// because its buffer was full or they exceeded its maximum size.
+	RecordBufferedSinkMessagesDropped(sinkName string, count int64)
 }
 
 // Metric is the enum representation of each metric supported within the log package.
@@ -44,4 +56,43 @@ const (
 	FluentSinkWriteError
 	BufferedSinkMessagesDropped
 	LogMessageCount
+	HTTPSinkRequestsDropped
+	HTTPSinkCircuitBreakerOpened
+	HTTPSinkCircuitBreakerClosed
+	HTTPSinkCircuitBreakerDropped
+	HTTPSinkFailovers
 )
+
+// HTTPSinkStatusClass classifies the outcome of a request sent by an
+// http-server sink.
+type HTTPSinkStatusClass int
+
+const (
+	// HTTPSinkStatus2xx is a successful response.
+	HTTPSinkStatus2xx HTTPSinkStatusClass = iota
+	// HTTPSinkStatus4xx is a client error response.
+	HTTPSinkStatus4xx
+	// HTTPSinkStatus5xx is a server error response.
+	HTTPSinkStatus5xx
+	// HTTPSinkStatusOther is any other response, e.g. a redirection
+	// which was not followed.
+	HTTPSinkStatusOther
+	// HTTPSinkStatusError is a request which did not get a response,
+	// e.g. because of a connection error or a timeout.
+	HTTPSinkStatusError
+)
+
+// httpSinkStatusClassNames are the names of the status classes, used
+// as metric labels.
+var httpSinkStatusClassNames = [...]string{
+	HTTPSinkStatus2xx:   "2xx",
+	HTTPSinkStatus4xx:   "4xx",
+	HTTPSinkStatus5xx:   "5xx",
+	HTTPSinkStatusOther: "other",
+	HTTPSinkStatusError: "error",
+}
+
+// String implements fmt.Stringer.
+func (c HTTPSinkStatusClass) String() string {
+	return httpSinkStatusClassNames[c]
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
+)
+
+// makeFormatKey returns a string identifying the format and format
+// options of the given sink configuration. Sinks with the same format
+// key use equivalent formatters.
+func makeFormatKey(c logconfig.CommonSinkConfig) string {
+	var b strings.Builder
+	b.WriteString(*c.Format)
+	keys := make([]string, 0, len(c.FormatOptions))
+	for k := range c.FormatOptions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// The separators cannot appear in the format name, and are
+		// unlikely in the option names and values.
+		b.WriteByte('\x00')
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(c.FormatOptions[k])
+	}
+	return b.String()
+}
+
+// sharesFormatWith returns true if l formats the entries identically to
+// o, so that a formatted entry can be shared between the two sinks. An
+// entry must also be formatted with the same counter to be shared, which
+// is the case when the sinks have received the same entries so far.
+func (l *sinkInfo) sharesFormatWith(o *sinkInfo) bool {
+	return l.formatKey != "" && l.formatKey == o.formatKey &&
+		l.redact == o.redact && l.redactable == o.redactable &&
+		l.maxEntrySize == o.maxEntrySize && (l.locality == nil) == (o.locality == nil)
+}
+
+// findSharedFormat returns the index of a sink preceding the i-th sink
+// of l, whose formatted entry in bufs can be output to the i-th sink
+// as-is, or -1 if there is none.
+func (l *loggerT) findSharedFormat(bufs *bufferSlice, i int, counter uint64) int {
+	s := l.sinkInfos[i]
+	if s.formatKey == "" {
+		return -1
+	}
+	for j := 0; j < i; j++ {
+		if bufs.b[j] != nil && bufs.counters[j] == counter && s.sharesFormatWith(l.sinkInfos[j]) {
+			return j
+		}
+	}
+	return -1
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/cli/exit"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log/channel"
+	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
+	"github.com/cockroachdb/cockroach/pkg/util/log/severity"
+	"github.com/stretchr/testify/require"
+)
+
+// discardSink is a log sink that drops the entries.
+type discardSink struct{}
+
+func (discardSink) active() bool                                  { return true }
+func (discardSink) attachHints(stacks []byte) []byte              { return stacks }
+func (discardSink) output(b []byte, opts sinkOutputOptions) error { return nil }
+func (discardSink) exitCode() exit.Code                           { return exit.UnspecifiedError() }
+
+// newTestSinkInfo returns a sinkInfo accepting all the entries, and
+// formatting them with the given format and format options.
+func newTestSinkInfo(tb testing.TB, format string, options map[string]string) *sinkInfo {
+	f := false
+	si := &sinkInfo{sink: discardSink{}}
+	require.NoError(tb, si.applyConfig(logconfig.CommonSinkConfig{
+		Format:        &format,
+		FormatOptions: options,
+		Redact:        &f,
+		Redactable:    &f,
+		Criticality:   &f,
+	}))
+	si.threshold.setAll(severity.INFO)
+	return si
+}
+
+func TestFindSharedFormat(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	l := &loggerT{sinkInfos: []*sinkInfo{
+		newTestSinkInfo(t, "crdb-v2", nil),
+		newTestSinkInfo(t, "json", nil),
+		newTestSinkInfo(t, "crdb-v2", nil),
+		newTestSinkInfo(t, "crdb-v2", map[string]string{"colors": "ansi"}),
+	}}
+	bufs := getBufferSlice(len(l.sinkInfos))
+	defer putBufferSlice(bufs)
+	for i := 0; i < 2; i++ {
+		bufs.b[i] = getBuffer()
+		bufs.counters[i] = 1
+	}
+
+	// The entry formatted for the first sink can be reused.
+	require.Equal(t, 0, l.findSharedFormat(bufs, 2, 1 /* counter */))
+	// Not if the entries have different counters.
+	require.Equal(t, -1, l.findSharedFormat(bufs, 2, 2 /* counter */))
+	// Nor if the format options differ.
+	require.Equal(t, -1, l.findSharedFormat(bufs, 3, 1 /* counter */))
+	// Nor if only one of the sinks includes the locality.
+	l.sinkInfos[2].locality = makeLocalityTags("region=us-east1")
+	require.Equal(t, -1, l.findSharedFormat(bufs, 2, 1 /* counter */))
+	l.sinkInfos[2].locality = nil
+	// Nor if the redaction differs.
+	l.sinkInfos[2].redactable = true
+	require.Equal(t, -1, l.findSharedFormat(bufs, 2, 1 /* counter */))
+}
+
+// BenchmarkOutputLogEntryFanOut measures the cost of outputting an entry
+// to three sinks using the same format, when the formatted entry is
+// shared between the sinks, compared to when it is not.
+func BenchmarkOutputLogEntryFanOut(b *testing.B) {
+	defer TestingResetActive()
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name   string
+		shared bool
+	}{
+		{name: "unshared", shared: false},
+		{name: "shared", shared: true},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			l := &loggerT{}
+			for i := 0; i < 3; i++ {
+				si := newTestSinkInfo(b, "json", nil)
+				if !tc.shared {
+					si.formatKey = ""
+				}
+				l.sinkInfos = append(l.sinkInfos, si)
+			}
+			entry := makeUnstructuredEntry(ctx, severity.INFO, channel.DEV, 0,
+				true /* redactable */, "hello %s", "world")
+			b.ResetTimer()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				l.outputLogEntry(entry)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"math/rand"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log/severity"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// defaultSampleExemptSeverity is the minimum severity of the entries
+// that are never dropped by sampling, if not configured otherwise.
+const defaultSampleExemptSeverity = severity.WARNING
+
+// sinkSamplerSeedForTesting, if non-zero, is used to seed the random
+// number generators of the sink samplers, so that tests can rely on a
+// deterministic selection of entries.
+var sinkSamplerSeedForTesting int64
+
+// sinkSampler selects the fraction of log entries forwarded to a sink
+// configured with a sample rate.
+type sinkSampler struct {
+	rate float64
+	// exemptSeverity is the minimum severity of the entries that are
+	// always forwarded.
+	exemptSeverity Severity
+
+	mu struct {
+		syncutil.Mutex
+		rng *rand.Rand
+	}
+}
+
+func newSinkSampler(rate float64, exemptSeverity Severity) *sinkSampler {
+	if exemptSeverity == severity.UNKNOWN {
+		exemptSeverity = defaultSampleExemptSeverity
+	}
+	seed := sinkSamplerSeedForTesting
+	if seed == 0 {
+		seed = timeutil.Now().UnixNano()
+	}
+	s := &sinkSampler{rate: rate, exemptSeverity: exemptSeverity}
+	s.mu.rng = rand.New(rand.NewSource(seed))
+	return s
+}
+
+// shouldForward returns true if an entry at the given severity should
+// be forwarded to the sink.
+func (s *sinkSampler) shouldForward(sev Severity) bool {
+	if sev >= s.exemptSeverity {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mu.rng.Float64() < s.rate
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cockroachdb/redact"
+)
+
+// truncatedMessageSuffix is appended to the messages truncated to the
+// maximum entry size of a sink.
+const truncatedMessageSuffix = "...[truncated %d bytes]"
+
+// formatEntry formats the entry for this sink. If the sink has a
+// maximum entry size and the formatted entry exceeds it, the message of
+// the entry is truncated so that the entry fits.
+//
+// The truncation is performed on the message before formatting, so
+// that the output of the formatter remains well-formed (e.g. valid
+// JSON). Since formatters may escape the message, the truncation is
+// repeated until the entry fits or the message is reduced to the
+// truncation suffix.
+func (l *sinkInfo) formatEntry(entry logEntry) *buffer {
+	buf := l.formatter.formatEntry(entry)
+	if l.maxEntrySize <= 0 || buf.Len() <= l.maxEntrySize {
+		return buf
+	}
+
+	msg := entry.payload.message
+	if entry.structured {
+		// The payload of a structured entry cannot be truncated while
+		// preserving its structure. Report it as a flat message instead.
+		msg = "{" + msg + "}"
+		entry.structured = false
+	}
+	keep := len(msg)
+	for buf.Len() > l.maxEntrySize && keep > 0 {
+		keep -= buf.Len() - l.maxEntrySize
+		if keep < 0 {
+			keep = 0
+		}
+		putBuffer(buf)
+		entry.payload.message = truncateMessage(msg, keep, entry.payload.redactable)
+		buf = l.formatter.formatEntry(entry)
+	}
+	return buf
+}
+
+// truncateMessage returns the first keep bytes of msg, followed by a
+// suffix indicating the number of bytes removed. The message is cut
+// at a rune boundary, and the redaction markers are kept balanced if
+// the message is redactable.
+func truncateMessage(msg string, keep int, redactable bool) string {
+	for keep > 0 && keep < len(msg) && !utf8.RuneStart(msg[keep]) {
+		keep--
+	}
+	kept := msg[:keep]
+	if redactable {
+		startMarker, endMarker := string(redact.StartMarker()), string(redact.EndMarker())
+		if strings.LastIndex(kept, startMarker) > strings.LastIndex(kept, endMarker) {
+			kept += endMarker
+		}
+	}
+	return kept + fmt.Sprintf(truncatedMessageSuffix, len(msg)-keep)
+}
@@ -82,4 +82,6 @@ var _ logSink = (*stderrSink)(nil)
 var _ logSink = (*fileSink)(nil)
 var _ logSink = (*fluentSink)(nil)
 var _ logSink = (*httpSink)(nil)
+var _ logSink = (*externalSink)(nil)
+var _ logSink = (*syslogSink)(nil)
 var _ logSink = (*bufferedSink)(nil)
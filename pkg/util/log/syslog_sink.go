@@ -0,0 +1,240 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/cli/exit"
+	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
+	"github.com/cockroachdb/cockroach/pkg/util/log/severity"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// syslogTimestampFormat is the TIMESTAMP format of RFC 5424 messages.
+const syslogTimestampFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// syslogSeverity returns the RFC 5424 severity code for the given
+// severity.
+func syslogSeverity(sev Severity) int {
+	switch sev {
+	case severity.FATAL:
+		return 2 // critical
+	case severity.ERROR:
+		return 3 // error
+	case severity.WARNING:
+		return 4 // warning
+	case severity.INFO:
+		return 6 // informational
+	default:
+		return 5 // notice
+	}
+}
+
+// formatSyslog wraps the formatter configured for a syslog sink, and
+// prepends the RFC 5424 header to each formatted entry.
+type formatSyslog struct {
+	inner    logFormatter
+	facility int
+	appName  string
+	hostname string
+	procID   string
+}
+
+func newFormatSyslog(inner logFormatter, c logconfig.SyslogSinkConfig) *formatSyslog {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &formatSyslog{
+		inner:    inner,
+		facility: logconfig.SyslogFacilities[*c.Facility],
+		appName:  *c.AppName,
+		hostname: hostname,
+		procID:   strconv.Itoa(os.Getpid()),
+	}
+}
+
+// formatterName reports the name of the wrapped formatter, which is
+// the one named in the configuration.
+func (f *formatSyslog) formatterName() string { return f.inner.formatterName() }
+
+func (f *formatSyslog) doc() string { return f.inner.doc() }
+
+func (f *formatSyslog) setOption(key string, value string) error {
+	return f.inner.setOption(key, value)
+}
+
+func (f *formatSyslog) contentType() string { return f.inner.contentType() }
+
+func (f *formatSyslog) formatEntry(entry logEntry) *buffer {
+	msg := f.inner.formatEntry(entry)
+	defer putBuffer(msg)
+
+	// The MSGID field reports the logging channel. Header entries are
+	// not reported on a particular channel.
+	msgID := "-"
+	if !entry.header {
+		msgID = entry.ch.String()
+	}
+	buf := getBuffer()
+	fmt.Fprintf(buf, "<%d>1 %s %s %s %s %s - ",
+		f.facility*8+syslogSeverity(entry.sev),
+		timeutil.Unix(0, entry.ts).UTC().Format(syslogTimestampFormat),
+		f.hostname, f.appName, f.procID, msgID)
+	buf.Write(bytes.TrimRight(msg.Bytes(), "\n"))
+	return buf
+}
+
+// syslogSink sends log entries to a syslog server.
+type syslogSink struct {
+	// sinkName is the name of the sink in the logging configuration.
+	sinkName string
+	network  string
+	addr     string
+	config   *logconfig.SyslogSinkConfig
+	// tlsConfig is set if the connection uses TLS.
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	// octetCounting is set for stream-oriented protocols, where messages
+	// are framed by prefixing them with their length (RFC 6587).
+	octetCounting bool
+
+	mu struct {
+		syncutil.Mutex
+		conn net.Conn
+	}
+}
+
+func newSyslogSink(sinkName string, c logconfig.SyslogSinkConfig) (*syslogSink, error) {
+	ss := &syslogSink{
+		sinkName:      sinkName,
+		network:       c.Net,
+		addr:          c.Address,
+		config:        &c,
+		timeout:       *c.Timeout,
+		octetCounting: !strings.HasPrefix(c.Net, "udp") && c.Net != "unixgram",
+	}
+	if *c.TLS {
+		ss.tlsConfig = &tls.Config{InsecureSkipVerify: *c.UnsafeTLS}
+		if c.CACert != nil {
+			caPEM, err := os.ReadFile(*c.CACert)
+			if err != nil {
+				return nil, errors.Wrap(err, "reading CA certificate")
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caPEM) {
+				return nil, errors.Newf("no valid certificates found in %s", *c.CACert)
+			}
+			ss.tlsConfig.RootCAs = pool
+		}
+	}
+	return ss, nil
+}
+
+func (ss *syslogSink) String() string {
+	return fmt.Sprintf("syslog:%s://%s", ss.network, ss.addr)
+}
+
+// active returns true if this sink is currently active.
+func (*syslogSink) active() bool {
+	return true
+}
+
+// attachHints attaches some hints about the location of the message
+// to the stack message.
+func (*syslogSink) attachHints(stacks []byte) []byte {
+	return stacks
+}
+
+// output emits some formatted bytes to this sink.
+// the sink is invited to perform an extra flush if indicated
+// by the argument. This is set to true for e.g. Fatal
+// entries.
+//
+// The parent logger's outputMu is held during this operation: log
+// sinks must not recursively call into logging when implementing
+// this method.
+func (ss *syslogSink) output(b []byte, opts sinkOutputOptions) error {
+	if ss.octetCounting {
+		framed := make([]byte, 0, len(b)+8)
+		framed = strconv.AppendInt(framed, int64(len(b)), 10)
+		framed = append(framed, ' ')
+		b = append(framed, b...)
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	// Try to write and reconnect immediately if the first write fails.
+	if ss.mu.conn != nil {
+		if err := ss.tryWriteLocked(b); err == nil {
+			return nil
+		}
+		ss.closeLocked()
+	}
+	if err := ss.dialLocked(); err != nil {
+		return errors.Wrapf(err, "%s: dialing syslog server", ss)
+	}
+	if err := ss.tryWriteLocked(b); err != nil {
+		ss.closeLocked()
+		return errors.Wrapf(err, "%s: writing to syslog server", ss)
+	}
+	return nil
+}
+
+func (ss *syslogSink) dialLocked() error {
+	dialer := &net.Dialer{Timeout: ss.timeout}
+	var err error
+	if ss.tlsConfig != nil {
+		ss.mu.conn, err = tls.DialWithDialer(dialer, ss.network, ss.addr, ss.tlsConfig)
+	} else {
+		ss.mu.conn, err = dialer.Dial(ss.network, ss.addr)
+	}
+	return err
+}
+
+func (ss *syslogSink) tryWriteLocked(b []byte) error {
+	if err := ss.mu.conn.SetWriteDeadline(timeutil.Now().Add(ss.timeout)); err != nil {
+		return err
+	}
+	_, err := ss.mu.conn.Write(b)
+	return err
+}
+
+func (ss *syslogSink) closeLocked() {
+	if ss.mu.conn != nil {
+		_ = ss.mu.conn.Close()
+		ss.mu.conn = nil
+	}
+}
+
+// exitCode returns the exit code to use if the logger decides
+// to terminate because of an error in output().
+func (*syslogSink) exitCode() exit.Code {
+	return exit.LoggingNetCollectorUnavailable()
+}
+
+// close closes the connection to the syslog server, if any.
+func (ss *syslogSink) close() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.closeLocked()
+}
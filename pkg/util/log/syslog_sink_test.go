@@ -0,0 +1,75 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package log
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log/channel"
+	"github.com/cockroachdb/cockroach/pkg/util/log/logconfig"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSyslogSink verifies that log entries are sent to a syslog server
+// over UDP as RFC 5424 messages.
+func TestSyslogSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sc := ScopeWithoutShowLogs(t)
+	defer sc.Close(t)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	facility := "local0"
+	cfg := logconfig.DefaultConfig()
+	cfg.Sinks.SyslogServers = map[string]*logconfig.SyslogSinkConfig{
+		"ops": {
+			Net:     "udp",
+			Address: conn.LocalAddr().String(),
+			SyslogDefaults: logconfig.SyslogDefaults{
+				Facility: &facility,
+			},
+			Channels: logconfig.SelectChannels(channel.OPS),
+		},
+	}
+	require.NoError(t, cfg.Validate(&sc.logDir))
+
+	TestingResetActive()
+	cleanup, err := ApplyConfig(cfg, nil /* fileSinkMetricsForDir */, nil /* fatalOnLogStall */)
+	require.NoError(t, err)
+	defer cleanup()
+
+	Ops.Warningf(context.Background(), "syslog test message")
+
+	// local0 (16) * 8 + warning (4) = 132.
+	re := regexp.MustCompile(
+		`^<132>1 \d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z \S+ cockroach \d+ OPS - W\d{6} .*syslog test message$`)
+	buf := make([]byte, 65536)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(10*time.Second)))
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		msg := string(buf[:n])
+		if !strings.Contains(msg, "syslog test message") {
+			continue
+		}
+		require.Regexp(t, re, msg)
+		break
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/cli/exit"
 	"github.com/cockroachdb/cockroach/pkg/testutils/skip"
@@ -513,4 +514,9 @@ type TestLogMetricsImpl struct{}
 
 func (t *TestLogMetricsImpl) IncrementCounter(_ Metric, _ int64) {}
 
+func (t *TestLogMetricsImpl) RecordHTTPSinkRequest(_ string, _ HTTPSinkStatusClass, _ time.Duration) {
+}
+
+func (t *TestLogMetricsImpl) RecordBufferedSinkMessagesDropped(_ string, _ int64) {}
+
 var _ LogMetrics = (*TestLogMetricsImpl)(nil)